@@ -6,19 +6,101 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds the application configuration
 type Config struct {
 	MediaDir string
 	Port     int
+
+	// Bandwidth limits in bytes per second (0 = unlimited)
+	GlobalBandwidthLimit    int64
+	PerIPBandwidthLimit     int64
+	PerFolderBandwidthLimit int64
+
+	// Concurrent stream limits (0 = unlimited)
+	MaxStreamsPerIP  int
+	MaxStreamsGlobal int
+
+	// Signed stream URLs
+	SignedStreamURLs    bool
+	StreamURLSecret     string
+	StreamURLTTL        time.Duration
+	StreamURLBindIP     bool
+	StreamURLByteBudget int64
+
+	// On-demand transcoding
+	Transcoder            string // "ffmpeg", "fake" or "none"
+	FFmpegPath            string
+	TranscodeCacheDir     string
+	TranscodeCacheMaxSize int64 // bytes, 0 = unlimited
+	TranscodeWorkers      int
+
+	// Image thumbnails
+	ThumbnailCacheDir string
+
+	// Cover art extracted from audio files
+	CoverArtCacheDir string
+
+	// Persistent server state, such as generated certificates
+	DataDir string
+
+	// HTTPS. With TLS enabled and no certificate files, a self-signed certificate is generated
+	// under DataDir for TLSHosts.
+	TLSEnabled       bool
+	TLSCertFile      string
+	TLSKeyFile       string
+	TLSHosts         []string
+	HTTP2            bool
+	HTTPRedirectPort int // plain HTTP listener redirecting to HTTPS, 0 = disabled
+
+	// Server timeouts. Pages and API calls must be written within WriteTimeout; streams,
+	// downloads and server-sent events instead fail after StreamIdleTimeout without progress.
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	StreamIdleTimeout time.Duration
+
+	// Watching media folders for changes
+	WatchMode         string // "auto", "poll" or "off"
+	WatchDebounce     time.Duration
+	WatchPollInterval time.Duration
+
+	// Duplicate detection
+	HashWorkers int
 }
 
 // Load loads configuration from environment variables with sensible defaults
 func Load() *Config {
 	cfg := &Config{
-		MediaDir: "./media",
-		Port:     8080,
+		MediaDir:     "./media",
+		Port:         8080,
+		StreamURLTTL: 6 * time.Hour,
+
+		Transcoder:            "ffmpeg",
+		FFmpegPath:            "ffmpeg",
+		TranscodeCacheDir:     filepath.Join(os.TempDir(), "media-server-transcodes"),
+		TranscodeCacheMaxSize: 10 << 30,
+		TranscodeWorkers:      1,
+
+		ThumbnailCacheDir: filepath.Join(os.TempDir(), "media-server-thumbnails"),
+		CoverArtCacheDir:  filepath.Join(os.TempDir(), "media-server-cover-art"),
+
+		DataDir: "./data",
+		HTTP2:   true,
+
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		StreamIdleTimeout: 60 * time.Second,
+
+		WatchMode:         "auto",
+		WatchDebounce:     2 * time.Second,
+		WatchPollInterval: 30 * time.Second,
+
+		HashWorkers: 2,
 	}
 
 	// Override media directory from environment variable
@@ -35,6 +117,91 @@ func Load() *Config {
 		}
 	}
 
+	// Override bandwidth limits from environment variables
+	cfg.GlobalBandwidthLimit = getEnvInt64("BANDWIDTH_GLOBAL_LIMIT", cfg.GlobalBandwidthLimit)
+	cfg.PerIPBandwidthLimit = getEnvInt64("BANDWIDTH_PER_IP_LIMIT", cfg.PerIPBandwidthLimit)
+	cfg.PerFolderBandwidthLimit = getEnvInt64("BANDWIDTH_PER_FOLDER_LIMIT", cfg.PerFolderBandwidthLimit)
+
+	// Override concurrent stream limits from environment variables
+	cfg.MaxStreamsPerIP = int(getEnvInt64("MAX_STREAMS_PER_IP", int64(cfg.MaxStreamsPerIP)))
+	cfg.MaxStreamsGlobal = int(getEnvInt64("MAX_STREAMS_GLOBAL", int64(cfg.MaxStreamsGlobal)))
+
+	// Override signed stream URL settings from environment variables
+	cfg.SignedStreamURLs = getEnvBool("SIGNED_STREAM_URLS", cfg.SignedStreamURLs)
+	cfg.StreamURLSecret = os.Getenv("STREAM_URL_SECRET")
+	cfg.StreamURLTTL = getEnvDuration("STREAM_URL_TTL", cfg.StreamURLTTL)
+	cfg.StreamURLBindIP = getEnvBool("STREAM_URL_BIND_IP", cfg.StreamURLBindIP)
+	cfg.StreamURLByteBudget = getEnvInt64("STREAM_URL_BYTE_BUDGET", cfg.StreamURLByteBudget)
+
+	// Override transcoding settings from environment variables
+	if envTranscoder := os.Getenv("TRANSCODER"); envTranscoder != "" {
+		cfg.Transcoder = envTranscoder
+	}
+	if envFFmpeg := os.Getenv("FFMPEG_PATH"); envFFmpeg != "" {
+		cfg.FFmpegPath = envFFmpeg
+	}
+	if envCacheDir := os.Getenv("TRANSCODE_CACHE_DIR"); envCacheDir != "" {
+		cfg.TranscodeCacheDir = envCacheDir
+	}
+	cfg.TranscodeCacheMaxSize = getEnvInt64("TRANSCODE_CACHE_MAX_BYTES", cfg.TranscodeCacheMaxSize)
+	if workers := int(getEnvInt64("TRANSCODE_WORKERS", int64(cfg.TranscodeWorkers))); workers > 0 {
+		cfg.TranscodeWorkers = workers
+	}
+
+	// Override thumbnail cache directory from environment variable
+	if envThumbDir := os.Getenv("THUMBNAIL_CACHE_DIR"); envThumbDir != "" {
+		cfg.ThumbnailCacheDir = envThumbDir
+	}
+
+	// Override cover art cache directory from environment variable
+	if envArtDir := os.Getenv("COVER_ART_CACHE_DIR"); envArtDir != "" {
+		cfg.CoverArtCacheDir = envArtDir
+	}
+
+	// Override data directory from environment variable
+	if envDataDir := os.Getenv("DATA_DIR"); envDataDir != "" {
+		cfg.DataDir = envDataDir
+	}
+
+	// Override TLS settings from environment variables. Certificate files imply TLS.
+	cfg.TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	cfg.TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		log.Fatalf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	cfg.TLSEnabled = getEnvBool("TLS_ENABLED", cfg.TLSCertFile != "")
+	if envHosts := os.Getenv("TLS_HOSTS"); envHosts != "" {
+		for _, host := range strings.Split(envHosts, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				cfg.TLSHosts = append(cfg.TLSHosts, host)
+			}
+		}
+	}
+	cfg.HTTP2 = getEnvBool("HTTP2_ENABLED", cfg.HTTP2)
+	cfg.HTTPRedirectPort = int(getEnvInt64("HTTP_REDIRECT_PORT", int64(cfg.HTTPRedirectPort)))
+
+	// Override server timeouts from environment variables
+	cfg.ReadTimeout = getEnvDuration("READ_TIMEOUT", cfg.ReadTimeout)
+	cfg.WriteTimeout = getEnvDuration("WRITE_TIMEOUT", cfg.WriteTimeout)
+	cfg.IdleTimeout = getEnvDuration("IDLE_TIMEOUT", cfg.IdleTimeout)
+	cfg.StreamIdleTimeout = getEnvDuration("STREAM_IDLE_TIMEOUT", cfg.StreamIdleTimeout)
+
+	// Override folder watching settings from environment variables
+	switch envWatch := os.Getenv("WATCH_MODE"); envWatch {
+	case "":
+	case "auto", "poll", "off":
+		cfg.WatchMode = envWatch
+	default:
+		log.Printf("Invalid WATCH_MODE value: %s, using default: %s", envWatch, cfg.WatchMode)
+	}
+	cfg.WatchDebounce = getEnvDuration("WATCH_DEBOUNCE", cfg.WatchDebounce)
+	cfg.WatchPollInterval = getEnvDuration("WATCH_POLL_INTERVAL", cfg.WatchPollInterval)
+
+	// Override duplicate detection settings from environment variables
+	if workers := int(getEnvInt64("HASH_WORKERS", int64(cfg.HashWorkers))); workers > 0 {
+		cfg.HashWorkers = workers
+	}
+
 	// Ensure media directory exists
 	if err := cfg.ensureMediaDir(); err != nil {
 		log.Fatalf("Failed to setup media directory: %v", err)
@@ -63,3 +230,51 @@ func (c *Config) ensureMediaDir() error {
 	}
 	return nil
 }
+
+// getEnvInt64 reads a non-negative integer environment variable, falling back to def when unset or invalid
+func getEnvInt64(name string, def int64) int64 {
+	envValue := os.Getenv(name)
+	if envValue == "" {
+		return def
+	}
+
+	value, err := strconv.ParseInt(envValue, 10, 64)
+	if err != nil || value < 0 {
+		log.Printf("Invalid %s value: %s, using default: %d", name, envValue, def)
+		return def
+	}
+
+	return value
+}
+
+// getEnvBool reads a boolean environment variable, falling back to def when unset or invalid
+func getEnvBool(name string, def bool) bool {
+	envValue := os.Getenv(name)
+	if envValue == "" {
+		return def
+	}
+
+	value, err := strconv.ParseBool(envValue)
+	if err != nil {
+		log.Printf("Invalid %s value: %s, using default: %v", name, envValue, def)
+		return def
+	}
+
+	return value
+}
+
+// getEnvDuration reads a positive duration environment variable (e.g. "90s", "6h"), falling back to def
+func getEnvDuration(name string, def time.Duration) time.Duration {
+	envValue := os.Getenv(name)
+	if envValue == "" {
+		return def
+	}
+
+	value, err := time.ParseDuration(envValue)
+	if err != nil || value <= 0 {
+		log.Printf("Invalid %s value: %s, using default: %v", name, envValue, def)
+		return def
+	}
+
+	return value
+}
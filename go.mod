@@ -1,3 +1,11 @@
 module media-server
 
 go 1.24.3
+
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/text v0.21.0
+)
+
+require golang.org/x/sys v0.29.0 // indirect
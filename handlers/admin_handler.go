@@ -28,6 +28,8 @@ type AdminHandler struct {
 	cacheService       *services.CacheService
 	performanceService *services.PerformanceService
 	mediaFolderService *services.MediaFolderService
+	bandwidthService   *services.BandwidthService
+	duplicateService   *services.DuplicateService
 	sseClients         map[string]chan []byte
 	sseClientsMutex    sync.RWMutex
 }
@@ -94,7 +96,8 @@ func NewAdminHandler(cfg *config.Config, adminService *services.AdminService) *A
 // NewAdminHandlerWithServices creates a new AdminHandler instance with enhanced services
 func NewAdminHandlerWithServices(cfg *config.Config, adminService *services.AdminService,
 	cacheService *services.CacheService, performanceService *services.PerformanceService,
-	mediaFolderService *services.MediaFolderService) *AdminHandler {
+	mediaFolderService *services.MediaFolderService, bandwidthService *services.BandwidthService,
+	duplicateService *services.DuplicateService) *AdminHandler {
 
 	// Load templates with custom functions
 	funcMap := template.FuncMap{
@@ -151,6 +154,8 @@ func NewAdminHandlerWithServices(cfg *config.Config, adminService *services.Admi
 		cacheService:       cacheService,
 		performanceService: performanceService,
 		mediaFolderService: mediaFolderService,
+		bandwidthService:   bandwidthService,
+		duplicateService:   duplicateService,
 		sseClients:         make(map[string]chan []byte),
 		sseClientsMutex:    sync.RWMutex{},
 	}
@@ -659,6 +664,9 @@ func (ah *AdminHandler) HandleWorkerPoolsAPI(w http.ResponseWriter, r *http.Requ
 	}
 }
 
+// sseKeepaliveInterval is how often an idle server-sent event stream sends a comment line
+const sseKeepaliveInterval = 15 * time.Second
+
 // HandleRealtimeSSE handles Server-Sent Events for real-time admin dashboard updates
 func (ah *AdminHandler) HandleRealtimeSSE(w http.ResponseWriter, r *http.Request) {
 	// Set SSE headers
@@ -693,11 +701,21 @@ func (ah *AdminHandler) HandleRealtimeSSE(w http.ResponseWriter, r *http.Request
 	// Send initial data
 	ah.sendInitialData(w)
 
+	// Comment lines keep the stream alive between updates, so that its idle write deadline
+	// only expires once the client stops reading
+	keepalive := time.NewTicker(sseKeepaliveInterval)
+	defer keepalive.Stop()
+
 	// Listen for client disconnect and data updates
 	for {
 		select {
 		case <-r.Context().Done():
 			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
 		case data := <-clientChan:
 			// Send data to client
 			fmt.Fprintf(w, "data: %s\n\n", data)
@@ -912,6 +930,10 @@ func (ah *AdminHandler) HandleMediaFolderAPI(w http.ResponseWriter, r *http.Requ
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
+			// The duplicates report only covers active folders
+			if ah.duplicateService != nil {
+				ah.duplicateService.Refresh()
+			}
 		case "set_default":
 			if err := ah.mediaFolderService.SetDefaultFolder(folderID); err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
@@ -961,6 +983,27 @@ func (ah *AdminHandler) HandleScanFolderAPI(w http.ResponseWriter, r *http.Reque
 	json.NewEncoder(w).Encode(stats)
 }
 
+// HandleDuplicatesAPI returns the duplicate files report (GET) or starts a new hashing pass (POST)
+func (ah *AdminHandler) HandleDuplicatesAPI(w http.ResponseWriter, r *http.Request) {
+	if ah.duplicateService == nil {
+		http.Error(w, "Duplicate detection not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ah.duplicateService.Report())
+	case http.MethodPost:
+		ah.duplicateService.Refresh()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(ah.duplicateService.Report())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 // HandleBrowseFoldersAPI provides folder browsing for admin
 func (ah *AdminHandler) HandleBrowseFoldersAPI(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -1015,3 +1058,44 @@ func (ah *AdminHandler) HandleBrowseFoldersAPI(w http.ResponseWriter, r *http.Re
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(folders)
 }
+
+// HandleBandwidthAPI provides JSON API for viewing and updating streaming bandwidth limits
+func (ah *AdminHandler) HandleBandwidthAPI(w http.ResponseWriter, r *http.Request) {
+	if ah.bandwidthService == nil {
+		http.Error(w, "Bandwidth service not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		stats := ah.bandwidthService.GetStats()
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			log.Printf("Error encoding bandwidth stats JSON: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+
+	case http.MethodPut, http.MethodPost:
+		var limits models.BandwidthLimits
+		if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if err := ah.bandwidthService.SetLimits(limits); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		adminIP := r.Context().Value("admin_ip").(string)
+		ah.adminService.LogActivity(adminIP, "bandwidth_limits_updated",
+			fmt.Sprintf("global=%d per_ip=%d per_folder=%d", limits.GlobalLimit, limits.PerIPLimit, limits.PerFolderLimit),
+			r.UserAgent(), true, "")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ah.bandwidthService.GetStats())
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
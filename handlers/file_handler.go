@@ -9,6 +9,9 @@ import (
 	"media-server/services"
 	"media-server/utils"
 	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -19,6 +22,8 @@ type FileHandler struct {
 	templates          *template.Template
 	cacheService       *services.CacheService
 	performanceService *services.PerformanceService
+	urlSigner          *services.URLSigner
+	thumbnailService   *services.ThumbnailService
 }
 
 // NewFileHandler creates a new FileHandler instance
@@ -84,7 +89,8 @@ func NewFileHandler(cfg *config.Config) *FileHandler {
 
 // NewFileHandlerWithServices creates a new FileHandler instance with enhanced services
 func NewFileHandlerWithServices(cfg *config.Config, cacheService *services.CacheService,
-	performanceService *services.PerformanceService, mediaFolderService *services.MediaFolderService) *FileHandler {
+	performanceService *services.PerformanceService, mediaFolderService *services.MediaFolderService,
+	urlSigner *services.URLSigner, thumbnailService *services.ThumbnailService) *FileHandler {
 
 	log.Println("Creating FileHandler with enhanced services...")
 	fileService := services.NewFileServiceWithMediaFolders(cfg.MediaDir, cacheService, performanceService, mediaFolderService)
@@ -144,6 +150,8 @@ func NewFileHandlerWithServices(cfg *config.Config, cacheService *services.Cache
 		templates:          templates,
 		cacheService:       cacheService,
 		performanceService: performanceService,
+		urlSigner:          urlSigner,
+		thumbnailService:   thumbnailService,
 	}
 }
 
@@ -151,6 +159,11 @@ func NewFileHandlerWithServices(cfg *config.Config, cacheService *services.Cache
 func (fh *FileHandler) HandleFileList(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/")
 
+	// Send paths outside the media folder namespace to their f/<folder ID>/ address
+	if redirectToCanonical(w, r, fh.fileService, "/", path) {
+		return
+	}
+
 	// Get file info to determine if it's a file or directory
 	fileInfo, err := fh.fileService.GetFileInfo(path)
 	if err != nil {
@@ -165,7 +178,7 @@ func (fh *FileHandler) HandleFileList(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, "/player/"+path, http.StatusSeeOther)
 		} else {
 			// Redirect non-media files to direct streaming
-			http.Redirect(w, r, "/stream/"+path, http.StatusSeeOther)
+			http.Redirect(w, r, streamURLFor(fh.urlSigner, r, path), http.StatusSeeOther)
 		}
 		return
 	}
@@ -177,17 +190,36 @@ func (fh *FileHandler) HandleFileList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The parent of a media folder is the root
+	breadcrumbs := fh.breadcrumbs(path)
+	parentPath := ""
+	if len(breadcrumbs) > 1 {
+		parentPath = breadcrumbs[len(breadcrumbs)-2].Path
+	}
+
 	// Prepare template data
 	data := struct {
 		Title       string
 		CurrentPath string
+		DisplayPath string
 		ParentPath  string
+		Breadcrumbs []breadcrumb
 		Files       []*models.FileInfo
+		ZipURL      string
+		TarURL      string
+
+		ThumbnailURLFor func(string) string
 	}{
-		Title:       fh.getPageTitle(path),
+		Title:       fh.getPageTitle(breadcrumbs),
 		CurrentPath: path,
-		ParentPath:  utils.GetParentPath(path),
+		DisplayPath: displayPath(breadcrumbs),
+		ParentPath:  parentPath,
+		Breadcrumbs: breadcrumbs,
 		Files:       files,
+		ZipURL:      archiveURLFor(fh.urlSigner, r, path, archiveFormatZip),
+		TarURL:      archiveURLFor(fh.urlSigner, r, path, archiveFormatTar),
+
+		ThumbnailURLFor: thumbnailURLFunc(fh.urlSigner, r, fh.thumbnailService, "small"),
 	}
 
 	// Render template
@@ -219,17 +251,62 @@ func (fh *FileHandler) handleError(w http.ResponseWriter, r *http.Request, title
 	}
 }
 
+// breadcrumb is a link to one of the directories above a listing
+type breadcrumb struct {
+	Name string
+	Path string
+}
+
+// breadcrumbs returns the directories leading to a media path, starting with its media folder
+// when it is in one
+func (fh *FileHandler) breadcrumbs(mediaPath string) []breadcrumb {
+	var crumbs []breadcrumb
+	parentPath, rel := "", mediaPath
+	if folder, folderRel := fh.fileService.MediaFolderFor(mediaPath); folder != nil {
+		parentPath = models.FolderMediaPath(folder.ID, "")
+		rel = folderRel
+		crumbs = append(crumbs, breadcrumb{Name: folder.Name, Path: parentPath})
+	}
+
+	for _, part := range utils.SplitPath(filepath.FromSlash(rel)) {
+		parentPath = path.Join(parentPath, part)
+		crumbs = append(crumbs, breadcrumb{Name: part, Path: parentPath})
+	}
+	return crumbs
+}
+
+// displayPath joins the names of breadcrumbs into a readable path
+func displayPath(crumbs []breadcrumb) string {
+	names := make([]string, 0, len(crumbs))
+	for _, crumb := range crumbs {
+		names = append(names, crumb.Name)
+	}
+	return strings.Join(names, "/")
+}
+
 // getPageTitle generates an appropriate page title
-func (fh *FileHandler) getPageTitle(path string) string {
-	if path == "" {
+func (fh *FileHandler) getPageTitle(crumbs []breadcrumb) string {
+	if len(crumbs) == 0 {
 		return "Media Server"
 	}
 
-	// Get the last part of the path for the title
-	parts := utils.SplitPath(path)
-	if len(parts) > 0 {
-		return "Media Server - " + parts[len(parts)-1]
+	// Name the page after the directory it lists
+	return "Media Server - " + crumbs[len(crumbs)-1].Name
+}
+
+// redirectToCanonical redirects a request for a media path that is not in its canonical
+// f/<folder ID>/ form to the canonical path under the same route prefix, keeping the query.
+// It reports whether it redirected.
+func redirectToCanonical(w http.ResponseWriter, r *http.Request, fileService *services.FileService, prefix, mediaPath string) bool {
+	canonical, err := fileService.CanonicalPath(mediaPath)
+	if err != nil || canonical == "." || canonical == mediaPath {
+		return false
 	}
 
-	return "Media Server - " + path
+	target := prefix + (&url.URL{Path: canonical}).EscapedPath()
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+	return true
 }
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
@@ -9,6 +10,7 @@ import (
 	"media-server/services"
 	"media-server/utils"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 )
@@ -19,6 +21,14 @@ type PlayerHandler struct {
 	templates          *template.Template
 	cacheService       *services.CacheService
 	performanceService *services.PerformanceService
+	urlSigner          *services.URLSigner
+	transcodeService   *services.TranscodeService
+	thumbnailService   *services.ThumbnailService
+	photoService       *services.PhotoService
+	coverArtService    *services.CoverArtService
+	mediaFolderService *services.MediaFolderService
+	mediaIndexService  *services.MediaIndexService
+	searchService      *services.SearchService
 }
 
 // NewPlayerHandler creates a new PlayerHandler instance
@@ -81,7 +91,11 @@ func NewPlayerHandler(cfg *config.Config) *PlayerHandler {
 
 // NewPlayerHandlerWithServices creates a new PlayerHandler instance with enhanced services
 func NewPlayerHandlerWithServices(cfg *config.Config, cacheService *services.CacheService,
-	performanceService *services.PerformanceService, mediaFolderService *services.MediaFolderService) *PlayerHandler {
+	performanceService *services.PerformanceService, mediaFolderService *services.MediaFolderService,
+	urlSigner *services.URLSigner, transcodeService *services.TranscodeService,
+	thumbnailService *services.ThumbnailService, photoService *services.PhotoService,
+	coverArtService *services.CoverArtService, mediaIndexService *services.MediaIndexService,
+	searchService *services.SearchService) *PlayerHandler {
 
 	fileService := services.NewFileServiceWithMediaFolders(cfg.MediaDir, cacheService, performanceService, mediaFolderService)
 
@@ -138,6 +152,14 @@ func NewPlayerHandlerWithServices(cfg *config.Config, cacheService *services.Cac
 		templates:          templates,
 		cacheService:       cacheService,
 		performanceService: performanceService,
+		urlSigner:          urlSigner,
+		transcodeService:   transcodeService,
+		thumbnailService:   thumbnailService,
+		photoService:       photoService,
+		coverArtService:    coverArtService,
+		mediaFolderService: mediaFolderService,
+		mediaIndexService:  mediaIndexService,
+		searchService:      searchService,
 	}
 }
 
@@ -146,6 +168,11 @@ func (ph *PlayerHandler) HandlePlayer(w http.ResponseWriter, r *http.Request) {
 	// Extract path from URL (remove /player/ prefix)
 	path := strings.TrimPrefix(r.URL.Path, "/player/")
 
+	// Send paths outside the media folder namespace to their f/<folder ID>/ address
+	if redirectToCanonical(w, r, ph.fileService, "/player/", path) {
+		return
+	}
+
 	// Get file info
 	fileInfo, err := ph.fileService.GetFileInfo(path)
 	if err != nil {
@@ -176,17 +203,29 @@ func (ph *PlayerHandler) HandlePlayer(w http.ResponseWriter, r *http.Request) {
 
 	// Prepare template data
 	data := struct {
-		Title       string
-		CurrentFile *models.FileInfo
-		Playlist    []*models.FileInfo
-		StreamURL   string
-		ParentPath  string
+		Title          string
+		CurrentFile    *models.FileInfo
+		Playlist       []*models.FileInfo
+		StreamURL      string
+		PlaybackURLFor func(string) string
+		DownloadURL    string
+		ParentPath     string
+
+		TranscodeStatusURL string
+		SubtitleURLFor     func(string) string
+		CoverArtURL        string
 	}{
-		Title:       "Media Player - " + fileInfo.Name,
-		CurrentFile: fileInfo,
-		Playlist:    playlist,
-		StreamURL:   "/stream/" + path,
-		ParentPath:  parentDir,
+		Title:          "Media Player - " + fileInfo.Name,
+		CurrentFile:    fileInfo,
+		Playlist:       playlist,
+		StreamURL:      ph.playbackURL(r, path),
+		PlaybackURLFor: ph.playbackURLFunc(r),
+		DownloadURL:    downloadURLFor(ph.urlSigner, r, path),
+		ParentPath:     parentDir,
+
+		TranscodeStatusURL: ph.transcodeStatusURL(r, path),
+		SubtitleURLFor:     subtitleURLFunc(ph.urlSigner, r),
+		CoverArtURL:        coverArtURLFunc(ph.urlSigner, r, ph.coverArtService, "large")(path),
 	}
 
 	// Render template
@@ -199,8 +238,8 @@ func (ph *PlayerHandler) HandlePlayer(w http.ResponseWriter, r *http.Request) {
 
 // HandleLibrary handles the video library interface
 func (ph *PlayerHandler) HandleLibrary(w http.ResponseWriter, r *http.Request) {
-	// Get all media files recursively
-	allFiles, err := ph.getAllMediaFiles("")
+	// Get all media files from the media index
+	allFiles, err := ph.getLibraryFiles()
 	if err != nil {
 		ph.handleError(w, r, "Error Loading Library", err.Error(), http.StatusInternalServerError)
 		return
@@ -224,15 +263,23 @@ func (ph *PlayerHandler) HandleLibrary(w http.ResponseWriter, r *http.Request) {
 
 	// Prepare template data
 	data := struct {
-		Title  string
-		Videos []*models.FileInfo
-		Audios []*models.FileInfo
-		Images []*models.FileInfo
+		Title        string
+		Videos       []*models.FileInfo
+		Audios       []*models.FileInfo
+		Images       []*models.FileInfo
+		StreamURLFor func(string) string
+
+		ThumbnailURLFor func(string) string
+		CoverArtURLFor  func(string) string
 	}{
-		Title:  "Media Library",
-		Videos: videos,
-		Audios: audios,
-		Images: images,
+		Title:        "Media Library",
+		Videos:       videos,
+		Audios:       audios,
+		Images:       images,
+		StreamURLFor: ph.streamURLFunc(r),
+
+		ThumbnailURLFor: thumbnailURLFunc(ph.urlSigner, r, ph.thumbnailService, "medium"),
+		CoverArtURLFor:  coverArtURLFunc(ph.urlSigner, r, ph.coverArtService, "medium"),
 	}
 
 	// Render template
@@ -243,6 +290,177 @@ func (ph *PlayerHandler) HandleLibrary(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// HandlePhotos handles the photo timeline interface (/photos?year=YYYY)
+func (ph *PlayerHandler) HandlePhotos(w http.ResponseWriter, r *http.Request) {
+	timeline, year, _, err := ph.photoTimeline(r)
+	if err != nil {
+		if _, ok := err.(*models.ValidationError); ok {
+			ph.handleError(w, r, "Invalid Request", err.Error(), http.StatusBadRequest)
+			return
+		}
+		ph.handleError(w, r, "Error Loading Photos", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	full, _ := ph.photoService.GetTimeline()
+
+	// Prepare template data
+	data := struct {
+		Title        string
+		Timeline     *models.PhotoTimeline
+		Years        []*models.PhotoYear
+		AllCount     int
+		SelectedYear int
+
+		ThumbnailURLFor func(string) string
+	}{
+		Title:        "Photos",
+		Timeline:     timeline,
+		Years:        full.Years,
+		AllCount:     full.Count,
+		SelectedYear: year,
+
+		ThumbnailURLFor: thumbnailURLFunc(ph.urlSigner, r, ph.thumbnailService, "small"),
+	}
+
+	// Render template
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := ph.templates.ExecuteTemplate(w, "photos.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// HandlePhotosAPI returns the photo timeline as JSON (/api/photos?year=YYYY&month=MM)
+func (ph *PlayerHandler) HandlePhotosAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	timeline, _, _, err := ph.photoTimeline(r)
+	if err != nil {
+		if _, ok := err.(*models.ValidationError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error building photo timeline: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timeline)
+}
+
+// photoTimeline returns the photo timeline, filtered by the optional year and month query parameters
+func (ph *PlayerHandler) photoTimeline(r *http.Request) (*models.PhotoTimeline, int, int, error) {
+	if ph.photoService == nil {
+		return nil, 0, 0, fmt.Errorf("photo timeline is not available")
+	}
+
+	var year, month int
+	if value := r.URL.Query().Get("year"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return nil, 0, 0, models.NewValidationError("year", "invalid year: "+value)
+		}
+		year = parsed
+	}
+	if value := r.URL.Query().Get("month"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 12 {
+			return nil, 0, 0, models.NewValidationError("month", "invalid month: "+value)
+		}
+		if year == 0 {
+			return nil, 0, 0, models.NewValidationError("month", "month requires a year")
+		}
+		month = parsed
+	}
+
+	timeline, err := ph.photoService.GetTimeline()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if year != 0 {
+		timeline = timeline.Filter(year, month)
+	}
+	return timeline, year, month, nil
+}
+
+// HandleSearchAPI searches the library and returns a page of hits with facet counts as JSON
+// (/api/search?q=...&type=...&folder=...&ext=...&min_size=...&max_size=...&from=...&to=...)
+func (ph *PlayerHandler) HandleSearchAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if ph.searchService == nil {
+		http.Error(w, "Search is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	query, err := models.ParseSearchQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := ph.searchService.Search(query)
+
+	thumbnailURLFor := thumbnailURLFunc(ph.urlSigner, r, ph.thumbnailService, "small")
+	coverArtURLFor := coverArtURLFunc(ph.urlSigner, r, ph.coverArtService, "small")
+	for _, hit := range result.Hits {
+		hit.PlayerURL = (&url.URL{Path: "/player/" + hit.MediaPath}).EscapedPath()
+		hit.ImageURL = thumbnailURLFor(hit.MediaPath)
+		if hit.ImageURL == "" {
+			hit.ImageURL = coverArtURLFor(hit.MediaPath)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// getLibraryFiles returns the media files of every active media folder from the media index,
+// default folder first. The default folder is walked instead while it has not been indexed yet,
+// and all folders are walked when the index is disabled.
+func (ph *PlayerHandler) getLibraryFiles() ([]*models.FileInfo, error) {
+	if ph.mediaIndexService == nil || ph.mediaFolderService == nil {
+		return ph.getAllMediaFiles("")
+	}
+
+	folders := ph.mediaFolderService.GetActiveFolders()
+	models.SortMediaFolders(folders)
+
+	var allFiles []*models.FileInfo
+	for _, folder := range folders {
+		indexed, found, err := ph.mediaIndexService.Files(folder.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			if folder.IsDefault {
+				files, err := ph.getAllMediaFiles(models.FolderMediaPath(folder.ID, ""))
+				if err != nil {
+					return nil, err
+				}
+				allFiles = append(allFiles, files...)
+			}
+			continue
+		}
+
+		files := make([]*models.FileInfo, 0, len(indexed))
+		for _, file := range indexed {
+			files = append(files, file.FileInfo(folder.ID))
+		}
+		ph.fileService.AttachMediaMetadata(files)
+		allFiles = append(allFiles, files...)
+	}
+
+	return allFiles, nil
+}
+
 // getAllMediaFiles recursively gets all media files
 func (ph *PlayerHandler) getAllMediaFiles(basePath string) ([]*models.FileInfo, error) {
 	var allFiles []*models.FileInfo
@@ -269,6 +487,43 @@ func (ph *PlayerHandler) getAllMediaFiles(basePath string) ([]*models.FileInfo,
 	return allFiles, nil
 }
 
+// streamURLFunc returns a template helper that builds (signed) stream URLs for the requesting client
+func (ph *PlayerHandler) streamURLFunc(r *http.Request) func(string) string {
+	return func(path string) string {
+		return streamURLFor(ph.urlSigner, r, path)
+	}
+}
+
+// playbackURL returns the URL the player loads for path: the HLS playlist for transport streams
+// and the transcoded MP4 rendition for other containers browsers cannot play directly, and the
+// stream URL for everything else
+func (ph *PlayerHandler) playbackURL(r *http.Request, path string) string {
+	if models.IsHLSSource(path) {
+		return hlsPlaylistURL(ph.urlSigner, r, path)
+	}
+	if ph.transcodeService != nil && models.NeedsTranscoding(path) {
+		return resourceURLFor(ph.urlSigner, r, "/transcode/", path, models.TranscodeMP4Name, nil)
+	}
+	return streamURLFor(ph.urlSigner, r, path)
+}
+
+// transcodeStatusURL returns the URL the player polls until the rendition of path is ready, or ""
+// when path is played without transcoding
+func (ph *PlayerHandler) transcodeStatusURL(r *http.Request, path string) string {
+	if ph.transcodeService == nil || !models.NeedsTranscoding(path) {
+		return ""
+	}
+	query := url.Values{"format": {string(models.TranscodeFormatMP4)}}
+	return resourceURLFor(ph.urlSigner, r, "/transcode/", path, transcodeStatusName, query)
+}
+
+// playbackURLFunc returns a template function that builds playback URLs for the current request
+func (ph *PlayerHandler) playbackURLFunc(r *http.Request) func(string) string {
+	return func(path string) string {
+		return ph.playbackURL(r, path)
+	}
+}
+
 // handleError renders an error page
 func (ph *PlayerHandler) handleError(w http.ResponseWriter, r *http.Request, title, message string, statusCode int) {
 	data := struct {
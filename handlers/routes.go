@@ -10,16 +10,24 @@ import (
 // SetupRoutes configures all application routes
 func SetupRoutes(mux *http.ServeMux, cfg *config.Config, adminService *services.AdminService,
 	cacheService *services.CacheService, performanceService *services.PerformanceService,
-	mediaFolderService *services.MediaFolderService) {
+	mediaFolderService *services.MediaFolderService, bandwidthService *services.BandwidthService,
+	urlSigner *services.URLSigner, hlsService *services.HLSService, faststartService *services.FaststartService,
+	transcodeService *services.TranscodeService, thumbnailService *services.ThumbnailService,
+	photoService *services.PhotoService, coverArtService *services.CoverArtService,
+	mediaIndexService *services.MediaIndexService, searchService *services.SearchService,
+	duplicateService *services.DuplicateService) {
 	// Create handlers with enhanced services
-	fileHandler := NewFileHandlerWithServices(cfg, cacheService, performanceService, mediaFolderService)
-	streamHandler := NewStreamHandlerWithServices(cfg, adminService, cacheService, performanceService, mediaFolderService)
-	playerHandler := NewPlayerHandlerWithServices(cfg, cacheService, performanceService, mediaFolderService)
-	adminHandler := NewAdminHandlerWithServices(cfg, adminService, cacheService, performanceService, mediaFolderService)
+	fileHandler := NewFileHandlerWithServices(cfg, cacheService, performanceService, mediaFolderService, urlSigner, thumbnailService)
+	streamHandler := NewStreamHandlerWithServices(cfg, adminService, cacheService, performanceService, mediaFolderService, bandwidthService, urlSigner, hlsService, faststartService, transcodeService, thumbnailService, coverArtService)
+	playerHandler := NewPlayerHandlerWithServices(cfg, cacheService, performanceService, mediaFolderService, urlSigner, transcodeService, thumbnailService, photoService, coverArtService, mediaIndexService, searchService)
+	adminHandler := NewAdminHandlerWithServices(cfg, adminService, cacheService, performanceService, mediaFolderService, bandwidthService, duplicateService)
 
 	// Create admin middleware
 	adminMiddleware := middleware.NewAdminMiddleware(adminService)
 
+	// Long responses get a progress-based deadline instead of the server's write timeout
+	timeoutMiddleware := middleware.NewTimeoutMiddleware(cfg.StreamIdleTimeout)
+
 	// Static file serving
 	staticHandler := http.StripPrefix("/static/", http.FileServer(http.Dir("static")))
 	mux.Handle("/static/", staticHandler)
@@ -41,13 +49,15 @@ func SetupRoutes(mux *http.ServeMux, cfg *config.Config, adminService *services.
 	mux.Handle("/admin/api/streaming", adminMiddleware.AdminAuth(http.HandlerFunc(adminHandler.HandleStreamingAPI)))
 	mux.Handle("/admin/api/cache", adminMiddleware.AdminAuth(http.HandlerFunc(adminHandler.HandleCacheAPI)))
 	mux.Handle("/admin/api/worker-pools", adminMiddleware.AdminAuth(http.HandlerFunc(adminHandler.HandleWorkerPoolsAPI)))
-	mux.Handle("/admin/api/realtime", adminMiddleware.AdminAuth(http.HandlerFunc(adminHandler.HandleRealtimeSSE)))
+	mux.Handle("/admin/api/realtime", timeoutMiddleware.Streaming(adminMiddleware.AdminAuth(http.HandlerFunc(adminHandler.HandleRealtimeSSE))))
+	mux.Handle("/admin/api/bandwidth", adminMiddleware.AdminAuth(http.HandlerFunc(adminHandler.HandleBandwidthAPI)))
 
 	// Media folder management API routes (admin only)
 	mux.Handle("/admin/api/media-folders", adminMiddleware.AdminAuth(http.HandlerFunc(adminHandler.HandleMediaFoldersAPI)))
 	mux.Handle("/admin/api/media-folder", adminMiddleware.AdminAuth(http.HandlerFunc(adminHandler.HandleMediaFolderAPI)))
 	mux.Handle("/admin/api/scan-folder", adminMiddleware.AdminAuth(http.HandlerFunc(adminHandler.HandleScanFolderAPI)))
 	mux.Handle("/admin/api/browse-folders", adminMiddleware.AdminAuth(http.HandlerFunc(adminHandler.HandleBrowseFoldersAPI)))
+	mux.Handle("/admin/api/duplicates", adminMiddleware.AdminAuth(http.HandlerFunc(adminHandler.HandleDuplicatesAPI)))
 
 	// Admin/Settings interface (protected by admin auth)
 	mux.Handle("/settings", adminMiddleware.AdminAuth(http.HandlerFunc(adminHandler.HandleSettings)))
@@ -57,11 +67,39 @@ func SetupRoutes(mux *http.ServeMux, cfg *config.Config, adminService *services.
 	// Media library interface (with connection tracking and media password protection)
 	mux.Handle("/library", adminMiddleware.ConnectionTracking(http.HandlerFunc(playerHandler.HandleLibrary)))
 
+	// Photo timeline interface and JSON API (with connection tracking)
+	mux.Handle("/photos", adminMiddleware.ConnectionTracking(http.HandlerFunc(playerHandler.HandlePhotos)))
+	mux.Handle("/api/photos", adminMiddleware.ConnectionTracking(http.HandlerFunc(playerHandler.HandlePhotosAPI)))
+
+	// Library search JSON API (with connection tracking)
+	mux.Handle("/api/search", adminMiddleware.ConnectionTracking(http.HandlerFunc(playerHandler.HandleSearchAPI)))
+
 	// Video player interface (with connection tracking and media password protection)
 	mux.Handle("/player/", adminMiddleware.MediaPasswordAuth(adminMiddleware.ConnectionTracking(http.HandlerFunc(playerHandler.HandlePlayer))))
 
 	// File streaming (with connection tracking and media password protection)
-	mux.Handle("/stream/", adminMiddleware.MediaPasswordAuth(adminMiddleware.ConnectionTracking(http.HandlerFunc(streamHandler.HandleStream))))
+	mux.Handle("/stream/", timeoutMiddleware.Streaming(adminMiddleware.MediaPasswordAuth(adminMiddleware.ConnectionTracking(http.HandlerFunc(streamHandler.HandleStream)))))
+
+	// File downloads as attachments with resume support (with connection tracking and media password protection)
+	mux.Handle("/download/", timeoutMiddleware.Streaming(adminMiddleware.MediaPasswordAuth(adminMiddleware.ConnectionTracking(http.HandlerFunc(streamHandler.HandleDownload)))))
+
+	// HLS packaging of MPEG transport streams (with connection tracking and media password protection)
+	mux.Handle("/hls/", timeoutMiddleware.Streaming(adminMiddleware.MediaPasswordAuth(adminMiddleware.ConnectionTracking(http.HandlerFunc(streamHandler.HandleHLS)))))
+
+	// Image thumbnails (with media password protection)
+	mux.Handle("/thumb/", adminMiddleware.MediaPasswordAuth(http.HandlerFunc(streamHandler.HandleThumbnail)))
+
+	// Cover art of audio files (with media password protection)
+	mux.Handle("/art/", adminMiddleware.MediaPasswordAuth(http.HandlerFunc(streamHandler.HandleCoverArt)))
+
+	// Sidecar subtitles converted to WebVTT (with connection tracking and media password protection)
+	mux.Handle("/subtitles/", adminMiddleware.MediaPasswordAuth(adminMiddleware.ConnectionTracking(http.HandlerFunc(streamHandler.HandleSubtitles))))
+
+	// On-demand transcoded renditions (with connection tracking and media password protection)
+	mux.Handle("/transcode/", timeoutMiddleware.Streaming(adminMiddleware.MediaPasswordAuth(adminMiddleware.ConnectionTracking(http.HandlerFunc(streamHandler.HandleTranscode)))))
+
+	// Folder and selection archives (with connection tracking; media passwords are checked per file)
+	mux.Handle("/archive/", timeoutMiddleware.Streaming(adminMiddleware.ConnectionTracking(http.HandlerFunc(streamHandler.HandleArchive))))
 
 	// File listing (with connection tracking)
 	mux.Handle("/", adminMiddleware.ConnectionTracking(http.HandlerFunc(fileHandler.HandleFileList)))
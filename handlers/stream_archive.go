@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"media-server/models"
+	"media-server/services"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Archive formats supported by HandleArchive
+const (
+	archiveFormatZip = "zip"
+	archiveFormatTar = "tar"
+)
+
+// archiveRequest is the JSON body accepted by POST /archive/ to archive a selection of paths
+type archiveRequest struct {
+	Paths  []string `json:"paths"`
+	Format string   `json:"format"`
+	Name   string   `json:"name"`
+}
+
+// HandleArchive streams a ZIP or TAR archive of a directory (GET /archive/<path>) or of a
+// posted selection of paths (POST /archive/). Nothing is buffered to disk.
+func (sh *StreamHandler) HandleArchive(w http.ResponseWriter, r *http.Request) {
+	var paths []string
+	var format, name string
+	clientIP := models.GetClientIP(r.RemoteAddr, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		dirPath := strings.Trim(strings.TrimPrefix(r.URL.Path, "/archive/"), "/")
+		var ok bool
+		if w, ok = sh.verifySignedURL(w, r, clientIP, "/archive/", dirPath); !ok {
+			return
+		}
+		paths = []string{dirPath}
+		format = r.URL.Query().Get("format")
+		name = path.Base(dirPath)
+		if folder, rel := sh.fileService.MediaFolderFor(dirPath); folder != nil && rel == "." {
+			name = folder.Name
+		}
+	case http.MethodPost:
+		// A selection has no single path a signature could cover
+		if sh.urlSigner != nil {
+			http.Error(w, "Archiving a selection is not available with signed stream URLs", http.StatusForbidden)
+			return
+		}
+
+		var req archiveRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		paths = req.Paths
+		format = req.Format
+		name = req.Name
+		if name == "" {
+			name = "selection"
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if format == "" {
+		format = archiveFormatZip
+	}
+	if format != archiveFormatZip && format != archiveFormatTar {
+		http.Error(w, "Unsupported archive format", http.StatusBadRequest)
+		return
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "media"
+	}
+
+	log.Printf("Archive request from %s for %d path(s), format: %s", clientIP, len(paths), format)
+
+	entries, err := sh.fileService.CollectArchiveEntries(paths)
+	if err != nil {
+		log.Printf("Archive collection failed for %v: %v", paths, err)
+		status := http.StatusBadRequest
+		if strings.Contains(err.Error(), "not found") {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if len(entries) == 0 {
+		http.Error(w, "No files to archive", http.StatusNotFound)
+		return
+	}
+
+	// Every file must pass the same media password check as /stream/
+	if !sh.checkArchivePasswords(w, r, clientIP, entries) {
+		return
+	}
+
+	filename := name + "." + format
+	w.Header().Set("Content-Type", archiveContentType(format))
+	w.Header().Set("Content-Disposition", attachmentDisposition(filename))
+	w.Header().Set("ETag", archiveETag(format, entries))
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	archivePath := "archive:" + filename
+	if sh.adminService != nil {
+		if err := sh.adminService.StartStream(clientIP, archivePath, true, 0); err != nil {
+			sh.rejectStream(w, r, clientIP, archivePath, err)
+			return
+		}
+	}
+
+	startTime := time.Now()
+	limiter := sh.newStreamLimiter(r, clientIP, "")
+	if limiter != nil {
+		defer limiter.Close()
+	}
+
+	counter := &archiveWriter{w: w}
+	if format == archiveFormatTar {
+		err = sh.writeTarArchive(counter, entries, limiter)
+	} else {
+		err = sh.writeZipArchive(counter, entries, limiter)
+	}
+	if err != nil {
+		// Headers are already sent; the client sees a truncated archive
+		log.Printf("Error streaming archive %s: %v", filename, err)
+	}
+
+	if sh.adminService != nil {
+		sh.adminService.EndStream(clientIP, archivePath, true, -1, counter.n, time.Since(startTime))
+	}
+}
+
+// checkArchivePasswords verifies the media password for every archived file and its parent
+// directories, responding with 401 and returning false when one is missing or wrong
+func (sh *StreamHandler) checkArchivePasswords(w http.ResponseWriter, r *http.Request, clientIP string, entries []*models.ArchiveEntry) bool {
+	if sh.adminService == nil {
+		return true
+	}
+
+	password := r.Header.Get("X-Media-Password")
+	if password == "" {
+		password = r.URL.Query().Get("password")
+	}
+
+	checked := make(map[string]bool)
+	for _, entry := range entries {
+		for p := entry.Path; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+			if checked[p] {
+				break
+			}
+			checked[p] = true
+
+			if !sh.adminService.CheckMediaPassword(p, password) {
+				sh.adminService.LogActivity(clientIP, "media_access_denied", p, r.UserAgent(), false, "Invalid or missing password")
+				w.Header().Set("X-Password-Required", "true")
+				w.Header().Set("X-Media-Path", p)
+				http.Error(w, "Password required for this media", http.StatusUnauthorized)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// writeZipArchive writes entries as an uncompressed (store mode) ZIP; ZIP64 records are
+// added automatically for large files and archives
+func (sh *StreamHandler) writeZipArchive(w io.Writer, entries []*models.ArchiveEntry, limiter *services.StreamLimiter) error {
+	zw := zip.NewWriter(w)
+
+	for _, entry := range entries {
+		header := &zip.FileHeader{
+			Name:     entry.Name,
+			Method:   zip.Store,
+			Modified: entry.ModTime.UTC().Truncate(time.Second),
+		}
+		header.SetMode(0644)
+
+		dst, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if err := sh.copyArchiveEntry(dst, entry, limiter); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// writeTarArchive writes entries as a TAR archive
+func (sh *StreamHandler) writeTarArchive(w io.Writer, entries []*models.ArchiveEntry, limiter *services.StreamLimiter) error {
+	tw := tar.NewWriter(w)
+
+	for _, entry := range entries {
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     entry.Name,
+			Size:     entry.Size,
+			Mode:     0644,
+			ModTime:  entry.ModTime.UTC().Truncate(time.Second),
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if err := sh.copyArchiveEntry(tw, entry, limiter); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// copyArchiveEntry copies exactly the size recorded for entry, so the archive matches its
+// headers even if the file changes while it is being streamed
+func (sh *StreamHandler) copyArchiveEntry(dst io.Writer, entry *models.ArchiveEntry, limiter *services.StreamLimiter) error {
+	file, err := os.Open(entry.FullPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	n, err := sh.copyNWithBuffer(dst, file, entry.Size, limiter)
+	if err != nil {
+		return err
+	}
+	if n != entry.Size {
+		return fmt.Errorf("%s changed while archiving", entry.Path)
+	}
+	return nil
+}
+
+// archiveWriter passes writes through to w and counts the bytes written
+type archiveWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer
+func (aw *archiveWriter) Write(p []byte) (int, error) {
+	n, err := aw.w.Write(p)
+	aw.n += int64(n)
+	return n, err
+}
+
+// archiveContentType returns the MIME type for an archive format
+func archiveContentType(format string) string {
+	if format == archiveFormatTar {
+		return "application/x-tar"
+	}
+	return "application/zip"
+}
+
+// archiveETag identifies an archive by its format and the name, size and modification time of
+// every entry; the archive layout is deterministic, so equal ETags mean identical bytes
+func archiveETag(format string, entries []*models.ArchiveEntry) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n", format)
+	for _, entry := range entries {
+		fmt.Fprintf(hash, "%s\n%d\n%d\n", entry.Name, entry.Size, entry.ModTime.Unix())
+	}
+	return fmt.Sprintf(`"%x"`, hash.Sum(nil)[:16])
+}
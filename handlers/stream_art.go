@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"media-server/models"
+	"media-server/services"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// coverArtURLFunc returns a template function building cover art URLs of the given thumbnail
+// size, signed for the requesting client when signing is enabled, which returns "" for files that
+// are not audio (or when cover art is disabled)
+func coverArtURLFunc(signer *services.URLSigner, r *http.Request, coverArtService *services.CoverArtService, size string) func(string) string {
+	return func(path string) string {
+		if coverArtService == nil || models.GetMediaType(filepath.Ext(path)) != "audio" {
+			return ""
+		}
+		return mediaQueryURLFor(signer, r, "/art/", path, url.Values{"size": {size}})
+	}
+}
+
+// HandleCoverArt serves the cover art of an audio file (/art/<path>), embedded in the file or
+// found in its folder. With ?size=small|medium|large a JPEG thumbnail is served instead of the
+// original image.
+func (sh *StreamHandler) HandleCoverArt(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		sh.setCORSHeaders(w)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/art/")
+	if sh.coverArtService == nil || models.GetMediaType(filepath.Ext(path)) != "audio" {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	clientIP := models.GetClientIP(r.RemoteAddr, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
+	w, ok := sh.verifySignedURL(w, r, clientIP, "/art/", path)
+	if !ok {
+		return
+	}
+
+	edge := 0
+	if size := r.URL.Query().Get("size"); size != "" {
+		var err error
+		if _, edge, err = models.ParseThumbnailSize(size); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	fullPath, err := sh.fileService.ValidateFilePath(path)
+	if err != nil {
+		log.Printf("File validation failed for path %s: %v", path, err)
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	art, err := sh.coverArtService.GetCoverArt(fullPath)
+	if err != nil {
+		if err != services.ErrNoCoverArt {
+			log.Printf("Error getting cover art of %s: %v", path, err)
+		}
+		http.Error(w, "Cover art not found", http.StatusNotFound)
+		return
+	}
+
+	// Thumbnails are generated from the cached image, so tracks sharing art share thumbnails too
+	imagePath, contentType := sh.coverArtService.ImagePath(art), art.MimeType
+	if edge > 0 && sh.thumbnailService != nil && models.IsThumbnailSource(imagePath) {
+		thumbPath, err := sh.thumbnailService.GetThumbnail(imagePath, edge)
+		if err != nil {
+			if err == services.ErrThumbnailBusy {
+				w.Header().Set("Retry-After", "5")
+				http.Error(w, "Thumbnail generation is busy", http.StatusServiceUnavailable)
+				return
+			}
+			log.Printf("Error generating cover art thumbnail of %s: %v", path, err)
+			http.Error(w, "Unable to generate thumbnail", http.StatusUnprocessableEntity)
+			return
+		}
+		imagePath, contentType = thumbPath, "image/jpeg"
+	}
+
+	file, err := os.Open(imagePath)
+	if err != nil {
+		log.Printf("Error opening cover art %s: %v", imagePath, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		log.Printf("Error getting file info for %s: %v", imagePath, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The image is addressed by its hash, so the ETag changes whenever the art does
+	etag := `"` + art.Hash[:16] + "-" + strconv.Itoa(edge) + `"`
+	sh.setValidatorHeaders(w, etag, fileInfo.ModTime())
+	if done, _ := sh.checkPreconditions(w, r, etag, fileInfo.ModTime()); done {
+		return
+	}
+
+	sh.setCORSHeaders(w)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(fileInfo.Size(), 10))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	io.Copy(w, file)
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// fileETag returns a strong entity tag derived from the file's identity (modification time and size).
+// Replacing or rewriting a file changes at least one of them, so the tag is safe to use with If-Range.
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// setValidatorHeaders sets the ETag and Last-Modified validators for a response
+func (sh *StreamHandler) setValidatorHeaders(w http.ResponseWriter, etag string, modTime time.Time) {
+	w.Header().Set("ETag", etag)
+	if !isZeroTime(modTime) {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+}
+
+// checkPreconditions evaluates the conditional request headers (RFC 7232) against the file validators.
+// It returns done=true when a 304 or 412 response has already been written, and otherwise the Range
+// header that should be honoured (empty when If-Range did not match and the full file must be sent).
+func (sh *StreamHandler) checkPreconditions(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) (done bool, rangeHeader string) {
+	// If-Match takes precedence over If-Unmodified-Since
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !etagListMatches(im, etag, true) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return true, ""
+		}
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && !isZeroTime(modTime) {
+		if t, err := http.ParseTime(ius); err == nil && modTime.Truncate(time.Second).After(t) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return true, ""
+		}
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etagListMatches(inm, etag, false) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				writeNotModified(w)
+			} else {
+				w.WriteHeader(http.StatusPreconditionFailed)
+			}
+			return true, ""
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !isZeroTime(modTime) &&
+		(r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		if t, err := http.ParseTime(ims); err == nil && !modTime.Truncate(time.Second).After(t) {
+			writeNotModified(w)
+			return true, ""
+		}
+	}
+
+	rangeHeader = r.Header.Get("Range")
+	if rangeHeader != "" && r.Method == http.MethodGet {
+		if ir := r.Header.Get("If-Range"); ir != "" && !ifRangeMatches(ir, etag, modTime) {
+			// The client's partial copy is stale, send the complete current file instead
+			rangeHeader = ""
+		}
+	}
+
+	return false, rangeHeader
+}
+
+// ifRangeMatches reports whether an If-Range value still describes the current file
+func ifRangeMatches(value, etag string, modTime time.Time) bool {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "W/") {
+		// If-Range requires a strong comparison
+		return !strings.HasPrefix(value, "W/") && value == etag
+	}
+
+	if isZeroTime(modTime) {
+		return false
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return false
+	}
+	return modTime.Truncate(time.Second).Equal(t)
+}
+
+// etagListMatches reports whether etag matches any entry of a comma separated If-Match/If-None-Match list
+func etagListMatches(list, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong {
+			if !strings.HasPrefix(candidate, "W/") && candidate == etag {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeNotModified writes a 304 response, dropping headers that only describe a body
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	delete(h, "Content-Type")
+	delete(h, "Content-Length")
+	delete(h, "Content-Encoding")
+	if h.Get("ETag") != "" {
+		delete(h, "Last-Modified")
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// isZeroTime reports whether t is unset or the Unix epoch
+func isZeroTime(t time.Time) bool {
+	return t.IsZero() || t.Equal(time.Unix(0, 0))
+}
@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"media-server/config"
+	"media-server/models"
 	"media-server/services"
 	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -23,6 +26,14 @@ type StreamHandler struct {
 	adminService       *services.AdminService
 	cacheService       *services.CacheService
 	performanceService *services.PerformanceService
+	mediaFolderService *services.MediaFolderService
+	bandwidthService   *services.BandwidthService
+	urlSigner          *services.URLSigner
+	hlsService         *services.HLSService
+	faststartService   *services.FaststartService
+	transcodeService   *services.TranscodeService
+	thumbnailService   *services.ThumbnailService
+	coverArtService    *services.CoverArtService
 	bufferPool         *sync.Pool
 }
 
@@ -41,7 +52,10 @@ func NewStreamHandler(cfg *config.Config) *StreamHandler {
 // NewStreamHandlerWithServices creates a new StreamHandler instance with enhanced services
 func NewStreamHandlerWithServices(cfg *config.Config, adminService *services.AdminService,
 	cacheService *services.CacheService, performanceService *services.PerformanceService,
-	mediaFolderService *services.MediaFolderService) *StreamHandler {
+	mediaFolderService *services.MediaFolderService, bandwidthService *services.BandwidthService,
+	urlSigner *services.URLSigner, hlsService *services.HLSService, faststartService *services.FaststartService,
+	transcodeService *services.TranscodeService, thumbnailService *services.ThumbnailService,
+	coverArtService *services.CoverArtService) *StreamHandler {
 
 	fileService := services.NewFileServiceWithMediaFolders(cfg.MediaDir, cacheService, performanceService, mediaFolderService)
 	fileServer := http.FileServer(http.Dir(cfg.MediaDir))
@@ -52,6 +66,14 @@ func NewStreamHandlerWithServices(cfg *config.Config, adminService *services.Adm
 		adminService:       adminService,
 		cacheService:       cacheService,
 		performanceService: performanceService,
+		mediaFolderService: mediaFolderService,
+		bandwidthService:   bandwidthService,
+		urlSigner:          urlSigner,
+		hlsService:         hlsService,
+		faststartService:   faststartService,
+		transcodeService:   transcodeService,
+		thumbnailService:   thumbnailService,
+		coverArtService:    coverArtService,
 		bufferPool:         createBufferPool(),
 	}
 }
@@ -72,6 +94,22 @@ func (sh *StreamHandler) HandleStream(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/stream/")
 	log.Printf("Streaming request for path: %s, method: %s, range: %s", path, r.Method, r.Header.Get("Range"))
 
+	// ?download=1 turns a stream link into an attachment download
+	sh.serveMedia(w, r, "/stream/", path, r.URL.Query().Get("download") == "1")
+}
+
+// HandleDownload handles explicit download requests; ranges are supported so downloads can be resumed
+func (sh *StreamHandler) HandleDownload(w http.ResponseWriter, r *http.Request) {
+	// Extract path from URL (remove /download/ prefix)
+	path := strings.TrimPrefix(r.URL.Path, "/download/")
+	log.Printf("Download request for path: %s, method: %s, range: %s", path, r.Method, r.Header.Get("Range"))
+
+	sh.serveMedia(w, r, "/download/", path, true)
+}
+
+// serveMedia serves a media file requested on route either inline for playback or as an
+// attachment download
+func (sh *StreamHandler) serveMedia(w http.ResponseWriter, r *http.Request, route, path string, download bool) {
 	// Handle OPTIONS requests for CORS
 	if r.Method == "OPTIONS" {
 		sh.setCORSHeaders(w)
@@ -79,6 +117,14 @@ func (sh *StreamHandler) HandleStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	clientIP := models.GetClientIP(r.RemoteAddr, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
+
+	// Reject unsigned or expired links when signed stream URLs are enabled
+	w, ok := sh.verifySignedURL(w, r, clientIP, route, path)
+	if !ok {
+		return
+	}
+
 	// Validate the file path
 	fullPath, err := sh.fileService.ValidateFilePath(path)
 	if err != nil {
@@ -97,8 +143,30 @@ func (sh *StreamHandler) HandleStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Open the content to serve; MP4s with the moov box at the end are played from a virtual
+	// faststart layout, while downloads always get the original bytes
+	content, size, relocated, err := sh.openContent(fullPath, fileInfo, !download)
+	if err != nil {
+		log.Printf("Error opening file %s: %v", fullPath, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	// Set validators and evaluate conditional request headers
+	etag := fileETag(fileInfo)
+	if relocated {
+		etag = strings.TrimSuffix(etag, `"`) + `-faststart"`
+	}
+	sh.setValidatorHeaders(w, etag, fileInfo.ModTime())
+	done, rangeHeader := sh.checkPreconditions(w, r, etag, fileInfo.ModTime())
+	if done {
+		log.Printf("Conditional request for %s answered without body", path)
+		return
+	}
+
 	// Set basic streaming headers
-	sh.setBasicStreamingHeaders(w, path, fileInfo.Size())
+	sh.setBasicStreamingHeaders(w, path, size, download)
 
 	// Handle HEAD requests
 	if r.Method == "HEAD" {
@@ -106,18 +174,108 @@ func (sh *StreamHandler) HandleStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Admit the stream (range requests join the client's ongoing playback of this file)
+	sessionPath := sh.sessionPath(path)
+	if sh.adminService != nil {
+		if err := sh.adminService.StartStream(clientIP, sessionPath, download, size); err != nil {
+			sh.rejectStream(w, r, clientIP, sessionPath, err)
+			return
+		}
+	}
+	startTime := time.Now()
+
+	// Apply bandwidth limits for the body transfer
+	limiter := sh.newStreamLimiter(r, clientIP, fullPath)
+	if limiter != nil {
+		defer limiter.Close()
+	}
+
 	// Handle range requests for progressive streaming
-	if r.Header.Get("Range") != "" {
-		log.Printf("Handling range request: %s", r.Header.Get("Range"))
-		sh.handleRangeRequest(w, r, fullPath, fileInfo.Size())
+	var written int64
+	if rangeHeader != "" {
+		log.Printf("Handling range request: %s", rangeHeader)
+		written = sh.handleRangeRequest(w, r, rangeHeader, content, fullPath, size, limiter)
 	} else {
 		log.Printf("Serving complete file")
-		sh.serveCompleteFile(w, r, fullPath)
+		written = sh.serveCompleteFile(w, r, content, fullPath, limiter)
+	}
+
+	// Track streaming end
+	if sh.adminService != nil {
+		position := streamPosition(rangeHeader, size, written)
+		sh.adminService.EndStream(clientIP, sessionPath, download, position, written, time.Since(startTime))
+	}
+}
+
+// openContent opens the bytes served for a file. With faststart allowed, MP4 files that have
+// their moov box after the media data are opened as a virtual file with the moov box in front;
+// relocated reports whether that happened and size is the size of the content served.
+func (sh *StreamHandler) openContent(fullPath string, fileInfo os.FileInfo, faststart bool) (content io.ReadSeekCloser, size int64, relocated bool, err error) {
+	if faststart && sh.faststartService != nil && models.IsFaststartCandidate(fullPath) {
+		layout, err := sh.faststartService.GetLayout(fullPath)
+		if err != nil {
+			log.Printf("Error reading MP4 layout of %s: %v", fullPath, err)
+		} else if layout.Relocated && layout.IsValidFor(fileInfo.Size(), fileInfo.ModTime()) {
+			reader, err := sh.faststartService.Open(layout)
+			if err != nil {
+				return nil, 0, false, err
+			}
+			return reader, reader.Size(), true, nil
+		}
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return file, fileInfo.Size(), false, nil
+}
+
+// rejectStream answers a request refused by stream admission control
+func (sh *StreamHandler) rejectStream(w http.ResponseWriter, r *http.Request, clientIP, path string, err error) {
+	status := http.StatusServiceUnavailable
+	if limitErr, ok := err.(*services.StreamLimitError); ok {
+		retryAfter := int64(math.Ceil(limitErr.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+		if limitErr.Scope != "global" {
+			status = http.StatusTooManyRequests
+		}
 	}
+
+	log.Printf("Stream rejected for %s (%s): %v", clientIP, path, err)
+	sh.adminService.LogActivity(clientIP, "stream_rejected", path, r.UserAgent(), false, err.Error())
+
+	w.Header().Del("Content-Length")
+	http.Error(w, "Too many concurrent streams", status)
+}
+
+// sessionPath returns the canonical media path the playback sessions of path are tracked under,
+// so that requests through either URL form of a file join the same session
+func (sh *StreamHandler) sessionPath(path string) string {
+	if canonical, err := sh.fileService.CanonicalPath(path); err == nil {
+		return canonical
+	}
+	return path
+}
+
+// newStreamLimiter creates a bandwidth limiter for the request, or nil when throttling is not configured
+func (sh *StreamHandler) newStreamLimiter(r *http.Request, clientIP, fullPath string) *services.StreamLimiter {
+	if sh.bandwidthService == nil {
+		return nil
+	}
+
+	folderID := ""
+	if sh.mediaFolderService != nil {
+		if folder := sh.mediaFolderService.FolderForPath(fullPath); folder != nil {
+			folderID = folder.ID
+		}
+	}
+
+	return sh.bandwidthService.NewStreamLimiter(r.Context(), clientIP, folderID)
 }
 
-// setBasicStreamingHeaders sets basic headers for media file streaming
-func (sh *StreamHandler) setBasicStreamingHeaders(w http.ResponseWriter, path string, fileSize int64) {
+// setBasicStreamingHeaders sets basic headers for media file streaming or downloading
+func (sh *StreamHandler) setBasicStreamingHeaders(w http.ResponseWriter, path string, fileSize int64, download bool) {
 	// Get file extension and set proper content type
 	ext := strings.ToLower(filepath.Ext(path))
 	contentType := sh.getContentType(ext)
@@ -126,8 +284,12 @@ func (sh *StreamHandler) setBasicStreamingHeaders(w http.ResponseWriter, path st
 	// Enable range requests for media files (essential for video streaming)
 	w.Header().Set("Accept-Ranges", "bytes")
 
-	// Set content disposition to inline for streaming (not download)
-	w.Header().Set("Content-Disposition", "inline")
+	// Set content disposition to inline for streaming, or attachment for downloads
+	if download {
+		w.Header().Set("Content-Disposition", attachmentDisposition(filepath.Base(path)))
+	} else {
+		w.Header().Set("Content-Disposition", "inline")
+	}
 
 	// Set cache headers for better performance
 	w.Header().Set("Cache-Control", "public, max-age=3600")
@@ -143,8 +305,8 @@ func (sh *StreamHandler) setBasicStreamingHeaders(w http.ResponseWriter, path st
 func (sh *StreamHandler) setCORSHeaders(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Range, Content-Type")
-	w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges")
+	w.Header().Set("Access-Control-Allow-Headers", "Range, Content-Type, If-Range, If-None-Match, If-Modified-Since")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges, ETag, Last-Modified, Content-Disposition")
 }
 
 // getContentType returns the appropriate MIME type for media files
@@ -211,7 +373,7 @@ func (sh *StreamHandler) getContentType(ext string) string {
 		return "image/webp"
 	case ".svg":
 		return "image/svg+xml"
-	case ".tiff":
+	case ".tiff", ".tif":
 		return "image/tiff"
 	case ".ico":
 		return "image/x-icon"
@@ -220,155 +382,160 @@ func (sh *StreamHandler) getContentType(ext string) string {
 	}
 }
 
-// handleRangeRequest handles HTTP range requests for progressive streaming
-func (sh *StreamHandler) handleRangeRequest(w http.ResponseWriter, r *http.Request, filePath string, fileSize int64) {
-	rangeHeader := r.Header.Get("Range")
+// attachmentDisposition builds an RFC 6266 attachment Content-Disposition with an ASCII fallback
+// filename and an RFC 5987 UTF-8 encoded filename* parameter
+func attachmentDisposition(filename string) string {
+	var fallback, encoded strings.Builder
 
-	// Parse range header (format: "bytes=start-end")
-	if !strings.HasPrefix(rangeHeader, "bytes=") {
-		log.Printf("Invalid range header format: %s", rangeHeader)
-		http.Error(w, "Invalid range header", http.StatusBadRequest)
-		return
-	}
-
-	rangeSpec := strings.TrimPrefix(rangeHeader, "bytes=")
-	ranges := strings.Split(rangeSpec, ",")
-
-	// Handle only the first range for simplicity
-	if len(ranges) == 0 {
-		log.Printf("No ranges found in header: %s", rangeHeader)
-		http.Error(w, "Invalid range header", http.StatusBadRequest)
-		return
-	}
-
-	rangeParts := strings.Split(strings.TrimSpace(ranges[0]), "-")
-	if len(rangeParts) != 2 {
-		log.Printf("Invalid range format: %s", ranges[0])
-		http.Error(w, "Invalid range header", http.StatusBadRequest)
-		return
-	}
-
-	var start, end int64
-	var err error
-
-	// Parse start position
-	if rangeParts[0] != "" {
-		start, err = strconv.ParseInt(rangeParts[0], 10, 64)
-		if err != nil {
-			log.Printf("Invalid range start: %s", rangeParts[0])
-			http.Error(w, "Invalid range start", http.StatusBadRequest)
-			return
+	for _, r := range filename {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' || r == '%' {
+			fallback.WriteByte('_')
+		} else {
+			fallback.WriteRune(r)
 		}
-	} else {
-		start = 0
 	}
 
-	// Parse end position
-	if rangeParts[1] != "" {
-		end, err = strconv.ParseInt(rangeParts[1], 10, 64)
-		if err != nil {
-			log.Printf("Invalid range end: %s", rangeParts[1])
-			http.Error(w, "Invalid range end", http.StatusBadRequest)
-			return
+	const attrChars = "!#$&+-.^_`|~"
+	for _, b := range []byte(filename) {
+		if ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') || strings.IndexByte(attrChars, b) >= 0 {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
 		}
-	} else {
-		// If no end specified, serve to end of file
-		end = fileSize - 1
 	}
 
-	// Validate range
-	if start < 0 || end >= fileSize || start > end {
-		log.Printf("Range not satisfiable: %d-%d (file size: %d)", start, end, fileSize)
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, fallback.String(), encoded.String())
+}
+
+// handleRangeRequest handles HTTP range requests for progressive streaming and returns the body bytes written
+func (sh *StreamHandler) handleRangeRequest(w http.ResponseWriter, r *http.Request, rangeHeader string, content io.ReadSeeker,
+	filePath string, fileSize int64, limiter *services.StreamLimiter) int64 {
+	// Parse range header (format: "bytes=start-end[,start-end...]" or "bytes=-suffix")
+	ranges, err := parseRangeHeader(rangeHeader, fileSize)
+	switch err {
+	case nil:
+	case errUnsupportedRangeUnit:
+		// RFC 7233 requires ignoring range units we do not understand
+		log.Printf("Ignoring range header with unsupported unit: %s", rangeHeader)
+		return sh.serveCompleteFile(w, r, content, filePath, limiter)
+	case errNoOverlap, errTooManyRanges:
+		log.Printf("Range not satisfiable: %s (file size: %d)", rangeHeader, fileSize)
 		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", fileSize))
 		http.Error(w, "Range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
-		return
+		return 0
+	default:
+		log.Printf("Invalid range header format: %s", rangeHeader)
+		http.Error(w, "Invalid range header", http.StatusBadRequest)
+		return 0
 	}
 
-	log.Printf("Serving range: %d-%d/%d", start, end, fileSize)
+	// Merge overlapping and nearly adjacent ranges before deciding how to respond
+	ranges = coalesceRanges(ranges, rangeCoalesceGap)
+	if len(ranges) > maxRanges {
+		// Too many disjoint ranges to be worth the multipart overhead, send the whole file
+		log.Printf("Range header requested %d disjoint ranges (max %d), serving complete file", len(ranges), maxRanges)
+		return sh.serveCompleteFile(w, r, content, filePath, limiter)
+	}
 
-	// Open file
-	file, err := os.Open(filePath)
-	if err != nil {
-		log.Printf("Error opening file %s: %v", filePath, err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+	if len(ranges) == 1 {
+		return sh.serveSingleRange(w, content, filePath, ranges[0], fileSize, limiter)
 	}
-	defer file.Close()
+	return sh.serveMultipleRanges(w, content, filePath, ranges, fileSize, limiter)
+}
+
+// serveSingleRange serves one byte range as a plain 206 response
+func (sh *StreamHandler) serveSingleRange(w http.ResponseWriter, content io.ReadSeeker, filePath string, ra httpRange, fileSize int64,
+	limiter *services.StreamLimiter) int64 {
+	log.Printf("Serving range: %d-%d/%d", ra.start, ra.end(), fileSize)
 
 	// Seek to start position
-	_, err = file.Seek(start, 0)
-	if err != nil {
+	if _, err := content.Seek(ra.start, io.SeekStart); err != nil {
 		log.Printf("Error seeking file %s: %v", filePath, err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		return 0
 	}
 
 	// Set range response headers (override content-length from basic headers)
-	contentLength := end - start + 1
-	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, fileSize))
-	w.Header().Set("Content-Length", strconv.FormatInt(contentLength, 10))
+	w.Header().Set("Content-Range", ra.contentRange(fileSize))
+	w.Header().Set("Content-Length", strconv.FormatInt(ra.length, 10))
 	w.WriteHeader(http.StatusPartialContent)
 
 	// Copy the requested range using optimized buffer
-	written, err := sh.copyNWithBuffer(w, file, contentLength)
+	written, err := sh.copyNWithBuffer(w, content, ra.length, limiter)
 	if err != nil {
 		log.Printf("Error copying file range %s (wrote %d bytes): %v", filePath, written, err)
 	} else {
 		log.Printf("Successfully served %d bytes", written)
 	}
+	return written
 }
 
-// serveCompleteFile serves the complete file for non-range requests with optimized streaming
-func (sh *StreamHandler) serveCompleteFile(w http.ResponseWriter, r *http.Request, filePath string) {
-	startTime := time.Now()
+// serveMultipleRanges serves several byte ranges as a multipart/byteranges response
+func (sh *StreamHandler) serveMultipleRanges(w http.ResponseWriter, content io.ReadSeeker, filePath string, ranges []httpRange, fileSize int64,
+	limiter *services.StreamLimiter) int64 {
+	log.Printf("Serving %d ranges of %s as multipart/byteranges", len(ranges), filePath)
 
-	// Track streaming start
-	if sh.adminService != nil {
-		sh.adminService.StartStream()
-	}
+	partContentType := w.Header().Get("Content-Type")
+	mw := multipart.NewWriter(w)
+	bodySize := multipartRangesSize(ranges, mw.Boundary(), partContentType, fileSize)
 
-	// Open file
-	file, err := os.Open(filePath)
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.Header().Set("Content-Length", strconv.FormatInt(bodySize, 10))
+	w.WriteHeader(http.StatusPartialContent)
+
+	copyN := func(dst io.Writer, src io.Reader, n int64) (int64, error) {
+		return sh.copyNWithBuffer(dst, src, n, limiter)
+	}
+	written, err := writeMultipartRanges(mw, content, ranges, partContentType, fileSize, copyN)
 	if err != nil {
-		log.Printf("Error opening file %s: %v", filePath, err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		log.Printf("Error copying file ranges %s (wrote %d bytes): %v", filePath, written, err)
+	} else {
+		log.Printf("Successfully served %d bytes in %d ranges", written, len(ranges))
 	}
-	defer file.Close()
+	return written
+}
+
+// serveCompleteFile serves the complete file for non-range requests with optimized streaming
+// and returns the body bytes written
+func (sh *StreamHandler) serveCompleteFile(w http.ResponseWriter, r *http.Request, content io.Reader, filePath string,
+	limiter *services.StreamLimiter) int64 {
+	startTime := time.Now()
 
 	// Set status OK and copy file content using optimized buffer
 	w.WriteHeader(http.StatusOK)
-	written, err := sh.copyWithBuffer(w, file)
+	written, err := sh.copyWithBuffer(w, content, limiter)
 
-	// Track streaming end
 	duration := time.Since(startTime)
-	if sh.adminService != nil {
-		sh.adminService.EndStream(written, duration)
-	}
-
 	if err != nil {
 		log.Printf("Error copying file %s (wrote %d bytes): %v", filePath, written, err)
 	} else {
 		log.Printf("Successfully served complete file: %d bytes in %v", written, duration)
 	}
+	return written
 }
 
-// copyWithBuffer copies data using a pooled buffer for better performance
-func (sh *StreamHandler) copyWithBuffer(dst io.Writer, src io.Reader) (int64, error) {
+// copyWithBuffer copies data using a pooled buffer for better performance, throttled by limiter when set
+func (sh *StreamHandler) copyWithBuffer(dst io.Writer, src io.Reader, limiter *services.StreamLimiter) (int64, error) {
 	// Get buffer from pool
 	buffer := sh.bufferPool.Get().([]byte)
 	defer sh.bufferPool.Put(buffer)
 
+	if limiter != nil {
+		return limiter.CopyBuffer(dst, src, buffer)
+	}
 	return io.CopyBuffer(dst, src, buffer)
 }
 
-// copyNWithBuffer copies N bytes using a pooled buffer for better performance
-func (sh *StreamHandler) copyNWithBuffer(dst io.Writer, src io.Reader, n int64) (int64, error) {
+// copyNWithBuffer copies N bytes using a pooled buffer for better performance, throttled by limiter when set
+func (sh *StreamHandler) copyNWithBuffer(dst io.Writer, src io.Reader, n int64, limiter *services.StreamLimiter) (int64, error) {
 	// Get buffer from pool
 	buffer := sh.bufferPool.Get().([]byte)
 	defer sh.bufferPool.Put(buffer)
 
 	// Use a limited reader to ensure we don't read more than n bytes
 	limitedReader := io.LimitReader(src, n)
+	if limiter != nil {
+		return limiter.CopyBuffer(dst, limitedReader, buffer)
+	}
 	return io.CopyBuffer(dst, limitedReader, buffer)
 }
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"media-server/models"
+	"media-server/services"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hlsPlaylistName is the name of the media playlist served for a transport stream
+const hlsPlaylistName = "index.m3u8"
+
+// hlsPlaylistURL returns the HLS playlist URL for a transport stream media path, signed for the
+// requesting client when signing is enabled
+func hlsPlaylistURL(signer *services.URLSigner, r *http.Request, mediaPath string) string {
+	return resourceURLFor(signer, r, "/hls/", mediaPath, hlsPlaylistName, nil)
+}
+
+// HandleHLS serves the HLS playlist (/hls/<path>/index.m3u8) and segments
+// (/hls/<path>/segment-<n>.ts) of an MPEG transport stream, straight from the original file
+func (sh *StreamHandler) HandleHLS(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		sh.setCORSHeaders(w)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/hls/")
+	mediaPath, resource := path.Split(rest)
+	mediaPath = strings.TrimSuffix(mediaPath, "/")
+	log.Printf("HLS request for path: %s, resource: %s", mediaPath, resource)
+
+	if sh.hlsService == nil || !models.IsHLSSource(mediaPath) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	// One signature of the media path covers its playlist and all of its segments
+	clientIP := models.GetClientIP(r.RemoteAddr, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
+	w, ok := sh.verifySignedURL(w, r, clientIP, "/hls/", mediaPath)
+	if !ok {
+		return
+	}
+
+	fullPath, err := sh.fileService.ValidateFilePath(mediaPath)
+	if err != nil {
+		log.Printf("File validation failed for path %s: %v", mediaPath, err)
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	index, err := sh.hlsService.GetIndex(fullPath)
+	if err != nil {
+		if err == services.ErrHLSBusy {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "HLS packaging is busy", http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("Error packaging %s as HLS: %v", mediaPath, err)
+		http.Error(w, "Unable to package file for HLS", http.StatusUnprocessableEntity)
+		return
+	}
+
+	sh.setCORSHeaders(w)
+
+	if resource == hlsPlaylistName {
+		sh.serveHLSPlaylist(w, r, index)
+		return
+	}
+
+	segmentNumber, ok := parseSegmentName(resource)
+	if !ok || segmentNumber >= len(index.Segments) {
+		http.Error(w, "Segment not found", http.StatusNotFound)
+		return
+	}
+	sh.serveHLSSegment(w, r, mediaPath, index, segmentNumber)
+}
+
+// parseSegmentName extracts n from "segment-<n>.ts"
+func parseSegmentName(name string) (int, bool) {
+	if !strings.HasPrefix(name, "segment-") || !strings.HasSuffix(name, ".ts") {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "segment-"), ".ts"))
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// serveHLSPlaylist writes the VOD media playlist of an indexed transport stream
+func (sh *StreamHandler) serveHLSPlaylist(w http.ResponseWriter, r *http.Request, index *models.HLSIndex) {
+	// Carry the query over to the segment requests: a media password, and the verified signature
+	// of the stream, which signs every segment URL when signing is enabled
+	query := ""
+	if r.URL.RawQuery != "" {
+		query = "?" + r.URL.RawQuery
+	}
+
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n")
+	playlist.WriteString("#EXT-X-VERSION:3\n")
+	playlist.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	fmt.Fprintf(&playlist, "#EXT-X-TARGETDURATION:%d\n", index.TargetDuration())
+	playlist.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	for i, segment := range index.Segments {
+		fmt.Fprintf(&playlist, "#EXTINF:%.3f,\n", segment.Duration)
+		fmt.Fprintf(&playlist, "segment-%d.ts%s\n", i, query)
+	}
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Content-Length", strconv.Itoa(playlist.Len()))
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	io.WriteString(w, playlist.String())
+}
+
+// serveHLSSegment writes one segment: the PAT and PMT packets, so that every segment can be
+// decoded on its own, followed by the segment's packets from the source file
+func (sh *StreamHandler) serveHLSSegment(w http.ResponseWriter, r *http.Request, mediaPath string, index *models.HLSIndex, n int) {
+	segment := index.Segments[n]
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Content-Length", strconv.FormatInt(index.SegmentSize(segment), 10))
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	file, err := os.Open(index.Path)
+	if err != nil {
+		log.Printf("Error opening file %s: %v", index.Path, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	prefix := int64(index.PacketSize - models.TSPacketSize)
+	tables := make([]byte, 2*models.TSPacketSize)
+	_, err = file.ReadAt(tables[:models.TSPacketSize], index.PATOffset+prefix)
+	if err == nil {
+		_, err = file.ReadAt(tables[models.TSPacketSize:], index.PMTOffset+prefix)
+	}
+	if err != nil {
+		log.Printf("Error reading program tables of %s: %v", index.Path, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	clientIP := models.GetClientIP(r.RemoteAddr, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
+
+	// Segments of one file join the client's playback session like range requests do
+	sessionPath := sh.sessionPath(mediaPath)
+	if sh.adminService != nil {
+		if err := sh.adminService.StartStream(clientIP, sessionPath, false, index.Size); err != nil {
+			sh.rejectStream(w, r, clientIP, sessionPath, err)
+			return
+		}
+	}
+	startTime := time.Now()
+
+	limiter := sh.newStreamLimiter(r, clientIP, index.Path)
+	if limiter != nil {
+		defer limiter.Close()
+	}
+
+	w.WriteHeader(http.StatusOK)
+	written, err := w.Write(tables)
+	var body int64
+	if err == nil {
+		var src io.Reader = io.NewSectionReader(file, segment.Offset, segment.Length)
+		if prefix > 0 {
+			src = &m2tsReader{src: src, packet: make([]byte, index.PacketSize)}
+		}
+		body, err = sh.copyWithBuffer(w, src, limiter)
+	}
+	if err != nil {
+		log.Printf("Error serving HLS segment %d of %s: %v", n, mediaPath, err)
+	}
+
+	if sh.adminService != nil {
+		position := segment.Offset + body*int64(index.PacketSize)/models.TSPacketSize
+		sh.adminService.EndStream(clientIP, sessionPath, false, position, int64(written)+body, time.Since(startTime))
+	}
+}
+
+// m2tsReader strips the 4-byte timestamp prefix from 192-byte M2TS packets, producing plain TS
+type m2tsReader struct {
+	src     io.Reader
+	packet  []byte
+	pending []byte
+}
+
+// Read implements io.Reader
+func (mr *m2tsReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(mr.pending) == 0 {
+			if _, err := io.ReadFull(mr.src, mr.packet); err != nil {
+				if err == io.ErrUnexpectedEOF {
+					err = io.EOF
+				}
+				if n > 0 {
+					return n, nil
+				}
+				return 0, err
+			}
+			mr.pending = mr.packet[len(mr.packet)-models.TSPacketSize:]
+		}
+
+		copied := copy(p[n:], mr.pending)
+		mr.pending = mr.pending[copied:]
+		n += copied
+	}
+	return n, nil
+}
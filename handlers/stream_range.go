@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxRangeSpecs is the number of comma separated specs accepted in a single Range header
+	maxRangeSpecs = 100
+	// maxRanges is the number of parts served in a multipart/byteranges response after coalescing
+	maxRanges = 16
+	// rangeCoalesceGap merges ranges separated by fewer bytes than a part header costs
+	rangeCoalesceGap = 128
+)
+
+var (
+	// errInvalidRange is returned when the Range header is malformed
+	errInvalidRange = errors.New("invalid range")
+	// errNoOverlap is returned when none of the requested ranges overlap the file
+	errNoOverlap = errors.New("invalid range: failed to overlap")
+	// errUnsupportedRangeUnit is returned when the Range header uses a unit other than bytes
+	errUnsupportedRangeUnit = errors.New("unsupported range unit")
+	// errTooManyRanges is returned when the Range header asks for more ranges than we serve
+	errTooManyRanges = errors.New("too many ranges")
+)
+
+// httpRange represents a single byte range of a file
+type httpRange struct {
+	start  int64
+	length int64
+}
+
+// end returns the inclusive last byte offset of the range
+func (r httpRange) end() int64 {
+	return r.start + r.length - 1
+}
+
+// contentRange returns the Content-Range header value for the range
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end(), size)
+}
+
+// mimeHeader returns the part header used for the range in a multipart/byteranges body
+func (r httpRange) mimeHeader(contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {r.contentRange(size)},
+		"Content-Type":  {contentType},
+	}
+}
+
+// parseRangeHeader parses a Range header (RFC 7233) against a file of the given size.
+// Unsatisfiable ranges are dropped; errNoOverlap is returned if nothing is left.
+func parseRangeHeader(header string, size int64) ([]httpRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		if strings.Contains(header, "=") {
+			return nil, errUnsupportedRangeUnit
+		}
+		return nil, errInvalidRange
+	}
+
+	specs := strings.Split(strings.TrimPrefix(header, prefix), ",")
+	if len(specs) > maxRangeSpecs {
+		return nil, errTooManyRanges
+	}
+
+	var ranges []httpRange
+	noOverlap := false
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		startStr, endStr, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, errInvalidRange
+		}
+		startStr, endStr = strings.TrimSpace(startStr), strings.TrimSpace(endStr)
+
+		var r httpRange
+		if startStr == "" {
+			// Suffix range: "-N" means the last N bytes of the file
+			if endStr == "" {
+				return nil, errInvalidRange
+			}
+			suffix, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || suffix < 0 {
+				return nil, errInvalidRange
+			}
+			if suffix == 0 || size == 0 {
+				noOverlap = true
+				continue
+			}
+			if suffix > size {
+				suffix = size
+			}
+			r.start = size - suffix
+			r.length = suffix
+		} else {
+			start, err := strconv.ParseInt(startStr, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errInvalidRange
+			}
+			if start >= size {
+				// Range starts beyond the end of the file
+				noOverlap = true
+				continue
+			}
+			r.start = start
+
+			if endStr == "" {
+				// No end specified, serve to end of file
+				r.length = size - start
+			} else {
+				end, err := strconv.ParseInt(endStr, 10, 64)
+				if err != nil || start > end {
+					return nil, errInvalidRange
+				}
+				if end >= size {
+					end = size - 1
+				}
+				r.length = end - start + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		if noOverlap {
+			return nil, errNoOverlap
+		}
+		return nil, errInvalidRange
+	}
+
+	return ranges, nil
+}
+
+// coalesceRanges sorts ranges and merges those that overlap or are separated by at most gap bytes
+func coalesceRanges(ranges []httpRange, gap int64) []httpRange {
+	if len(ranges) < 2 {
+		return ranges
+	}
+
+	sorted := make([]httpRange, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].start < sorted[j].start
+	})
+
+	merged := make([]httpRange, 0, len(sorted))
+	current := sorted[0]
+	for _, r := range sorted[1:] {
+		if r.start <= current.end()+1+gap {
+			if r.end() > current.end() {
+				current.length = r.end() - current.start + 1
+			}
+			continue
+		}
+		merged = append(merged, current)
+		current = r
+	}
+	merged = append(merged, current)
+
+	return merged
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter int64
+
+// Write implements io.Writer
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// multipartRangesSize returns the exact body size of a multipart/byteranges response
+func multipartRangesSize(ranges []httpRange, boundary, contentType string, size int64) int64 {
+	var counter countingWriter
+	mw := multipart.NewWriter(&counter)
+	mw.SetBoundary(boundary)
+
+	var encSize int64
+	for _, r := range ranges {
+		mw.CreatePart(r.mimeHeader(contentType, size))
+		encSize += r.length
+	}
+	mw.Close()
+
+	return encSize + int64(counter)
+}
+
+// writeMultipartRanges writes ranges of src as a multipart/byteranges body using copyN for the payload
+func writeMultipartRanges(mw *multipart.Writer, src io.ReadSeeker, ranges []httpRange, contentType string, size int64,
+	copyN func(dst io.Writer, src io.Reader, n int64) (int64, error)) (int64, error) {
+
+	var written int64
+	for _, r := range ranges {
+		part, err := mw.CreatePart(r.mimeHeader(contentType, size))
+		if err != nil {
+			return written, err
+		}
+
+		if _, err := src.Seek(r.start, io.SeekStart); err != nil {
+			return written, err
+		}
+
+		n, err := copyN(part, src, r.length)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, mw.Close()
+}
+
+// streamPosition returns the byte offset a response reached in the file: the end of the served
+// range, the bytes written for a complete file, or -1 for multipart and failed range responses
+func streamPosition(rangeHeader string, size, written int64) int64 {
+	if rangeHeader == "" {
+		return written
+	}
+
+	ranges, err := parseRangeHeader(rangeHeader, size)
+	if err == errUnsupportedRangeUnit {
+		// The range was ignored and the complete file served
+		return written
+	}
+	if err != nil {
+		return -1
+	}
+
+	ranges = coalesceRanges(ranges, rangeCoalesceGap)
+	switch {
+	case len(ranges) == 1:
+		return ranges[0].start + written
+	case len(ranges) > maxRanges:
+		return written
+	default:
+		return -1
+	}
+}
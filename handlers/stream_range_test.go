@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// disjointRanges returns a Range header with n ranges of 10 bytes that coalescing cannot merge
+func disjointRanges(n int) string {
+	specs := make([]string, n)
+	for i := range specs {
+		start := i * (rangeCoalesceGap + 100)
+		specs[i] = fmt.Sprintf("%d-%d", start, start+9)
+	}
+	return "bytes=" + strings.Join(specs, ",")
+}
+
+func TestParseRangeHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		size    int64
+		want    []httpRange
+		wantErr error
+	}{
+		{"closed", "bytes=0-99", 1000, []httpRange{{0, 100}}, nil},
+		{"single byte", "bytes=5-5", 1000, []httpRange{{5, 1}}, nil},
+		{"open-ended", "bytes=500-", 1000, []httpRange{{500, 500}}, nil},
+		{"suffix", "bytes=-100", 1000, []httpRange{{900, 100}}, nil},
+		{"suffix longer than the file", "bytes=-2000", 1000, []httpRange{{0, 1000}}, nil},
+		{"end beyond the file", "bytes=900-2000", 1000, []httpRange{{900, 100}}, nil},
+		{"several with spaces", "bytes=0-0, -1 ,10-19", 1000, []httpRange{{0, 1}, {999, 1}, {10, 10}}, nil},
+		{"empty specs skipped", "bytes=0-9,,", 1000, []httpRange{{0, 10}}, nil},
+		{"unsatisfiable dropped", "bytes=1000-,0-9", 1000, []httpRange{{0, 10}}, nil},
+		{"start at the end", "bytes=1000-", 1000, nil, errNoOverlap},
+		{"zero suffix", "bytes=-0", 1000, nil, errNoOverlap},
+		{"empty file", "bytes=0-", 0, nil, errNoOverlap},
+		{"suffix of an empty file", "bytes=-5", 0, nil, errNoOverlap},
+		{"start after end", "bytes=5-4", 1000, nil, errInvalidRange},
+		{"no dash", "bytes=5", 1000, nil, errInvalidRange},
+		{"no bounds", "bytes=-", 1000, nil, errInvalidRange},
+		{"not a number", "bytes=a-b", 1000, nil, errInvalidRange},
+		{"negative start", "bytes=-5-10", 1000, nil, errInvalidRange},
+		{"no specs", "bytes=", 1000, nil, errInvalidRange},
+		{"no unit", "0-99", 1000, nil, errInvalidRange},
+		{"other unit", "items=0-99", 1000, nil, errUnsupportedRangeUnit},
+		{"spec limit", "bytes=" + strings.Repeat("0-0,", maxRangeSpecs-1) + "0-0", 1000, nil, nil},
+		{"too many specs", "bytes=" + strings.Repeat("0-0,", maxRangeSpecs) + "0-0", 1000, nil, errTooManyRanges},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRangeHeader(tt.header, tt.size)
+			if err != tt.wantErr {
+				t.Fatalf("parseRangeHeader(%q, %d) error = %v, want %v", tt.header, tt.size, err, tt.wantErr)
+			}
+			if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRangeHeader(%q, %d) = %v, want %v", tt.header, tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoalesceRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []httpRange
+		want   []httpRange
+	}{
+		{"single", []httpRange{{10, 5}}, []httpRange{{10, 5}}},
+		{"overlapping", []httpRange{{0, 100}, {50, 100}}, []httpRange{{0, 150}}},
+		{"contained", []httpRange{{0, 100}, {10, 10}}, []httpRange{{0, 100}}},
+		{"adjacent", []httpRange{{0, 10}, {10, 10}}, []httpRange{{0, 20}}},
+		{"gap at the limit", []httpRange{{0, 10}, {10 + rangeCoalesceGap, 10}}, []httpRange{{0, 20 + rangeCoalesceGap}}},
+		{"gap beyond the limit", []httpRange{{0, 10}, {11 + rangeCoalesceGap, 10}}, []httpRange{{0, 10}, {11 + rangeCoalesceGap, 10}}},
+		{"unsorted", []httpRange{{1000, 10}, {0, 10}, {5, 10}}, []httpRange{{0, 15}, {1000, 10}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := append([]httpRange(nil), tt.ranges...)
+			got := coalesceRanges(input, rangeCoalesceGap)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("coalesceRanges(%v) = %v, want %v", tt.ranges, got, tt.want)
+			}
+			if !reflect.DeepEqual(input, tt.ranges) {
+				t.Errorf("coalesceRanges modified its input: %v", input)
+			}
+		})
+	}
+}
+
+func TestHandleRangeRequest(t *testing.T) {
+	const size = 10000
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+		wantParts  []httpRange // for multipart responses
+	}{
+		{"single range", "bytes=100-199", http.StatusPartialContent, nil},
+		{"suffix range", "bytes=-10", http.StatusPartialContent, nil},
+		{"coalesced into one", "bytes=0-9,20-29", http.StatusPartialContent, nil},
+		{"multipart", "bytes=0-9,500-,-10", http.StatusPartialContent, []httpRange{{0, 10}, {500, size - 500}}},
+		{"multipart at the range limit", disjointRanges(maxRanges), http.StatusPartialContent, nil},
+		{"complete file above the range limit", disjointRanges(maxRanges + 1), http.StatusOK, nil},
+		{"other unit", "items=0-9", http.StatusOK, nil},
+		{"unsatisfiable", "bytes=10000-", http.StatusRequestedRangeNotSatisfiable, nil},
+		{"too many specs", disjointRanges(maxRangeSpecs + 1), http.StatusRequestedRangeNotSatisfiable, nil},
+		{"invalid", "bytes=9-0", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sh := &StreamHandler{bufferPool: createBufferPool()}
+			r := httptest.NewRequest(http.MethodGet, "/stream/video.mp4", nil)
+			r.Header.Set("Range", tt.header)
+			rec := httptest.NewRecorder()
+			rec.Header().Set("Content-Type", "video/mp4")
+
+			written := sh.handleRangeRequest(rec, r, tt.header, bytes.NewReader(content), "video.mp4", size, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			body := rec.Body.Bytes()
+			switch {
+			case rec.Code == http.StatusOK:
+				if !bytes.Equal(body, content) || written != size {
+					t.Errorf("served %d bytes (%d written), want the complete file", len(body), written)
+				}
+			case rec.Code == http.StatusRequestedRangeNotSatisfiable:
+				if got := rec.Header().Get("Content-Range"); got != "bytes */10000" {
+					t.Errorf("Content-Range = %q, want %q", got, "bytes */10000")
+				}
+			case rec.Code == http.StatusPartialContent:
+				checkPartialContent(t, rec, content, written, tt.header, tt.wantParts)
+			}
+		})
+	}
+}
+
+// checkPartialContent checks a 206 response against the coalesced ranges of header, and that
+// its Content-Length matches the body actually written
+func checkPartialContent(t *testing.T, rec *httptest.ResponseRecorder, content []byte, written int64, header string, wantParts []httpRange) {
+	t.Helper()
+
+	size := int64(len(content))
+	ranges, err := parseRangeHeader(header, size)
+	if err != nil {
+		t.Fatalf("parseRangeHeader: %v", err)
+	}
+	ranges = coalesceRanges(ranges, rangeCoalesceGap)
+	if wantParts != nil && !reflect.DeepEqual(ranges, wantParts) {
+		t.Fatalf("ranges = %v, want %v", ranges, wantParts)
+	}
+
+	body := rec.Body.Bytes()
+	if length := rec.Header().Get("Content-Length"); length != strconv.Itoa(len(body)) {
+		t.Errorf("Content-Length = %s, body is %d bytes", length, len(body))
+	}
+
+	mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("Content-Type: %v", err)
+	}
+
+	if len(ranges) == 1 {
+		ra := ranges[0]
+		if got := rec.Header().Get("Content-Range"); got != ra.contentRange(size) {
+			t.Errorf("Content-Range = %q, want %q", got, ra.contentRange(size))
+		}
+		if !bytes.Equal(body, content[ra.start:ra.start+ra.length]) || written != ra.length {
+			t.Errorf("body of %d bytes (%d written) does not match range %d-%d", len(body), written, ra.start, ra.end())
+		}
+		return
+	}
+
+	if mediaType != "multipart/byteranges" {
+		t.Fatalf("Content-Type = %s, want multipart/byteranges", mediaType)
+	}
+	if want := multipartRangesSize(ranges, params["boundary"], "video/mp4", size); want != int64(len(body)) {
+		t.Errorf("multipartRangesSize = %d, body is %d bytes", want, len(body))
+	}
+
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	var payload int64
+	for i, ra := range ranges {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		if got := part.Header.Get("Content-Range"); got != ra.contentRange(size) {
+			t.Errorf("part %d Content-Range = %q, want %q", i, got, ra.contentRange(size))
+		}
+		if got := part.Header.Get("Content-Type"); got != "video/mp4" {
+			t.Errorf("part %d Content-Type = %q, want video/mp4", i, got)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		if !bytes.Equal(data, content[ra.start:ra.start+ra.length]) {
+			t.Errorf("part %d does not match range %d-%d", i, ra.start, ra.end())
+		}
+		payload += int64(len(data))
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("unexpected part after %d ranges: %v", len(ranges), err)
+	}
+	if written != payload {
+		t.Errorf("written = %d, want the %d payload bytes", written, payload)
+	}
+}
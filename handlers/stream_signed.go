@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"media-server/models"
+	"media-server/services"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// errBudgetExhausted aborts a copy once a signed URL's byte budget is used up
+var errBudgetExhausted = errors.New("stream URL byte budget exhausted")
+
+// verifySignedURL enforces signed stream URLs when signing is enabled, for path requested on
+// route (e.g. "/stream/"). It returns the writer the response body must go through (wrapped when
+// the URL carries a byte budget) and false when the request has been rejected.
+func (sh *StreamHandler) verifySignedURL(w http.ResponseWriter, r *http.Request, clientIP, route, path string) (http.ResponseWriter, bool) {
+	if sh.urlSigner == nil {
+		return w, true
+	}
+
+	grant, err := sh.urlSigner.Verify(route, path, r.URL.Query(), clientIP)
+	if err == nil && sh.urlSigner.RemainingBudget(grant) == 0 {
+		err = services.ErrURLBudgetExhausted
+	}
+	if err != nil {
+		log.Printf("Signed URL rejected for %s (%s): %v", clientIP, path, err)
+		if sh.adminService != nil {
+			sh.adminService.LogActivity(clientIP, "stream_url_rejected", path, r.UserAgent(), false, err.Error())
+		}
+		http.Error(w, "Invalid or expired stream link", http.StatusForbidden)
+		return w, false
+	}
+
+	if grant.Budget > 0 {
+		return &budgetResponseWriter{
+			ResponseWriter: w,
+			signer:         sh.urlSigner,
+			grant:          grant,
+		}, true
+	}
+	return w, true
+}
+
+// budgetResponseWriter stops writing the body once the signed URL's byte budget is used up
+type budgetResponseWriter struct {
+	http.ResponseWriter
+	signer *services.URLSigner
+	grant  *services.SignedURLGrant
+}
+
+// Write writes as much of data as the remaining budget allows
+func (bw *budgetResponseWriter) Write(data []byte) (int, error) {
+	allowed := bw.signer.ConsumeBudget(bw.grant, int64(len(data)))
+	n, err := bw.ResponseWriter.Write(data[:allowed])
+	if err != nil {
+		return n, err
+	}
+	if allowed < int64(len(data)) {
+		return n, errBudgetExhausted
+	}
+	return n, nil
+}
+
+// Flush implements http.Flusher interface
+func (bw *budgetResponseWriter) Flush() {
+	if flusher, ok := bw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// streamURLFor returns the /stream/ URL for path, signed for the requesting client when signing is enabled
+func streamURLFor(signer *services.URLSigner, r *http.Request, path string) string {
+	return mediaURLFor(signer, r, "/stream/", path)
+}
+
+// downloadURLFor returns the /download/ URL for path, signed for the requesting client when signing is enabled
+func downloadURLFor(signer *services.URLSigner, r *http.Request, path string) string {
+	return mediaURLFor(signer, r, "/download/", path)
+}
+
+// archiveURLFor returns the /archive/ URL of a directory in format, signed for the requesting
+// client when signing is enabled
+func archiveURLFor(signer *services.URLSigner, r *http.Request, dirPath, format string) string {
+	return mediaQueryURLFor(signer, r, "/archive/", strings.Trim(dirPath, "/"), url.Values{"format": {format}})
+}
+
+// mediaQueryURLFor returns the escaped URL of path under prefix with the given query, signed for
+// the requesting client when signing is enabled
+func mediaQueryURLFor(signer *services.URLSigner, r *http.Request, prefix, path string, query url.Values) string {
+	values := url.Values{}
+	if signer != nil {
+		clientIP := models.GetClientIP(r.RemoteAddr, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
+		values = signer.SignQuery(prefix, path, clientIP)
+	}
+	for key, value := range query {
+		values[key] = value
+	}
+
+	rawURL := prefix + (&url.URL{Path: path}).EscapedPath()
+	if len(values) > 0 {
+		rawURL += "?" + values.Encode()
+	}
+	return rawURL
+}
+
+// mediaURLFor returns prefix+path, signed for the requesting client when signing is enabled
+func mediaURLFor(signer *services.URLSigner, r *http.Request, prefix, path string) string {
+	if signer == nil {
+		return prefix + path
+	}
+
+	clientIP := models.GetClientIP(r.RemoteAddr, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
+	return signer.SignStreamURL(prefix, path, clientIP)
+}
+
+// resourceURLFor returns the URL of a resource of mediaPath under route (e.g. the playlist of
+// /hls/<path>/), with the given query. When signing is enabled the URL carries a signature of
+// mediaPath on route for the requesting client, which is valid for every resource of mediaPath.
+func resourceURLFor(signer *services.URLSigner, r *http.Request, route, mediaPath, resource string, query url.Values) string {
+	if signer == nil {
+		rawURL := route + mediaPath + "/" + resource
+		if len(query) > 0 {
+			rawURL += "?" + query.Encode()
+		}
+		return rawURL
+	}
+
+	clientIP := models.GetClientIP(r.RemoteAddr, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
+	signed := signer.SignQuery(route, mediaPath, clientIP)
+	for key, values := range query {
+		signed[key] = values
+	}
+	return route + (&url.URL{Path: mediaPath + "/" + resource}).EscapedPath() + "?" + signed.Encode()
+}
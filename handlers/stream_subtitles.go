@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"media-server/models"
+	"media-server/services"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSubtitleFileSize caps the size of subtitle files converted in memory
+const maxSubtitleFileSize = 10 << 20
+
+// subtitleURLFunc returns a template function building the WebVTT URLs of sidecar subtitle files,
+// signed for the requesting client when signing is enabled
+func subtitleURLFunc(signer *services.URLSigner, r *http.Request) func(string) string {
+	return func(subtitlePath string) string {
+		return mediaQueryURLFor(signer, r, "/subtitles/", subtitlePath, nil)
+	}
+}
+
+// HandleSubtitles serves a sidecar subtitle file (/subtitles/<path>) converted to WebVTT. The
+// optional offset query parameter shifts all cues, in seconds ("-2.5") or as a duration ("1500ms").
+func (sh *StreamHandler) HandleSubtitles(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		sh.setCORSHeaders(w)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/subtitles/")
+	log.Printf("Subtitle request for path: %s", path)
+
+	clientIP := models.GetClientIP(r.RemoteAddr, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
+	w, ok := sh.verifySignedURL(w, r, clientIP, "/subtitles/", path)
+	if !ok {
+		return
+	}
+
+	ext := filepath.Ext(path)
+	if !models.IsSubtitleFile(ext) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	offset, err := parseSubtitleOffset(r.URL.Query().Get("offset"))
+	if err != nil {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+
+	fullPath, err := sh.fileService.ValidateFilePath(path)
+	if err != nil {
+		log.Printf("File validation failed for path %s: %v", path, err)
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	fileInfo, err := os.Stat(fullPath)
+	if err != nil || !fileInfo.Mode().IsRegular() {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if fileInfo.Size() > maxSubtitleFileSize {
+		http.Error(w, "Subtitle file too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// The converted document depends on the file and the offset
+	etag := strings.TrimSuffix(fileETag(fileInfo), `"`) + "-vtt-" + strconv.FormatInt(offset.Milliseconds(), 10) + `"`
+	sh.setValidatorHeaders(w, etag, fileInfo.ModTime())
+	if done, _ := sh.checkPreconditions(w, r, etag, fileInfo.ModTime()); done {
+		return
+	}
+
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		log.Printf("Error reading subtitle file %s: %v", fullPath, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	vtt, err := services.ConvertToWebVTT(data, strings.TrimPrefix(strings.ToLower(ext), "."), offset)
+	if err != nil {
+		log.Printf("Error converting subtitle file %s: %v", fullPath, err)
+		http.Error(w, "Unable to convert subtitle file", http.StatusUnprocessableEntity)
+		return
+	}
+
+	sh.setCORSHeaders(w)
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(vtt)))
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Write(vtt)
+}
+
+// parseSubtitleOffset parses a cue offset given in seconds or as a Go duration
+func parseSubtitleOffset(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	offset, err := time.ParseDuration(value)
+	if seconds, floatErr := strconv.ParseFloat(value, 64); floatErr == nil {
+		offset, err = time.Duration(seconds*float64(time.Second)), nil
+		if math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+			err = fmt.Errorf("invalid offset")
+		}
+	}
+	if err == nil && (offset > 24*time.Hour || offset < -24*time.Hour) {
+		err = fmt.Errorf("offset out of range")
+	}
+	return offset, err
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"media-server/models"
+	"media-server/services"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// thumbnailURLFunc returns a template function building thumbnail URLs of the given size, signed
+// for the requesting client when signing is enabled, which returns "" for files without
+// thumbnails (or when thumbnails are disabled)
+func thumbnailURLFunc(signer *services.URLSigner, r *http.Request, thumbnailService *services.ThumbnailService, size string) func(string) string {
+	return func(path string) string {
+		if thumbnailService == nil || !models.IsThumbnailSource(path) {
+			return ""
+		}
+		return mediaQueryURLFor(signer, r, "/thumb/", path, url.Values{"size": {size}})
+	}
+}
+
+// HandleThumbnail serves a JPEG thumbnail of an image (/thumb/<path>?size=small|medium|large)
+func (sh *StreamHandler) HandleThumbnail(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		sh.setCORSHeaders(w)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/thumb/")
+	if sh.thumbnailService == nil || !models.IsThumbnailSource(path) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	clientIP := models.GetClientIP(r.RemoteAddr, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
+	w, ok := sh.verifySignedURL(w, r, clientIP, "/thumb/", path)
+	if !ok {
+		return
+	}
+
+	_, edge, err := models.ParseThumbnailSize(r.URL.Query().Get("size"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fullPath, err := sh.fileService.ValidateFilePath(path)
+	if err != nil {
+		log.Printf("File validation failed for path %s: %v", path, err)
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	thumbPath, err := sh.thumbnailService.GetThumbnail(fullPath, edge)
+	if err != nil {
+		if err == services.ErrThumbnailBusy {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Thumbnail generation is busy", http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("Error generating thumbnail of %s: %v", path, err)
+		http.Error(w, "Unable to generate thumbnail", http.StatusUnprocessableEntity)
+		return
+	}
+
+	file, err := os.Open(thumbPath)
+	if err != nil {
+		log.Printf("Error opening thumbnail %s: %v", thumbPath, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		log.Printf("Error getting file info for %s: %v", thumbPath, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	etag := fileETag(fileInfo)
+	sh.setValidatorHeaders(w, etag, fileInfo.ModTime())
+	if done, _ := sh.checkPreconditions(w, r, etag, fileInfo.ModTime()); done {
+		return
+	}
+
+	sh.setCORSHeaders(w)
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.FormatInt(fileInfo.Size(), 10))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	io.Copy(w, file)
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"media-server/models"
+	"media-server/services"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// transcodeStatusName is the resource reporting the state of a rendition
+const transcodeStatusName = "status"
+
+// transcodeURL returns the URL of a resource of the transcoded renditions of a media path
+func transcodeURL(mediaPath, resource string) string {
+	return "/transcode/" + mediaPath + "/" + resource
+}
+
+// transcodeStatus is the JSON body describing the state of a rendition
+type transcodeStatus struct {
+	Status string                 `json:"status"`
+	Format models.TranscodeFormat `json:"format"`
+	Error  string                 `json:"error,omitempty"`
+	URL    string                 `json:"url"`
+}
+
+// HandleTranscode serves browser-compatible renditions of media files that browsers cannot play
+// directly, producing them on first request:
+//
+//	/transcode/<path>/status?format=mp4|hls  state of the rendition as JSON
+//	/transcode/<path>/stream.mp4             fragmented MP4 rendition
+//	/transcode/<path>/index.m3u8             HLS rendition playlist (segments: segment-<n>.ts)
+//
+// Rendition files requested before they are ready are answered with 202 Accepted and the status.
+func (sh *StreamHandler) HandleTranscode(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		sh.setCORSHeaders(w)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/transcode/")
+	mediaPath, resource := path.Split(rest)
+	mediaPath = strings.TrimSuffix(mediaPath, "/")
+	log.Printf("Transcode request for path: %s, resource: %s", mediaPath, resource)
+
+	if sh.transcodeService == nil || !models.NeedsTranscoding(mediaPath) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	// Verify before anything can start a transcode; one signature of the media path covers all
+	// of its renditions
+	clientIP := models.GetClientIP(r.RemoteAddr, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
+	w, ok := sh.verifySignedURL(w, r, clientIP, "/transcode/", mediaPath)
+	if !ok {
+		return
+	}
+
+	var format models.TranscodeFormat
+	switch resource {
+	case transcodeStatusName:
+		var err error
+		if format, err = models.ParseTranscodeFormat(r.URL.Query().Get("format")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case models.TranscodeMP4Name:
+		format = models.TranscodeFormatMP4
+	case models.TranscodePlaylistName:
+		format = models.TranscodeFormatHLS
+	default:
+		if _, ok := parseSegmentName(resource); !ok {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		format = models.TranscodeFormatHLS
+	}
+
+	fullPath, err := sh.fileService.ValidateFilePath(mediaPath)
+	if err != nil {
+		log.Printf("File validation failed for path %s: %v", mediaPath, err)
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	job, err := sh.transcodeService.Request(fullPath, format)
+	if err != nil {
+		if err == services.ErrTranscodeQueueFull {
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, "Transcoding queue is full", http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("Error requesting %s transcode of %s: %v", format, mediaPath, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	sh.setCORSHeaders(w)
+
+	if resource == transcodeStatusName {
+		writeTranscodeStatus(w, r, mediaPath, job, http.StatusOK)
+		return
+	}
+
+	switch job.Status {
+	case models.TranscodeReady:
+	case models.TranscodeFailed:
+		writeTranscodeStatus(w, r, mediaPath, job, http.StatusUnprocessableEntity)
+		return
+	default:
+		w.Header().Set("Retry-After", "5")
+		writeTranscodeStatus(w, r, mediaPath, job, http.StatusAccepted)
+		return
+	}
+
+	// The rendition stays in the cache while it is served
+	sh.transcodeService.Acquire(job)
+	defer sh.transcodeService.Release(job)
+
+	filePath := sh.transcodeService.OutputPath(job, resource)
+	if resource == models.TranscodePlaylistName {
+		sh.serveTranscodedPlaylist(w, r, filePath)
+		return
+	}
+	sh.serveTranscodedFile(w, r, mediaPath, fullPath, filePath)
+}
+
+// writeTranscodeStatus writes the state of a rendition as JSON. The rendition URL carries the
+// signature of the request, if any.
+func writeTranscodeStatus(w http.ResponseWriter, r *http.Request, mediaPath string, job models.TranscodeJob, status int) {
+	resource := models.TranscodeMP4Name
+	if job.Format == models.TranscodeFormatHLS {
+		resource = models.TranscodePlaylistName
+	}
+
+	renditionURL := transcodeURL(mediaPath, resource)
+	if signature := services.SignatureParams(r.URL.Query()); len(signature) > 0 {
+		renditionURL += "?" + signature.Encode()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(transcodeStatus{
+		Status: job.Status,
+		Format: job.Format,
+		Error:  job.Error,
+		URL:    renditionURL,
+	})
+}
+
+// serveTranscodedPlaylist writes an HLS rendition playlist, carrying the request query (e.g. a
+// media password) over to the segment requests
+func (sh *StreamHandler) serveTranscodedPlaylist(w http.ResponseWriter, r *http.Request, filePath string) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		log.Printf("Error reading transcoded playlist %s: %v", filePath, err)
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	playlist := string(data)
+	if r.URL.RawQuery != "" {
+		lines := strings.Split(playlist, "\n")
+		for i, line := range lines {
+			if line != "" && !strings.HasPrefix(line, "#") {
+				lines[i] = line + "?" + r.URL.RawQuery
+			}
+		}
+		playlist = strings.Join(lines, "\n")
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Content-Length", strconv.Itoa(len(playlist)))
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	io.WriteString(w, playlist)
+}
+
+// serveTranscodedFile serves a file of a ready rendition with range support, counting it
+// towards the client's playback session of the source media file
+func (sh *StreamHandler) serveTranscodedFile(w http.ResponseWriter, r *http.Request, mediaPath, fullPath, filePath string) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		log.Printf("Error getting file info for %s: %v", filePath, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	size := fileInfo.Size()
+
+	etag := fileETag(fileInfo)
+	sh.setValidatorHeaders(w, etag, fileInfo.ModTime())
+	done, rangeHeader := sh.checkPreconditions(w, r, etag, fileInfo.ModTime())
+	if done {
+		return
+	}
+
+	contentType := "video/mp4"
+	if strings.HasSuffix(filePath, ".ts") {
+		contentType = "video/mp2t"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Disposition", "inline")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Segments and range requests join the client's playback session of the source file
+	clientIP := models.GetClientIP(r.RemoteAddr, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
+	isSegment := contentType == "video/mp2t"
+	sessionSize := size
+	if isSegment {
+		sessionSize = 0
+	}
+	sessionPath := sh.sessionPath(mediaPath)
+	if sh.adminService != nil {
+		if err := sh.adminService.StartStream(clientIP, sessionPath, false, sessionSize); err != nil {
+			sh.rejectStream(w, r, clientIP, sessionPath, err)
+			return
+		}
+	}
+	startTime := time.Now()
+
+	// Folder bandwidth limits apply to the folder of the source file
+	limiter := sh.newStreamLimiter(r, clientIP, fullPath)
+	if limiter != nil {
+		defer limiter.Close()
+	}
+
+	var written int64
+	if rangeHeader != "" {
+		written = sh.handleRangeRequest(w, r, rangeHeader, file, filePath, size, limiter)
+	} else {
+		written = sh.serveCompleteFile(w, r, file, filePath, limiter)
+	}
+
+	if sh.adminService != nil {
+		position := streamPosition(rangeHeader, size, written)
+		if isSegment {
+			position = -1
+		}
+		sh.adminService.EndStream(clientIP, sessionPath, false, position, written, time.Since(startTime))
+	}
+}
@@ -2,15 +2,22 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"media-server/config"
 	"media-server/handlers"
 	"media-server/middleware"
+	"media-server/models"
 	"media-server/services"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -36,30 +43,123 @@ func main() {
 	// Initialize media folder service
 	log.Println("Initializing media folder service...")
 	mediaFolderService := services.NewMediaFolderService(cfg.MediaDir)
+	mediaFolderService.SetCacheService(cacheService)
+
+	// Initialize bandwidth service
+	log.Println("Initializing bandwidth service...")
+	bandwidthService := services.NewBandwidthService(models.BandwidthLimits{
+		GlobalLimit:    cfg.GlobalBandwidthLimit,
+		PerIPLimit:     cfg.PerIPBandwidthLimit,
+		PerFolderLimit: cfg.PerFolderBandwidthLimit,
+	})
+
+	// Initialize HLS packaging for transport streams
+	hlsService := services.NewHLSService(cacheService, performanceService)
+
+	// Initialize MP4 faststart relocation for files with the moov box at the end
+	faststartService := services.NewFaststartService(cacheService)
+
+	// Initialize on-demand transcoding (optional)
+	transcodeService := newTranscodeService(cfg, performanceService)
+
+	// Initialize image thumbnails with a persistent disk cache
+	log.Println("Initializing thumbnail service...")
+	thumbnailService, err := services.NewThumbnailService(cfg.ThumbnailCacheDir, performanceService)
+	if err != nil {
+		log.Printf("Thumbnails disabled: %v", err)
+	}
+
+	// Initialize cover art extraction with a persistent disk cache
+	log.Println("Initializing cover art service...")
+	coverArtService, err := services.NewCoverArtService(cfg.CoverArtCacheDir, cacheService)
+	if err != nil {
+		log.Printf("Cover art disabled: %v", err)
+	}
+
+	// Initialize the persistent media index, filled by folder scans
+	log.Println("Initializing media index...")
+	mediaIndexService, err := services.NewMediaIndexService(filepath.Join(cfg.DataDir, "media-index.db"))
+	if err != nil {
+		log.Printf("Media index disabled: %v", err)
+	} else {
+		mediaFolderService.SetMediaIndex(mediaIndexService)
+	}
+
+	// Initialize library search over the media index (optional)
+	var searchService *services.SearchService
+	if mediaIndexService != nil {
+		searchService = services.NewSearchService(mediaFolderService, mediaIndexService, cacheService)
+	}
+
+	// Initialize duplicate detection over the media index (optional)
+	var duplicateService *services.DuplicateService
+	if mediaIndexService != nil {
+		log.Printf("Initializing duplicate detection with %d hashing workers...", cfg.HashWorkers)
+		duplicateService = services.NewDuplicateService(mediaFolderService, mediaIndexService, performanceService, cfg.HashWorkers)
+	}
+
+	// Initialize folder watching for live library updates (optional)
+	var watchService *services.WatchService
+	if cfg.WatchMode != services.WatchModeOff {
+		log.Printf("Initializing folder watching (%s)...", cfg.WatchMode)
+		watchService = services.NewWatchService(mediaFolderService, cacheService, mediaIndexService,
+			cfg.WatchMode, cfg.WatchDebounce, cfg.WatchPollInterval)
+	}
+
+	// Initialize the photo timeline
+	photoService := services.NewPhotoService(mediaFolderService, cacheService)
+
+	// Initialize stream URL signer (optional)
+	var urlSigner *services.URLSigner
+	if cfg.SignedStreamURLs {
+		log.Println("Initializing signed stream URLs...")
+		urlSigner = services.NewURLSigner(cfg.StreamURLSecret, cfg.StreamURLTTL, cfg.StreamURLBindIP, cfg.StreamURLByteBudget)
+	}
 
 	// Initialize admin service with performance monitoring
 	log.Println("Initializing admin service...")
 	adminService := services.NewAdminService()
 	adminService.SetPerformanceService(performanceService)
 	adminService.SetCacheService(cacheService)
+	adminService.SetBandwidthService(bandwidthService)
+	adminService.SetFileService(services.NewFileServiceWithMediaFolders(cfg.MediaDir, cacheService, performanceService, mediaFolderService))
+	adminService.SetStreamLimits(cfg.MaxStreamsPerIP, cfg.MaxStreamsGlobal)
 
 	// Setup middleware
 	mux := http.NewServeMux()
 
 	// Setup routes with enhanced services
 	log.Println("Setting up routes...")
-	handlers.SetupRoutes(mux, cfg, adminService, cacheService, performanceService, mediaFolderService)
+	handlers.SetupRoutes(mux, cfg, adminService, cacheService, performanceService, mediaFolderService, bandwidthService, urlSigner, hlsService, faststartService, transcodeService, thumbnailService, photoService, coverArtService, mediaIndexService, searchService, duplicateService)
 
-	// Apply middleware (logging and security)
-	handler := middleware.Logging(middleware.Security(mux))
+	// Apply middleware (logging, security and compression)
+	handler := middleware.Logging(middleware.Security(middleware.Compression(mux)))
 
 	// Create HTTP server with optimized settings
 	server := &http.Server{
 		Addr:         cfg.GetAddress(),
 		Handler:      handler,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+
+	// HTTP/1.1 is always served; HTTP/2 is negotiated over TLS unless disabled
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetHTTP2(cfg.HTTP2)
+
+	// Load or generate the TLS certificate (optional)
+	var certificateManager *services.CertificateManager
+	var redirectServer *http.Server
+	if cfg.TLSEnabled {
+		certificateManager = newCertificateManager(cfg)
+		server.TLSConfig = certificateManager.TLSConfig()
+		go certificateManager.StartWatching()
+
+		if cfg.HTTPRedirectPort > 0 {
+			redirectServer = newRedirectServer(cfg)
+		}
 	}
 
 	// Start performance monitoring
@@ -68,27 +168,65 @@ func main() {
 	// Start cache cleanup routine
 	go cacheService.StartCleanup()
 
+	// Start bandwidth bucket cleanup routine
+	go bandwidthService.StartCleanup()
+
+	// Load the search index from the media index, then bring both up to date; the library
+	// uses the previous index meanwhile
+	if searchService != nil {
+		go searchService.BuildIndex()
+	}
+	if mediaIndexService != nil {
+		go mediaFolderService.ScanAllFolders()
+	}
+
+	// Start watching the media folders for changes
+	if watchService != nil {
+		go watchService.StartWatching()
+	}
+
 	// Start real-time admin dashboard broadcasting
 	go func() {
 		// Get the admin handler from routes to start broadcasting
 		// We need to create a temporary admin handler to start broadcasting
-		tempAdminHandler := handlers.NewAdminHandlerWithServices(cfg, adminService, cacheService, performanceService, mediaFolderService)
+		tempAdminHandler := handlers.NewAdminHandlerWithServices(cfg, adminService, cacheService, performanceService, mediaFolderService, bandwidthService, duplicateService)
 		tempAdminHandler.StartRealtimeBroadcast()
 	}()
 
 	// Start the server in a goroutine
 	go func() {
-		log.Printf("Media server starting on http://localhost:%d", cfg.Port)
+		scheme := "http"
+		if cfg.TLSEnabled {
+			scheme = "https"
+		}
+		log.Printf("Media server starting on %s://localhost:%d", scheme, cfg.Port)
 		log.Printf("Serving media from: %s", cfg.MediaDir)
-		log.Printf("Admin dashboard available at: http://localhost:%d/admin/dashboard", cfg.Port)
+		log.Printf("Admin dashboard available at: %s://localhost:%d/admin/dashboard", scheme, cfg.Port)
 		log.Printf("CPU cores detected: %d, GOMAXPROCS: %d", runtime.NumCPU(), runtime.GOMAXPROCS(0))
 		log.Println("Note: Admin dashboard is accessible from localhost or authorized IPs only")
 
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if cfg.TLSEnabled {
+			// The certificate comes from server.TLSConfig
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed to start: %v", err)
 		}
 	}()
 
+	// Start the HTTP to HTTPS redirect listener
+	if redirectServer != nil {
+		go func() {
+			log.Printf("Redirecting HTTP on port %d to HTTPS", cfg.HTTPRedirectPort)
+			if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Redirect server failed to start: %v", err)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -100,17 +238,122 @@ func main() {
 	defer cancel()
 
 	// Shutdown services gracefully
+	if transcodeService != nil {
+		transcodeService.Stop()
+	}
 	performanceService.Stop()
 	cacheService.Stop()
+	bandwidthService.Stop()
+	if watchService != nil {
+		watchService.Stop()
+	}
+	if certificateManager != nil {
+		certificateManager.Stop()
+	}
 
-	// Shutdown the server
+	// Shutdown the servers
+	if redirectServer != nil {
+		redirectServer.Shutdown(ctx)
+	}
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+	if mediaIndexService != nil {
+		mediaIndexService.Close()
+	}
 
 	log.Println("Server exited")
 }
 
+// newTranscodeService sets up the configured transcoder backend, or returns nil when
+// transcoding is disabled or unavailable
+func newTranscodeService(cfg *config.Config, performanceService *services.PerformanceService) *services.TranscodeService {
+	var transcoder services.Transcoder
+	switch cfg.Transcoder {
+	case "none", "":
+		log.Println("Transcoding disabled")
+		return nil
+	case "fake":
+		transcoder = &services.FakeTranscoder{}
+	case "ffmpeg":
+		ffmpeg, err := services.NewFFmpegTranscoder(cfg.FFmpegPath)
+		if err != nil {
+			log.Printf("Transcoding disabled: %v", err)
+			return nil
+		}
+		transcoder = ffmpeg
+	default:
+		log.Printf("Transcoding disabled: unknown transcoder %q", cfg.Transcoder)
+		return nil
+	}
+
+	log.Printf("Initializing %s transcoding with %d workers...", transcoder.Name(), cfg.TranscodeWorkers)
+	workerPool := performanceService.GetOrCreateWorkerPool("transcoding", cfg.TranscodeWorkers, 32)
+	transcodeService, err := services.NewTranscodeService(transcoder, workerPool, cfg.TranscodeCacheDir, cfg.TranscodeCacheMaxSize)
+	if err != nil {
+		log.Printf("Transcoding disabled: %v", err)
+		return nil
+	}
+	return transcodeService
+}
+
+// newCertificateManager loads the configured certificate files, or generates a self-signed
+// certificate under the data directory when there are none
+func newCertificateManager(cfg *config.Config) *services.CertificateManager {
+	if cfg.TLSCertFile != "" {
+		log.Printf("Loading TLS certificate from %s...", cfg.TLSCertFile)
+		certificateManager, err := services.NewCertificateManager(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+		return certificateManager
+	}
+
+	hosts := cfg.TLSHosts
+	if len(hosts) == 0 {
+		hosts = services.DefaultCertificateHosts()
+	}
+	log.Println("Using a self-signed TLS certificate...")
+	certificateManager, err := services.NewSelfSignedCertificateManager(filepath.Join(cfg.DataDir, "tls"), hosts)
+	if err != nil {
+		log.Fatalf("Failed to set up self-signed TLS certificate: %v", err)
+	}
+	return certificateManager
+}
+
+// newRedirectServer creates a plain HTTP server redirecting every request to the same URL on
+// the HTTPS port
+func newRedirectServer(cfg *config.Config) *http.Server {
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if cfg.Port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(cfg.Port))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		target := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		// 308 keeps the method and body of non-GET requests
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, target.String(), status)
+	})
+
+	return &http.Server{
+		Addr:         fmt.Sprintf(":%d", cfg.HTTPRedirectPort),
+		Handler:      redirect,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+}
+
 // configureRuntime optimizes Go runtime settings for maximum performance
 func configureRuntime() {
 	numCPU := runtime.NumCPU()
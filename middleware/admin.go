@@ -8,6 +8,7 @@ import (
 	"media-server/services"
 	"net"
 	"net/http"
+	"path"
 	"strings"
 )
 
@@ -93,7 +94,10 @@ func (am *AdminMiddleware) ConnectionTracking(next http.Handler) http.Handler {
 func (am *AdminMiddleware) MediaPasswordAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Only apply to media streaming requests
-		if !strings.HasPrefix(r.URL.Path, "/stream/") && !strings.HasPrefix(r.URL.Path, "/player/") {
+		if !strings.HasPrefix(r.URL.Path, "/stream/") && !strings.HasPrefix(r.URL.Path, "/player/") &&
+			!strings.HasPrefix(r.URL.Path, "/download/") && !strings.HasPrefix(r.URL.Path, "/hls/") &&
+			!strings.HasPrefix(r.URL.Path, "/transcode/") && !strings.HasPrefix(r.URL.Path, "/subtitles/") &&
+			!strings.HasPrefix(r.URL.Path, "/thumb/") && !strings.HasPrefix(r.URL.Path, "/art/") {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -104,6 +108,20 @@ func (am *AdminMiddleware) MediaPasswordAuth(next http.Handler) http.Handler {
 			mediaPath = strings.TrimPrefix(r.URL.Path, "/stream/")
 		} else if strings.HasPrefix(r.URL.Path, "/player/") {
 			mediaPath = strings.TrimPrefix(r.URL.Path, "/player/")
+		} else if strings.HasPrefix(r.URL.Path, "/download/") {
+			mediaPath = strings.TrimPrefix(r.URL.Path, "/download/")
+		} else if strings.HasPrefix(r.URL.Path, "/hls/") {
+			// The last element names the playlist or segment of the media file
+			mediaPath = path.Dir(strings.TrimPrefix(r.URL.Path, "/hls/"))
+		} else if strings.HasPrefix(r.URL.Path, "/transcode/") {
+			// The last element names the transcoded rendition file of the media file
+			mediaPath = path.Dir(strings.TrimPrefix(r.URL.Path, "/transcode/"))
+		} else if strings.HasPrefix(r.URL.Path, "/subtitles/") {
+			mediaPath = strings.TrimPrefix(r.URL.Path, "/subtitles/")
+		} else if strings.HasPrefix(r.URL.Path, "/thumb/") {
+			mediaPath = strings.TrimPrefix(r.URL.Path, "/thumb/")
+		} else if strings.HasPrefix(r.URL.Path, "/art/") {
+			mediaPath = strings.TrimPrefix(r.URL.Path, "/art/")
 		}
 
 		// Check if password is required
@@ -161,6 +179,11 @@ func (rt *responseTracker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return nil, nil, fmt.Errorf("hijacking not supported")
 }
 
+// Unwrap returns the wrapped writer for http.ResponseController
+func (rt *responseTracker) Unwrap() http.ResponseWriter {
+	return rt.ResponseWriter
+}
+
 // Flush implements http.Flusher interface
 func (rt *responseTracker) Flush() {
 	if flusher, ok := rt.ResponseWriter.(http.Flusher); ok {
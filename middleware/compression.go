@@ -0,0 +1,228 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// minCompressSize is the smallest response (by Content-Length) worth compressing
+const minCompressSize = 1024
+
+// uncompressedPrefixes are routes serving media or images, which are already compressed and
+// rely on byte ranges and exact lengths
+var uncompressedPrefixes = []string{"/stream/", "/download/", "/hls/", "/transcode/", "/archive/", "/thumb/", "/art/"}
+
+// compressibleTypes are the content types compressed in addition to text/*
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"application/xml":        true,
+	"image/svg+xml":          true,
+}
+
+// encoder is a content coding the server can produce
+type encoder struct {
+	name string
+	pool *sync.Pool
+}
+
+// encoders are the supported content codings, in order of preference
+var encoders = []encoder{
+	{
+		name: "gzip",
+		pool: &sync.Pool{New: func() interface{} {
+			w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+			return w
+		}},
+	},
+}
+
+// compressor is the writer interface shared by the encoders
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// Compression middleware compresses text, JSON and script responses with an encoding the client
+// accepts. Media routes and responses that are already encoded or partial are passed through.
+func Compression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range uncompressedPrefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+		if r.Method != http.MethodHead {
+			cw.encoder = negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		}
+		defer cw.close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding returns the preferred encoder accepted by an Accept-Encoding header, or nil
+func negotiateEncoding(acceptEncoding string) *encoder {
+	if acceptEncoding == "" {
+		return nil
+	}
+
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if value, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = value
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = quality
+	}
+
+	for i := range encoders {
+		quality, found := accepted[encoders[i].name]
+		if !found {
+			quality, found = accepted["*"]
+		}
+		if found && quality > 0 {
+			return &encoders[i]
+		}
+	}
+	return nil
+}
+
+// isCompressible reports whether a content type is worth compressing
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
+
+// compressWriter wraps http.ResponseWriter to compress the body. The header is held back until
+// the first write, when the content type is known.
+type compressWriter struct {
+	http.ResponseWriter
+	encoder     *encoder
+	compressor  compressor
+	statusCode  int
+	wroteHeader bool
+}
+
+// WriteHeader records the status code; the header is sent with the first write
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	// Informational responses are sent straight away and do not end the header
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.statusCode = code
+	cw.wroteHeader = true
+}
+
+// Write compresses data when the response qualifies
+func (cw *compressWriter) Write(data []byte) (int, error) {
+	cw.wroteHeader = true
+	if cw.Header().Get("Content-Type") == "" && len(data) > 0 {
+		cw.Header().Set("Content-Type", http.DetectContentType(data))
+	}
+	cw.start()
+
+	if cw.compressor != nil {
+		return cw.compressor.Write(data)
+	}
+	return cw.ResponseWriter.Write(data)
+}
+
+// start decides whether to compress and sends the header, once
+func (cw *compressWriter) start() {
+	if cw.statusCode == 0 {
+		return
+	}
+	statusCode := cw.statusCode
+	cw.statusCode = 0
+
+	header := cw.Header()
+	if isCompressible(header.Get("Content-Type")) {
+		header.Add("Vary", "Accept-Encoding")
+
+		if cw.shouldCompress(statusCode) {
+			cw.compressor = cw.encoder.pool.Get().(compressor)
+			cw.compressor.Reset(cw.ResponseWriter)
+
+			header.Set("Content-Encoding", cw.encoder.name)
+			header.Del("Content-Length")
+			// The compressed body is a different representation from the one a strong
+			// validator was computed for
+			if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+				header.Set("ETag", "W/"+etag)
+			}
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// shouldCompress reports whether a response with a compressible type is to be compressed
+func (cw *compressWriter) shouldCompress(statusCode int) bool {
+	if cw.encoder == nil {
+		return false
+	}
+	if statusCode < 200 || statusCode == http.StatusNoContent || statusCode == http.StatusPartialContent ||
+		statusCode == http.StatusNotModified {
+		return false
+	}
+
+	header := cw.Header()
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	if length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && length < minCompressSize {
+		return false
+	}
+	return true
+}
+
+// Flush sends everything compressed so far, which server-sent events rely on
+func (cw *compressWriter) Flush() {
+	cw.wroteHeader = true
+	cw.start()
+	if cw.compressor != nil {
+		cw.compressor.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close sends a header that was never written and finishes the compressed stream
+func (cw *compressWriter) close() {
+	if cw.wroteHeader {
+		cw.start()
+	}
+	if cw.compressor != nil {
+		cw.compressor.Close()
+		cw.compressor.Reset(io.Discard)
+		cw.encoder.pool.Put(cw.compressor)
+		cw.compressor = nil
+	}
+}
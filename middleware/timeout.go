@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// deadlineExtendInterval limits how often a streaming response moves its write deadline
+const deadlineExtendInterval = time.Second
+
+// TimeoutMiddleware replaces the server-wide write timeout, which suits pages and API calls, with
+// a progress-based idle deadline on routes that stream long responses
+type TimeoutMiddleware struct {
+	streamIdleTimeout time.Duration
+}
+
+// NewTimeoutMiddleware creates a new TimeoutMiddleware. Streaming responses fail once no data
+// could be written for streamIdleTimeout.
+func NewTimeoutMiddleware(streamIdleTimeout time.Duration) *TimeoutMiddleware {
+	return &TimeoutMiddleware{
+		streamIdleTimeout: streamIdleTimeout,
+	}
+}
+
+// Streaming middleware for media streams, downloads and server-sent events. The write deadline
+// is pushed back whenever the handler writes, so a response may run as long as it keeps making
+// progress, and the read deadline is lifted so that the connection is not torn down mid-stream.
+func (tm *TimeoutMiddleware) Streaming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		controller := http.NewResponseController(w)
+		controller.SetReadDeadline(time.Time{})
+
+		writer := &deadlineWriter{
+			ResponseWriter: w,
+			controller:     controller,
+			idleTimeout:    tm.streamIdleTimeout,
+		}
+		// The handler may need a while before its first byte, e.g. to prepare a transcode
+		writer.extend()
+
+		next.ServeHTTP(writer, r)
+	})
+}
+
+// deadlineWriter wraps http.ResponseWriter to extend the write deadline as data is written
+type deadlineWriter struct {
+	http.ResponseWriter
+	controller  *http.ResponseController
+	idleTimeout time.Duration
+	extendedAt  time.Time
+}
+
+// extend moves the write deadline idleTimeout past now, at most once per deadlineExtendInterval
+func (dw *deadlineWriter) extend() {
+	now := time.Now()
+	if now.Sub(dw.extendedAt) < deadlineExtendInterval {
+		return
+	}
+	dw.extendedAt = now
+	dw.controller.SetWriteDeadline(now.Add(dw.idleTimeout))
+}
+
+// Write extends the deadline before writing
+func (dw *deadlineWriter) Write(data []byte) (int, error) {
+	dw.extend()
+	return dw.ResponseWriter.Write(data)
+}
+
+// WriteHeader extends the deadline before writing the header
+func (dw *deadlineWriter) WriteHeader(code int) {
+	dw.extend()
+	dw.ResponseWriter.WriteHeader(code)
+}
+
+// Flush implements http.Flusher interface
+func (dw *deadlineWriter) Flush() {
+	dw.extend()
+	dw.controller.Flush()
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (dw *deadlineWriter) Unwrap() http.ResponseWriter {
+	return dw.ResponseWriter
+}
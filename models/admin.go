@@ -32,6 +32,7 @@ type Connection struct {
 	IsBlocked     bool          `json:"is_blocked"`
 	BlockedReason string        `json:"blocked_reason"`
 	Location      string        `json:"location"`
+	ActiveStreams int           `json:"active_streams"`
 	mutex         sync.RWMutex  `json:"-"`
 }
 
@@ -100,6 +101,17 @@ func (c *Connection) UpdateActivity(bytesTransferred int64) {
 	c.RequestCount++
 }
 
+// AdjustStreams changes the number of playback streams open on this connection
+func (c *Connection) AdjustStreams(delta int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.ActiveStreams += delta
+	if c.ActiveStreams < 0 {
+		c.ActiveStreams = 0
+	}
+}
+
 // GetFormattedSpeed returns a human-readable speed string
 func (c *Connection) GetFormattedSpeed() string {
 	c.mutex.RLock()
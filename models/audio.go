@@ -0,0 +1,86 @@
+package models
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AudioTags holds the metadata read from the tags and stream headers of an audio file
+type AudioTags struct {
+	Path        string    `json:"-"`
+	Size        int64     `json:"-"`
+	ModTime     time.Time `json:"-"`
+	Format      string    `json:"format"` // tag format: id3v2, id3v1, vorbis or mp4
+	Title       string    `json:"title,omitempty"`
+	Artist      string    `json:"artist,omitempty"`
+	Album       string    `json:"album,omitempty"`
+	AlbumArtist string    `json:"album_artist,omitempty"`
+	Track       int       `json:"track,omitempty"`
+	TrackTotal  int       `json:"track_total,omitempty"`
+	Disc        int       `json:"disc,omitempty"`
+	DiscTotal   int       `json:"disc_total,omitempty"`
+	Year        int       `json:"year,omitempty"`
+	Genre       string    `json:"genre,omitempty"`
+	Duration    float64   `json:"duration,omitempty"` // seconds
+}
+
+// IsTaggedAudio checks if tags can be read from an audio file
+func IsTaggedAudio(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3", ".flac", ".ogg", ".oga", ".opus", ".m4a":
+		return true
+	}
+	return false
+}
+
+// IsValidFor reports whether the tags still match the file they were read from
+func (at *AudioTags) IsValidFor(size int64, modTime time.Time) bool {
+	return at.Size == size && at.ModTime.Equal(modTime)
+}
+
+// FormattedDuration returns the duration as m:ss or h:mm:ss, or "" when it is unknown
+func (at *AudioTags) FormattedDuration() string {
+	return formatDuration(at.Duration)
+}
+
+// formatDuration formats a duration in seconds as m:ss or h:mm:ss, or "" when it is unknown
+func formatDuration(duration float64) string {
+	if duration <= 0 {
+		return ""
+	}
+	seconds := int(duration + 0.5)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// id3Genres are the ID3v1 genres, including the Winamp extensions, indexed by genre number
+var id3Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap",
+	"Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks",
+	"Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"AlternRock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychedelic", "Rave", "Showtunes", "Trailer", "Lo-Fi",
+	"Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+	"Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion", "Bebop", "Latin", "Revival",
+	"Celtic", "Bluegrass", "Avantgarde", "Gothic Rock", "Progressive Rock", "Psychedelic Rock", "Symphonic Rock", "Slow Rock",
+	"Big Band", "Chorus", "Easy Listening", "Acoustic", "Humour", "Speech", "Chanson", "Opera",
+	"Chamber Music", "Sonata", "Symphony", "Booty Bass", "Primus", "Porn Groove", "Satire", "Slow Jam",
+	"Club", "Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul", "Freestyle",
+	"Duet", "Punk Rock", "Drum Solo", "A Cappella", "Euro-House", "Dance Hall",
+}
+
+// ID3GenreName returns the name of an ID3v1 genre number, or "" when it is unknown
+func ID3GenreName(index int) string {
+	if index < 0 || index >= len(id3Genres) {
+		return ""
+	}
+	return id3Genres[index]
+}
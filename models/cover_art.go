@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// Cover art sources
+const (
+	CoverArtEmbedded = "embedded"
+	CoverArtFolder   = "folder"
+)
+
+// CoverArt identifies the cover art of an audio file (or of a folder image) by the hash of the
+// image, so that tracks sharing the same art share one cached copy
+type CoverArt struct {
+	Path     string    `json:"-"`
+	Size     int64     `json:"-"`
+	ModTime  time.Time `json:"-"`
+	Hash     string    `json:"hash,omitempty"` // hex SHA-256 of the image, "" when there is none
+	MimeType string    `json:"mime_type,omitempty"`
+	Source   string    `json:"source,omitempty"` // embedded or folder
+}
+
+// IsValidFor reports whether the entry still matches the file it was read from
+func (ca *CoverArt) IsValidFor(size int64, modTime time.Time) bool {
+	return ca.Size == size && ca.ModTime.Equal(modTime)
+}
+
+// Extension returns the file extension matching the image type
+func (ca *CoverArt) Extension() string {
+	switch ca.MimeType {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	case "image/bmp":
+		return ".bmp"
+	}
+	return ".jpg"
+}
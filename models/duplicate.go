@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// FileHash is the content fingerprint of a file, valid while the file keeps the size and
+// modification time it was hashed at
+type FileHash struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Quick   string    `json:"quick"`            // SHA-256 of the size and the first and last chunks
+	SHA256  string    `json:"sha256,omitempty"` // of the whole file, only computed when quick fingerprints collide
+}
+
+// Matches reports whether a hash still describes a file with the given size and modification time
+func (h *FileHash) Matches(size int64, modTime time.Time) bool {
+	return h.Size == size && h.ModTime.Equal(modTime)
+}
+
+// DuplicateFile is one copy of a duplicated file
+type DuplicateFile struct {
+	Path      string    `json:"path"`       // relative to its media folder, with forward slashes
+	MediaPath string    `json:"media_path"` // f/<folder ID>/<path>
+	FolderID  string    `json:"folder_id"`
+	Folder    string    `json:"folder"`
+	ModTime   time.Time `json:"mod_time"`
+}
+
+// DuplicateGroup is a set of files with identical content
+type DuplicateGroup struct {
+	SHA256      string           `json:"sha256"`
+	Size        int64            `json:"size"` // of each copy
+	Files       []*DuplicateFile `json:"files"`
+	WastedSpace int64            `json:"wasted_space"` // taken by all copies but one
+}
+
+// DuplicateReport lists the duplicated files in the active media folders, largest waste first
+type DuplicateReport struct {
+	Groups         []*DuplicateGroup `json:"groups"`
+	DuplicateFiles int               `json:"duplicate_files"` // copies beyond the first of each group
+	WastedSpace    int64             `json:"wasted_space"`
+	HashedFiles    int               `json:"hashed_files"` // files sharing their size with another, so fingerprinted
+	Hashing        bool              `json:"hashing"`      // whether a hashing pass is running
+	UpdatedAt      time.Time         `json:"updated_at"`
+}
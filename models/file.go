@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // FileInfo represents information about a file or directory
@@ -14,6 +15,15 @@ type FileInfo struct {
 	Size      int64  `json:"size"`
 	Extension string `json:"extension"`
 	IsMedia   bool   `json:"is_media"`
+
+	// Sidecar subtitles of a video file
+	Subtitles []SubtitleTrack `json:"subtitles,omitempty"`
+
+	// Tags of an audio file
+	Tags *AudioTags `json:"tags,omitempty"`
+
+	// Container metadata of a video file
+	Video *VideoInfo `json:"video,omitempty"`
 }
 
 // NewFileInfo creates a new FileInfo from an os.DirEntry
@@ -72,6 +82,7 @@ func IsMediaFile(extension string) bool {
 		".webp": true,
 		".svg":  true,
 		".tiff": true,
+		".tif":  true,
 		".ico":  true,
 	}
 	return mediaExtensions[extension]
@@ -100,7 +111,7 @@ func (f *FileInfo) GetMediaType() string {
 	imageExts := map[string]bool{
 		".jpg": true, ".jpeg": true, ".png": true, ".gif": true,
 		".bmp": true, ".webp": true, ".svg": true, ".tiff": true,
-		".ico": true,
+		".tif": true, ".ico": true,
 	}
 
 	if videoExts[ext] {
@@ -133,3 +144,12 @@ func (f *FileInfo) GetIcon() string {
 		return "📄"
 	}
 }
+
+// ArchiveEntry represents a file included in a folder or selection archive
+type ArchiveEntry struct {
+	Name     string    `json:"name"` // path inside the archive, always slash-separated
+	Path     string    `json:"path"` // media path relative to the media directory
+	FullPath string    `json:"-"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+}
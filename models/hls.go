@@ -0,0 +1,75 @@
+package models
+
+import (
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TS packet sizes
+const (
+	TSPacketSize   = 188 // plain MPEG transport stream (.ts)
+	M2TSPacketSize = 192 // BDAV/AVCHD transport stream with a 4-byte timestamp prefix (.m2ts, .mts)
+)
+
+// HLSSegment is a run of transport stream packets starting at a random access point
+type HLSSegment struct {
+	Offset   int64   `json:"offset"`   // byte offset in the source file
+	Length   int64   `json:"length"`   // bytes in the source file, a multiple of the packet size
+	Duration float64 `json:"duration"` // seconds
+}
+
+// HLSIndex describes how a transport stream file is split into HLS segments
+type HLSIndex struct {
+	Path       string       `json:"path"`
+	Size       int64        `json:"size"`
+	ModTime    time.Time    `json:"mod_time"`
+	PacketSize int          `json:"packet_size"`
+	PATOffset  int64        `json:"pat_offset"` // offset of the first PAT packet
+	PMTOffset  int64        `json:"pmt_offset"` // offset of the first PMT packet
+	StreamPID  int          `json:"stream_pid"` // PID used to find random access points
+	StreamType byte         `json:"stream_type"`
+	Segments   []HLSSegment `json:"segments"`
+}
+
+// IsHLSSource checks if a file is a transport stream that can be packaged as HLS
+func IsHLSSource(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ts", ".m2ts", ".mts":
+		return true
+	}
+	return false
+}
+
+// IsValidFor reports whether the index still matches the file it was built from
+func (hi *HLSIndex) IsValidFor(size int64, modTime time.Time) bool {
+	return hi.Size == size && hi.ModTime.Equal(modTime)
+}
+
+// TargetDuration returns the EXT-X-TARGETDURATION value, the longest segment rounded up
+func (hi *HLSIndex) TargetDuration() int {
+	target := 1
+	for _, segment := range hi.Segments {
+		if d := int(math.Ceil(segment.Duration)); d > target {
+			target = d
+		}
+	}
+	return target
+}
+
+// TotalDuration returns the sum of all segment durations in seconds
+func (hi *HLSIndex) TotalDuration() float64 {
+	var total float64
+	for _, segment := range hi.Segments {
+		total += segment.Duration
+	}
+	return total
+}
+
+// SegmentSize returns the size of a served segment: the PAT and PMT packets followed by the
+// segment's packets, all as plain 188-byte TS packets
+func (hi *HLSIndex) SegmentSize(segment HLSSegment) int64 {
+	packets := segment.Length / int64(hi.PacketSize)
+	return (packets + 2) * TSPacketSize
+}
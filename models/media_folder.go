@@ -3,6 +3,7 @@ package models
 import (
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
@@ -21,6 +22,10 @@ type MediaFolder struct {
 	FileCount   int       `json:"file_count"`
 	TotalSize   int64     `json:"total_size"`
 	MediaTypes  []string  `json:"media_types"`
+
+	// Video breakdowns from the last scan
+	VideoCodecs map[string]int `json:"video_codecs,omitempty"`
+	Resolutions map[string]int `json:"resolutions,omitempty"`
 }
 
 // MediaFolderStats represents statistics for a media folder
@@ -31,6 +36,14 @@ type MediaFolderStats struct {
 	LastModified time.Time          `json:"last_modified"`
 	ScanDuration time.Duration      `json:"scan_duration"`
 	Errors       []string           `json:"errors,omitempty"`
+
+	// Breakdowns of the videos whose containers could be probed
+	VideoCodecs map[string]int `json:"video_codecs"`
+	AudioCodecs map[string]int `json:"audio_codecs"`
+	Resolutions map[string]int `json:"resolutions"`
+
+	// The media files found, for the media index (hidden files are left out)
+	Files []*IndexedFile `json:"-"`
 }
 
 // MediaFolderRequest represents a request to add a new media folder
@@ -102,12 +115,54 @@ func (mf *MediaFolder) GetDisplayPath() string {
 	return mf.Path
 }
 
-// ScanFolder scans the media folder and returns statistics
-func (mf *MediaFolder) ScanFolder() (*MediaFolderStats, error) {
+// FolderPathPrefix starts the media paths that address a file within a media folder, as in
+// "f/<folder ID>/Movies/film.mp4"
+const FolderPathPrefix = "f/"
+
+// FolderMediaPath returns the slash-separated media path of a path relative to a media folder
+// ("" or "." for the folder itself)
+func FolderMediaPath(folderID, rel string) string {
+	rel = strings.Trim(filepath.ToSlash(rel), "/")
+	if rel == "" || rel == "." {
+		return FolderPathPrefix + folderID
+	}
+	return FolderPathPrefix + folderID + "/" + rel
+}
+
+// SplitFolderMediaPath splits a slash-separated media path of the form f/<folder ID>/<path> into
+// the folder ID and the path within the folder ("." for the folder itself)
+func SplitFolderMediaPath(mediaPath string) (string, string, bool) {
+	rest, found := strings.CutPrefix(mediaPath, FolderPathPrefix)
+	if !found || rest == "" {
+		return "", "", false
+	}
+	folderID, rel, _ := strings.Cut(rest, "/")
+	if rel == "" {
+		rel = "."
+	}
+	return folderID, rel, true
+}
+
+// SortMediaFolders orders media folders default first, then by name
+func SortMediaFolders(folders []*MediaFolder) {
+	sort.Slice(folders, func(i, j int) bool {
+		if folders[i].IsDefault != folders[j].IsDefault {
+			return folders[i].IsDefault
+		}
+		return folders[i].Name < folders[j].Name
+	})
+}
+
+// ScanFolder scans the media folder and returns statistics. probeVideo, when set, returns the
+// container metadata of a video (or nil) for the codec and resolution breakdowns.
+func (mf *MediaFolder) ScanFolder(probeVideo func(fullPath string) *VideoInfo) (*MediaFolderStats, error) {
 	startTime := time.Now()
 	stats := &MediaFolderStats{
-		MediaTypes: make(map[string]int),
-		Errors:     make([]string, 0),
+		MediaTypes:  make(map[string]int),
+		Errors:      make([]string, 0),
+		VideoCodecs: make(map[string]int),
+		AudioCodecs: make(map[string]int),
+		Resolutions: make(map[string]int),
 	}
 
 	err := filepath.Walk(mf.Path, func(path string, info os.FileInfo, err error) error {
@@ -134,6 +189,21 @@ func (mf *MediaFolder) ScanFolder() (*MediaFolderStats, error) {
 		if IsMediaFile(ext) {
 			mediaType := GetMediaType(ext)
 			stats.MediaTypes[mediaType]++
+
+			if mediaType == "video" && probeVideo != nil && IsProbeableVideo(path) {
+				if video := probeVideo(path); video != nil {
+					stats.addVideo(video)
+				}
+			}
+
+			if rel, err := filepath.Rel(mf.Path, path); err == nil && !isHiddenRelPath(rel) {
+				stats.Files = append(stats.Files, &IndexedFile{
+					Path:      filepath.ToSlash(rel),
+					Size:      info.Size(),
+					ModTime:   info.ModTime(),
+					MediaType: mediaType,
+				})
+			}
 		}
 
 		return nil
@@ -148,11 +218,34 @@ func (mf *MediaFolder) ScanFolder() (*MediaFolderStats, error) {
 	return stats, err
 }
 
+// isHiddenRelPath reports whether any element of a relative path is hidden
+func isHiddenRelPath(rel string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// addVideo counts a probed video in the codec and resolution breakdowns
+func (s *MediaFolderStats) addVideo(video *VideoInfo) {
+	if video.VideoCodec != "" {
+		s.VideoCodecs[video.VideoCodec]++
+	}
+	if video.AudioCodec != "" {
+		s.AudioCodecs[video.AudioCodec]++
+	}
+	if class := video.ResolutionClass(); class != "" {
+		s.Resolutions[class]++
+	}
+}
+
 // GetMediaType returns the media type for a file extension
 func GetMediaType(ext string) string {
 	videoExts := []string{".mp4", ".avi", ".mkv", ".mov", ".wmv", ".flv", ".webm", ".m4v", ".3gp", ".ogv"}
 	audioExts := []string{".mp3", ".wav", ".flac", ".aac", ".ogg", ".wma", ".m4a", ".opus"}
-	imageExts := []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp", ".svg", ".tiff", ".ico"}
+	imageExts := []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp", ".svg", ".tiff", ".tif", ".ico"}
 
 	extLower := strings.ToLower(ext)
 
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// IndexedFile is a media file recorded in the persistent media index
+type IndexedFile struct {
+	Path      string    `json:"path"` // relative to the media folder, with forward slashes
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+	MediaType string    `json:"media_type"`
+	AddedAt   time.Time `json:"added_at"` // when the file was first indexed
+}
+
+// Name returns the file name of an indexed file
+func (f *IndexedFile) Name() string {
+	return path.Base(f.Path)
+}
+
+// FileInfo returns the directory listing entry of an indexed file in a media folder, addressed
+// by its media path
+func (f *IndexedFile) FileInfo(folderID string) *FileInfo {
+	return &FileInfo{
+		Name:      f.Name(),
+		Path:      filepath.FromSlash(FolderMediaPath(folderID, f.Path)),
+		Size:      f.Size,
+		Extension: path.Ext(f.Path),
+		IsMedia:   true,
+	}
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FaststartLayout describes how an MP4 file whose moov box follows the media data is served
+// as a virtual file with the moov box moved in front of the first mdat box
+type FaststartLayout struct {
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"` // size of the source file
+	ModTime    time.Time `json:"mod_time"`
+	Relocated  bool      `json:"relocated"`   // false when the file can be served as is
+	InsertAt   int64     `json:"insert_at"`   // source offset of the first mdat box
+	MoovOffset int64     `json:"moov_offset"` // source offset of the original moov box
+	MoovSize   int64     `json:"moov_size"`   // size of the original moov box
+	Moov       []byte    `json:"-"`           // moov box with chunk offsets rewritten for the virtual layout
+}
+
+// IsFaststartCandidate checks if a file uses the ISO base media (MP4/QuickTime) container
+func IsFaststartCandidate(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp4", ".m4v", ".mov", ".m4a", ".3gp":
+		return true
+	}
+	return false
+}
+
+// IsValidFor reports whether the layout still matches the file it was built from
+func (fl *FaststartLayout) IsValidFor(size int64, modTime time.Time) bool {
+	return fl.Size == size && fl.ModTime.Equal(modTime)
+}
+
+// VirtualSize returns the size of the file as served
+func (fl *FaststartLayout) VirtualSize() int64 {
+	if !fl.Relocated {
+		return fl.Size
+	}
+	return fl.Size - fl.MoovSize + int64(len(fl.Moov))
+}
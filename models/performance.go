@@ -58,6 +58,67 @@ type StreamingMetrics struct {
 	ConcurrentPeak    int     `json:"concurrent_peak"`    // max concurrent streams
 	BufferPoolSize    int     `json:"buffer_pool_size"`
 	BufferPoolActive  int     `json:"buffer_pool_active"`
+	ThrottledStreams  int     `json:"throttled_streams"`    // streams currently subject to a bandwidth limit
+	CurrentThroughput float64 `json:"current_throughput"`   // bytes per second through the bandwidth limiter
+	ActiveDownloads   int     `json:"active_downloads"`
+	TotalDownloads    int64   `json:"total_downloads"`
+	BytesDownloaded   int64   `json:"bytes_downloaded"`
+	Sessions          []PlaybackSession `json:"sessions"` // open playback and download sessions
+}
+
+// PlaybackSession represents one client playing (or downloading) one file. All the range
+// requests a player makes for the file are grouped into the same session.
+type PlaybackSession struct {
+	ID             string        `json:"id"`
+	ClientIP       string        `json:"client_ip"`
+	MediaPath      string        `json:"media_path"`
+	Download       bool          `json:"download"`
+	FileSize       int64         `json:"file_size"`
+	ActiveRequests int           `json:"active_requests"`
+	TotalRequests  int64         `json:"total_requests"`
+	BytesServed    int64         `json:"bytes_served"`
+	TransferTime   time.Duration `json:"transfer_time"` // time spent serving requests
+	LastPosition   int64         `json:"last_position"` // byte offset reached by the last request
+	StartedAt      time.Time     `json:"started_at"`
+	LastActive     time.Time     `json:"last_active"`
+}
+
+// Duration returns the wall-clock time from the first request to the latest activity
+func (ps *PlaybackSession) Duration() time.Duration {
+	return ps.LastActive.Sub(ps.StartedAt)
+}
+
+// Progress returns the last position as a percentage of the file size
+func (ps *PlaybackSession) Progress() float64 {
+	if ps.FileSize <= 0 {
+		return 0
+	}
+	return float64(ps.LastPosition) / float64(ps.FileSize) * 100
+}
+
+// AverageSpeed returns the session's transfer speed in bytes per second
+func (ps *PlaybackSession) AverageSpeed() float64 {
+	if ps.TransferTime <= 0 {
+		return 0
+	}
+	return float64(ps.BytesServed) / ps.TransferTime.Seconds()
+}
+
+// BandwidthLimits represents the configured streaming rate limits in bytes per second (0 = unlimited)
+type BandwidthLimits struct {
+	GlobalLimit    int64            `json:"global_limit"`
+	PerIPLimit     int64            `json:"per_ip_limit"`
+	PerFolderLimit int64            `json:"per_folder_limit"`        // default limit for every media folder
+	FolderLimits   map[string]int64 `json:"folder_limits,omitempty"` // overrides keyed by media folder ID
+}
+
+// BandwidthStats represents current bandwidth usage and limits
+type BandwidthStats struct {
+	Limits           BandwidthLimits    `json:"limits"`
+	GlobalThroughput float64            `json:"global_throughput"` // bytes per second
+	ClientThroughput map[string]float64 `json:"client_throughput"` // bytes per second keyed by client IP
+	FolderThroughput map[string]float64 `json:"folder_throughput"` // bytes per second keyed by media folder ID
+	ThrottledStreams int                `json:"throttled_streams"`
 }
 
 // DatabaseMetrics represents metrics for database operations (if applicable)
@@ -182,6 +243,38 @@ func (sm *StreamingMetrics) GetTotalStreamedGB() float64 {
 	return float64(sm.BytesStreamed) / 1024 / 1024 / 1024
 }
 
+// GetCurrentThroughput returns the current throttled streaming throughput in MB/s
+func (sm *StreamingMetrics) GetCurrentThroughput() float64 {
+	return sm.CurrentThroughput / 1024 / 1024
+}
+
+// GetFolderLimit returns the effective limit for a media folder
+func (bl *BandwidthLimits) GetFolderLimit(folderID string) int64 {
+	if limit, exists := bl.FolderLimits[folderID]; exists {
+		return limit
+	}
+	return bl.PerFolderLimit
+}
+
+// Validate checks that all limits are non-negative
+func (bl *BandwidthLimits) Validate() error {
+	if bl.GlobalLimit < 0 {
+		return NewValidationError("global_limit", "Global limit cannot be negative")
+	}
+	if bl.PerIPLimit < 0 {
+		return NewValidationError("per_ip_limit", "Per-IP limit cannot be negative")
+	}
+	if bl.PerFolderLimit < 0 {
+		return NewValidationError("per_folder_limit", "Per-folder limit cannot be negative")
+	}
+	for folderID, limit := range bl.FolderLimits {
+		if limit < 0 {
+			return NewValidationError("folder_limits", "Limit for folder "+folderID+" cannot be negative")
+		}
+	}
+	return nil
+}
+
 // GetQuerySuccessRate returns database query success rate
 func (dm *DatabaseMetrics) GetQuerySuccessRate() float64 {
 	if dm.TotalQueries == 0 {
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Photo date sources
+const (
+	PhotoDateEXIF    = "exif"
+	PhotoDateModTime = "mtime"
+)
+
+// GPSPosition is a position recorded by a camera, in decimal degrees and meters
+type GPSPosition struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude,omitempty"`
+}
+
+// PhotoMetadata holds the EXIF metadata of a photo
+type PhotoMetadata struct {
+	Path         string       `json:"-"`
+	Size         int64        `json:"-"`
+	ModTime      time.Time    `json:"-"`
+	TakenAt      time.Time    `json:"taken_at"`
+	DateSource   string       `json:"date_source"` // exif or mtime
+	Make         string       `json:"make,omitempty"`
+	Model        string       `json:"model,omitempty"`
+	Lens         string       `json:"lens,omitempty"`
+	ExposureTime string       `json:"exposure_time,omitempty"` // e.g. "1/250"
+	FNumber      float64      `json:"f_number,omitempty"`
+	ISO          int          `json:"iso,omitempty"`
+	FocalLength  float64      `json:"focal_length,omitempty"` // millimeters
+	Orientation  int          `json:"orientation,omitempty"`
+	GPS          *GPSPosition `json:"gps,omitempty"`
+}
+
+// IsEXIFSource checks if EXIF metadata can be read from an image file
+func IsEXIFSource(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".tif", ".tiff":
+		return true
+	}
+	return false
+}
+
+// IsValidFor reports whether the metadata still matches the file it was read from
+func (pm *PhotoMetadata) IsValidFor(size int64, modTime time.Time) bool {
+	return pm.Size == size && pm.ModTime.Equal(modTime)
+}
+
+// Camera returns the camera make and model, without repeating the make when the model
+// already includes it (as in "Canon" "Canon EOS R5")
+func (pm *PhotoMetadata) Camera() string {
+	if pm.Make == "" || strings.HasPrefix(strings.ToLower(pm.Model), strings.ToLower(pm.Make)) {
+		return pm.Model
+	}
+	if pm.Model == "" {
+		return pm.Make
+	}
+	return pm.Make + " " + pm.Model
+}
+
+// Exposure returns a summary of the exposure settings such as "1/250s f/2.8 ISO 200 35mm"
+func (pm *PhotoMetadata) Exposure() string {
+	var parts []string
+	if pm.ExposureTime != "" {
+		parts = append(parts, pm.ExposureTime+"s")
+	}
+	if pm.FNumber > 0 {
+		parts = append(parts, fmt.Sprintf("f/%g", pm.FNumber))
+	}
+	if pm.ISO > 0 {
+		parts = append(parts, fmt.Sprintf("ISO %d", pm.ISO))
+	}
+	if pm.FocalLength > 0 {
+		parts = append(parts, fmt.Sprintf("%gmm", pm.FocalLength))
+	}
+	return strings.Join(parts, " ")
+}
+
+// TimelinePhoto is an image placed on the photo timeline
+type TimelinePhoto struct {
+	Name      string         `json:"name"`
+	Path      string         `json:"path"`       // relative to its media folder
+	FolderID  string         `json:"folder_id"`  // media folder containing the photo
+	MediaPath string         `json:"media_path"` // f/<folder ID>/<path>, for stream and thumbnail URLs
+	Size      int64          `json:"size"`
+	TakenAt   time.Time      `json:"taken_at"`
+	Metadata  *PhotoMetadata `json:"metadata"`
+}
+
+// PhotoDay groups the photos taken on one day, oldest first
+type PhotoDay struct {
+	Date   string           `json:"date"` // YYYY-MM-DD
+	Day    int              `json:"day"`
+	Photos []*TimelinePhoto `json:"photos"`
+}
+
+// PhotoMonth groups the days of a month, newest first
+type PhotoMonth struct {
+	Month int         `json:"month"`
+	Name  string      `json:"name"`
+	Count int         `json:"count"`
+	Days  []*PhotoDay `json:"days"`
+}
+
+// PhotoYear groups the months of a year, newest first
+type PhotoYear struct {
+	Year   int           `json:"year"`
+	Count  int           `json:"count"`
+	Months []*PhotoMonth `json:"months"`
+}
+
+// PhotoTimeline groups photos by year, month and day, newest first
+type PhotoTimeline struct {
+	Count       int          `json:"count"`
+	Years       []*PhotoYear `json:"years"`
+	GeneratedAt time.Time    `json:"generated_at"`
+}
+
+// NewPhotoTimeline groups photos by the calendar date they were taken on
+func NewPhotoTimeline(photos []*TimelinePhoto) *PhotoTimeline {
+	timeline := &PhotoTimeline{
+		Years:       make([]*PhotoYear, 0),
+		GeneratedAt: time.Now(),
+	}
+
+	// Newest day first, photos within a day in the order they were taken
+	sorted := make([]*TimelinePhoto, len(photos))
+	copy(sorted, photos)
+	sortTimelinePhotos(sorted)
+
+	var year *PhotoYear
+	var month *PhotoMonth
+	var day *PhotoDay
+	for _, photo := range sorted {
+		y, m, d := photo.TakenAt.Date()
+		if year == nil || year.Year != y {
+			year = &PhotoYear{Year: y}
+			timeline.Years = append(timeline.Years, year)
+			month = nil
+		}
+		if month == nil || month.Month != int(m) {
+			month = &PhotoMonth{Month: int(m), Name: m.String()}
+			year.Months = append(year.Months, month)
+			day = nil
+		}
+		if day == nil || day.Day != d {
+			day = &PhotoDay{Date: fmt.Sprintf("%04d-%02d-%02d", y, m, d), Day: d}
+			month.Days = append(month.Days, day)
+		}
+		day.Photos = append(day.Photos, photo)
+		month.Count++
+		year.Count++
+		timeline.Count++
+	}
+
+	return timeline
+}
+
+// Filter returns the part of the timeline for a year and optionally a month (0 for all months)
+func (pt *PhotoTimeline) Filter(year, month int) *PhotoTimeline {
+	filtered := &PhotoTimeline{
+		Years:       make([]*PhotoYear, 0),
+		GeneratedAt: pt.GeneratedAt,
+	}
+	for _, y := range pt.Years {
+		if y.Year != year {
+			continue
+		}
+		if month == 0 {
+			filtered.Years = append(filtered.Years, y)
+			filtered.Count += y.Count
+			continue
+		}
+		for _, m := range y.Months {
+			if m.Month == month {
+				filtered.Years = append(filtered.Years, &PhotoYear{Year: y.Year, Count: m.Count, Months: []*PhotoMonth{m}})
+				filtered.Count += m.Count
+			}
+		}
+	}
+	return filtered
+}
+
+// sortTimelinePhotos orders photos by day (newest first), then by time of day (oldest first)
+// and path
+func sortTimelinePhotos(photos []*TimelinePhoto) {
+	dayOf := func(t time.Time) int {
+		y, m, d := t.Date()
+		return y*10000 + int(m)*100 + d
+	}
+	sort.Slice(photos, func(i, j int) bool {
+		a, b := photos[i], photos[j]
+		if da, db := dayOf(a.TakenAt), dayOf(b.TakenAt); da != db {
+			return da > db
+		}
+		if !a.TakenAt.Equal(b.TakenAt) {
+			return a.TakenAt.Before(b.TakenAt)
+		}
+		return a.Path < b.Path
+	})
+}
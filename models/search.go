@@ -0,0 +1,236 @@
+package models
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Search sort orders
+const (
+	SearchSortRelevance = "relevance"
+	SearchSortName      = "name"
+	SearchSortSize      = "size"
+	SearchSortModified  = "modified"
+	SearchSortAdded     = "added"
+)
+
+// Search date fields
+const (
+	SearchDateModified = "modified"
+	SearchDateAdded    = "added"
+)
+
+// Search page sizes
+const (
+	DefaultSearchPerPage = 50
+	MaxSearchPerPage     = 200
+)
+
+// SearchQuery is a library search: free text plus filters, all of which must match
+type SearchQuery struct {
+	Text       string    `json:"text"`
+	MediaTypes []string  `json:"media_types,omitempty"` // video, audio or image
+	FolderIDs  []string  `json:"folder_ids,omitempty"`
+	Extensions []string  `json:"extensions,omitempty"` // lowercase, with the leading dot
+	MinSize    int64     `json:"min_size,omitempty"`   // bytes
+	MaxSize    int64     `json:"max_size,omitempty"`   // bytes, 0 for no limit
+	From       time.Time `json:"-"`                    // inclusive
+	To         time.Time `json:"-"`                    // exclusive
+	DateField  string    `json:"date_field"`           // modified or added
+	Sort       string    `json:"sort"`
+	Page       int       `json:"page"`
+	PerPage    int       `json:"per_page"`
+}
+
+// SearchHit is a media file matching a search
+type SearchHit struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`       // relative to its media folder, with forward slashes
+	MediaPath string    `json:"media_path"` // f/<folder ID>/<path>, as served by the player and streams
+	FolderID  string    `json:"folder_id"`
+	Folder    string    `json:"folder"`
+	MediaType string    `json:"media_type"`
+	Extension string    `json:"extension"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+	AddedAt   time.Time `json:"added_at"`
+	Score     float64   `json:"score"`
+	Summary   string    `json:"summary,omitempty"` // known metadata, such as artist and album
+	PlayerURL string    `json:"player_url,omitempty"`
+	ImageURL  string    `json:"image_url,omitempty"` // thumbnail or cover art
+}
+
+// FacetCount is the number of matches sharing a facet value
+type FacetCount struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+// SearchFacets counts the matches by media type, folder and extension. Each facet is counted
+// with every filter applied except its own, so that the counts show what selecting another
+// value would find.
+type SearchFacets struct {
+	MediaTypes []FacetCount `json:"media_types"`
+	Folders    []FacetCount `json:"folders"`
+	Extensions []FacetCount `json:"extensions"`
+}
+
+// SearchResult is one page of search hits with the facets of all matches
+type SearchResult struct {
+	Query   *SearchQuery `json:"query"`
+	Total   int          `json:"total"`
+	Page    int          `json:"page"`
+	PerPage int          `json:"per_page"`
+	Pages   int          `json:"pages"`
+	Hits    []*SearchHit `json:"hits"`
+	Facets  SearchFacets `json:"facets"`
+}
+
+// ParseSearchQuery reads a search from URL query parameters: q, type, folder and ext (each
+// repeatable or comma-separated), min_size and max_size (bytes, or with a k, m or g suffix),
+// from and to (YYYY-MM-DD, inclusive), date (modified or added), sort, page and per_page
+func ParseSearchQuery(values url.Values) (*SearchQuery, error) {
+	query := &SearchQuery{
+		Text:      strings.TrimSpace(values.Get("q")),
+		DateField: SearchDateModified,
+		Sort:      SearchSortRelevance,
+		Page:      1,
+		PerPage:   DefaultSearchPerPage,
+	}
+
+	for _, mediaType := range listParam(values, "type") {
+		mediaType = strings.ToLower(mediaType)
+		switch mediaType {
+		case "video", "audio", "image":
+			query.MediaTypes = append(query.MediaTypes, mediaType)
+		default:
+			return nil, NewValidationError("type", "unsupported media type: "+mediaType)
+		}
+	}
+	query.FolderIDs = listParam(values, "folder")
+	for _, ext := range listParam(values, "ext") {
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		query.Extensions = append(query.Extensions, ext)
+	}
+
+	var err error
+	if query.MinSize, err = parseSizeParam(values, "min_size"); err != nil {
+		return nil, err
+	}
+	if query.MaxSize, err = parseSizeParam(values, "max_size"); err != nil {
+		return nil, err
+	}
+	if query.MaxSize > 0 && query.MinSize > query.MaxSize {
+		return nil, NewValidationError("max_size", "max_size is smaller than min_size")
+	}
+
+	if query.From, err = parseDateParam(values, "from"); err != nil {
+		return nil, err
+	}
+	if query.To, err = parseDateParam(values, "to"); err != nil {
+		return nil, err
+	}
+	if !query.To.IsZero() {
+		// Include the whole of the last day
+		query.To = query.To.AddDate(0, 0, 1)
+		if !query.From.IsZero() && !query.From.Before(query.To) {
+			return nil, NewValidationError("to", "to is before from")
+		}
+	}
+
+	if value := values.Get("date"); value != "" {
+		switch value {
+		case SearchDateModified, SearchDateAdded:
+			query.DateField = value
+		default:
+			return nil, NewValidationError("date", "unsupported date field: "+value)
+		}
+	}
+
+	if value := values.Get("sort"); value != "" {
+		switch value {
+		case SearchSortRelevance, SearchSortName, SearchSortSize, SearchSortModified, SearchSortAdded:
+			query.Sort = value
+		default:
+			return nil, NewValidationError("sort", "unsupported sort order: "+value)
+		}
+	}
+
+	if value := values.Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return nil, NewValidationError("page", "invalid page: "+value)
+		}
+		query.Page = page
+	}
+	if value := values.Get("per_page"); value != "" {
+		perPage, err := strconv.Atoi(value)
+		if err != nil || perPage < 1 || perPage > MaxSearchPerPage {
+			return nil, NewValidationError("per_page", "per_page must be between 1 and "+strconv.Itoa(MaxSearchPerPage))
+		}
+		query.PerPage = perPage
+	}
+
+	return query, nil
+}
+
+// listParam returns the non-empty values of a repeatable, comma-separated query parameter
+func listParam(values url.Values, name string) []string {
+	var list []string
+	for _, value := range values[name] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// parseSizeParam parses a size in bytes, optionally with a k, m or g (binary) suffix
+func parseSizeParam(values url.Values, name string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(values.Get(name)))
+	if value == "" {
+		return 0, nil
+	}
+
+	number := strings.TrimSuffix(value, "b")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(number, "k"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(number, "m"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(number, "g"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		number = number[:len(number)-1]
+	}
+
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size < 0 {
+		return 0, NewValidationError(name, "invalid size: "+value)
+	}
+	return int64(size * float64(multiplier)), nil
+}
+
+// parseDateParam parses a YYYY-MM-DD date in local time
+func parseDateParam(values url.Values, name string) (time.Time, error) {
+	value := strings.TrimSpace(values.Get(name))
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, NewValidationError(name, "invalid date (expected YYYY-MM-DD): "+value)
+	}
+	return date, nil
+}
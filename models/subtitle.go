@@ -0,0 +1,140 @@
+package models
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// SubtitleTrack is a sidecar subtitle file found next to a video
+type SubtitleTrack struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Format   string `json:"format"` // srt, vtt, ass or ssa
+	Language string `json:"language,omitempty"`
+	Label    string `json:"label"`
+	Forced   bool   `json:"forced,omitempty"`
+}
+
+// languageNames maps common ISO 639 codes to display names
+var languageNames = map[string]string{
+	"ar": "Arabic", "ara": "Arabic",
+	"cs": "Czech", "cze": "Czech", "ces": "Czech",
+	"da": "Danish", "dan": "Danish",
+	"de": "German", "ger": "German", "deu": "German",
+	"el": "Greek", "gre": "Greek", "ell": "Greek",
+	"en": "English", "eng": "English",
+	"es": "Spanish", "spa": "Spanish",
+	"fi": "Finnish", "fin": "Finnish",
+	"fr": "French", "fre": "French", "fra": "French",
+	"he": "Hebrew", "heb": "Hebrew",
+	"hi": "Hindi", "hin": "Hindi",
+	"hu": "Hungarian", "hun": "Hungarian",
+	"id": "Indonesian", "ind": "Indonesian",
+	"it": "Italian", "ita": "Italian",
+	"ja": "Japanese", "jpn": "Japanese",
+	"ko": "Korean", "kor": "Korean",
+	"nl": "Dutch", "dut": "Dutch", "nld": "Dutch",
+	"no": "Norwegian", "nor": "Norwegian",
+	"pl": "Polish", "pol": "Polish",
+	"pt": "Portuguese", "por": "Portuguese",
+	"ro": "Romanian", "rum": "Romanian", "ron": "Romanian",
+	"ru": "Russian", "rus": "Russian",
+	"sv": "Swedish", "swe": "Swedish",
+	"sw": "Swahili", "swa": "Swahili",
+	"th": "Thai", "tha": "Thai",
+	"tr": "Turkish", "tur": "Turkish",
+	"uk": "Ukrainian", "ukr": "Ukrainian",
+	"vi": "Vietnamese", "vie": "Vietnamese",
+	"zh": "Chinese", "chi": "Chinese", "zho": "Chinese",
+}
+
+// IsSubtitleFile checks if a file extension represents a supported subtitle format
+func IsSubtitleFile(extension string) bool {
+	switch strings.ToLower(extension) {
+	case ".srt", ".vtt", ".ass", ".ssa":
+		return true
+	}
+	return false
+}
+
+// ParseSubtitleTrack checks whether subtitleName is a sidecar subtitle of videoName, i.e. it has
+// the same basename optionally followed by language and flag suffixes ("Movie.en.forced.srt"),
+// and describes the track if so. path is the media path of the subtitle file.
+func ParseSubtitleTrack(videoName, subtitleName, path string) (*SubtitleTrack, bool) {
+	ext := filepath.Ext(subtitleName)
+	if !IsSubtitleFile(ext) {
+		return nil, false
+	}
+
+	videoBase := strings.TrimSuffix(videoName, filepath.Ext(videoName))
+	base := strings.TrimSuffix(subtitleName, ext)
+	if !strings.EqualFold(base, videoBase) && !strings.HasPrefix(strings.ToLower(base), strings.ToLower(videoBase)+".") {
+		return nil, false
+	}
+
+	track := &SubtitleTrack{
+		Name:   subtitleName,
+		Path:   path,
+		Format: strings.ToLower(strings.TrimPrefix(ext, ".")),
+	}
+
+	var extra []string
+	var flags []string
+	for _, token := range strings.Split(base[len(videoBase):], ".") {
+		if token == "" {
+			continue
+		}
+		lower := strings.ToLower(token)
+		switch {
+		case lower == "forced":
+			track.Forced = true
+			flags = append(flags, "Forced")
+		case lower == "sdh" || lower == "cc":
+			flags = append(flags, "SDH")
+		case track.Language == "" && isLanguageCode(lower):
+			track.Language = lower
+		default:
+			extra = append(extra, token)
+		}
+	}
+
+	label := "Subtitles"
+	if track.Language != "" {
+		label = LanguageName(track.Language)
+	}
+	if len(extra) > 0 {
+		if track.Language != "" {
+			label += " - " + strings.Join(extra, " ")
+		} else {
+			label = strings.Join(extra, " ")
+		}
+	}
+	if len(flags) > 0 {
+		label += " (" + strings.Join(flags, ", ") + ")"
+	}
+	track.Label = label
+
+	return track, true
+}
+
+// isLanguageCode checks if a filename token looks like a language tag ("en", "eng", "pt-br")
+func isLanguageCode(token string) bool {
+	primary, region, hasRegion := strings.Cut(token, "-")
+	if _, known := languageNames[primary]; !known {
+		return false
+	}
+	return !hasRegion || (len(region) >= 2 && len(region) <= 4)
+}
+
+// LanguageName returns the display name of a language tag, or the tag itself when unknown
+func LanguageName(code string) string {
+	primary, region, hasRegion := strings.Cut(strings.ToLower(code), "-")
+	name, known := languageNames[primary]
+	if !known {
+		return code
+	}
+	if hasRegion {
+		name += " (" + strings.ToUpper(region) + ")"
+	}
+	return name
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// ThumbnailSizes maps the supported thumbnail size names to their maximum edge length in pixels
+var ThumbnailSizes = map[string]int{
+	"small":  160,
+	"medium": 320,
+	"large":  640,
+}
+
+// DefaultThumbnailSize is used when no size is requested
+const DefaultThumbnailSize = "medium"
+
+// IsThumbnailSource checks if thumbnails can be generated for a file
+func IsThumbnailSource(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	}
+	return false
+}
+
+// ParseThumbnailSize validates a requested thumbnail size name and returns its edge length
+func ParseThumbnailSize(name string) (string, int, error) {
+	if name == "" {
+		name = DefaultThumbnailSize
+	}
+	edge, ok := ThumbnailSizes[strings.ToLower(name)]
+	if !ok {
+		return "", 0, NewValidationError("size", "unsupported thumbnail size: "+name)
+	}
+	return strings.ToLower(name), edge, nil
+}
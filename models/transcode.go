@@ -0,0 +1,69 @@
+package models
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TranscodeFormat identifies a browser-compatible rendition produced by a transcoder
+type TranscodeFormat string
+
+// Supported transcode output formats
+const (
+	TranscodeFormatMP4 TranscodeFormat = "mp4" // fragmented MP4 (H.264/AAC), a single file
+	TranscodeFormatHLS TranscodeFormat = "hls" // HLS VOD playlist with MPEG-TS segments
+)
+
+// Output file names inside a rendition directory
+const (
+	TranscodeMP4Name      = "stream.mp4"
+	TranscodePlaylistName = "index.m3u8"
+)
+
+// Transcode job states
+const (
+	TranscodeQueued  = "queued"
+	TranscodeRunning = "running"
+	TranscodeReady   = "ready"
+	TranscodeFailed  = "failed"
+)
+
+// TranscodeJob tracks the production of one rendition of a source file
+type TranscodeJob struct {
+	ID         string          `json:"id"`
+	Path       string          `json:"-"` // full path of the source file
+	Format     TranscodeFormat `json:"format"`
+	Status     string          `json:"status"`
+	Error      string          `json:"error,omitempty"`
+	OutputDir  string          `json:"-"`
+	Size       int64           `json:"size"` // bytes on disk once ready
+	QueuedAt   time.Time       `json:"queued_at"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+}
+
+// ParseTranscodeFormat validates a requested format, defaulting to fragmented MP4
+func ParseTranscodeFormat(value string) (TranscodeFormat, error) {
+	switch TranscodeFormat(strings.ToLower(value)) {
+	case "", TranscodeFormatMP4:
+		return TranscodeFormatMP4, nil
+	case TranscodeFormatHLS:
+		return TranscodeFormatHLS, nil
+	}
+	return "", NewValidationError("format", "unsupported transcode format: "+value)
+}
+
+// NeedsTranscoding checks if a media file uses a container most browsers cannot play directly
+func NeedsTranscoding(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mkv", ".avi", ".wmv", ".flv":
+		return true
+	}
+	return false
+}
+
+// IsDone reports whether the job has finished, successfully or not
+func (tj *TranscodeJob) IsDone() bool {
+	return tj.Status == TranscodeReady || tj.Status == TranscodeFailed
+}
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MediaTrack describes an audio or subtitle track of a video file
+type MediaTrack struct {
+	Codec      string `json:"codec"`
+	Language   string `json:"language,omitempty"` // ISO 639 code
+	Name       string `json:"name,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+	SampleRate int    `json:"sample_rate,omitempty"`
+	Default    bool   `json:"default,omitempty"`
+	Forced     bool   `json:"forced,omitempty"`
+}
+
+// VideoInfo holds the metadata read from the container of a video file
+type VideoInfo struct {
+	Path           string       `json:"-"`
+	Size           int64        `json:"-"`
+	ModTime        time.Time    `json:"-"`
+	Container      string       `json:"container"`          // mp4, matroska or webm
+	Duration       float64      `json:"duration,omitempty"` // seconds
+	Width          int          `json:"width,omitempty"`
+	Height         int          `json:"height,omitempty"`
+	FrameRate      float64      `json:"frame_rate,omitempty"`
+	VideoCodec     string       `json:"video_codec,omitempty"`
+	AudioCodec     string       `json:"audio_codec,omitempty"` // codec of the first audio track
+	AudioTracks    []MediaTrack `json:"audio_tracks,omitempty"`
+	SubtitleTracks []MediaTrack `json:"subtitle_tracks,omitempty"`
+}
+
+// IsProbeableVideo checks if container metadata can be read from a video file
+func IsProbeableVideo(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp4", ".m4v", ".mov", ".3gp", ".mkv", ".webm":
+		return true
+	}
+	return false
+}
+
+// IsValidFor reports whether the metadata still matches the file it was read from
+func (vi *VideoInfo) IsValidFor(size int64, modTime time.Time) bool {
+	return vi.Size == size && vi.ModTime.Equal(modTime)
+}
+
+// FormattedDuration returns the duration as m:ss or h:mm:ss, or "" when it is unknown
+func (vi *VideoInfo) FormattedDuration() string {
+	return formatDuration(vi.Duration)
+}
+
+// Resolution returns the frame size as "1920x1080", or "" when it is unknown
+func (vi *VideoInfo) Resolution() string {
+	if vi.Width <= 0 || vi.Height <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d", vi.Width, vi.Height)
+}
+
+// ResolutionClass groups the frame size into the usual classes (2160p, 1080p, 720p, 480p, SD),
+// going by the larger of the frame's lines and its 16:9-equivalent height so that cropped
+// widescreen and portrait videos land in the expected class
+func (vi *VideoInfo) ResolutionClass() string {
+	if vi.Width <= 0 || vi.Height <= 0 {
+		return ""
+	}
+	long, short := max(vi.Width, vi.Height), min(vi.Width, vi.Height)
+	lines := max(short, long*9/16)
+	switch {
+	case lines >= 2000:
+		return "2160p"
+	case lines >= 1000:
+		return "1080p"
+	case lines >= 700:
+		return "720p"
+	case lines >= 460:
+		return "480p"
+	}
+	return "SD"
+}
+
+// FormattedFrameRate returns the frame rate rounded to two decimals, or "" when it is unknown
+func (vi *VideoInfo) FormattedFrameRate() string {
+	if vi.FrameRate <= 0 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", vi.FrameRate), "0"), ".") + " fps"
+}
+
+// Label returns a display label for a track, such as "English (AAC 5.1)"
+func (mt MediaTrack) Label() string {
+	details := mt.Codec
+	switch mt.Channels {
+	case 0:
+	case 1:
+		details += " mono"
+	case 2:
+		details += " stereo"
+	case 6:
+		details += " 5.1"
+	case 8:
+		details += " 7.1"
+	default:
+		details += fmt.Sprintf(" %dch", mt.Channels)
+	}
+
+	label := mt.Name
+	if label == "" && mt.Language != "" {
+		label = LanguageName(mt.Language)
+	}
+	if mt.Forced {
+		details += ", forced"
+	}
+	if label == "" {
+		return details
+	}
+	return label + " (" + details + ")"
+}
+
+// codecNames maps MP4 sample entry types and Matroska codec IDs to display names
+var codecNames = map[string]string{
+	// MP4 sample entries
+	"avc1": "H.264", "avc3": "H.264", "hvc1": "HEVC", "hev1": "HEVC", "av01": "AV1",
+	"vp08": "VP8", "vp09": "VP9", "mp4v": "MPEG-4", "s263": "H.263",
+	"apch": "ProRes", "apcn": "ProRes", "apcs": "ProRes", "apco": "ProRes", "ap4h": "ProRes",
+	"mp4a": "AAC", "ac-3": "AC-3", "ec-3": "E-AC-3", "Opus": "Opus", "fLaC": "FLAC",
+	"alac": "ALAC", ".mp3": "MP3", "lpcm": "PCM", "sowt": "PCM", "twos": "PCM",
+	"tx3g": "Timed Text", "wvtt": "WebVTT", "stpp": "TTML", "c608": "CEA-608",
+	// Matroska codec IDs
+	"V_MPEG4/ISO/AVC": "H.264", "V_MPEGH/ISO/HEVC": "HEVC", "V_AV1": "AV1", "V_VP8": "VP8",
+	"V_VP9": "VP9", "V_MPEG4/ISO/ASP": "MPEG-4", "V_MPEG2": "MPEG-2", "V_MPEG1": "MPEG-1",
+	"V_THEORA": "Theora", "V_MS/VFW/FOURCC": "VFW",
+	"A_AAC": "AAC", "A_AC3": "AC-3", "A_EAC3": "E-AC-3", "A_DTS": "DTS", "A_TRUEHD": "TrueHD",
+	"A_OPUS": "Opus", "A_VORBIS": "Vorbis", "A_FLAC": "FLAC", "A_MPEG/L3": "MP3",
+	"A_MPEG/L2": "MP2", "A_PCM/INT/LIT": "PCM", "A_PCM/INT/BIG": "PCM", "A_PCM/FLOAT/IEEE": "PCM",
+	"S_TEXT/UTF8": "SRT", "S_TEXT/SSA": "SSA", "S_TEXT/ASS": "ASS", "S_TEXT/WEBVTT": "WebVTT",
+	"S_HDMV/PGS": "PGS", "S_VOBSUB": "VobSub", "S_DVBSUB": "DVB",
+}
+
+// CodecName returns the display name of an MP4 sample entry type or Matroska codec ID
+func CodecName(codec string) string {
+	if name, known := codecNames[codec]; known {
+		return name
+	}
+	// Matroska codec IDs carry variants after the base ID, e.g. A_AAC/MPEG4/LC
+	for prefix, name := range codecNames {
+		if strings.HasPrefix(codec, prefix+"/") {
+			return name
+		}
+	}
+	return strings.TrimSpace(codec)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"media-server/models"
+	"sort"
 	"sync"
 	"time"
 )
@@ -22,8 +23,33 @@ type AdminService struct {
 	logMutex           sync.RWMutex
 	performanceService *PerformanceService
 	cacheService       *CacheService
+	bandwidthService   *BandwidthService
+	fileService        *FileService
 	streamingMetrics   models.StreamingMetrics
 	streamingMutex     sync.RWMutex
+	streamSessions     map[string]*models.PlaybackSession
+	speedSamples       int64
+	maxStreamsPerIP    int
+	maxStreamsGlobal   int
+}
+
+// maxSessionRequests caps the concurrent requests of one playback session, so that parallel
+// range requests cannot serve a file many times over as a single stream
+const maxSessionRequests = 4
+
+// streamSessionLinger is how long a playback stays admitted after its last request finishes,
+// so that the follow-up range requests of a player are not counted as new streams
+const streamSessionLinger = 30 * time.Second
+
+// StreamLimitError is returned by StartStream when a concurrent stream limit is reached
+type StreamLimitError struct {
+	Scope      string // "session", "client" or "global"
+	Limit      int
+	RetryAfter time.Duration
+}
+
+func (e *StreamLimitError) Error() string {
+	return fmt.Sprintf("%s stream limit of %d reached", e.Scope, e.Limit)
 }
 
 // NewAdminService creates a new AdminService instance
@@ -37,9 +63,19 @@ func NewAdminService() *AdminService {
 		totalConnectionsEver: 0,
 		startTime:            time.Now(),
 		streamingMetrics:     models.StreamingMetrics{},
+		streamSessions:       make(map[string]*models.PlaybackSession),
 	}
 }
 
+// SetStreamLimits sets the maximum number of concurrent streams per client IP and server-wide (0 = unlimited)
+func (as *AdminService) SetStreamLimits(perIP, global int) {
+	as.streamingMutex.Lock()
+	defer as.streamingMutex.Unlock()
+
+	as.maxStreamsPerIP = perIP
+	as.maxStreamsGlobal = global
+}
+
 // SetPerformanceService sets the performance service for monitoring
 func (as *AdminService) SetPerformanceService(ps *PerformanceService) {
 	as.mutex.Lock()
@@ -54,6 +90,45 @@ func (as *AdminService) SetCacheService(cs *CacheService) {
 	as.cacheService = cs
 }
 
+// SetBandwidthService sets the bandwidth service for throughput reporting
+func (as *AdminService) SetBandwidthService(bs *BandwidthService) {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	as.bandwidthService = bs
+}
+
+// SetFileService sets the file service media paths are canonicalized with, so that a media
+// password covers every URL form of a file. Passwords set before are moved to their canonical paths.
+func (as *AdminService) SetFileService(fs *FileService) {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	as.fileService = fs
+
+	mediaAccess := make(map[string]*models.MediaAccess, len(as.mediaAccess))
+	for mediaPath, access := range as.mediaAccess {
+		canonical := as.canonicalMediaPath(mediaPath)
+		if existing, exists := mediaAccess[canonical]; exists && existing.CreatedAt.After(access.CreatedAt) {
+			continue
+		}
+		access.MediaPath = canonical
+		mediaAccess[canonical] = access
+	}
+	as.mediaAccess = mediaAccess
+}
+
+// canonicalMediaPath returns the path a media password is stored under: the f/<folder ID>/<path>
+// form of mediaPath, or mediaPath itself when it does not resolve
+func (as *AdminService) canonicalMediaPath(mediaPath string) string {
+	if as.fileService == nil {
+		return mediaPath
+	}
+	canonical, err := as.fileService.CanonicalPath(mediaPath)
+	if err != nil {
+		return mediaPath
+	}
+	return canonical
+}
+
 // AddAdminUser adds a new admin user with IP-based authentication
 func (as *AdminService) AddAdminUser(name, ipAddress string) (*models.AdminUser, error) {
 	as.mutex.Lock()
@@ -253,6 +328,7 @@ func (as *AdminService) SetMediaPassword(mediaPath, password, createdBy string)
 	as.mutex.Lock()
 	defer as.mutex.Unlock()
 
+	mediaPath = as.canonicalMediaPath(mediaPath)
 	id, err := generateID()
 	if err != nil {
 		return fmt.Errorf("failed to generate access ID: %w", err)
@@ -278,7 +354,7 @@ func (as *AdminService) SetMediaPassword(mediaPath, password, createdBy string)
 // CheckMediaPassword checks if the provided password is correct for the media
 func (as *AdminService) CheckMediaPassword(mediaPath, password string) bool {
 	as.mutex.RLock()
-	access, exists := as.mediaAccess[mediaPath]
+	access, exists := as.mediaAccess[as.canonicalMediaPath(mediaPath)]
 	as.mutex.RUnlock()
 
 	if !exists || !access.IsActive {
@@ -302,6 +378,7 @@ func (as *AdminService) RemoveMediaPassword(mediaPath, removedBy string) {
 	as.mutex.Lock()
 	defer as.mutex.Unlock()
 
+	mediaPath = as.canonicalMediaPath(mediaPath)
 	if access, exists := as.mediaAccess[mediaPath]; exists {
 		access.IsActive = false
 		as.LogActivity(removedBy, "media_password_removed", fmt.Sprintf("Password removed from media: %s", mediaPath), "", true, "")
@@ -434,53 +511,281 @@ func (as *AdminService) getLocationFromIP(ip string) string {
 	return "Unknown"
 }
 
-// StartStream records the start of a new stream
-func (as *AdminService) StartStream() {
+// StartStream admits a request for mediaPath from clientIP. Requests for a file the client is
+// already playing (or downloading) join that playback session, up to maxSessionRequests at once;
+// requests beyond that and new sessions are rejected with a *StreamLimitError when a limit is
+// reached. Downloads are tracked separately from playback.
+func (as *AdminService) StartStream(clientIP, mediaPath string, download bool, fileSize int64) error {
 	as.streamingMutex.Lock()
-	defer as.streamingMutex.Unlock()
 
-	as.streamingMetrics.ActiveStreams++
-	as.streamingMetrics.TotalStreams++
+	now := time.Now()
+	expired, finished := as.pruneStreamSessions(now)
 
-	if as.streamingMetrics.ActiveStreams > as.streamingMetrics.ConcurrentPeak {
-		as.streamingMetrics.ConcurrentPeak = as.streamingMetrics.ActiveStreams
+	key := streamSessionKey(clientIP, mediaPath, download)
+	if session, exists := as.streamSessions[key]; exists {
+		// Part of an ongoing playback (e.g. the next range request), not a new stream
+		if session.ActiveRequests >= maxSessionRequests {
+			as.streamingMutex.Unlock()
+			as.finishStreamSessions(expired, finished)
+			return &StreamLimitError{Scope: "session", Limit: maxSessionRequests, RetryAfter: time.Second}
+		}
+		session.ActiveRequests++
+		session.TotalRequests++
+		session.LastActive = now
+		as.streamingMutex.Unlock()
+		as.finishStreamSessions(expired, finished)
+		return nil
 	}
+
+	if err := as.checkStreamLimits(clientIP, now); err != nil {
+		as.streamingMutex.Unlock()
+		as.finishStreamSessions(expired, finished)
+		return err
+	}
+
+	id, _ := generateID()
+	as.streamSessions[key] = &models.PlaybackSession{
+		ID:             id,
+		ClientIP:       clientIP,
+		MediaPath:      mediaPath,
+		Download:       download,
+		FileSize:       fileSize,
+		ActiveRequests: 1,
+		TotalRequests:  1,
+		StartedAt:      now,
+		LastActive:     now,
+	}
+
+	if download {
+		as.streamingMetrics.ActiveDownloads++
+		as.streamingMetrics.TotalDownloads++
+	} else {
+		as.streamingMetrics.ActiveStreams++
+		as.streamingMetrics.TotalStreams++
+
+		if as.streamingMetrics.ActiveStreams > as.streamingMetrics.ConcurrentPeak {
+			as.streamingMetrics.ConcurrentPeak = as.streamingMetrics.ActiveStreams
+		}
+	}
+	as.streamingMutex.Unlock()
+
+	if download {
+		as.LogActivity(clientIP, "download_started", mediaPath, "", true, "")
+	} else {
+		as.LogActivity(clientIP, "playback_started", mediaPath, "", true, "")
+	}
+
+	expired[clientIP]++
+	as.finishStreamSessions(expired, finished)
+	return nil
 }
 
-// EndStream records the end of a stream
-func (as *AdminService) EndStream(bytesStreamed int64, duration time.Duration) {
+// EndStream records the end of a request admitted by StartStream. position is the byte offset
+// the request reached in the file, or negative when it is not meaningful (e.g. multipart ranges).
+func (as *AdminService) EndStream(clientIP, mediaPath string, download bool, position, bytesStreamed int64, duration time.Duration) {
 	as.streamingMutex.Lock()
 	defer as.streamingMutex.Unlock()
 
-	as.streamingMetrics.ActiveStreams--
-	as.streamingMetrics.BytesStreamed += bytesStreamed
+	if session, exists := as.streamSessions[streamSessionKey(clientIP, mediaPath, download)]; exists {
+		session.ActiveRequests--
+		session.BytesServed += bytesStreamed
+		session.TransferTime += duration
+		if position >= 0 {
+			session.LastPosition = position
+		}
+		session.LastActive = time.Now()
+	}
+
+	if download {
+		// Downloads are accounted separately so they do not skew playback statistics
+		as.streamingMetrics.BytesDownloaded += bytesStreamed
+	} else {
+		as.streamingMetrics.BytesStreamed += bytesStreamed
+	}
+}
+
+// recordSessionSpeed folds a finished playback session into the average and peak speeds;
+// callers must hold streamingMutex
+func (as *AdminService) recordSessionSpeed(session *models.PlaybackSession) {
+	speed := session.AverageSpeed()
+	if session.Download || speed <= 0 {
+		return
+	}
+
+	as.speedSamples++
+
+	// Update average speed (simple moving average over sessions)
+	if as.speedSamples > 1 {
+		samples := float64(as.speedSamples)
+		as.streamingMetrics.AverageSpeed = (as.streamingMetrics.AverageSpeed*(samples-1) + speed) / samples
+	} else {
+		as.streamingMetrics.AverageSpeed = speed
+	}
+
+	// Update peak speed
+	if speed > as.streamingMetrics.PeakSpeed {
+		as.streamingMetrics.PeakSpeed = speed
+	}
+}
+
+// finishStreamSessions applies connection stream count changes and logs the end of expired sessions
+func (as *AdminService) finishStreamSessions(deltas map[string]int, finished []*models.PlaybackSession) {
+	as.adjustConnectionStreams(deltas)
+
+	for _, session := range finished {
+		action := "playback_ended"
+		if session.Download {
+			action = "download_ended"
+		}
+		details := fmt.Sprintf("%d requests, %s served in %v, reached %.1f%%",
+			session.TotalRequests, formatBytes(session.BytesServed),
+			session.Duration().Round(time.Second), session.Progress())
+		as.LogActivity(session.ClientIP, action, session.MediaPath, "", true, details)
+	}
+}
+
+// GetPlaybackSessions returns a snapshot of the open playback and download sessions, most recent first
+func (as *AdminService) GetPlaybackSessions() []models.PlaybackSession {
+	as.streamingMutex.Lock()
+	expired, finished := as.pruneStreamSessions(time.Now())
+	sessions := as.snapshotStreamSessions()
+	as.streamingMutex.Unlock()
+
+	as.finishStreamSessions(expired, finished)
+	return sessions
+}
+
+// snapshotStreamSessions copies the open sessions; callers must hold streamingMutex
+func (as *AdminService) snapshotStreamSessions() []models.PlaybackSession {
+	sessions := make([]models.PlaybackSession, 0, len(as.streamSessions))
+	for _, session := range as.streamSessions {
+		sessions = append(sessions, *session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastActive.After(sessions[j].LastActive)
+	})
+	return sessions
+}
 
-	// Calculate speed for this stream
-	if duration > 0 {
-		speed := float64(bytesStreamed) / duration.Seconds()
+// streamSessionKey returns the key grouping requests into a stream session
+func streamSessionKey(clientIP, mediaPath string, download bool) string {
+	kind := "play"
+	if download {
+		kind = "download"
+	}
+	return clientIP + "|" + kind + "|" + mediaPath
+}
 
-		// Update average speed (simple moving average)
-		if as.streamingMetrics.TotalStreams > 1 {
-			totalStreams := float64(as.streamingMetrics.TotalStreams)
-			as.streamingMetrics.AverageSpeed = (as.streamingMetrics.AverageSpeed*(totalStreams-1) + speed) / totalStreams
-		} else {
-			as.streamingMetrics.AverageSpeed = speed
+// checkStreamLimits returns a *StreamLimitError if a new stream for clientIP would exceed a limit;
+// callers must hold streamingMutex
+func (as *AdminService) checkStreamLimits(clientIP string, now time.Time) error {
+	if as.maxStreamsGlobal > 0 && len(as.streamSessions) >= as.maxStreamsGlobal {
+		return &StreamLimitError{
+			Scope:      "global",
+			Limit:      as.maxStreamsGlobal,
+			RetryAfter: as.earliestStreamRelease("", now),
 		}
+	}
 
-		// Update peak speed
-		if speed > as.streamingMetrics.PeakSpeed {
-			as.streamingMetrics.PeakSpeed = speed
+	if as.maxStreamsPerIP > 0 {
+		count := 0
+		for _, session := range as.streamSessions {
+			if session.ClientIP == clientIP {
+				count++
+			}
+		}
+		if count >= as.maxStreamsPerIP {
+			return &StreamLimitError{
+				Scope:      "client",
+				Limit:      as.maxStreamsPerIP,
+				RetryAfter: as.earliestStreamRelease(clientIP, now),
+			}
+		}
+	}
+
+	return nil
+}
+
+// earliestStreamRelease estimates when a stream slot (for clientIP, or any client if empty) frees up;
+// callers must hold streamingMutex
+func (as *AdminService) earliestStreamRelease(clientIP string, now time.Time) time.Duration {
+	earliest := streamSessionLinger
+	for _, session := range as.streamSessions {
+		if session.ActiveRequests > 0 || (clientIP != "" && session.ClientIP != clientIP) {
+			continue
+		}
+		if remaining := streamSessionLinger - now.Sub(session.LastActive); remaining < earliest {
+			earliest = remaining
+		}
+	}
+
+	if earliest < time.Second {
+		earliest = time.Second
+	}
+	return earliest
+}
+
+// pruneStreamSessions removes idle stream sessions and returns the per-IP change in open streams
+// along with the sessions that ended; callers must hold streamingMutex
+func (as *AdminService) pruneStreamSessions(now time.Time) (map[string]int, []*models.PlaybackSession) {
+	expired := make(map[string]int)
+	var finished []*models.PlaybackSession
+
+	for key, session := range as.streamSessions {
+		if session.ActiveRequests <= 0 && now.Sub(session.LastActive) > streamSessionLinger {
+			delete(as.streamSessions, key)
+			if session.Download {
+				as.streamingMetrics.ActiveDownloads--
+			} else {
+				as.streamingMetrics.ActiveStreams--
+			}
+			as.recordSessionSpeed(session)
+			expired[session.ClientIP]--
+			finished = append(finished, session)
+		}
+	}
+
+	return expired, finished
+}
+
+// adjustConnectionStreams applies per-IP stream count changes to the tracked connections
+func (as *AdminService) adjustConnectionStreams(deltas map[string]int) {
+	if len(deltas) == 0 {
+		return
+	}
+
+	as.mutex.RLock()
+	defer as.mutex.RUnlock()
+
+	for _, conn := range as.connections {
+		if delta := deltas[conn.IPAddress]; delta != 0 {
+			conn.AdjustStreams(delta)
 		}
 	}
 }
 
 // GetStreamingMetrics returns current streaming metrics
 func (as *AdminService) GetStreamingMetrics() models.StreamingMetrics {
-	as.streamingMutex.RLock()
-	defer as.streamingMutex.RUnlock()
-
+	as.streamingMutex.Lock()
+	expired, finished := as.pruneStreamSessions(time.Now())
 	// Return a copy to avoid race conditions
-	return as.streamingMetrics
+	metrics := as.streamingMetrics
+	metrics.Sessions = as.snapshotStreamSessions()
+	as.streamingMutex.Unlock()
+
+	as.finishStreamSessions(expired, finished)
+
+	as.mutex.RLock()
+	bandwidthService := as.bandwidthService
+	as.mutex.RUnlock()
+
+	if bandwidthService != nil {
+		metrics.ThrottledStreams = bandwidthService.GetThrottledStreams()
+		metrics.CurrentThroughput = bandwidthService.GetThroughput()
+	}
+
+	return metrics
 }
 
 // GetPerformanceMetrics returns performance metrics if available
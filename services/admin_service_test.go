@@ -0,0 +1,134 @@
+package services
+
+import (
+	"media-server/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newPasswordTestService returns an AdminService canonicalizing paths against a media directory
+// holding Movies/a.mp4, with the legacy and f/<folder ID> paths of that file
+func newPasswordTestService(t *testing.T) (*AdminService, string, string) {
+	t.Helper()
+
+	mediaDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(mediaDir, "Movies"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(mediaDir, "Movies", "a.mp4"), []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mediaFolderService := NewMediaFolderService(mediaDir)
+	as := NewAdminService()
+	as.SetFileService(NewFileServiceWithMediaFolders(mediaDir, nil, nil, mediaFolderService))
+
+	return as, "Movies/a.mp4", models.FolderMediaPath(folderID(mediaDir), "Movies/a.mp4")
+}
+
+func TestMediaPasswordCoversBothPathForms(t *testing.T) {
+	as, legacyPath, folderPath := newPasswordTestService(t)
+
+	tests := []struct {
+		name     string
+		setOn    string
+		checkOn  string
+		removeOn string
+	}{
+		{"legacy to folder path", legacyPath, folderPath, folderPath},
+		{"folder to legacy path", folderPath, legacyPath, legacyPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := as.SetMediaPassword(tt.setOn, "secret", "127.0.0.1"); err != nil {
+				t.Fatalf("SetMediaPassword(%q): %v", tt.setOn, err)
+			}
+			if as.CheckMediaPassword(tt.checkOn, "") {
+				t.Errorf("CheckMediaPassword(%q) without a password succeeded", tt.checkOn)
+			}
+			if as.CheckMediaPassword(tt.checkOn, "wrong") {
+				t.Errorf("CheckMediaPassword(%q) with a wrong password succeeded", tt.checkOn)
+			}
+			if !as.CheckMediaPassword(tt.checkOn, "secret") {
+				t.Errorf("CheckMediaPassword(%q) with the password failed", tt.checkOn)
+			}
+
+			as.RemoveMediaPassword(tt.removeOn, "127.0.0.1")
+			if !as.CheckMediaPassword(tt.setOn, "") {
+				t.Errorf("password on %q still required after removing it on %q", tt.setOn, tt.removeOn)
+			}
+		})
+	}
+}
+
+func TestSetFileServiceMigratesMediaPasswords(t *testing.T) {
+	as, legacyPath, folderPath := newPasswordTestService(t)
+	fileService := as.fileService
+
+	// A password set before paths are canonicalized is kept under its legacy path
+	as = NewAdminService()
+	if err := as.SetMediaPassword(legacyPath, "secret", "127.0.0.1"); err != nil {
+		t.Fatalf("SetMediaPassword(%q): %v", legacyPath, err)
+	}
+	as.SetFileService(fileService)
+
+	if as.CheckMediaPassword(folderPath, "") {
+		t.Errorf("CheckMediaPassword(%q) without a password succeeded", folderPath)
+	}
+	if !as.CheckMediaPassword(folderPath, "secret") {
+		t.Errorf("CheckMediaPassword(%q) with the password failed", folderPath)
+	}
+}
+
+func TestMediaPasswordCoversNestedMediaFolders(t *testing.T) {
+	as, legacyPath, _ := newPasswordTestService(t)
+	mediaDir := as.fileService.baseDir
+
+	// Movies/a.mp4 is also reachable as a.mp4 in a media folder added at Movies
+	movies, err := as.fileService.mediaFolderService.AddFolder(&models.MediaFolderRequest{
+		Name: "Movies",
+		Path: filepath.Join(mediaDir, "Movies"),
+	}, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("AddFolder: %v", err)
+	}
+	nestedPath := models.FolderMediaPath(movies.ID, "a.mp4")
+	outerPath := models.FolderMediaPath(folderID(mediaDir), "Movies/a.mp4")
+
+	paths := []string{legacyPath, outerPath, nestedPath}
+	for _, setOn := range paths {
+		if err := as.SetMediaPassword(setOn, "secret", "127.0.0.1"); err != nil {
+			t.Fatalf("SetMediaPassword(%q): %v", setOn, err)
+		}
+		for _, checkOn := range paths {
+			if as.CheckMediaPassword(checkOn, "") {
+				t.Errorf("password set on %q not required on %q", setOn, checkOn)
+			}
+		}
+		as.RemoveMediaPassword(setOn, "127.0.0.1")
+	}
+}
+
+func TestStartStreamCapsSessionRequests(t *testing.T) {
+	as := NewAdminService()
+	as.SetStreamLimits(1, 0)
+
+	for i := 0; i < maxSessionRequests; i++ {
+		if err := as.StartStream("10.0.0.1", "f/1/a.mp4", false, 100); err != nil {
+			t.Fatalf("request %d: StartStream: %v", i+1, err)
+		}
+	}
+
+	err := as.StartStream("10.0.0.1", "f/1/a.mp4", false, 100)
+	limitErr, ok := err.(*StreamLimitError)
+	if !ok || limitErr.Scope != "session" {
+		t.Fatalf("StartStream beyond %d requests = %v, want a session limit error", maxSessionRequests, err)
+	}
+
+	// A finished request frees its slot
+	as.EndStream("10.0.0.1", "f/1/a.mp4", false, 10, 10, 0)
+	if err := as.StartStream("10.0.0.1", "f/1/a.mp4", false, 100); err != nil {
+		t.Errorf("StartStream after EndStream: %v", err)
+	}
+}
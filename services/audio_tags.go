@@ -0,0 +1,975 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"media-server/models"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxAudioTagSize caps the size of a tag block (ID3v2 tag, Vorbis comment, MP4 moov box) read
+// into memory; blocks larger than this usually carry embedded artwork and are skipped
+const maxAudioTagSize = 16 << 20
+
+// mp3SyncSearchSize bounds how far past the tags the first MPEG audio frame is searched for
+const mp3SyncSearchSize = 64 << 10
+
+// errStopWalk ends a container walk early
+var errStopWalk = errors.New("stop walk")
+
+// oggTailSize is read from the end of an Ogg file to find the last granule position; it covers
+// the largest possible Ogg page
+const oggTailSize = 64 << 10
+
+// id3v22Frames maps the three-letter ID3v2.2 frame IDs to their ID3v2.3 equivalents
+var id3v22Frames = map[string]string{
+	"TT2": "TIT2", "TP1": "TPE1", "TP2": "TPE2", "TAL": "TALB",
+	"TRK": "TRCK", "TPA": "TPOS", "TYE": "TYER", "TCO": "TCON", "TLE": "TLEN",
+	"PIC": "APIC",
+}
+
+// cachedAudioTags returns the tags of an audio file, from the cache while the file is unchanged,
+// or nil when they cannot be read
+func cachedAudioTags(cacheService *CacheService, fullPath string) *models.AudioTags {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil
+	}
+
+	if cacheService != nil {
+		if tags, found := cacheService.GetAudioTags(fullPath); found && tags.IsValidFor(info.Size(), info.ModTime()) {
+			return tags
+		}
+	}
+
+	tags, err := ReadAudioTags(fullPath)
+	if err != nil {
+		log.Printf("Error reading audio tags of %s: %v", fullPath, err)
+		return nil
+	}
+
+	if cacheService != nil {
+		cacheService.SetAudioTags(fullPath, tags)
+	}
+	return tags
+}
+
+// ReadAudioTags reads the tags and duration of an MP3 (ID3v1/v2.2-2.4), FLAC, Ogg Vorbis, Opus
+// or M4A file. Files in other formats yield empty tags; only I/O failures are returned as errors.
+func ReadAudioTags(fullPath string) (*models.AudioTags, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	tags := &models.AudioTags{
+		Path:    fullPath,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	size := info.Size()
+
+	head := make([]byte, 12)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	// FLAC and MP3 files may start with an ID3v2 tag
+	audioStart := int64(0)
+	if bytes.HasPrefix(head, []byte("ID3")) {
+		if audioStart, err = readID3v2(file, tags); err != nil {
+			return nil, err
+		}
+		n, err = file.ReadAt(head[:cap(head)], audioStart)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		head = head[:n]
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		err = readFLAC(file, audioStart, tags)
+	case bytes.HasPrefix(head, []byte("OggS")):
+		err = readOgg(file, size, tags)
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		err = readMP4Tags(file, size, tags)
+	default:
+		audioEnd := size
+		if readID3v1(file, size, tags) {
+			audioEnd -= 128
+		}
+		if duration := mp3Duration(file, audioStart, audioEnd); duration > 0 {
+			tags.Duration = duration
+		}
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// readID3v2 reads an ID3v2 tag at the start of a file and returns the offset following it.
+// Tags of unknown versions or above maxAudioTagSize are skipped.
+func readID3v2(file io.ReaderAt, tags *models.AudioTags) (int64, error) {
+	data, version, end, err := readID3v2Tag(file)
+	if err != nil || data == nil {
+		return end, err
+	}
+
+	found := false
+	eachID3v2Frame(data, version, func(id string, body []byte) bool {
+		if strings.HasPrefix(id, "T") {
+			if values := decodeID3Text(body); len(values) > 0 && setID3Frame(tags, id, values) {
+				found = true
+			}
+		}
+		return true
+	})
+
+	if found {
+		tags.Format = "id3v2"
+	}
+	return end, nil
+}
+
+// readID3v2Tag returns the frame data and major version of an ID3v2 tag at the start of a file,
+// with unsynchronisation and the extended header removed, and the offset following the tag.
+// The data is nil for tags of unknown versions, above maxAudioTagSize or truncated.
+func readID3v2Tag(file io.ReaderAt) ([]byte, byte, int64, error) {
+	header := make([]byte, 10)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		if err == io.EOF {
+			return nil, 0, 0, nil // truncated file
+		}
+		return nil, 0, 0, err
+	}
+
+	version, flags := header[3], header[5]
+	size := int64(syncsafeInt(header[6:10]))
+	end := 10 + size
+	if flags&0x10 != 0 {
+		end += 10 // footer
+	}
+	if version < 2 || version > 4 || size > maxAudioTagSize {
+		return nil, version, end, nil
+	}
+
+	data := make([]byte, size)
+	if _, err := file.ReadAt(data, 10); err != nil {
+		return nil, version, end, nil // truncated tag
+	}
+	if version < 4 && flags&0x80 != 0 {
+		data = removeUnsynchronisation(data)
+	}
+	if version >= 3 && flags&0x40 != 0 {
+		// Skip the extended header; its size excludes itself in v2.3 only
+		if len(data) < 4 {
+			return nil, version, end, nil
+		}
+		extSize := int(syncsafeInt(data[:4]))
+		if version == 3 {
+			extSize = int(binary.BigEndian.Uint32(data[:4])) + 4
+		}
+		if extSize < 0 || extSize > len(data) {
+			return nil, version, end, nil
+		}
+		data = data[extSize:]
+	}
+
+	return data, version, end, nil
+}
+
+// eachID3v2Frame calls fn with the ID and decoded body of every frame of an ID3v2 tag until fn
+// returns false. ID3v2.2 frame IDs are translated to their ID3v2.3 equivalents; compressed and
+// encrypted frames are skipped.
+func eachID3v2Frame(data []byte, version byte, fn func(id string, body []byte) bool) {
+	idSize, headerSize := 4, 10
+	if version == 2 {
+		idSize, headerSize = 3, 6
+	}
+
+	for len(data) >= headerSize && data[0] != 0 {
+		id := string(data[:idSize])
+		var size int
+		var flags uint16
+		switch version {
+		case 2:
+			size = int(data[3])<<16 | int(data[4])<<8 | int(data[5])
+			id = id3v22Frames[id]
+		case 3:
+			size = int(binary.BigEndian.Uint32(data[4:8]))
+			flags = binary.BigEndian.Uint16(data[8:10])
+		case 4:
+			size = int(syncsafeInt(data[4:8]))
+			flags = binary.BigEndian.Uint16(data[8:10])
+		}
+		if size < 0 || size > len(data)-headerSize {
+			break
+		}
+		body := data[headerSize : headerSize+size]
+		data = data[headerSize+size:]
+
+		switch version {
+		case 3:
+			if flags&0x00C0 != 0 {
+				continue // compressed or encrypted
+			}
+			if flags&0x0020 != 0 && len(body) > 0 {
+				body = body[1:] // group identifier
+			}
+		case 4:
+			if flags&0x000C != 0 {
+				continue // compressed or encrypted
+			}
+			if flags&0x0040 != 0 && len(body) > 0 {
+				body = body[1:] // group identifier
+			}
+			if flags&0x0001 != 0 && len(body) >= 4 {
+				body = body[4:] // data length indicator
+			}
+			if flags&0x0002 != 0 {
+				body = removeUnsynchronisation(body)
+			}
+		}
+
+		if id != "" && !fn(id, body) {
+			return
+		}
+	}
+}
+
+// setID3Frame stores the values of a text frame and reports whether the frame was used
+func setID3Frame(tags *models.AudioTags, id string, values []string) bool {
+	switch id {
+	case "TIT2":
+		tags.Title = values[0]
+	case "TPE1":
+		tags.Artist = strings.Join(values, ", ")
+	case "TALB":
+		tags.Album = values[0]
+	case "TPE2":
+		tags.AlbumArtist = strings.Join(values, ", ")
+	case "TRCK":
+		tags.Track, tags.TrackTotal = parseNumberPair(values[0])
+	case "TPOS":
+		tags.Disc, tags.DiscTotal = parseNumberPair(values[0])
+	case "TYER", "TDRC":
+		tags.Year = parseYear(values[0])
+	case "TCON":
+		genres := make([]string, 0, len(values))
+		for _, value := range values {
+			if genre := id3GenreName(value); genre != "" && !slices.Contains(genres, genre) {
+				genres = append(genres, genre)
+			}
+		}
+		tags.Genre = strings.Join(genres, ", ")
+	case "TLEN":
+		// Only a hint; durations measured from the audio stream take precedence
+		if ms, err := strconv.Atoi(values[0]); err == nil && ms > 0 {
+			tags.Duration = float64(ms) / 1000
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// decodeID3Text decodes the values of an ID3v2 text frame; ID3v2.4 separates multiple values
+// with null characters
+func decodeID3Text(body []byte) []string {
+	if len(body) < 2 {
+		return nil
+	}
+
+	var raw []string
+	encoding, text := body[0], body[1:]
+	switch encoding {
+	case 0: // ISO-8859-1, which writers commonly fill with Windows-1252
+		for _, part := range bytes.Split(text, []byte{0}) {
+			raw = append(raw, decodeWindows1252(part))
+		}
+	case 1, 2: // UTF-16 with BOM, UTF-16BE
+		for len(text) >= 2 {
+			end := len(text) &^ 1
+			for i := 0; i+1 < len(text); i += 2 {
+				if text[i] == 0 && text[i+1] == 0 {
+					end = i
+					break
+				}
+			}
+			part := text[:end]
+			bigEndian := encoding == 2
+			if len(part) >= 2 && part[0] == 0xFE && part[1] == 0xFF {
+				part, bigEndian = part[2:], true
+			} else if len(part) >= 2 && part[0] == 0xFF && part[1] == 0xFE {
+				part, bigEndian = part[2:], false
+			}
+			raw = append(raw, decodeUTF16(part, bigEndian))
+			text = text[min(end+2, len(text)):]
+		}
+	case 3: // UTF-8
+		raw = strings.Split(string(text), "\x00")
+	default:
+		return nil
+	}
+
+	values := make([]string, 0, len(raw))
+	for _, value := range raw {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// id3GenreName resolves ID3 genre references such as "(17)", "(17)Rock" or "17" to names
+func id3GenreName(value string) string {
+	for strings.HasPrefix(value, "(") && !strings.HasPrefix(value, "((") {
+		end := strings.Index(value, ")")
+		if end < 0 {
+			break
+		}
+		if refinement := strings.TrimSpace(value[end+1:]); refinement != "" {
+			value = refinement
+			continue
+		}
+		value = value[1:end]
+		break
+	}
+
+	switch value {
+	case "RX":
+		return "Remix"
+	case "CR":
+		return "Cover"
+	}
+	if index, err := strconv.Atoi(value); err == nil {
+		return models.ID3GenreName(index)
+	}
+	return strings.TrimPrefix(value, "(")
+}
+
+// readID3v1 reads an ID3v1(.1) tag at the end of a file into the fields not set by an ID3v2
+// tag and reports whether the tag was present
+func readID3v1(file io.ReaderAt, size int64, tags *models.AudioTags) bool {
+	if size < 128 {
+		return false
+	}
+	tag := make([]byte, 128)
+	if _, err := file.ReadAt(tag, size-128); err != nil || string(tag[:3]) != "TAG" {
+		return false
+	}
+
+	field := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return strings.TrimSpace(decodeWindows1252(b))
+	}
+
+	found := false
+	set := func(dst *string, value string) {
+		if *dst == "" && value != "" {
+			*dst = value
+			found = true
+		}
+	}
+	set(&tags.Title, field(tag[3:33]))
+	set(&tags.Artist, field(tag[33:63]))
+	set(&tags.Album, field(tag[63:93]))
+	if tags.Year == 0 {
+		tags.Year = parseYear(field(tag[93:97]))
+	}
+	// ID3v1.1 stores the track number in the last byte of the comment
+	if tags.Track == 0 && tag[125] == 0 && tag[126] != 0 {
+		tags.Track = int(tag[126])
+	}
+	set(&tags.Genre, models.ID3GenreName(int(tag[127])))
+
+	if found && tags.Format == "" {
+		tags.Format = "id3v1"
+	}
+	return true
+}
+
+// mpegFrame is the decoded header of an MPEG audio frame
+type mpegFrame struct {
+	mpeg1           bool
+	mono            bool
+	bitrate         int // bits per second
+	sampleRate      int
+	samplesPerFrame int
+	length          int // bytes including the header
+}
+
+// mpegBitrates are the bitrates in kbit/s by [MPEG-1][layer-1][index]
+var mpegBitrates = [2][3][15]int{
+	{ // MPEG-2 and 2.5
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+	{ // MPEG-1
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+}
+
+// mpegSampleRates are the sample rates by version bits (0: MPEG-2.5, 2: MPEG-2, 3: MPEG-1)
+var mpegSampleRates = [4][3]int{
+	{11025, 12000, 8000},
+	{},
+	{22050, 24000, 16000},
+	{44100, 48000, 32000},
+}
+
+// parseMPEGFrame decodes a 4-byte MPEG audio frame header
+func parseMPEGFrame(h []byte) (mpegFrame, bool) {
+	if len(h) < 4 || h[0] != 0xFF || h[1]&0xE0 != 0xE0 {
+		return mpegFrame{}, false
+	}
+
+	version := (h[1] >> 3) & 3
+	layer := 4 - int((h[1]>>1)&3) // 1, 2 or 3; 4 is reserved
+	bitrateIndex := int(h[2] >> 4)
+	rateIndex := int((h[2] >> 2) & 3)
+	padding := int((h[2] >> 1) & 1)
+	if version == 1 || layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return mpegFrame{}, false
+	}
+
+	frame := mpegFrame{
+		mpeg1:      version == 3,
+		mono:       h[3]>>6 == 3,
+		sampleRate: mpegSampleRates[version][rateIndex],
+	}
+	mpeg1 := 0
+	if frame.mpeg1 {
+		mpeg1 = 1
+	}
+	frame.bitrate = mpegBitrates[mpeg1][layer-1][bitrateIndex] * 1000
+
+	switch {
+	case layer == 1:
+		frame.samplesPerFrame = 384
+		frame.length = (12*frame.bitrate/frame.sampleRate + padding) * 4
+	case layer == 3 && !frame.mpeg1:
+		frame.samplesPerFrame = 576
+		frame.length = 72*frame.bitrate/frame.sampleRate + padding
+	default:
+		frame.samplesPerFrame = 1152
+		frame.length = 144*frame.bitrate/frame.sampleRate + padding
+	}
+	return frame, true
+}
+
+// mp3Duration measures the duration of the MPEG audio between start and end from the Xing/Info
+// or VBRI header of the first frame, or from its bitrate for constant bitrate files
+func mp3Duration(file io.ReaderAt, start, end int64) float64 {
+	buf := make([]byte, min(int64(mp3SyncSearchSize), max(0, end-start)))
+	n, err := file.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return 0
+	}
+	buf = buf[:n]
+
+	for i := 0; i+4 <= len(buf); i++ {
+		frame, ok := parseMPEGFrame(buf[i:])
+		if !ok {
+			continue
+		}
+		// Require a second frame header right after the first one to rule out false syncs
+		if next := i + frame.length; next+4 <= len(buf) {
+			if _, ok := parseMPEGFrame(buf[next:]); !ok {
+				continue
+			}
+		}
+
+		data := buf[i:min(len(buf), i+frame.length)]
+		if frames := vbrFrameCount(data, frame); frames > 0 {
+			return float64(frames) * float64(frame.samplesPerFrame) / float64(frame.sampleRate)
+		}
+		return float64(end-start-int64(i)) * 8 / float64(frame.bitrate)
+	}
+	return 0
+}
+
+// vbrFrameCount returns the number of frames recorded in a Xing/Info or VBRI header
+func vbrFrameCount(data []byte, frame mpegFrame) uint32 {
+	// The Xing header follows the side information of the first frame
+	sideInfo := 32
+	switch {
+	case frame.mpeg1 && frame.mono:
+		sideInfo = 17
+	case !frame.mpeg1 && frame.mono:
+		sideInfo = 9
+	case !frame.mpeg1:
+		sideInfo = 17
+	}
+	if xing := 4 + sideInfo; len(data) >= xing+12 {
+		id := string(data[xing : xing+4])
+		if (id == "Xing" || id == "Info") && binary.BigEndian.Uint32(data[xing+4:])&1 != 0 {
+			return binary.BigEndian.Uint32(data[xing+8:])
+		}
+	}
+	if len(data) >= 36+18 && string(data[36:40]) == "VBRI" {
+		return binary.BigEndian.Uint32(data[36+14:])
+	}
+	return 0
+}
+
+// readFLAC reads the STREAMINFO and VORBIS_COMMENT metadata blocks of a FLAC stream at offset
+func readFLAC(file io.ReaderAt, offset int64, tags *models.AudioTags) error {
+	return flacBlocks(file, offset, func(blockType byte, body, length int64) error {
+		switch {
+		case blockType == 0 && length >= 34:
+			info := make([]byte, 34)
+			if _, err := file.ReadAt(info, body); err != nil {
+				return err
+			}
+			// 20 bits sample rate, 3 bits channels, 5 bits sample size, 36 bits total samples
+			packed := binary.BigEndian.Uint64(info[10:18])
+			sampleRate := packed >> 44
+			totalSamples := packed & (1<<36 - 1)
+			if sampleRate > 0 && totalSamples > 0 {
+				tags.Duration = float64(totalSamples) / float64(sampleRate)
+			}
+		case blockType == 4 && length <= maxAudioTagSize:
+			comment := make([]byte, length)
+			if _, err := file.ReadAt(comment, body); err != nil {
+				return err
+			}
+			parseVorbisComment(comment, tags)
+		}
+		return nil
+	})
+}
+
+// flacBlocks calls fn with the type, body offset and length of every metadata block of a FLAC
+// stream at offset; fn may return errStopWalk to end the walk early
+func flacBlocks(file io.ReaderAt, offset int64, fn func(blockType byte, body, length int64) error) error {
+	header := make([]byte, 4)
+	for pos := offset + 4; ; {
+		if _, err := file.ReadAt(header, pos); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7F
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		body := pos + 4
+
+		if blockType == 127 {
+			return nil // invalid block type
+		}
+		if err := fn(blockType, body, length); err != nil {
+			if err == errStopWalk {
+				return nil
+			}
+			return err
+		}
+
+		pos = body + length
+		if last {
+			return nil
+		}
+	}
+}
+
+// parseVorbisComment reads a Vorbis comment block (FLAC, Ogg Vorbis and Opus)
+func parseVorbisComment(data []byte, tags *models.AudioTags) {
+	fields := vorbisCommentFields(data)
+	if len(fields) == 0 {
+		return
+	}
+
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if values := fields[key]; len(values) > 0 {
+				return values[0]
+			}
+		}
+		return ""
+	}
+
+	tags.Format = "vorbis"
+	tags.Title = first("TITLE")
+	tags.Artist = strings.Join(fields["ARTIST"], ", ")
+	tags.Album = first("ALBUM")
+	tags.AlbumArtist = first("ALBUMARTIST", "ALBUM ARTIST", "ALBUM_ARTIST")
+	tags.Genre = strings.Join(fields["GENRE"], ", ")
+	tags.Year = parseYear(first("DATE", "YEAR", "ORIGINALDATE"))
+
+	tags.Track, tags.TrackTotal = parseNumberPair(first("TRACKNUMBER"))
+	if tags.TrackTotal == 0 {
+		tags.TrackTotal, _ = strconv.Atoi(first("TRACKTOTAL", "TOTALTRACKS"))
+	}
+	tags.Disc, tags.DiscTotal = parseNumberPair(first("DISCNUMBER"))
+	if tags.DiscTotal == 0 {
+		tags.DiscTotal, _ = strconv.Atoi(first("DISCTOTAL", "TOTALDISCS"))
+	}
+}
+
+// vorbisCommentFields returns the values of a Vorbis comment block by upper-cased field name
+func vorbisCommentFields(data []byte) map[string][]string {
+	if len(data) < 4 {
+		return nil
+	}
+	vendorLength := uint64(binary.LittleEndian.Uint32(data))
+	if 4+vendorLength+4 > uint64(len(data)) {
+		return nil
+	}
+	data = data[4+vendorLength:]
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+
+	fields := make(map[string][]string)
+	for i := uint32(0); i < count && len(data) >= 4; i++ {
+		length := uint64(binary.LittleEndian.Uint32(data))
+		if 4+length > uint64(len(data)) {
+			break
+		}
+		comment := string(data[4 : 4+length])
+		data = data[4+length:]
+
+		key, value, ok := strings.Cut(comment, "=")
+		if value = strings.TrimSpace(value); ok && value != "" {
+			key = strings.ToUpper(key)
+			fields[key] = append(fields[key], value)
+		}
+	}
+	return fields
+}
+
+// oggReader reassembles the packets of the first logical stream of an Ogg file
+type oggReader struct {
+	r        *bufio.Reader
+	serial   uint32
+	started  bool
+	segments []byte // lacing values left on the current page
+	data     []byte // payload left on the current page
+}
+
+// readPage loads the next page of the stream
+func (ogg *oggReader) readPage() error {
+	header := make([]byte, 27)
+	for {
+		if _, err := io.ReadFull(ogg.r, header); err != nil {
+			return err
+		}
+		if string(header[:4]) != "OggS" {
+			return fmt.Errorf("invalid Ogg page")
+		}
+
+		segments := make([]byte, header[26])
+		if _, err := io.ReadFull(ogg.r, segments); err != nil {
+			return err
+		}
+		total := 0
+		for _, length := range segments {
+			total += int(length)
+		}
+		data := make([]byte, total)
+		if _, err := io.ReadFull(ogg.r, data); err != nil {
+			return err
+		}
+
+		serial := binary.LittleEndian.Uint32(header[14:18])
+		if !ogg.started {
+			ogg.serial, ogg.started = serial, true
+		}
+		if serial == ogg.serial {
+			ogg.segments, ogg.data = segments, data
+			return nil
+		}
+	}
+}
+
+// nextPacket returns the next packet of the stream
+func (ogg *oggReader) nextPacket() ([]byte, error) {
+	var packet []byte
+	for {
+		for len(ogg.segments) == 0 {
+			if err := ogg.readPage(); err != nil {
+				return nil, err
+			}
+		}
+
+		length := int(ogg.segments[0])
+		ogg.segments = ogg.segments[1:]
+		packet = append(packet, ogg.data[:length]...)
+		ogg.data = ogg.data[length:]
+		if len(packet) > maxAudioTagSize {
+			return nil, fmt.Errorf("Ogg packet too large")
+		}
+		if length < 255 {
+			return packet, nil
+		}
+	}
+}
+
+// readOgg reads the comment header and duration of an Ogg Vorbis or Opus file
+func readOgg(file io.ReaderAt, size int64, tags *models.AudioTags) error {
+	ogg := &oggReader{r: bufio.NewReader(io.NewSectionReader(file, 0, size))}
+
+	ident, err := ogg.nextPacket()
+	if err != nil {
+		return nil // not a readable Ogg stream
+	}
+
+	var sampleRate, preSkip int64
+	var commentPrefix string
+	switch {
+	case len(ident) >= 16 && string(ident[:7]) == "\x01vorbis":
+		sampleRate = int64(binary.LittleEndian.Uint32(ident[12:16]))
+		commentPrefix = "\x03vorbis"
+	case len(ident) >= 12 && string(ident[:8]) == "OpusHead":
+		// Opus granule positions always count 48 kHz samples
+		sampleRate = 48000
+		preSkip = int64(binary.LittleEndian.Uint16(ident[10:12]))
+		commentPrefix = "OpusTags"
+	default:
+		return nil // other codecs (Speex, FLAC in Ogg, Theora) are not supported
+	}
+
+	if comment, err := ogg.nextPacket(); err == nil && strings.HasPrefix(string(comment), commentPrefix) {
+		parseVorbisComment(comment[len(commentPrefix):], tags)
+	}
+
+	if granule := lastOggGranule(file, size, ogg.serial); granule > preSkip && sampleRate > 0 {
+		tags.Duration = float64(granule-preSkip) / float64(sampleRate)
+	}
+	return nil
+}
+
+// lastOggGranule returns the granule position of the last page of a logical stream, or -1
+func lastOggGranule(file io.ReaderAt, size int64, serial uint32) int64 {
+	start := max(0, size-oggTailSize)
+	tail := make([]byte, size-start)
+	if _, err := file.ReadAt(tail, start); err != nil && err != io.EOF {
+		return -1
+	}
+
+	for i := bytes.LastIndex(tail, []byte("OggS")); i >= 0; i = bytes.LastIndex(tail[:i], []byte("OggS")) {
+		if i+27 > len(tail) || binary.LittleEndian.Uint32(tail[i+14:]) != serial {
+			continue
+		}
+		if granule := int64(binary.LittleEndian.Uint64(tail[i+6:])); granule >= 0 {
+			return granule
+		}
+	}
+	return -1
+}
+
+// readMP4Tags reads the iTunes-style metadata (moov/udta/meta/ilst) and duration of an MP4 file
+func readMP4Tags(file io.ReaderAt, size int64, tags *models.AudioTags) error {
+	moov, err := readMP4Box(file, size, "moov", maxAudioTagSize)
+	if err != nil || moov == nil {
+		return err
+	}
+
+	if mvhd := childBox(moov, "mvhd"); len(mvhd) >= 20 {
+		var timescale, duration uint64
+		if mvhd[0] == 1 && len(mvhd) >= 32 {
+			timescale = uint64(binary.BigEndian.Uint32(mvhd[20:24]))
+			duration = binary.BigEndian.Uint64(mvhd[24:32])
+		} else {
+			timescale = uint64(binary.BigEndian.Uint32(mvhd[12:16]))
+			duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+		}
+		if timescale > 0 && duration > 0 && duration != 1<<32-1 {
+			tags.Duration = float64(duration) / float64(timescale)
+		}
+	}
+
+	found := false
+	for ilst := mp4ItemList(moov); len(ilst) > 0; {
+		itemType, item, rest, err := splitBox(ilst)
+		if err != nil {
+			break
+		}
+		ilst = rest
+
+		data := childBox(item, "data")
+		if len(data) < 8 {
+			continue
+		}
+		value := data[8:] // after the type indicator and locale
+		text := strings.TrimSpace(string(value))
+
+		switch itemType {
+		case "\xa9nam":
+			tags.Title = text
+		case "\xa9ART":
+			tags.Artist = text
+		case "\xa9alb":
+			tags.Album = text
+		case "aART":
+			tags.AlbumArtist = text
+		case "\xa9day":
+			tags.Year = parseYear(text)
+		case "\xa9gen":
+			tags.Genre = text
+		case "gnre":
+			// ID3v1 genre number plus one
+			if len(value) >= 2 {
+				tags.Genre = models.ID3GenreName(int(binary.BigEndian.Uint16(value)) - 1)
+			}
+		case "trkn":
+			if len(value) >= 6 {
+				tags.Track = int(binary.BigEndian.Uint16(value[2:4]))
+				tags.TrackTotal = int(binary.BigEndian.Uint16(value[4:6]))
+			}
+		case "disk":
+			if len(value) >= 6 {
+				tags.Disc = int(binary.BigEndian.Uint16(value[2:4]))
+				tags.DiscTotal = int(binary.BigEndian.Uint16(value[4:6]))
+			}
+		default:
+			continue
+		}
+		found = true
+	}
+
+	if found {
+		tags.Format = "mp4"
+	}
+	return nil
+}
+
+// mp4ItemList returns the body of the iTunes-style metadata item list of a moov box, or nil
+func mp4ItemList(moov []byte) []byte {
+	meta := childBox(childBox(moov, "udta"), "meta")
+	if meta == nil {
+		meta = childBox(moov, "meta")
+	}
+	// meta is a full box in MP4 files but a plain container in QuickTime files
+	if len(meta) >= 8 && string(meta[4:8]) != "hdlr" {
+		meta = meta[4:]
+	}
+	return childBox(meta, "ilst")
+}
+
+// readMP4Box returns the body of the first top-level box of the given type, or nil when the
+// file has none. Boxes larger than limit are rejected.
+func readMP4Box(file io.ReaderAt, size int64, boxType string, limit int64) ([]byte, error) {
+	var body []byte
+	err := mp4Children(file, 0, size, func(childType string, start, end int64) error {
+		if childType != boxType {
+			return nil
+		}
+		if end-start > limit {
+			return fmt.Errorf("%s box exceeds %d bytes", boxType, limit)
+		}
+		body = make([]byte, end-start)
+		if _, err := file.ReadAt(body, start); err != nil {
+			return err
+		}
+		return errStopWalk
+	})
+	if err == errStopWalk {
+		err = nil
+	}
+	return body, err
+}
+
+// mp4Children calls fn with the type and body range of every box between start and end without
+// reading the bodies; fn may return errStopWalk to end the walk early. Walking stops silently at
+// the first malformed box.
+func mp4Children(file io.ReaderAt, start, end int64, fn func(boxType string, bodyStart, bodyEnd int64) error) error {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := file.ReadAt(header[:8], offset); err != nil {
+			return err
+		}
+
+		boxSize := int64(binary.BigEndian.Uint32(header[0:4]))
+		headerSize := int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = end - offset
+		case 1:
+			if _, err := file.ReadAt(header[8:16], offset+8); err != nil {
+				return err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if boxSize < headerSize || boxSize > end-offset {
+			return nil
+		}
+
+		if err := fn(string(header[4:8]), offset+headerSize, offset+boxSize); err != nil {
+			return err
+		}
+		offset += boxSize
+	}
+	return nil
+}
+
+// childBox returns the body of the first child box of the given type, or nil
+func childBox(data []byte, boxType string) []byte {
+	for len(data) > 0 {
+		childType, body, rest, err := splitBox(data)
+		if err != nil {
+			return nil
+		}
+		if childType == boxType {
+			return body
+		}
+		data = rest
+	}
+	return nil
+}
+
+// syncsafeInt decodes a 28-bit ID3v2 syncsafe integer
+func syncsafeInt(b []byte) uint32 {
+	return uint32(b[0]&0x7F)<<21 | uint32(b[1]&0x7F)<<14 | uint32(b[2]&0x7F)<<7 | uint32(b[3]&0x7F)
+}
+
+// removeUnsynchronisation reverses the ID3v2 unsynchronisation scheme (0xFF 0x00 -> 0xFF)
+func removeUnsynchronisation(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte{0xFF, 0x00}, []byte{0xFF})
+}
+
+// parseNumberPair parses "3" or "3/12" as a number and an optional total
+func parseNumberPair(value string) (int, int) {
+	number, total, _ := strings.Cut(value, "/")
+	n, _ := strconv.Atoi(strings.TrimSpace(number))
+	t, _ := strconv.Atoi(strings.TrimSpace(total))
+	return n, t
+}
+
+// parseYear returns the year of a date such as "2019" or "2019-04-12T10:00", or 0
+func parseYear(value string) int {
+	value = strings.TrimSpace(value)
+	if len(value) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(value[:4])
+	if err != nil || year <= 0 {
+		return 0
+	}
+	return year
+}
@@ -0,0 +1,422 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log"
+	"media-server/models"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// minBucketBurst is the smallest burst a token bucket allows, so tiny limits still make progress
+	minBucketBurst = 16 * 1024
+	// meterWindow is the number of whole seconds averaged by a rateMeter
+	meterWindow = 3
+	// idleBucketTTL is how long an unused per-client or per-folder bucket is kept
+	idleBucketTTL = 5 * time.Minute
+)
+
+// BandwidthService enforces global, per-client and per-folder streaming rate limits
+type BandwidthService struct {
+	limits           models.BandwidthLimits
+	global           *tokenBucket
+	clients          map[string]*tokenBucket
+	folders          map[string]*tokenBucket
+	throttledStreams int64
+	mutex            sync.RWMutex
+	ctx              context.Context
+	cancel           context.CancelFunc
+}
+
+// NewBandwidthService creates a new BandwidthService with the given initial limits
+func NewBandwidthService(limits models.BandwidthLimits) *BandwidthService {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if limits.FolderLimits == nil {
+		limits.FolderLimits = make(map[string]int64)
+	}
+
+	return &BandwidthService{
+		limits:  limits,
+		global:  newTokenBucket(limits.GlobalLimit),
+		clients: make(map[string]*tokenBucket),
+		folders: make(map[string]*tokenBucket),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// StartCleanup starts the background routine that drops idle per-client and per-folder buckets
+func (bs *BandwidthService) StartCleanup() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	log.Println("Bandwidth cleanup routine started")
+
+	for {
+		select {
+		case <-bs.ctx.Done():
+			log.Println("Bandwidth cleanup routine stopped")
+			return
+		case <-ticker.C:
+			bs.cleanup()
+		}
+	}
+}
+
+// Stop gracefully stops the bandwidth service
+func (bs *BandwidthService) Stop() {
+	log.Println("Stopping bandwidth service...")
+	bs.cancel()
+}
+
+// GetLimits returns a copy of the current limits
+func (bs *BandwidthService) GetLimits() models.BandwidthLimits {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+
+	return bs.copyLimits()
+}
+
+// SetLimits replaces the current limits; active streams pick up the new rates immediately
+func (bs *BandwidthService) SetLimits(limits models.BandwidthLimits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	bs.limits = limits
+	if bs.limits.FolderLimits == nil {
+		bs.limits.FolderLimits = make(map[string]int64)
+	}
+
+	bs.global.setRate(limits.GlobalLimit)
+	for _, bucket := range bs.clients {
+		bucket.setRate(limits.PerIPLimit)
+	}
+	for folderID, bucket := range bs.folders {
+		bucket.setRate(bs.limits.GetFolderLimit(folderID))
+	}
+
+	log.Printf("Bandwidth limits updated: global=%s/s, per-ip=%s/s, per-folder=%s/s",
+		formatBytes(limits.GlobalLimit), formatBytes(limits.PerIPLimit), formatBytes(limits.PerFolderLimit))
+	return nil
+}
+
+// NewStreamLimiter returns a limiter for a single stream from clientIP out of the given media folder.
+// folderID may be empty when the file does not belong to a known media folder.
+func (bs *BandwidthService) NewStreamLimiter(ctx context.Context, clientIP, folderID string) *StreamLimiter {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	buckets := []*tokenBucket{bs.global}
+
+	client, exists := bs.clients[clientIP]
+	if !exists {
+		client = newTokenBucket(bs.limits.PerIPLimit)
+		bs.clients[clientIP] = client
+	}
+	buckets = append(buckets, client)
+
+	if folderID != "" {
+		folder, exists := bs.folders[folderID]
+		if !exists {
+			folder = newTokenBucket(bs.limits.GetFolderLimit(folderID))
+			bs.folders[folderID] = folder
+		}
+		buckets = append(buckets, folder)
+	}
+
+	atomic.AddInt64(&bs.throttledStreams, 1)
+
+	return &StreamLimiter{
+		ctx:     ctx,
+		buckets: buckets,
+		service: bs,
+	}
+}
+
+// GetThroughput returns the current global streaming throughput in bytes per second
+func (bs *BandwidthService) GetThroughput() float64 {
+	return bs.global.meter.rate(time.Now())
+}
+
+// GetThrottledStreams returns the number of streams currently passing through the limiter
+func (bs *BandwidthService) GetThrottledStreams() int {
+	return int(atomic.LoadInt64(&bs.throttledStreams))
+}
+
+// GetStats returns current limits and throughput
+func (bs *BandwidthService) GetStats() models.BandwidthStats {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+
+	now := time.Now()
+	stats := models.BandwidthStats{
+		Limits:           bs.copyLimits(),
+		GlobalThroughput: bs.global.meter.rate(now),
+		ClientThroughput: make(map[string]float64),
+		FolderThroughput: make(map[string]float64),
+		ThrottledStreams: bs.GetThrottledStreams(),
+	}
+
+	for ip, bucket := range bs.clients {
+		if rate := bucket.meter.rate(now); rate > 0 {
+			stats.ClientThroughput[ip] = rate
+		}
+	}
+	for folderID, bucket := range bs.folders {
+		if rate := bucket.meter.rate(now); rate > 0 {
+			stats.FolderThroughput[folderID] = rate
+		}
+	}
+
+	return stats
+}
+
+// copyLimits returns a deep copy of the limits; callers must hold the mutex
+func (bs *BandwidthService) copyLimits() models.BandwidthLimits {
+	limits := bs.limits
+	limits.FolderLimits = make(map[string]int64, len(bs.limits.FolderLimits))
+	for folderID, limit := range bs.limits.FolderLimits {
+		limits.FolderLimits[folderID] = limit
+	}
+	return limits
+}
+
+// cleanup removes buckets that have not been used recently
+func (bs *BandwidthService) cleanup() {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	cutoff := time.Now().Add(-idleBucketTTL)
+	removed := 0
+
+	for ip, bucket := range bs.clients {
+		if bucket.idleSince(cutoff) {
+			delete(bs.clients, ip)
+			removed++
+		}
+	}
+	for folderID, bucket := range bs.folders {
+		if bucket.idleSince(cutoff) {
+			delete(bs.folders, folderID)
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		log.Printf("Bandwidth cleanup: removed %d idle buckets", removed)
+	}
+}
+
+// StreamLimiter throttles a single stream against all buckets that apply to it
+type StreamLimiter struct {
+	ctx     context.Context
+	buckets []*tokenBucket
+	service *BandwidthService
+	closed  int32
+}
+
+// CopyBuffer copies from src to dst using buf, waiting on the token buckets before every write
+func (sl *StreamLimiter) CopyBuffer(dst io.Writer, src io.Reader, buf []byte) (int64, error) {
+	var written int64
+
+	for {
+		chunk := buf[:sl.chunkSize(len(buf))]
+		nr, readErr := src.Read(chunk)
+		if nr > 0 {
+			if err := sl.wait(nr); err != nil {
+				return written, err
+			}
+
+			nw, writeErr := dst.Write(chunk[:nr])
+			written += int64(nw)
+			if writeErr != nil {
+				return written, writeErr
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
+		}
+
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+}
+
+// Close releases the limiter; it is safe to call more than once
+func (sl *StreamLimiter) Close() {
+	if atomic.CompareAndSwapInt32(&sl.closed, 0, 1) {
+		atomic.AddInt64(&sl.service.throttledStreams, -1)
+	}
+}
+
+// chunkSize limits each read so that a single write never exceeds the smallest bucket burst
+func (sl *StreamLimiter) chunkSize(max int) int {
+	size := max
+	for _, bucket := range sl.buckets {
+		if burst := bucket.getBurst(); burst > 0 && burst < size {
+			size = burst
+		}
+	}
+	return size
+}
+
+// wait reserves n bytes from every bucket and sleeps for the longest resulting delay
+func (sl *StreamLimiter) wait(n int) error {
+	now := time.Now()
+	var delay time.Duration
+
+	for _, bucket := range sl.buckets {
+		if d := bucket.reserve(n, now); d > delay {
+			delay = d
+		}
+	}
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-sl.ctx.Done():
+		return sl.ctx.Err()
+	}
+}
+
+// tokenBucket is a byte-based token bucket; a rate of zero means unlimited
+type tokenBucket struct {
+	rate     float64
+	burst    float64
+	tokens   float64
+	last     time.Time
+	lastUsed time.Time
+	meter    rateMeter
+	mutex    sync.Mutex
+}
+
+// newTokenBucket creates a token bucket for the given rate in bytes per second
+func newTokenBucket(rate int64) *tokenBucket {
+	tb := &tokenBucket{
+		last:     time.Now(),
+		lastUsed: time.Now(),
+	}
+	tb.setRate(rate)
+	tb.tokens = tb.burst
+	return tb
+}
+
+// setRate changes the rate; the burst is a quarter second of traffic
+func (tb *tokenBucket) setRate(rate int64) {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	tb.rate = float64(rate)
+	tb.burst = tb.rate / 4
+	if tb.burst < minBucketBurst {
+		tb.burst = minBucketBurst
+	}
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+}
+
+// getBurst returns the burst size in bytes, or 0 when the bucket is unlimited
+func (tb *tokenBucket) getBurst() int {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	if tb.rate <= 0 {
+		return 0
+	}
+	return int(tb.burst)
+}
+
+// reserve takes n tokens and returns how long the caller must wait before using them
+func (tb *tokenBucket) reserve(n int, now time.Time) time.Duration {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	tb.lastUsed = now
+	tb.meter.add(int64(n), now)
+
+	if tb.rate <= 0 {
+		return 0
+	}
+
+	// Refill tokens for the time elapsed since the last reservation
+	elapsed := now.Sub(tb.last).Seconds()
+	if elapsed > 0 {
+		tb.tokens += elapsed * tb.rate
+		if tb.tokens > tb.burst {
+			tb.tokens = tb.burst
+		}
+	}
+	tb.last = now
+
+	tb.tokens -= float64(n)
+	if tb.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// idleSince reports whether the bucket has not been used since cutoff
+func (tb *tokenBucket) idleSince(cutoff time.Time) bool {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	return tb.lastUsed.Before(cutoff)
+}
+
+// rateMeter measures throughput over a sliding window of whole seconds
+type rateMeter struct {
+	seconds [meterWindow + 1]int64
+	bytes   [meterWindow + 1]int64
+	mutex   sync.Mutex
+}
+
+// add records n bytes transferred at now
+func (rm *rateMeter) add(n int64, now time.Time) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	sec := now.Unix()
+	slot := sec % int64(len(rm.seconds))
+	if rm.seconds[slot] != sec {
+		rm.seconds[slot] = sec
+		rm.bytes[slot] = 0
+	}
+	rm.bytes[slot] += n
+}
+
+// rate returns the average bytes per second over the last complete seconds
+func (rm *rateMeter) rate(now time.Time) float64 {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	current := now.Unix()
+	var total int64
+	for i := range rm.seconds {
+		if sec := rm.seconds[i]; sec < current && sec >= current-meterWindow {
+			total += rm.bytes[i]
+		}
+	}
+
+	return float64(total) / meterWindow
+}
@@ -154,6 +154,103 @@ func (cs *CacheService) SetDirectoryListing(path string, listing []*models.FileI
 	cs.SetWithTTL("dirlist:"+path, listing, 2*time.Minute)
 }
 
+// GetHLSIndex retrieves a cached HLS segment index for a file
+func (cs *CacheService) GetHLSIndex(fullPath string) (*models.HLSIndex, bool) {
+	value, exists := cs.Get("hls:" + fullPath)
+	if !exists {
+		return nil, false
+	}
+
+	index, ok := value.(*models.HLSIndex)
+	return index, ok
+}
+
+// SetHLSIndex caches an HLS segment index; it is validated against the file before use
+func (cs *CacheService) SetHLSIndex(fullPath string, index *models.HLSIndex) {
+	// Indexes are expensive to build and stay valid until the file changes
+	cs.SetWithTTL("hls:"+fullPath, index, 24*time.Hour)
+}
+
+// GetFaststartLayout retrieves a cached MP4 faststart layout for a file
+func (cs *CacheService) GetFaststartLayout(fullPath string) (*models.FaststartLayout, bool) {
+	value, exists := cs.Get("faststart:" + fullPath)
+	if !exists {
+		return nil, false
+	}
+
+	layout, ok := value.(*models.FaststartLayout)
+	return layout, ok
+}
+
+// SetFaststartLayout caches an MP4 faststart layout; it is validated against the file before use
+func (cs *CacheService) SetFaststartLayout(fullPath string, layout *models.FaststartLayout) {
+	cs.SetWithTTL("faststart:"+fullPath, layout, 24*time.Hour)
+}
+
+// GetAudioTags retrieves cached audio tags for a file
+func (cs *CacheService) GetAudioTags(fullPath string) (*models.AudioTags, bool) {
+	value, exists := cs.Get("audiotags:" + fullPath)
+	if !exists {
+		return nil, false
+	}
+
+	tags, ok := value.(*models.AudioTags)
+	return tags, ok
+}
+
+// SetAudioTags caches audio tags; they are validated against the file before use
+func (cs *CacheService) SetAudioTags(fullPath string, tags *models.AudioTags) {
+	cs.SetWithTTL("audiotags:"+fullPath, tags, 24*time.Hour)
+}
+
+// GetVideoInfo retrieves cached video container metadata for a file
+func (cs *CacheService) GetVideoInfo(fullPath string) (*models.VideoInfo, bool) {
+	value, exists := cs.Get("videoinfo:" + fullPath)
+	if !exists {
+		return nil, false
+	}
+
+	info, ok := value.(*models.VideoInfo)
+	return info, ok
+}
+
+// SetVideoInfo caches video container metadata; it is validated against the file before use
+func (cs *CacheService) SetVideoInfo(fullPath string, info *models.VideoInfo) {
+	cs.SetWithTTL("videoinfo:"+fullPath, info, 24*time.Hour)
+}
+
+// GetPhotoMetadata retrieves cached EXIF metadata for a photo
+func (cs *CacheService) GetPhotoMetadata(fullPath string) (*models.PhotoMetadata, bool) {
+	value, exists := cs.Get("photometa:" + fullPath)
+	if !exists {
+		return nil, false
+	}
+
+	metadata, ok := value.(*models.PhotoMetadata)
+	return metadata, ok
+}
+
+// SetPhotoMetadata caches EXIF metadata; it is validated against the file before use
+func (cs *CacheService) SetPhotoMetadata(fullPath string, metadata *models.PhotoMetadata) {
+	cs.SetWithTTL("photometa:"+fullPath, metadata, 24*time.Hour)
+}
+
+// GetCoverArt retrieves the cached cover art entry of a file
+func (cs *CacheService) GetCoverArt(fullPath string) (*models.CoverArt, bool) {
+	value, exists := cs.Get("coverart:" + fullPath)
+	if !exists {
+		return nil, false
+	}
+
+	art, ok := value.(*models.CoverArt)
+	return art, ok
+}
+
+// SetCoverArt caches a cover art entry; it is validated against the file before use
+func (cs *CacheService) SetCoverArt(fullPath string, art *models.CoverArt) {
+	cs.SetWithTTL("coverart:"+fullPath, art, 24*time.Hour)
+}
+
 // Delete removes a value from the cache
 func (cs *CacheService) Delete(key string) {
 	cs.mutex.Lock()
@@ -298,7 +395,32 @@ func (cs *CacheService) estimateEntrySize(entry *CacheEntry) int64 {
 		return baseSize + int64(len(v))
 	case *models.FileInfo:
 		// Estimate FileInfo size
-		return baseSize + int64(len(v.Name)) + int64(len(v.Path)) + int64(len(v.Extension)) + 64
+		size := baseSize + int64(len(v.Name)) + int64(len(v.Path)) + int64(len(v.Extension)) + int64(len(v.Subtitles))*128 + 64
+		if v.Tags != nil {
+			size += 256
+		}
+		if v.Video != nil {
+			size += 256 + int64(len(v.Video.AudioTracks)+len(v.Video.SubtitleTracks))*96
+		}
+		return size
+	case *models.VideoInfo:
+		// Estimate VideoInfo size
+		return baseSize + int64(len(v.Path)) + int64(len(v.AudioTracks)+len(v.SubtitleTracks))*96 + 192
+	case *models.AudioTags:
+		// Estimate AudioTags size
+		return baseSize + int64(len(v.Path)) + int64(len(v.Title)+len(v.Artist)+len(v.Album)+len(v.AlbumArtist)+len(v.Genre)) + 128
+	case *models.PhotoMetadata:
+		// Estimate PhotoMetadata size
+		return baseSize + int64(len(v.Path)) + int64(len(v.Make)+len(v.Model)+len(v.Lens)) + 160
+	case *models.CoverArt:
+		// Estimate CoverArt size
+		return baseSize + int64(len(v.Path)) + 128
+	case *models.FaststartLayout:
+		// Estimate FaststartLayout size
+		return baseSize + int64(len(v.Path)) + int64(len(v.Moov)) + 96
+	case *models.HLSIndex:
+		// Estimate HLSIndex size
+		return baseSize + int64(len(v.Path)) + int64(len(v.Segments))*24 + 128
 	default:
 		// Default estimation for other types
 		return baseSize + 256
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// pictureTypeFrontCover is the picture type of the front cover in ID3 APIC frames and FLAC
+// PICTURE blocks
+const pictureTypeFrontCover = 3
+
+// ErrNoCoverArt is returned when neither an audio file nor its folder has cover art
+var ErrNoCoverArt = fmt.Errorf("no cover art")
+
+// pictureChooser keeps the front cover among the pictures of a file, or the first picture when
+// there is no front cover
+type pictureChooser struct {
+	image []byte
+	front bool
+}
+
+// add offers a picture and reports whether the front cover has been found
+func (pc *pictureChooser) add(pictureType uint32, image []byte) bool {
+	if len(image) == 0 || pc.front {
+		return pc.front
+	}
+	if pictureType == pictureTypeFrontCover {
+		pc.image, pc.front = image, true
+	} else if pc.image == nil {
+		pc.image = image
+	}
+	return pc.front
+}
+
+// ReadEmbeddedArt returns the image embedded in an MP3 (ID3v2 APIC), FLAC (PICTURE), Ogg Vorbis
+// or Opus (METADATA_BLOCK_PICTURE) or M4A (covr) file, preferring the front cover. It returns nil
+// when the file carries no art; only I/O failures are returned as errors.
+func ReadEmbeddedArt(fullPath string) ([]byte, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+
+	head := make([]byte, 12)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	// FLAC and MP3 files may start with an ID3v2 tag
+	audioStart := int64(0)
+	if bytes.HasPrefix(head, []byte("ID3")) {
+		data, version, end, err := readID3v2Tag(file)
+		if err != nil {
+			return nil, err
+		}
+		if image := id3Picture(data, version); image != nil {
+			return image, nil
+		}
+		audioStart = end
+		n, err = file.ReadAt(head[:cap(head)], audioStart)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		head = head[:n]
+	}
+
+	var image []byte
+	switch {
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		image, err = flacPicture(file, audioStart)
+	case bytes.HasPrefix(head, []byte("OggS")):
+		image, err = oggPicture(file, size)
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		image, err = mp4CoverArt(file, size)
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return image, nil
+}
+
+// id3Picture returns the picture of the APIC (ID3v2.2 PIC) frames of an ID3v2 tag
+func id3Picture(data []byte, version byte) []byte {
+	var chooser pictureChooser
+	eachID3v2Frame(data, version, func(id string, body []byte) bool {
+		if id != "APIC" {
+			return true
+		}
+		pictureType, image, ok := parseAPIC(body, version)
+		return !ok || !chooser.add(uint32(pictureType), image)
+	})
+	return chooser.image
+}
+
+// parseAPIC splits an APIC frame into its picture type and image data. The frame starts with
+// the text encoding and MIME type (a three-letter image format in ID3v2.2), followed by the
+// picture type and a description terminated according to the text encoding.
+func parseAPIC(body []byte, version byte) (byte, []byte, bool) {
+	if len(body) < 5 {
+		return 0, nil, false
+	}
+	encoding, rest := body[0], body[1:]
+
+	if version == 2 {
+		rest = rest[3:]
+	} else {
+		end := bytes.IndexByte(rest, 0)
+		if end < 0 {
+			return 0, nil, false
+		}
+		rest = rest[end+1:]
+	}
+	if len(rest) < 1 {
+		return 0, nil, false
+	}
+	pictureType, rest := rest[0], rest[1:]
+
+	// UTF-16 descriptions end with a 16-bit null on an even offset
+	if encoding == 1 || encoding == 2 {
+		end := -1
+		for i := 0; i+1 < len(rest); i += 2 {
+			if rest[i] == 0 && rest[i+1] == 0 {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return 0, nil, false
+		}
+		rest = rest[end+2:]
+	} else {
+		end := bytes.IndexByte(rest, 0)
+		if end < 0 {
+			return 0, nil, false
+		}
+		rest = rest[end+1:]
+	}
+
+	return pictureType, rest, true
+}
+
+// flacPicture returns the picture of the PICTURE metadata blocks of a FLAC stream at offset
+func flacPicture(file io.ReaderAt, offset int64) ([]byte, error) {
+	var chooser pictureChooser
+	err := flacBlocks(file, offset, func(blockType byte, body, length int64) error {
+		if blockType != 6 || length > maxAudioTagSize {
+			return nil
+		}
+		block := make([]byte, length)
+		if _, err := file.ReadAt(block, body); err != nil {
+			return err
+		}
+		if pictureType, image, ok := parseFLACPicture(block); ok && chooser.add(pictureType, image) {
+			return errStopWalk
+		}
+		return nil
+	})
+	return chooser.image, err
+}
+
+// parseFLACPicture splits a FLAC PICTURE block (also used base64-encoded in Vorbis comments)
+// into its picture type and image data
+func parseFLACPicture(block []byte) (uint32, []byte, bool) {
+	// Picture type, MIME type, description, then width, height, depth and colour count
+	if len(block) < 8 {
+		return 0, nil, false
+	}
+	pictureType := binary.BigEndian.Uint32(block[0:4])
+	pos := 8 + uint64(binary.BigEndian.Uint32(block[4:8]))
+	if pos+4 > uint64(len(block)) {
+		return 0, nil, false
+	}
+	pos += 4 + uint64(binary.BigEndian.Uint32(block[pos:]))
+	pos += 16
+	if pos+4 > uint64(len(block)) {
+		return 0, nil, false
+	}
+	length := uint64(binary.BigEndian.Uint32(block[pos:]))
+	pos += 4
+	if pos+length > uint64(len(block)) {
+		return 0, nil, false
+	}
+	return pictureType, block[pos : pos+length], true
+}
+
+// oggPicture returns the picture stored in the comment header of an Ogg Vorbis or Opus file,
+// either as a METADATA_BLOCK_PICTURE or as a legacy COVERART field
+func oggPicture(file io.ReaderAt, size int64) ([]byte, error) {
+	ogg := &oggReader{r: bufio.NewReader(io.NewSectionReader(file, 0, size))}
+
+	ident, err := ogg.nextPacket()
+	if err != nil {
+		return nil, nil // not a readable Ogg stream
+	}
+
+	var commentPrefix string
+	switch {
+	case len(ident) >= 7 && string(ident[:7]) == "\x01vorbis":
+		commentPrefix = "\x03vorbis"
+	case len(ident) >= 8 && string(ident[:8]) == "OpusHead":
+		commentPrefix = "OpusTags"
+	default:
+		return nil, nil
+	}
+
+	comment, err := ogg.nextPacket()
+	if err != nil || !bytes.HasPrefix(comment, []byte(commentPrefix)) {
+		return nil, nil
+	}
+	fields := vorbisCommentFields(comment[len(commentPrefix):])
+
+	var chooser pictureChooser
+	for _, value := range fields["METADATA_BLOCK_PICTURE"] {
+		block, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		if pictureType, image, ok := parseFLACPicture(block); ok && chooser.add(pictureType, image) {
+			break
+		}
+	}
+	if chooser.image == nil {
+		for _, value := range fields["COVERART"] {
+			if image, err := base64.StdEncoding.DecodeString(value); err == nil && len(image) > 0 {
+				return image, nil
+			}
+		}
+	}
+	return chooser.image, nil
+}
+
+// mp4CoverArt returns the first image of the covr item of an MP4 file's iTunes-style metadata
+func mp4CoverArt(file io.ReaderAt, size int64) ([]byte, error) {
+	moov, err := readMP4Box(file, size, "moov", maxAudioTagSize)
+	if err != nil || moov == nil {
+		return nil, err
+	}
+
+	for ilst := mp4ItemList(moov); len(ilst) > 0; {
+		itemType, item, rest, err := splitBox(ilst)
+		if err != nil {
+			break
+		}
+		ilst = rest
+		if itemType != "covr" {
+			continue
+		}
+
+		// Each data box holds one image after its type indicator and locale
+		if data := childBox(item, "data"); len(data) > 8 {
+			return data[8:], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"media-server/models"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// folderArtNames are the base names of folder images used as cover art, in order of preference
+var folderArtNames = []string{"cover", "folder", "front", "album", "albumart"}
+
+// folderArtExtensions are the image types accepted as folder art, in order of preference
+var folderArtExtensions = []string{".jpg", ".jpeg", ".png"}
+
+// CoverArtService extracts the cover art of audio files, falling back to a cover image in the
+// file's folder. Images are stored once per content hash in an on-disk cache, so that all the
+// tracks of an album share one copy (and one set of thumbnails).
+type CoverArtService struct {
+	cacheDir     string
+	cacheService *CacheService
+}
+
+// NewCoverArtService creates a new CoverArtService storing images in cacheDir
+func NewCoverArtService(cacheDir string, cacheService *CacheService) (*CoverArtService, error) {
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating cover art cache directory: %w", err)
+	}
+
+	return &CoverArtService{
+		cacheDir:     cacheDir,
+		cacheService: cacheService,
+	}, nil
+}
+
+// GetCoverArt returns the cover art of an audio file: its embedded picture, or else the cover
+// image of its folder. ErrNoCoverArt is returned when there is neither.
+func (cas *CoverArtService) GetCoverArt(fullPath string) (*models.CoverArt, error) {
+	art, err := cas.cachedArt(fullPath, cas.extractEmbedded)
+	if err != nil {
+		return nil, err
+	}
+	if art.Hash != "" {
+		return art, nil
+	}
+
+	imagePath := findFolderArt(filepath.Dir(fullPath))
+	if imagePath == "" {
+		return nil, ErrNoCoverArt
+	}
+	art, err = cas.cachedArt(imagePath, cas.readFolderImage)
+	if err != nil {
+		return nil, err
+	}
+	if art.Hash == "" {
+		return nil, ErrNoCoverArt
+	}
+	return art, nil
+}
+
+// ImagePath returns the path of the cached image of cover art
+func (cas *CoverArtService) ImagePath(art *models.CoverArt) string {
+	return filepath.Join(cas.cacheDir, art.Hash[:2], art.Hash+art.Extension())
+}
+
+// cachedArt returns the cover art read from a file by read, caching the result (including the
+// absence of art) until the file changes
+func (cas *CoverArtService) cachedArt(fullPath string, read func(string) ([]byte, string, error)) (*models.CoverArt, error) {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("error accessing file: %w", err)
+	}
+
+	if cas.cacheService != nil {
+		if art, found := cas.cacheService.GetCoverArt(fullPath); found && art.IsValidFor(info.Size(), info.ModTime()) {
+			// The image itself may have been removed from the disk cache since
+			if art.Hash == "" {
+				return art, nil
+			}
+			if _, err := os.Stat(cas.ImagePath(art)); err == nil {
+				return art, nil
+			}
+		}
+	}
+
+	image, source, err := read(fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	art := &models.CoverArt{
+		Path:    fullPath,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if mimeType := http.DetectContentType(image); len(image) > 0 && strings.HasPrefix(mimeType, "image/") {
+		sum := sha256.Sum256(image)
+		art.Hash = hex.EncodeToString(sum[:])
+		art.MimeType = mimeType
+		art.Source = source
+		if err := cas.store(art, image); err != nil {
+			return nil, err
+		}
+	}
+
+	if cas.cacheService != nil {
+		cas.cacheService.SetCoverArt(fullPath, art)
+	}
+	return art, nil
+}
+
+// extractEmbedded reads the picture embedded in an audio file
+func (cas *CoverArtService) extractEmbedded(fullPath string) ([]byte, string, error) {
+	image, err := ReadEmbeddedArt(fullPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading cover art: %w", err)
+	}
+	return image, models.CoverArtEmbedded, nil
+}
+
+// readFolderImage reads a folder image, skipping images too large to be cover art
+func (cas *CoverArtService) readFolderImage(fullPath string) ([]byte, string, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("error opening folder image: %w", err)
+	}
+	defer file.Close()
+
+	image, err := io.ReadAll(io.LimitReader(file, maxAudioTagSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading folder image: %w", err)
+	}
+	if len(image) > maxAudioTagSize {
+		log.Printf("Skipping folder image %s: larger than %d bytes", fullPath, maxAudioTagSize)
+		return nil, "", nil
+	}
+	return image, models.CoverArtFolder, nil
+}
+
+// store writes an image to the cache unless an identical one is already there
+func (cas *CoverArtService) store(art *models.CoverArt, image []byte) error {
+	imagePath := cas.ImagePath(art)
+	if _, err := os.Stat(imagePath); err == nil {
+		return nil
+	}
+
+	// Write to a temporary file first so that readers never see a partial image
+	if err := os.MkdirAll(filepath.Dir(imagePath), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(imagePath), ".art-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(image); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), imagePath); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// findFolderArt returns the path of the preferred cover image in a directory, matching names
+// case-insensitively, or "" when there is none
+func findFolderArt(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	names := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names[strings.ToLower(entry.Name())] = entry.Name()
+		}
+	}
+
+	for _, base := range folderArtNames {
+		for _, ext := range folderArtExtensions {
+			if name, found := names[base+ext]; found {
+				return filepath.Join(dir, name)
+			}
+		}
+	}
+	return ""
+}
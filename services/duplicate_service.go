@@ -0,0 +1,315 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"log"
+	"media-server/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Content fingerprinting
+const (
+	duplicateChunkSize = 64 << 10 // bytes read from each end of a file for its quick fingerprint
+	duplicateQueueSize = 64       // hashing tasks queued on the worker pool at once
+)
+
+// DuplicateService finds files with identical content in the active media folders. Whenever a
+// folder scan or the folder watcher updates the media index, the files sharing their size with
+// another file are fingerprinted from their size and first and last chunks, and matching
+// fingerprints are confirmed with a SHA-256 of the whole file. Hashes are stored in the media
+// index by path, size and modification time, so rescans only read new and changed files.
+type DuplicateService struct {
+	mediaFolderService *MediaFolderService
+	mediaIndex         *MediaIndexService
+	workerPool         *WorkerPool
+
+	report  *models.DuplicateReport
+	running bool // a hashing pass is running
+	pending bool // another pass was requested while it runs
+	mutex   sync.RWMutex
+}
+
+// hashCandidate is an indexed file that shares its size with another file
+type hashCandidate struct {
+	fullPath string
+	folder   *models.MediaFolder
+	file     *models.IndexedFile
+	hash     *models.FileHash
+}
+
+// NewDuplicateService creates a new DuplicateService. Files are hashed on a worker pool of the
+// performance service with the given number of workers.
+func NewDuplicateService(mediaFolderService *MediaFolderService, mediaIndex *MediaIndexService,
+	performanceService *PerformanceService, workers int) *DuplicateService {
+	if workers < 1 {
+		workers = 1
+	}
+
+	ds := &DuplicateService{
+		mediaFolderService: mediaFolderService,
+		mediaIndex:         mediaIndex,
+		workerPool:         performanceService.GetOrCreateWorkerPool("hashing", workers, duplicateQueueSize),
+		report:             &models.DuplicateReport{Groups: []*models.DuplicateGroup{}},
+	}
+
+	// Hashing runs in the background, so scans and the folder watcher are not held up
+	mediaIndex.OnChange(func(folderPath string) {
+		ds.Refresh()
+	})
+
+	return ds
+}
+
+// Refresh starts a hashing pass in the background. Passes requested while one is running are
+// coalesced into a single further pass.
+func (ds *DuplicateService) Refresh() {
+	ds.mutex.Lock()
+	if ds.running {
+		ds.pending = true
+		ds.mutex.Unlock()
+		return
+	}
+	ds.running = true
+	ds.mutex.Unlock()
+
+	go func() {
+		for {
+			ds.update()
+
+			ds.mutex.Lock()
+			if !ds.pending {
+				ds.running = false
+				ds.mutex.Unlock()
+				return
+			}
+			ds.pending = false
+			ds.mutex.Unlock()
+		}
+	}()
+}
+
+// Report returns the duplicates found by the last hashing pass
+func (ds *DuplicateService) Report() *models.DuplicateReport {
+	ds.mutex.RLock()
+	defer ds.mutex.RUnlock()
+
+	report := *ds.report
+	report.Hashing = ds.running
+	return &report
+}
+
+// update runs one hashing pass over the indexed files of the active media folders and replaces
+// the report
+func (ds *DuplicateService) update() {
+	startTime := time.Now()
+
+	// Only files of the same size can have the same content. Hashes of files in inactive
+	// folders are kept for when the folders are enabled again, and files of nested folders
+	// are only counted once.
+	bySize := make(map[int64][]*hashCandidate)
+	keep := make(map[string]bool)
+	listed := make(map[string]bool)
+	for _, folder := range ds.mediaFolderService.GetAllFolders() {
+		folderPath, err := folder.GetAbsolutePath()
+		if err != nil {
+			continue
+		}
+		files, _, err := ds.mediaIndex.Files(folderPath)
+		if err != nil {
+			log.Printf("Error reading %s for duplicate detection: %v", folderPath, err)
+			continue
+		}
+
+		for _, file := range files {
+			fullPath := filepath.Join(folderPath, filepath.FromSlash(file.Path))
+			keep[fullPath] = true
+			if !folder.IsActive || file.Size == 0 || listed[fullPath] {
+				continue
+			}
+			listed[fullPath] = true
+			bySize[file.Size] = append(bySize[file.Size], &hashCandidate{fullPath: fullPath, folder: folder, file: file})
+		}
+	}
+
+	var candidates []*hashCandidate
+	for _, group := range bySize {
+		if len(group) > 1 {
+			candidates = append(candidates, group...)
+		}
+	}
+	ds.hashAll(candidates, false)
+
+	// Confirm matching fingerprints with whole-file hashes
+	var confirm []*hashCandidate
+	for _, group := range groupCandidates(candidates, func(hash *models.FileHash) string { return hash.Quick }) {
+		confirm = append(confirm, group...)
+	}
+	ds.hashAll(confirm, true)
+
+	report := &models.DuplicateReport{
+		Groups:      []*models.DuplicateGroup{},
+		HashedFiles: len(candidates),
+		UpdatedAt:   time.Now(),
+	}
+	for sum, group := range groupCandidates(confirm, func(hash *models.FileHash) string { return hash.SHA256 }) {
+		duplicates := &models.DuplicateGroup{
+			SHA256:      sum,
+			Size:        group[0].file.Size,
+			Files:       make([]*models.DuplicateFile, 0, len(group)),
+			WastedSpace: group[0].file.Size * int64(len(group)-1),
+		}
+		for _, candidate := range group {
+			duplicates.Files = append(duplicates.Files, &models.DuplicateFile{
+				Path:      candidate.file.Path,
+				MediaPath: models.FolderMediaPath(candidate.folder.ID, candidate.file.Path),
+				FolderID:  candidate.folder.ID,
+				Folder:    candidate.folder.Name,
+				ModTime:   candidate.file.ModTime,
+			})
+		}
+		sort.Slice(duplicates.Files, func(i, j int) bool {
+			return duplicates.Files[i].MediaPath < duplicates.Files[j].MediaPath
+		})
+
+		report.Groups = append(report.Groups, duplicates)
+		report.DuplicateFiles += len(group) - 1
+		report.WastedSpace += duplicates.WastedSpace
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		if report.Groups[i].WastedSpace != report.Groups[j].WastedSpace {
+			return report.Groups[i].WastedSpace > report.Groups[j].WastedSpace
+		}
+		return report.Groups[i].SHA256 < report.Groups[j].SHA256
+	})
+
+	if _, err := ds.mediaIndex.PruneFileHashes(keep); err != nil {
+		log.Printf("Error pruning file hashes: %v", err)
+	}
+
+	ds.mutex.Lock()
+	ds.report = report
+	ds.mutex.Unlock()
+
+	log.Printf("Duplicate detection: %d files fingerprinted, %d groups of duplicates wasting %s (%v)",
+		len(candidates), len(report.Groups), formatBytes(report.WastedSpace), time.Since(startTime).Round(time.Millisecond))
+}
+
+// hashAll brings the hashes of candidates up to date on the worker pool, reusing the stored
+// hashes of unchanged files. With full set, whole files are hashed as well.
+func (ds *DuplicateService) hashAll(candidates []*hashCandidate, full bool) {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, duplicateQueueSize)
+
+	for _, candidate := range candidates {
+		if candidate.hash == nil {
+			if stored, found := ds.mediaIndex.FileHash(candidate.fullPath); found && stored.Matches(candidate.file.Size, candidate.file.ModTime) {
+				candidate.hash = stored
+			}
+		}
+		if candidate.hash != nil && (!full || candidate.hash.SHA256 != "") {
+			continue
+		}
+
+		// Never queue more tasks than the pool buffers, so submitting cannot fail for a full queue
+		slots <- struct{}{}
+		wg.Add(1)
+		err := ds.workerPool.Submit("hash:"+candidate.fullPath, func() error {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			return ds.hashFile(candidate, full)
+		})
+		if err != nil {
+			<-slots
+			wg.Done()
+			log.Printf("Hashing stopped: %v", err)
+			break
+		}
+	}
+
+	wg.Wait()
+}
+
+// hashFile fingerprints a file, hashes it whole when full is set, and stores its hash
+func (ds *DuplicateService) hashFile(candidate *hashCandidate, full bool) error {
+	file, err := os.Open(candidate.fullPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// A file changed since it was indexed is hashed after its index entry is updated
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != candidate.file.Size || !info.ModTime().Equal(candidate.file.ModTime) {
+		return nil
+	}
+
+	hash := &models.FileHash{Size: candidate.file.Size, ModTime: candidate.file.ModTime}
+	if candidate.hash != nil {
+		*hash = *candidate.hash
+	} else {
+		if hash.Quick, err = quickFingerprint(file, hash.Size); err != nil {
+			return err
+		}
+	}
+
+	if full && hash.SHA256 == "" {
+		sum := sha256.New()
+		if _, err := io.Copy(sum, io.NewSectionReader(file, 0, hash.Size)); err != nil {
+			return err
+		}
+		hash.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	}
+
+	candidate.hash = hash
+	return ds.mediaIndex.PutFileHash(candidate.fullPath, hash)
+}
+
+// quickFingerprint hashes the size of a file with its first and last chunks
+func quickFingerprint(file io.ReaderAt, size int64) (string, error) {
+	sum := sha256.New()
+	binary.Write(sum, binary.LittleEndian, size)
+
+	if _, err := io.Copy(sum, io.NewSectionReader(file, 0, min(size, duplicateChunkSize))); err != nil {
+		return "", err
+	}
+	if size > duplicateChunkSize {
+		tail := max(size-duplicateChunkSize, duplicateChunkSize)
+		if _, err := io.Copy(sum, io.NewSectionReader(file, tail, size-tail)); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// groupCandidates groups hashed candidates by a hash value, keeping the groups of two or more
+func groupCandidates(candidates []*hashCandidate, key func(*models.FileHash) string) map[string][]*hashCandidate {
+	groups := make(map[string][]*hashCandidate)
+	for _, candidate := range candidates {
+		if candidate.hash == nil {
+			continue
+		}
+		if value := key(candidate.hash); value != "" {
+			groups[value] = append(groups[value], candidate)
+		}
+	}
+
+	for value, group := range groups {
+		if len(group) < 2 {
+			delete(groups, value)
+		}
+	}
+	return groups
+}
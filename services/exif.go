@@ -0,0 +1,384 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EXIF tags
+const (
+	exifTagMake               = 0x010F
+	exifTagModel              = 0x0110
+	exifTagOrientation        = 0x0112
+	exifTagDateTime           = 0x0132
+	exifTagExposureTime       = 0x829A
+	exifTagFNumber            = 0x829D
+	exifTagExifIFD            = 0x8769
+	exifTagGPSIFD             = 0x8825
+	exifTagISO                = 0x8827
+	exifTagDateTimeOriginal   = 0x9003
+	exifTagDateTimeDigitized  = 0x9004
+	exifTagOffsetTimeOriginal = 0x9011
+	exifTagFocalLength        = 0x920A
+	exifTagLensMake           = 0xA433
+	exifTagLensModel          = 0xA434
+)
+
+// GPS tags
+const (
+	gpsTagLatitudeRef  = 0x0001
+	gpsTagLatitude     = 0x0002
+	gpsTagLongitudeRef = 0x0003
+	gpsTagLongitude    = 0x0004
+	gpsTagAltitudeRef  = 0x0005
+	gpsTagAltitude     = 0x0006
+)
+
+// exifDateLayout is the layout of EXIF date/time strings
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// maxEXIFSegment bounds the APP1 payload read from a JPEG file
+const maxEXIFSegment = 64 << 10
+
+// maxTIFFHeaderRead bounds how much of a TIFF file is read for its metadata IFDs
+const maxTIFFHeaderRead = 1 << 20
+
+// errNoEXIF is returned when a file carries no EXIF data
+var errNoEXIF = fmt.Errorf("no EXIF data")
+
+// exifEntry is a raw IFD entry
+type exifEntry struct {
+	typ   uint16
+	count uint32
+	value []byte // value bytes, resolved from the offset when they do not fit in the entry
+}
+
+// exifData is the parsed TIFF structure of an EXIF block
+type exifData struct {
+	order binary.ByteOrder
+	tiff  []byte
+	ifd0  map[uint16]exifEntry
+	exif  map[uint16]exifEntry // Exif sub-IFD, nil when absent
+	gps   map[uint16]exifEntry // GPS sub-IFD, nil when absent
+}
+
+// readJPEGEXIF returns the EXIF payload (TIFF header onwards) of a JPEG stream, reading only the
+// segments before the image data
+func readJPEGEXIF(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return nil, fmt.Errorf("not a JPEG file")
+	}
+
+	for {
+		// Markers may be preceded by fill bytes
+		marker, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if marker != 0xFF {
+			return nil, fmt.Errorf("invalid JPEG marker")
+		}
+		for marker == 0xFF {
+			if marker, err = br.ReadByte(); err != nil {
+				return nil, err
+			}
+		}
+
+		// Start of scan or end of image: no more metadata segments
+		if marker == 0xDA || marker == 0xD9 {
+			return nil, errNoEXIF
+		}
+		// Standalone markers have no length
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			continue
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(br, length[:]); err != nil {
+			return nil, err
+		}
+		size := int(binary.BigEndian.Uint16(length[:])) - 2
+		if size < 0 {
+			return nil, fmt.Errorf("invalid JPEG segment length")
+		}
+
+		if marker == 0xE1 && size <= maxEXIFSegment {
+			payload := make([]byte, size)
+			if _, err := io.ReadFull(br, payload); err != nil {
+				return nil, err
+			}
+			if bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+				return payload[6:], nil
+			}
+			continue // XMP or another APP1 block
+		}
+
+		if _, err := br.Discard(size); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readTIFFEXIF returns the start of a TIFF file, which holds its metadata IFDs in the same
+// layout as an EXIF payload. IFDs stored past the bound are skipped by readIFD.
+func readTIFFEXIF(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxTIFFHeaderRead))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 || !(bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*"))) {
+		return nil, fmt.Errorf("not a TIFF file")
+	}
+	return data, nil
+}
+
+// parseEXIF parses the TIFF header and first IFD of an EXIF payload, along with the Exif and
+// GPS sub-IFDs it points to
+func parseEXIF(tiff []byte) (*exifData, error) {
+	if len(tiff) < 8 {
+		return nil, fmt.Errorf("EXIF data too short")
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid TIFF byte order")
+	}
+	if order.Uint16(tiff[2:4]) != 42 {
+		return nil, fmt.Errorf("invalid TIFF header")
+	}
+
+	ed := &exifData{order: order, tiff: tiff}
+	ifd0, err := ed.readIFD(order.Uint32(tiff[4:8]))
+	if err != nil {
+		return nil, err
+	}
+	ed.ifd0 = ifd0
+
+	// A broken sub-IFD only loses the tags it holds
+	if offset, ok := ed.uintValue(ifd0, exifTagExifIFD); ok {
+		ed.exif, _ = ed.readIFD(offset)
+	}
+	if offset, ok := ed.uintValue(ifd0, exifTagGPSIFD); ok {
+		ed.gps, _ = ed.readIFD(offset)
+	}
+	return ed, nil
+}
+
+// exifTypeSizes maps TIFF field types to their size in bytes
+var exifTypeSizes = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+// readIFD reads the entries of the IFD at offset
+func (ed *exifData) readIFD(offset uint32) (map[uint16]exifEntry, error) {
+	if uint64(offset)+2 > uint64(len(ed.tiff)) {
+		return nil, fmt.Errorf("IFD offset out of range")
+	}
+
+	count := int(ed.order.Uint16(ed.tiff[offset:]))
+	start := int(offset) + 2
+	if start+count*12 > len(ed.tiff) {
+		return nil, fmt.Errorf("IFD entries out of range")
+	}
+
+	entries := make(map[uint16]exifEntry, count)
+	for i := 0; i < count; i++ {
+		raw := ed.tiff[start+i*12 : start+(i+1)*12]
+		tag := ed.order.Uint16(raw[0:2])
+		typ := ed.order.Uint16(raw[2:4])
+		n := ed.order.Uint32(raw[4:8])
+
+		typeSize, known := exifTypeSizes[typ]
+		if !known {
+			continue
+		}
+		size := uint64(typeSize) * uint64(n)
+
+		var value []byte
+		if size <= 4 {
+			value = raw[8 : 8+size]
+		} else {
+			valueOffset := uint64(ed.order.Uint32(raw[8:12]))
+			if valueOffset+size > uint64(len(ed.tiff)) {
+				continue
+			}
+			value = ed.tiff[valueOffset : valueOffset+size]
+		}
+
+		entries[tag] = exifEntry{typ: typ, count: n, value: value}
+	}
+
+	return entries, nil
+}
+
+// uintValue returns the first value of a BYTE, SHORT or LONG entry
+func (ed *exifData) uintValue(entries map[uint16]exifEntry, tag uint16) (uint32, bool) {
+	entry, ok := entries[tag]
+	if !ok || entry.count == 0 {
+		return 0, false
+	}
+
+	switch entry.typ {
+	case 1:
+		return uint32(entry.value[0]), true
+	case 3:
+		return uint32(ed.order.Uint16(entry.value)), true
+	case 4:
+		return ed.order.Uint32(entry.value), true
+	}
+	return 0, false
+}
+
+// stringValue returns the text of an ASCII entry, trimmed of NULs and padding
+func (ed *exifData) stringValue(entries map[uint16]exifEntry, tag uint16) string {
+	entry, ok := entries[tag]
+	if !ok || entry.typ != 2 {
+		return ""
+	}
+	value := entry.value
+	if i := bytes.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(string(value))
+}
+
+// rationalValues returns the numerators and denominators of a RATIONAL or SRATIONAL entry
+func (ed *exifData) rationalValues(entries map[uint16]exifEntry, tag uint16) ([][2]int64, bool) {
+	entry, ok := entries[tag]
+	if !ok || entry.count == 0 || (entry.typ != 5 && entry.typ != 10) {
+		return nil, false
+	}
+
+	values := make([][2]int64, entry.count)
+	for i := range values {
+		num := ed.order.Uint32(entry.value[i*8:])
+		den := ed.order.Uint32(entry.value[i*8+4:])
+		if entry.typ == 10 {
+			values[i] = [2]int64{int64(int32(num)), int64(int32(den))}
+		} else {
+			values[i] = [2]int64{int64(num), int64(den)}
+		}
+	}
+	return values, true
+}
+
+// floatValue returns the first value of a RATIONAL or SRATIONAL entry as a float
+func (ed *exifData) floatValue(entries map[uint16]exifEntry, tag uint16) (float64, bool) {
+	values, ok := ed.rationalValues(entries, tag)
+	if !ok || values[0][1] == 0 {
+		return 0, false
+	}
+	return float64(values[0][0]) / float64(values[0][1]), true
+}
+
+// DateTaken returns when the photo was taken, from DateTimeOriginal, DateTimeDigitized or
+// DateTime in that order. EXIF dates carry no zone unless OffsetTimeOriginal is set, so they
+// are read as local time.
+func (ed *exifData) DateTaken() (time.Time, bool) {
+	candidates := []string{
+		ed.stringValue(ed.exif, exifTagDateTimeOriginal),
+		ed.stringValue(ed.exif, exifTagDateTimeDigitized),
+		ed.stringValue(ed.ifd0, exifTagDateTime),
+	}
+
+	location := time.Local
+	if offset := ed.stringValue(ed.exif, exifTagOffsetTimeOriginal); offset != "" {
+		if t, err := time.Parse("-07:00", offset); err == nil {
+			location = t.Location()
+		}
+	}
+
+	for _, value := range candidates {
+		// Cameras without a clock write zeros or blanks
+		if value == "" || strings.HasPrefix(value, "0000") || strings.TrimLeft(value, " :") == "" {
+			continue
+		}
+		if len(value) > len(exifDateLayout) {
+			value = value[:len(exifDateLayout)] // drop sub-second suffixes
+		}
+		if t, err := time.ParseInLocation(exifDateLayout, value, location); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ExposureTime returns the exposure time as a fraction such as "1/250", or seconds for long
+// exposures
+func (ed *exifData) ExposureTime() string {
+	values, ok := ed.rationalValues(ed.exif, exifTagExposureTime)
+	if !ok || values[0][0] <= 0 || values[0][1] <= 0 {
+		return ""
+	}
+	num, den := values[0][0], values[0][1]
+	if num >= den {
+		return strconv.FormatFloat(float64(num)/float64(den), 'f', -1, 64)
+	}
+	// Reduce 10/2500 to 1/250
+	return fmt.Sprintf("1/%s", strconv.FormatFloat(math.Round(float64(den)/float64(num)*10)/10, 'f', -1, 64))
+}
+
+// GPS returns the position recorded in the GPS IFD, if any
+func (ed *exifData) GPS() (latitude, longitude, altitude float64, ok bool) {
+	latitude, latOK := ed.gpsCoordinate(gpsTagLatitude, gpsTagLatitudeRef, "S")
+	longitude, lonOK := ed.gpsCoordinate(gpsTagLongitude, gpsTagLongitudeRef, "W")
+	if !latOK || !lonOK || (latitude == 0 && longitude == 0) {
+		return 0, 0, 0, false
+	}
+
+	if value, found := ed.floatValue(ed.gps, gpsTagAltitude); found {
+		altitude = value
+		// Altitude reference 1 means below sea level
+		if ref, found := ed.uintValue(ed.gps, gpsTagAltitudeRef); found && ref == 1 {
+			altitude = -altitude
+		}
+	}
+	return latitude, longitude, altitude, true
+}
+
+// gpsCoordinate converts a degrees/minutes/seconds GPS entry to decimal degrees, negated when
+// the reference entry equals negativeRef
+func (ed *exifData) gpsCoordinate(tag, refTag uint16, negativeRef string) (float64, bool) {
+	values, ok := ed.rationalValues(ed.gps, tag)
+	if !ok || len(values) < 3 {
+		return 0, false
+	}
+
+	var degrees float64
+	for i, scale := range []float64{1, 60, 3600} {
+		if values[i][1] == 0 {
+			if values[i][0] != 0 {
+				return 0, false
+			}
+			continue
+		}
+		degrees += float64(values[i][0]) / float64(values[i][1]) / scale
+	}
+	if strings.EqualFold(ed.stringValue(ed.gps, refTag), negativeRef) {
+		degrees = -degrees
+	}
+	return degrees, true
+}
+
+// Orientation returns the EXIF orientation (1-8), defaulting to 1 (upright)
+func (ed *exifData) Orientation() int {
+	if value, ok := ed.uintValue(ed.ifd0, exifTagOrientation); ok && value >= 1 && value <= 8 {
+		return int(value)
+	}
+	return 1
+}
@@ -0,0 +1,447 @@
+package services
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"media-server/models"
+	"os"
+)
+
+// maxFaststartMoovSize caps the moov box size that is relocated in memory
+const maxFaststartMoovSize = 64 * 1024 * 1024
+
+// errMalformedBox is returned when an MP4 box does not fit in its parent
+var errMalformedBox = errors.New("malformed MP4 box")
+
+// mp4ContainerBoxes are the boxes on the path from moov to the chunk offset tables
+var mp4ContainerBoxes = map[string]bool{
+	"trak": true,
+	"mdia": true,
+	"minf": true,
+	"stbl": true,
+}
+
+// FaststartService serves MP4 files that have their moov box at the end as if it had been
+// moved to the front, so playback can start before the whole file is downloaded
+type FaststartService struct {
+	cacheService *CacheService
+}
+
+// NewFaststartService creates a new FaststartService; layouts are cached in cacheService when set
+func NewFaststartService(cacheService *CacheService) *FaststartService {
+	return &FaststartService{
+		cacheService: cacheService,
+	}
+}
+
+// GetLayout returns the faststart layout of an MP4 file. The layout is not relocated when the
+// file already has its moov box in front of the media data or cannot be relocated safely.
+func (fs *FaststartService) GetLayout(fullPath string) (*models.FaststartLayout, error) {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("error accessing file: %w", err)
+	}
+
+	if fs.cacheService != nil {
+		if layout, found := fs.cacheService.GetFaststartLayout(fullPath); found && layout.IsValidFor(info.Size(), info.ModTime()) {
+			return layout, nil
+		}
+	}
+
+	layout, err := scanFaststartLayout(fullPath, info)
+	if err != nil {
+		return nil, err
+	}
+	if layout.Relocated {
+		log.Printf("Serving %s with relocated moov box (%d bytes)", fullPath, len(layout.Moov))
+	}
+
+	if fs.cacheService != nil {
+		fs.cacheService.SetFaststartLayout(fullPath, layout)
+	}
+	return layout, nil
+}
+
+// Open returns a reader over the virtual file described by layout
+func (fs *FaststartService) Open(layout *models.FaststartLayout) (*FaststartReader, error) {
+	file, err := os.Open(layout.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	moovEnd := layout.MoovOffset + layout.MoovSize
+	return &FaststartReader{
+		file: file,
+		parts: []faststartPart{
+			{offset: 0, length: layout.InsertAt},
+			{data: layout.Moov, length: int64(len(layout.Moov))},
+			{offset: layout.InsertAt, length: layout.MoovOffset - layout.InsertAt},
+			{offset: moovEnd, length: layout.Size - moovEnd},
+		},
+		size: layout.VirtualSize(),
+	}, nil
+}
+
+// scanFaststartLayout walks the top-level boxes of an MP4 file and relocates moov if needed
+func scanFaststartLayout(fullPath string, info os.FileInfo) (*models.FaststartLayout, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	layout := &models.FaststartLayout{
+		Path:    fullPath,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+
+	size := info.Size()
+	firstMdat, moovOffset, moovSize := int64(-1), int64(-1), int64(0)
+	header := make([]byte, 16)
+
+	for offset := int64(0); offset+8 <= size; {
+		if _, err := file.ReadAt(header[:8], offset); err != nil {
+			return nil, err
+		}
+
+		boxSize := int64(binary.BigEndian.Uint32(header[0:4]))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - offset
+		case 1:
+			if _, err := file.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if boxSize < headerSize || boxSize > size-offset {
+			// Not a well-formed MP4, serve it untouched
+			return layout, nil
+		}
+
+		switch boxType {
+		case "mdat":
+			if firstMdat < 0 {
+				firstMdat = offset
+			}
+		case "moov":
+			moovOffset, moovSize = offset, boxSize
+		case "moof":
+			// Fragmented MP4s keep their sample offsets in the fragments
+			return layout, nil
+		}
+		offset += boxSize
+	}
+
+	if moovOffset < 0 || firstMdat < 0 || moovOffset < firstMdat {
+		return layout, nil
+	}
+	if moovSize > maxFaststartMoovSize {
+		log.Printf("Not relocating moov of %s: %d bytes exceeds the %d byte limit", fullPath, moovSize, maxFaststartMoovSize)
+		return layout, nil
+	}
+
+	moov := make([]byte, moovSize)
+	if _, err := file.ReadAt(moov, moovOffset); err != nil {
+		return nil, err
+	}
+
+	relocated, err := relocateMoov(moov, firstMdat, moovOffset)
+	if err != nil {
+		log.Printf("Not relocating moov of %s: %v", fullPath, err)
+		return layout, nil
+	}
+
+	layout.Relocated = true
+	layout.InsertAt = firstMdat
+	layout.MoovOffset = moovOffset
+	layout.MoovSize = moovSize
+	layout.Moov = relocated
+	return layout, nil
+}
+
+// relocateMoov rewrites the chunk offsets of a moov box for its new place at insertAt.
+// stco tables are upgraded to co64 when a shifted offset no longer fits in 32 bits.
+func relocateMoov(moov []byte, insertAt, moovOffset int64) ([]byte, error) {
+	moovSize := int64(len(moov))
+	moovEnd := moovOffset + moovSize
+
+	for _, useCO64 := range []bool{false, true} {
+		// The table sizes do not depend on the offset values, so measure first
+		sized, _, err := rewriteMoovBox(moov, func(offset uint64) uint64 { return offset }, useCO64)
+		if err != nil {
+			return nil, err
+		}
+		newSize := int64(len(sized))
+
+		shift := func(offset uint64) uint64 {
+			o := int64(offset)
+			switch {
+			case o >= insertAt && o < moovOffset:
+				return uint64(o + newSize)
+			case o >= moovEnd:
+				return uint64(o + newSize - moovSize)
+			default:
+				return offset
+			}
+		}
+
+		relocated, overflow, err := rewriteMoovBox(moov, shift, useCO64)
+		if err != nil {
+			return nil, err
+		}
+		if !overflow {
+			return relocated, nil
+		}
+	}
+
+	return nil, errors.New("chunk offsets overflow")
+}
+
+// rewriteMoovBox rewrites a complete moov box, reporting whether a 32-bit offset overflowed
+func rewriteMoovBox(moov []byte, shift func(uint64) uint64, useCO64 bool) ([]byte, bool, error) {
+	boxType, body, rest, err := splitBox(moov)
+	if err != nil {
+		return nil, false, err
+	}
+	if boxType != "moov" || len(rest) != 0 {
+		return nil, false, errMalformedBox
+	}
+
+	children, overflow, err := rewriteBoxes(body, shift, useCO64)
+	if err != nil {
+		return nil, false, err
+	}
+	return appendBox(nil, "moov", children), overflow, nil
+}
+
+// rewriteBoxes rewrites a sequence of boxes, descending into containers and updating chunk offsets
+func rewriteBoxes(data []byte, shift func(uint64) uint64, useCO64 bool) ([]byte, bool, error) {
+	out := make([]byte, 0, len(data))
+	overflow := false
+
+	for len(data) > 0 {
+		boxType, body, rest, err := splitBox(data)
+		if err != nil {
+			return nil, false, err
+		}
+		box := data[:len(data)-len(rest)]
+		data = rest
+
+		switch {
+		case mp4ContainerBoxes[boxType]:
+			children, childOverflow, err := rewriteBoxes(body, shift, useCO64)
+			if err != nil {
+				return nil, false, err
+			}
+			out = appendBox(out, boxType, children)
+			overflow = overflow || childOverflow
+		case boxType == "stco":
+			table, tableOverflow, err := rewriteChunkOffsets(body, 4, shift, useCO64)
+			if err != nil {
+				return nil, false, err
+			}
+			if useCO64 {
+				out = appendBox(out, "co64", table)
+			} else {
+				out = appendBox(out, "stco", table)
+			}
+			overflow = overflow || tableOverflow
+		case boxType == "co64":
+			table, _, err := rewriteChunkOffsets(body, 8, shift, true)
+			if err != nil {
+				return nil, false, err
+			}
+			out = appendBox(out, "co64", table)
+		default:
+			out = append(out, box...)
+		}
+	}
+
+	return out, overflow, nil
+}
+
+// rewriteChunkOffsets shifts the entries of an stco (4-byte) or co64 (8-byte) table body,
+// writing 8-byte entries when wide is set
+func rewriteChunkOffsets(body []byte, entrySize int, shift func(uint64) uint64, wide bool) ([]byte, bool, error) {
+	if len(body) < 8 {
+		return nil, false, errMalformedBox
+	}
+	count := int(binary.BigEndian.Uint32(body[4:8]))
+	if count < 0 || len(body) < 8+count*entrySize {
+		return nil, false, errMalformedBox
+	}
+
+	outSize := 4
+	if wide {
+		outSize = 8
+	}
+	out := make([]byte, 8+count*outSize)
+	copy(out, body[:8])
+
+	overflow := false
+	for i := 0; i < count; i++ {
+		var offset uint64
+		if entrySize == 8 {
+			offset = binary.BigEndian.Uint64(body[8+i*8:])
+		} else {
+			offset = uint64(binary.BigEndian.Uint32(body[8+i*4:]))
+		}
+
+		offset = shift(offset)
+		if wide {
+			binary.BigEndian.PutUint64(out[8+i*8:], offset)
+		} else {
+			if offset > math.MaxUint32 {
+				overflow = true
+			}
+			binary.BigEndian.PutUint32(out[8+i*4:], uint32(offset))
+		}
+	}
+
+	return out, overflow, nil
+}
+
+// splitBox returns the type and body of the first box in data and the data following it
+func splitBox(data []byte) (boxType string, body, rest []byte, err error) {
+	if len(data) < 8 {
+		return "", nil, nil, errMalformedBox
+	}
+
+	size := uint64(binary.BigEndian.Uint32(data[0:4]))
+	boxType = string(data[4:8])
+	headerSize := uint64(8)
+	switch size {
+	case 0:
+		size = uint64(len(data))
+	case 1:
+		if len(data) < 16 {
+			return "", nil, nil, errMalformedBox
+		}
+		size = binary.BigEndian.Uint64(data[8:16])
+		headerSize = 16
+	}
+	if size < headerSize || size > uint64(len(data)) {
+		return "", nil, nil, errMalformedBox
+	}
+
+	return boxType, data[headerSize:size], data[size:], nil
+}
+
+// appendBox appends a box with the given type and body to out
+func appendBox(out []byte, boxType string, body []byte) []byte {
+	size := uint64(8 + len(body))
+	if size > math.MaxUint32 {
+		header := make([]byte, 16)
+		binary.BigEndian.PutUint32(header[0:4], 1)
+		copy(header[4:8], boxType)
+		binary.BigEndian.PutUint64(header[8:16], size+8)
+		out = append(out, header...)
+	} else {
+		header := make([]byte, 8)
+		binary.BigEndian.PutUint32(header[0:4], uint32(size))
+		copy(header[4:8], boxType)
+		out = append(out, header...)
+	}
+	return append(out, body...)
+}
+
+// faststartPart is a piece of the virtual file, either a range of the source file or in-memory data
+type faststartPart struct {
+	offset int64
+	length int64
+	data   []byte
+}
+
+// FaststartReader reads the virtual faststart layout of an MP4 file
+type FaststartReader struct {
+	file  *os.File
+	parts []faststartPart
+	size  int64
+	pos   int64
+}
+
+// Size returns the size of the virtual file
+func (fr *FaststartReader) Size() int64 {
+	return fr.size
+}
+
+// ReadAt implements io.ReaderAt over the virtual layout
+func (fr *FaststartReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= fr.size {
+		return 0, io.EOF
+	}
+
+	n := 0
+	start := int64(0)
+	for _, part := range fr.parts {
+		end := start + part.length
+		if off < end && n < len(p) {
+			within := off - start
+			chunk := p[n:]
+			if int64(len(chunk)) > part.length-within {
+				chunk = chunk[:part.length-within]
+			}
+
+			var read int
+			var err error
+			if part.data != nil {
+				read = copy(chunk, part.data[within:])
+			} else {
+				read, err = fr.file.ReadAt(chunk, part.offset+within)
+			}
+			n += read
+			off += int64(read)
+			if err != nil {
+				return n, err
+			}
+		}
+		start = end
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read implements io.Reader
+func (fr *FaststartReader) Read(p []byte) (int, error) {
+	n, err := fr.ReadAt(p, fr.pos)
+	fr.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker
+func (fr *FaststartReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += fr.pos
+	case io.SeekEnd:
+		offset += fr.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	fr.pos = offset
+	return offset, nil
+}
+
+// Close closes the underlying file
+func (fr *FaststartReader) Close() error {
+	return fr.file.Close()
+}
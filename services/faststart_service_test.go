@@ -0,0 +1,282 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testBox builds an MP4 box from its type and body parts
+func testBox(boxType string, body ...[]byte) []byte {
+	return appendBox(nil, boxType, bytes.Join(body, nil))
+}
+
+// testChunkOffsets builds the body of an stco (entrySize 4) or co64 (entrySize 8) table
+func testChunkOffsets(entrySize int, offsets ...uint64) []byte {
+	body := make([]byte, 8+len(offsets)*entrySize)
+	binary.BigEndian.PutUint32(body[4:8], uint32(len(offsets)))
+	for i, offset := range offsets {
+		if entrySize == 8 {
+			binary.BigEndian.PutUint64(body[8+i*8:], offset)
+		} else {
+			binary.BigEndian.PutUint32(body[8+i*4:], uint32(offset))
+		}
+	}
+	return body
+}
+
+// testMoov builds a moov box with one track whose chunk offsets are in a table of tableType
+func testMoov(tableType string, offsets ...uint64) []byte {
+	entrySize := 4
+	if tableType == "co64" {
+		entrySize = 8
+	}
+	table := testBox(tableType, testChunkOffsets(entrySize, offsets...))
+	stbl := testBox("stbl", testBox("stsd", make([]byte, 8)), table)
+	trak := testBox("trak", testBox("tkhd", make([]byte, 12)), testBox("mdia", testBox("minf", stbl)))
+	return testBox("moov", testBox("mvhd", make([]byte, 20)), trak)
+}
+
+// chunkOffsetTable returns the type and entries of the chunk offset table of a moov box
+// built by testMoov
+func chunkOffsetTable(t *testing.T, moov []byte) (string, []uint64) {
+	t.Helper()
+
+	data := moov
+	for _, want := range []string{"moov", "trak", "mdia", "minf", "stbl"} {
+		var boxType string
+		var body []byte
+		var err error
+		for {
+			if boxType, body, data, err = splitBox(data); err != nil {
+				t.Fatalf("finding %s: %v", want, err)
+			}
+			if boxType == want {
+				break
+			}
+		}
+		data = body
+	}
+
+	for len(data) > 0 {
+		boxType, body, rest, err := splitBox(data)
+		if err != nil {
+			t.Fatalf("reading stbl: %v", err)
+		}
+		data = rest
+		if boxType != "stco" && boxType != "co64" {
+			continue
+		}
+
+		entrySize := 4
+		if boxType == "co64" {
+			entrySize = 8
+		}
+		count := int(binary.BigEndian.Uint32(body[4:8]))
+		if len(body) != 8+count*entrySize {
+			t.Fatalf("%s table of %d entries is %d bytes", boxType, count, len(body))
+		}
+		offsets := make([]uint64, count)
+		for i := range offsets {
+			if entrySize == 8 {
+				offsets[i] = binary.BigEndian.Uint64(body[8+i*8:])
+			} else {
+				offsets[i] = uint64(binary.BigEndian.Uint32(body[8+i*4:]))
+			}
+		}
+		return boxType, offsets
+	}
+
+	t.Fatal("no chunk offset table")
+	return "", nil
+}
+
+func TestRelocateMoov(t *testing.T) {
+	const insertAt = 32
+
+	tests := []struct {
+		name       string
+		tableType  string
+		moovOffset int64
+		offsets    []uint64
+		wantType   string
+		wantGrowth int    // bytes the relocated moov grows by
+		wantShift  []bool // whether each offset moves by the relocated moov size
+	}{
+		{
+			name:       "stco offsets in media data",
+			tableType:  "stco",
+			moovOffset: 4096,
+			offsets:    []uint64{insertAt + 8, 1000, 4095},
+			wantType:   "stco",
+			wantShift:  []bool{true, true, true},
+		},
+		{
+			name:       "offsets before the insertion point",
+			tableType:  "stco",
+			moovOffset: 4096,
+			offsets:    []uint64{0, insertAt - 1, insertAt},
+			wantType:   "stco",
+			wantShift:  []bool{false, false, true},
+		},
+		{
+			name:       "stco upgraded to co64 on overflow",
+			tableType:  "stco",
+			moovOffset: 1 << 33,
+			offsets:    []uint64{insertAt + 8, math.MaxUint32 - 16},
+			wantType:   "co64",
+			wantGrowth: 2 * 4,
+			wantShift:  []bool{true, true},
+		},
+		{
+			name:       "co64 offsets",
+			tableType:  "co64",
+			moovOffset: 1 << 33,
+			offsets:    []uint64{insertAt + 8, 1 << 32, 1<<33 - 1},
+			wantType:   "co64",
+			wantShift:  []bool{true, true, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			moov := testMoov(tt.tableType, tt.offsets...)
+
+			relocated, err := relocateMoov(moov, insertAt, tt.moovOffset)
+			if err != nil {
+				t.Fatalf("relocateMoov: %v", err)
+			}
+			if growth := len(relocated) - len(moov); growth != tt.wantGrowth {
+				t.Errorf("moov grew by %d bytes, want %d", growth, tt.wantGrowth)
+			}
+
+			tableType, offsets := chunkOffsetTable(t, relocated)
+			if tableType != tt.wantType {
+				t.Errorf("chunk offset table is %s, want %s", tableType, tt.wantType)
+			}
+			if len(offsets) != len(tt.offsets) {
+				t.Fatalf("%d chunk offsets, want %d", len(offsets), len(tt.offsets))
+			}
+			for i, offset := range tt.offsets {
+				want := offset
+				if tt.wantShift[i] {
+					want += uint64(len(relocated))
+				}
+				if offsets[i] != want {
+					t.Errorf("offset %d = %d, want %d", i, offsets[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRelocateMoovKeepsOffsetsAfterMoov(t *testing.T) {
+	// Media data after moov only moves by the growth of the moov box, which an stco table
+	// that still fits in 32 bits does not have
+	const insertAt, moovOffset = 32, 4096
+	moovEnd := uint64(moovOffset + len(testMoov("stco", 0, 0, 0)))
+	original := []uint64{100, moovEnd + 100, math.MaxUint32 - 4}
+
+	relocated, err := relocateMoov(testMoov("stco", original...), insertAt, moovOffset)
+	if err != nil {
+		t.Fatalf("relocateMoov: %v", err)
+	}
+
+	tableType, offsets := chunkOffsetTable(t, relocated)
+	if tableType != "stco" {
+		t.Fatalf("chunk offset table is %s, want stco", tableType)
+	}
+	want := []uint64{original[0] + uint64(len(relocated)), original[1], original[2]}
+	for i := range want {
+		if offsets[i] != want[i] {
+			t.Errorf("offset %d = %d, want %d", i, offsets[i], want[i])
+		}
+	}
+}
+
+func TestFaststartLayout(t *testing.T) {
+	ftyp := testBox("ftyp", []byte("isom\x00\x00\x02\x00isomiso2"))
+	payload := make([]byte, 3000)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	mdat := testBox("mdat", payload)
+	mdatOffset := uint64(len(ftyp))
+	chunks := []uint64{mdatOffset + 8, mdatOffset + 8 + 1000, mdatOffset + 8 + 2500}
+
+	tests := []struct {
+		name          string
+		boxes         [][]byte
+		wantRelocated bool
+	}{
+		{"moov at the end", [][]byte{ftyp, mdat, testMoov("stco", chunks...)}, true},
+		{"moov in front", [][]byte{ftyp, testMoov("stco", chunks...), mdat}, false},
+		{"fragmented", [][]byte{ftyp, mdat, testMoov("stco", chunks...), testBox("moof", make([]byte, 8))}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := bytes.Join(tt.boxes, nil)
+			fullPath := filepath.Join(t.TempDir(), "video.mp4")
+			if err := os.WriteFile(fullPath, original, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			fs := NewFaststartService(nil)
+			layout, err := fs.GetLayout(fullPath)
+			if err != nil {
+				t.Fatalf("GetLayout: %v", err)
+			}
+			if layout.Relocated != tt.wantRelocated {
+				t.Fatalf("Relocated = %v, want %v", layout.Relocated, tt.wantRelocated)
+			}
+			if !layout.Relocated {
+				return
+			}
+
+			reader, err := fs.Open(layout)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer reader.Close()
+			served, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("reading the faststart layout: %v", err)
+			}
+
+			// stco tables keep their size, so the served file is as large as the original
+			if int64(len(served)) != reader.Size() || reader.Size() != int64(len(original)) {
+				t.Fatalf("served %d bytes, Size %d, want %d", len(served), reader.Size(), len(original))
+			}
+
+			// ftyp, then the relocated moov, then the media data
+			if !bytes.Equal(served[:len(ftyp)], ftyp) {
+				t.Errorf("ftyp is not first")
+			}
+			boxType, _, rest, err := splitBox(served[len(ftyp):])
+			if err != nil || boxType != "moov" {
+				t.Fatalf("box after ftyp = %q, %v, want moov", boxType, err)
+			}
+			moov := served[len(ftyp) : len(served)-len(rest)]
+			if !bytes.Equal(rest, mdat) {
+				t.Errorf("media data does not follow moov unchanged")
+			}
+
+			// Every shifted chunk offset still points at the same media data
+			_, offsets := chunkOffsetTable(t, moov)
+			for i, offset := range offsets {
+				if want := chunks[i] + uint64(len(moov)); offset != want {
+					t.Errorf("chunk %d offset = %d, want %d", i, offset, want)
+					continue
+				}
+				if !bytes.Equal(served[offset:offset+16], original[chunks[i]:chunks[i]+16]) {
+					t.Errorf("chunk %d offset does not point at its media data", i)
+				}
+			}
+		})
+	}
+}
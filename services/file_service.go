@@ -6,11 +6,16 @@ import (
 	"media-server/models"
 	"media-server/utils"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 )
 
+// maxArchiveEntries caps the number of files a single archive may contain
+const maxArchiveEntries = 10000
+
 // FileService handles file operations and business logic with caching and parallel processing
 type FileService struct {
 	baseDir            string
@@ -65,12 +70,15 @@ func NewFileServiceWithMediaFolders(baseDir string, cacheService *CacheService,
 
 // ListDirectory lists the contents of a directory with caching and parallel processing
 func (fs *FileService) ListDirectory(requestPath string) ([]*models.FileInfo, error) {
-	// Sanitize the path
-	cleanPath := utils.SanitizePath(requestPath)
+	// Sanitize and resolve the path
+	cleanPath, fullPath, err := fs.resolvePath(requestPath)
+	if err != nil {
+		return nil, err
+	}
 
-	// Validate the path
-	if !utils.IsValidPath(cleanPath, fs.baseDir) {
-		return nil, fmt.Errorf("access denied: invalid path")
+	// The root lists the media folders
+	if cleanPath == "." && fs.mediaFolderService != nil {
+		return fs.listMediaFolders(), nil
 	}
 
 	// Check cache first if available
@@ -80,9 +88,6 @@ func (fs *FileService) ListDirectory(requestPath string) ([]*models.FileInfo, er
 		}
 	}
 
-	// Build full path
-	fullPath := filepath.Join(fs.baseDir, cleanPath)
-
 	// Check if path exists and is a directory
 	fileInfo, err := os.Stat(fullPath)
 	if err != nil {
@@ -110,6 +115,12 @@ func (fs *FileService) ListDirectory(requestPath string) ([]*models.FileInfo, er
 		files = fs.processFilesSequential(entries, cleanPath)
 	}
 
+	// Link sidecar subtitles to their videos
+	attachSubtitles(files)
+
+	// Read the tags of audio files and the container metadata of videos
+	fs.attachMediaMetadata(files, fullPath)
+
 	// Sort files: directories first, then by name
 	sort.Slice(files, func(i, j int) bool {
 		if files[i].IsDir != files[j].IsDir {
@@ -216,12 +227,10 @@ func (fs *FileService) processFilesParallel(entries []os.DirEntry, cleanPath str
 
 // GetFileInfo gets information about a specific file or directory with caching
 func (fs *FileService) GetFileInfo(requestPath string) (*models.FileInfo, error) {
-	// Sanitize the path
-	cleanPath := utils.SanitizePath(requestPath)
-
-	// Validate the path
-	if !utils.IsValidPath(cleanPath, fs.baseDir) {
-		return nil, fmt.Errorf("access denied: invalid path")
+	// Sanitize and resolve the path
+	cleanPath, fullPath, err := fs.resolvePath(requestPath)
+	if err != nil {
+		return nil, err
 	}
 
 	// Check cache first if available
@@ -231,9 +240,6 @@ func (fs *FileService) GetFileInfo(requestPath string) (*models.FileInfo, error)
 		}
 	}
 
-	// Build full path
-	fullPath := filepath.Join(fs.baseDir, cleanPath)
-
 	// Get file info
 	fileInfo, err := os.Stat(fullPath)
 	if err != nil {
@@ -256,6 +262,14 @@ func (fs *FileService) GetFileInfo(requestPath string) (*models.FileInfo, error)
 		IsMedia:   isMedia,
 	}
 
+	// Find sidecar subtitles of videos
+	if result.GetMediaType() == "video" {
+		fs.findSubtitles(result, filepath.Dir(fullPath))
+	}
+
+	// Read the tags of audio files and the container metadata of videos
+	fs.attachMediaMetadata([]*models.FileInfo{result}, filepath.Dir(fullPath))
+
 	// Cache the result if caching is available
 	if fs.cacheService != nil {
 		fs.cacheService.SetFileInfo(cleanPath, result)
@@ -264,38 +278,224 @@ func (fs *FileService) GetFileInfo(requestPath string) (*models.FileInfo, error)
 	return result, nil
 }
 
-// ResolveMediaPath resolves a media path using the media folder service
+// findSubtitles fills in the sidecar subtitles of a video from the directory containing it
+func (fs *FileService) findSubtitles(video *models.FileInfo, dirPath string) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		log.Printf("Error reading directory %s for subtitles: %v", dirPath, err)
+		return
+	}
+
+	parentPath := filepath.Dir(video.Path)
+	files := []*models.FileInfo{video}
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == video.Name {
+			continue
+		}
+		extension := filepath.Ext(entry.Name())
+		files = append(files, &models.FileInfo{
+			Name:      entry.Name(),
+			Path:      filepath.Join(parentPath, entry.Name()),
+			Extension: extension,
+			IsMedia:   models.IsMediaFile(extension),
+		})
+	}
+
+	attachSubtitles(files)
+}
+
+// attachSubtitles links the subtitle files of a directory listing to the videos they belong to.
+// A subtitle belongs to the video with the longest matching basename, so "Movie.Part2.en.srt"
+// goes to "Movie.Part2.mkv" rather than "Movie.mkv".
+func attachSubtitles(files []*models.FileInfo) {
+	var videos []*models.FileInfo
+	for _, file := range files {
+		if !file.IsDir && file.GetMediaType() == "video" {
+			file.Subtitles = nil
+			videos = append(videos, file)
+		}
+	}
+	if len(videos) == 0 {
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir || !models.IsSubtitleFile(file.Extension) {
+			continue
+		}
+
+		var best *models.FileInfo
+		var bestTrack *models.SubtitleTrack
+		for _, video := range videos {
+			track, ok := models.ParseSubtitleTrack(video.Name, file.Name, file.Path)
+			if ok && (best == nil || len(video.Name)-len(video.Extension) > len(best.Name)-len(best.Extension)) {
+				best, bestTrack = video, track
+			}
+		}
+		if best != nil {
+			best.Subtitles = append(best.Subtitles, *bestTrack)
+		}
+	}
+
+	for _, video := range videos {
+		sort.Slice(video.Subtitles, func(i, j int) bool {
+			return video.Subtitles[i].Name < video.Subtitles[j].Name
+		})
+	}
+}
+
+// GetAudioTags returns the tags of an audio file, read from the file when they are not cached
+// or the file changed since they were read
+func (fs *FileService) GetAudioTags(requestPath string) (*models.AudioTags, error) {
+	fullPath, err := fs.ValidateFilePath(requestPath)
+	if err != nil {
+		return nil, err
+	}
+	if !models.IsTaggedAudio(fullPath) {
+		return nil, fmt.Errorf("unsupported audio format")
+	}
+
+	tags := cachedAudioTags(fs.cacheService, fullPath)
+	if tags == nil {
+		return nil, fmt.Errorf("error reading audio tags")
+	}
+	return tags, nil
+}
+
+// attachMediaMetadata reads the tags of the audio files and the container metadata of the
+// videos in a directory listing
+func (fs *FileService) attachMediaMetadata(files []*models.FileInfo, dirPath string) {
+	for _, file := range files {
+		if file.IsDir {
+			continue
+		}
+		switch {
+		case models.IsTaggedAudio(file.Name):
+			file.Tags = cachedAudioTags(fs.cacheService, filepath.Join(dirPath, file.Name))
+		case models.IsProbeableVideo(file.Name):
+			file.Video = cachedVideoInfo(fs.cacheService, filepath.Join(dirPath, file.Name))
+		}
+	}
+}
+
+// AttachMediaMetadata reads the tags of the audio files and the container metadata of the videos
+// among files gathered from anywhere in the media folders, such as the entries of the media index
+func (fs *FileService) AttachMediaMetadata(files []*models.FileInfo) {
+	for _, file := range files {
+		if !models.IsTaggedAudio(file.Name) && !models.IsProbeableVideo(file.Name) {
+			continue
+		}
+		_, fullPath, err := fs.resolvePath(file.Path)
+		if err != nil {
+			continue
+		}
+
+		if models.IsTaggedAudio(file.Name) {
+			file.Tags = cachedAudioTags(fs.cacheService, fullPath)
+		} else {
+			file.Video = cachedVideoInfo(fs.cacheService, fullPath)
+		}
+	}
+}
+
+// ResolveMediaPath resolves a media path to the file system path it addresses, without checking
+// that the file exists
 func (fs *FileService) ResolveMediaPath(requestPath string) (string, error) {
-	// Sanitize the path
-	cleanPath := utils.SanitizePath(requestPath)
+	_, fullPath, err := fs.resolvePath(requestPath)
+	return fullPath, err
+}
+
+// CanonicalPath returns the single media path of the file or directory a request path resolves
+// to: f/<folder ID>/<path> in the most specific active media folder containing it, or "." for
+// the root. Files of nested media folders can be requested through each of them.
+func (fs *FileService) CanonicalPath(requestPath string) (string, error) {
+	cleanPath, fullPath, err := fs.resolvePath(requestPath)
+	if err != nil || cleanPath == "." || fs.mediaFolderService == nil {
+		return filepath.ToSlash(cleanPath), err
+	}
 
-	// If media folder service is available, use it to resolve the path
-	if fs.mediaFolderService != nil {
-		fullPath, _, err := fs.mediaFolderService.ResolvePath(cleanPath)
-		return fullPath, err
+	if folder := fs.mediaFolderService.FolderForPath(fullPath); folder != nil {
+		if folderPath, err := folder.GetAbsolutePath(); err == nil {
+			if rel, err := filepath.Rel(folderPath, fullPath); err == nil {
+				return models.FolderMediaPath(folder.ID, rel), nil
+			}
+		}
 	}
+	return filepath.ToSlash(cleanPath), nil
+}
 
-	// Fallback to base directory
-	if !utils.IsValidPath(cleanPath, fs.baseDir) {
-		return "", fmt.Errorf("access denied: invalid path")
+// MediaFolderFor returns the media folder a media path of the form f/<folder ID>/<path> is in,
+// and the path within the folder, or nil for other paths
+func (fs *FileService) MediaFolderFor(mediaPath string) (*models.MediaFolder, string) {
+	if fs.mediaFolderService == nil {
+		return nil, ""
 	}
 
-	fullPath := filepath.Join(fs.baseDir, cleanPath)
-	return fullPath, nil
+	folderID, rel, found := models.SplitFolderMediaPath(filepath.ToSlash(mediaPath))
+	if !found {
+		return nil, ""
+	}
+	folder, err := fs.mediaFolderService.GetFolder(folderID)
+	if err != nil {
+		return nil, ""
+	}
+	return folder, rel
 }
 
-// ValidateFilePath validates that a file path is safe and accessible
-func (fs *FileService) ValidateFilePath(requestPath string) (string, error) {
-	// Sanitize the path
+// resolvePath sanitizes a request path and returns its canonical media path, under which it is
+// listed and cached, and its file system path. Paths of the form f/<folder ID>/<path> address
+// an active media folder. Other paths are relative to the base directory and, when the base
+// directory is a media folder, are addressed through that folder, so that enabling another
+// folder never changes what they resolve to. "." is the root, which lists the media folders.
+func (fs *FileService) resolvePath(requestPath string) (string, string, error) {
 	cleanPath := utils.SanitizePath(requestPath)
+	root, rel := fs.baseDir, cleanPath
+
+	if folder, folderRel := fs.MediaFolderFor(cleanPath); folder != nil {
+		if !folder.IsActive {
+			return "", "", fmt.Errorf("path not found")
+		}
+		root, rel = folder.Path, filepath.FromSlash(folderRel)
+	} else if cleanPath != "." && fs.mediaFolderService != nil {
+		if folder := fs.mediaFolderService.FolderByPath(fs.baseDir); folder != nil {
+			if !folder.IsActive {
+				return "", "", fmt.Errorf("path not found")
+			}
+			cleanPath = filepath.FromSlash(models.FolderMediaPath(folder.ID, cleanPath))
+		}
+	}
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil || !utils.IsValidPath(rel, absRoot) {
+		return "", "", fmt.Errorf("access denied: invalid path")
+	}
+
+	return cleanPath, filepath.Join(absRoot, rel), nil
+}
 
-	// Validate the path
-	if !utils.IsValidPath(cleanPath, fs.baseDir) {
-		return "", fmt.Errorf("access denied: invalid path")
+// listMediaFolders lists the active media folders as the directories of the root
+func (fs *FileService) listMediaFolders() []*models.FileInfo {
+	folders := fs.mediaFolderService.GetActiveFolders()
+	models.SortMediaFolders(folders)
+
+	files := make([]*models.FileInfo, 0, len(folders))
+	for _, folder := range folders {
+		files = append(files, &models.FileInfo{
+			Name:  folder.Name,
+			IsDir: true,
+			Path:  filepath.FromSlash(models.FolderMediaPath(folder.ID, "")),
+		})
 	}
+	return files
+}
 
-	// Build full path
-	fullPath := filepath.Join(fs.baseDir, cleanPath)
+// ValidateFilePath validates that a file path is safe and accessible
+func (fs *FileService) ValidateFilePath(requestPath string) (string, error) {
+	// Sanitize and resolve the path
+	_, fullPath, err := fs.resolvePath(requestPath)
+	if err != nil {
+		return "", err
+	}
 
 	// Check if file exists
 	if _, err := os.Stat(fullPath); err != nil {
@@ -307,3 +507,135 @@ func (fs *FileService) ValidateFilePath(requestPath string) (string, error) {
 
 	return fullPath, nil
 }
+
+// CollectArchiveEntries walks the requested files and directories and returns the regular files
+// to include in an archive, in a stable order. Hidden files and directories are skipped, and
+// archive names are relative to the common parent of the requested paths.
+func (fs *FileService) CollectArchiveEntries(requestPaths []string) ([]*models.ArchiveEntry, error) {
+	if len(requestPaths) == 0 {
+		return nil, models.NewValidationError("paths", "at least one path is required")
+	}
+
+	cleanPaths := make([]string, 0, len(requestPaths))
+	fullPaths := make(map[string]string)
+	for _, requestPath := range requestPaths {
+		cleanPath, fullPath, err := fs.resolvePath(requestPath)
+		if err != nil {
+			return nil, err
+		}
+		cleanPath = filepath.ToSlash(cleanPath)
+		if isHiddenPath(cleanPath) {
+			return nil, fmt.Errorf("access denied: hidden path")
+		}
+		if _, seen := fullPaths[cleanPath]; !seen {
+			fullPaths[cleanPath] = fullPath
+			cleanPaths = append(cleanPaths, cleanPath)
+		}
+	}
+	sort.Strings(cleanPaths)
+
+	root := commonParent(cleanPaths)
+	entries := make([]*models.ArchiveEntry, 0)
+	included := make(map[string]bool)
+
+	for _, cleanPath := range cleanPaths {
+		fullPath := fullPaths[cleanPath]
+		if _, err := os.Stat(fullPath); err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("path not found: %s", cleanPath)
+			}
+			return nil, fmt.Errorf("error accessing path: %w", err)
+		}
+
+		// WalkDir visits entries in lexical order and does not follow symlinks
+		err := filepath.WalkDir(fullPath, func(walkPath string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if walkPath != fullPath && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(fullPath, walkPath)
+			if err != nil {
+				return err
+			}
+			mediaPath := path.Join(cleanPath, filepath.ToSlash(rel))
+			if included[mediaPath] {
+				// Already added through an overlapping selection
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			if len(entries) >= maxArchiveEntries {
+				return fmt.Errorf("archive exceeds the maximum of %d files", maxArchiveEntries)
+			}
+
+			name := strings.TrimPrefix(mediaPath, root)
+			name = strings.TrimPrefix(name, "/")
+			if root == strings.TrimSuffix(models.FolderPathPrefix, "/") {
+				// Name the media folders at the top of the archive rather than their IDs
+				if folder, rel := fs.MediaFolderFor(mediaPath); folder != nil {
+					name = path.Join(folder.Name, rel)
+				}
+			}
+			included[mediaPath] = true
+			entries = append(entries, &models.ArchiveEntry{
+				Name:     name,
+				Path:     mediaPath,
+				FullPath: walkPath,
+				Size:     info.Size(),
+				ModTime:  info.ModTime(),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error collecting archive entries: %w", err)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	return entries, nil
+}
+
+// isHiddenPath reports whether any component of a slash-separated path is hidden
+func isHiddenPath(path string) bool {
+	for _, part := range strings.Split(path, "/") {
+		if strings.HasPrefix(part, ".") && part != "." {
+			return true
+		}
+	}
+	return false
+}
+
+// commonParent returns the deepest directory containing all of the given slash-separated paths
+func commonParent(paths []string) string {
+	parent := pathDir(paths[0])
+	for _, p := range paths[1:] {
+		for parent != "" && !strings.HasPrefix(p, parent+"/") {
+			parent = pathDir(parent)
+		}
+	}
+	return parent
+}
+
+// pathDir returns the parent of a slash-separated path, or "" for top-level paths
+func pathDir(path string) string {
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
@@ -0,0 +1,456 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"media-server/models"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// hlsTargetSegmentDuration is the minimum segment length in seconds; segments are cut at
+	// the first random access point after it
+	hlsTargetSegmentDuration = 6
+	// ptsClockRate is the frequency of MPEG PTS/DTS timestamps
+	ptsClockRate = 90000
+	// ptsWrap is the modulus of the 33-bit PTS counter
+	ptsWrap = int64(1) << 33
+	// tsSyncByte starts every transport stream packet
+	tsSyncByte = 0x47
+	// hlsScanBufferSize is the read buffer used while scanning a transport stream
+	hlsScanBufferSize = 1 << 20
+	// hlsIndexQueueSize is the number of scans queued on the indexing worker pool at once
+	hlsIndexQueueSize = 64
+)
+
+// HLS packaging errors
+var (
+	ErrNotTransportStream   = errors.New("file is not an MPEG transport stream")
+	ErrNoRandomAccessPoints = errors.New("no random access points found in transport stream")
+	ErrHLSBusy              = errors.New("HLS indexing is busy")
+)
+
+// HLSService packages MPEG transport streams as HLS without transcoding by indexing the
+// random access points of the source file
+type HLSService struct {
+	cacheService *CacheService
+	workerPool   *WorkerPool
+	scans        map[string]*hlsScan
+	mutex        sync.Mutex
+}
+
+// hlsScan lets concurrent requests for the same file wait for a single scan
+type hlsScan struct {
+	done  chan struct{}
+	index *models.HLSIndex
+	err   error
+}
+
+// NewHLSService creates a new HLSService; segment indexes are cached in cacheService when set.
+// Files are scanned on a worker pool of the performance service, sized to half the CPU cores so
+// indexing large files cannot starve streaming.
+func NewHLSService(cacheService *CacheService, performanceService *PerformanceService) *HLSService {
+	workers := performanceService.GetCPUOptimalWorkerCount() / 2
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &HLSService{
+		cacheService: cacheService,
+		workerPool:   performanceService.GetOrCreateWorkerPool("hls-indexing", workers, hlsIndexQueueSize),
+		scans:        make(map[string]*hlsScan),
+	}
+}
+
+// GetIndex returns the segment index of a transport stream, scanning the file when there is
+// no cached index for its current size and modification time. Concurrent requests for the same
+// file wait for a single scan; ErrHLSBusy is returned when the worker pool is full.
+func (hs *HLSService) GetIndex(fullPath string) (*models.HLSIndex, error) {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("error accessing file: %w", err)
+	}
+
+	if hs.cacheService != nil {
+		if index, found := hs.cacheService.GetHLSIndex(fullPath); found && index.IsValidFor(info.Size(), info.ModTime()) {
+			return index, nil
+		}
+	}
+
+	hs.mutex.Lock()
+	if scan, running := hs.scans[fullPath]; running {
+		hs.mutex.Unlock()
+		<-scan.done
+		return scan.index, scan.err
+	}
+	scan := &hlsScan{done: make(chan struct{})}
+	hs.scans[fullPath] = scan
+	hs.mutex.Unlock()
+
+	startTime := time.Now()
+	var index *models.HLSIndex
+	scan.err = hs.workerPool.SubmitAndWait("hls-index:"+fullPath, func() error {
+		var err error
+		index, err = scanTransportStream(fullPath, info)
+		return err
+	})
+	if scan.err == ErrWorkerPoolFull {
+		scan.err = ErrHLSBusy
+	}
+	if scan.err == nil {
+		scan.index = index
+		log.Printf("Indexed %s for HLS: %d segments, %.1fs in %v",
+			fullPath, len(scan.index.Segments), scan.index.TotalDuration(), time.Since(startTime))
+		if hs.cacheService != nil {
+			hs.cacheService.SetHLSIndex(fullPath, scan.index)
+		}
+	} else {
+		log.Printf("Error indexing %s for HLS: %v", fullPath, scan.err)
+	}
+
+	hs.mutex.Lock()
+	delete(hs.scans, fullPath)
+	hs.mutex.Unlock()
+	close(scan.done)
+
+	return scan.index, scan.err
+}
+
+// tsKeyframe is a random access point of the indexed elementary stream
+type tsKeyframe struct {
+	offset int64 // offset of the packet starting the PES
+	pts    int64 // unwrapped presentation timestamp
+}
+
+// tsScanner finds the program tables and random access points of a transport stream
+type tsScanner struct {
+	index     *models.HLSIndex
+	pmtPID    int
+	keyframes []tsKeyframe
+	hasPTS    bool
+	lastPTS   int64
+	maxPTS    int64
+}
+
+// scanTransportStream reads a whole transport stream and builds its segment index
+func scanTransportStream(fullPath string, info os.FileInfo) (*models.HLSIndex, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, hlsScanBufferSize)
+	head, _ := reader.Peek(3*models.M2TSPacketSize + 4)
+	packetSize, prefix, ok := detectPacketSize(head)
+	if !ok {
+		return nil, ErrNotTransportStream
+	}
+
+	scanner := &tsScanner{
+		index: &models.HLSIndex{
+			Path:       fullPath,
+			Size:       info.Size(),
+			ModTime:    info.ModTime(),
+			PacketSize: packetSize,
+			PATOffset:  -1,
+			PMTOffset:  -1,
+			StreamPID:  -1,
+		},
+		pmtPID: -1,
+	}
+
+	packet := make([]byte, packetSize)
+	var offset int64
+	for {
+		if _, err := io.ReadFull(reader, packet); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				// A trailing partial packet is left out of the last segment
+				break
+			}
+			return nil, err
+		}
+		if packet[prefix] != tsSyncByte {
+			return nil, fmt.Errorf("lost sync at offset %d: %w", offset, ErrNotTransportStream)
+		}
+
+		scanner.parsePacket(packet[prefix:], offset)
+		offset += int64(packetSize)
+	}
+
+	return scanner.buildIndex(offset)
+}
+
+// detectPacketSize recognises 188-byte TS and 192-byte M2TS packets by their sync bytes
+func detectPacketSize(head []byte) (size, prefix int, ok bool) {
+	candidates := []struct{ size, prefix int }{
+		{models.TSPacketSize, 0},
+		{models.M2TSPacketSize, 4},
+	}
+
+	for _, c := range candidates {
+		ok = true
+		for i := 0; i < 3; i++ {
+			pos := c.prefix + i*c.size
+			if pos >= len(head) || head[pos] != tsSyncByte {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return c.size, c.prefix, true
+		}
+	}
+	return 0, 0, false
+}
+
+// parsePacket inspects one 188-byte TS packet found at offset in the file
+func (s *tsScanner) parsePacket(pkt []byte, offset int64) {
+	pid := int(pkt[1]&0x1f)<<8 | int(pkt[2])
+	payloadUnitStart := pkt[1]&0x40 != 0
+	adaptationControl := (pkt[3] >> 4) & 0x3
+
+	payloadStart := 4
+	randomAccess := false
+	if adaptationControl&0x2 != 0 {
+		adaptationLength := int(pkt[4])
+		if adaptationLength > 0 {
+			randomAccess = pkt[5]&0x40 != 0
+		}
+		payloadStart = 5 + adaptationLength
+	}
+	if adaptationControl&0x1 == 0 || payloadStart >= len(pkt) || !payloadUnitStart {
+		return
+	}
+	payload := pkt[payloadStart:]
+
+	switch pid {
+	case 0:
+		if s.index.PATOffset < 0 {
+			if pmtPID := parsePAT(payload); pmtPID >= 0 {
+				s.pmtPID = pmtPID
+				s.index.PATOffset = offset
+			}
+		}
+	case s.pmtPID:
+		if s.index.PMTOffset < 0 {
+			if streamPID, streamType, ok := parsePMT(payload); ok {
+				s.index.StreamPID = streamPID
+				s.index.StreamType = streamType
+				s.index.PMTOffset = offset
+			}
+		}
+	case s.index.StreamPID:
+		s.parsePES(payload, offset, randomAccess)
+	}
+}
+
+// parsePES records the timestamp of a PES packet and whether it starts at a random access point
+func (s *tsScanner) parsePES(payload []byte, offset int64, randomAccess bool) {
+	if len(payload) < 9 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return
+	}
+
+	headerEnd := 9 + int(payload[8])
+	if payload[7]&0x80 == 0 || len(payload) < 14 || headerEnd > len(payload) {
+		// Without a PTS the packet cannot be placed on the timeline
+		return
+	}
+
+	pts := parsePTS(payload[9:14])
+	if s.hasPTS {
+		pts = unwrapPTS(pts, s.lastPTS)
+	}
+	if !s.hasPTS || pts > s.maxPTS {
+		s.maxPTS = pts
+	}
+	s.hasPTS = true
+	s.lastPTS = pts
+
+	// Every audio frame is a random access point; video relies on the adaptation field flag,
+	// falling back to looking for keyframe start codes in the elementary stream
+	if randomAccess || !isVideoStreamType(s.index.StreamType) || containsKeyframe(s.index.StreamType, payload[headerEnd:]) {
+		s.keyframes = append(s.keyframes, tsKeyframe{offset: offset, pts: pts})
+	}
+}
+
+// buildIndex splits the file at random access points into segments of about the target duration
+func (s *tsScanner) buildIndex(end int64) (*models.HLSIndex, error) {
+	if s.index.PATOffset < 0 || s.index.PMTOffset < 0 || s.index.StreamPID < 0 {
+		return nil, ErrNotTransportStream
+	}
+	if len(s.keyframes) == 0 {
+		return nil, ErrNoRandomAccessPoints
+	}
+
+	target := int64(hlsTargetSegmentDuration * ptsClockRate)
+	segmentOffset := int64(0) // the first segment also carries anything before the first keyframe
+	segmentPTS := s.keyframes[0].pts
+
+	for _, kf := range s.keyframes[1:] {
+		elapsed := kf.pts - segmentPTS
+		if elapsed < 0 {
+			// Timestamp discontinuity, restart timing from this keyframe
+			segmentPTS = kf.pts
+			continue
+		}
+		if elapsed >= target && kf.offset > segmentOffset {
+			s.index.Segments = append(s.index.Segments, models.HLSSegment{
+				Offset:   segmentOffset,
+				Length:   kf.offset - segmentOffset,
+				Duration: float64(elapsed) / ptsClockRate,
+			})
+			segmentOffset = kf.offset
+			segmentPTS = kf.pts
+		}
+	}
+
+	lastDuration := float64(s.maxPTS-segmentPTS) / ptsClockRate
+	if lastDuration <= 0 {
+		lastDuration = 0.1
+	}
+	s.index.Segments = append(s.index.Segments, models.HLSSegment{
+		Offset:   segmentOffset,
+		Length:   end - segmentOffset,
+		Duration: lastDuration,
+	})
+
+	return s.index, nil
+}
+
+// psiSection returns the section carried by a PSI payload and its end, excluding the CRC
+func psiSection(payload []byte, tableID byte) ([]byte, int, bool) {
+	if len(payload) < 1 {
+		return nil, 0, false
+	}
+	pointer := int(payload[0])
+	if 1+pointer+3 > len(payload) {
+		return nil, 0, false
+	}
+
+	section := payload[1+pointer:]
+	if section[0] != tableID {
+		return nil, 0, false
+	}
+
+	sectionLength := int(section[1]&0x0f)<<8 | int(section[2])
+	end := 3 + sectionLength - 4
+	if end > len(section) {
+		end = len(section)
+	}
+	return section, end, true
+}
+
+// parsePAT returns the PMT PID of the first program in a PAT, or -1
+func parsePAT(payload []byte) int {
+	section, end, ok := psiSection(payload, 0x00)
+	if !ok {
+		return -1
+	}
+
+	for i := 8; i+4 <= end; i += 4 {
+		program := int(section[i])<<8 | int(section[i+1])
+		if program != 0 {
+			return int(section[i+2]&0x1f)<<8 | int(section[i+3])
+		}
+	}
+	return -1
+}
+
+// parsePMT returns the PID and type of the stream to index: the first video stream, or the
+// first audio stream when the program has no video
+func parsePMT(payload []byte) (pid int, streamType byte, ok bool) {
+	section, end, found := psiSection(payload, 0x02)
+	if !found || end < 12 {
+		return 0, 0, false
+	}
+
+	programInfoLength := int(section[10]&0x0f)<<8 | int(section[11])
+	for i := 12 + programInfoLength; i+5 <= end; {
+		esType := section[i]
+		esPID := int(section[i+1]&0x1f)<<8 | int(section[i+2])
+		esInfoLength := int(section[i+3]&0x0f)<<8 | int(section[i+4])
+		i += 5 + esInfoLength
+
+		if isVideoStreamType(esType) {
+			return esPID, esType, true
+		}
+		if isAudioStreamType(esType) && !ok {
+			pid, streamType, ok = esPID, esType, true
+		}
+	}
+	return pid, streamType, ok
+}
+
+// isVideoStreamType reports whether a PMT stream type is a video codec
+func isVideoStreamType(streamType byte) bool {
+	switch streamType {
+	case 0x01, 0x02, 0x10, 0x1b, 0x24, 0xea:
+		return true
+	}
+	return false
+}
+
+// isAudioStreamType reports whether a PMT stream type is an audio codec
+func isAudioStreamType(streamType byte) bool {
+	switch streamType {
+	case 0x03, 0x04, 0x0f, 0x11, 0x81, 0x87:
+		return true
+	}
+	return false
+}
+
+// containsKeyframe looks for the start of an intra-coded picture in the first bytes of a PES payload
+func containsKeyframe(streamType byte, es []byte) bool {
+	for i := 0; i+3 < len(es); i++ {
+		if es[i] != 0 || es[i+1] != 0 || es[i+2] != 1 {
+			continue
+		}
+		code := es[i+3]
+
+		switch streamType {
+		case 0x1b: // H.264: IDR slice or sequence parameter set
+			if nalType := code & 0x1f; nalType == 5 || nalType == 7 {
+				return true
+			}
+		case 0x24: // HEVC: IRAP slice or VPS/SPS
+			if nalType := (code >> 1) & 0x3f; (nalType >= 16 && nalType <= 21) || nalType == 32 || nalType == 33 {
+				return true
+			}
+		case 0x01, 0x02: // MPEG-1/2 video: sequence header or I picture
+			if code == 0xb3 {
+				return true
+			}
+			if code == 0x00 && i+5 < len(es) && (es[i+5]>>3)&0x07 == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parsePTS decodes a 33-bit timestamp from its 5-byte PES encoding
+func parsePTS(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 |
+		int64(b[1])<<22 |
+		int64(b[2]>>1)<<15 |
+		int64(b[3])<<7 |
+		int64(b[4]>>1)
+}
+
+// unwrapPTS places a 33-bit timestamp on a continuous timeline next to reference
+func unwrapPTS(pts, reference int64) int64 {
+	pts += reference - reference%ptsWrap
+	for pts-reference > ptsWrap/2 {
+		pts -= ptsWrap
+	}
+	for reference-pts > ptsWrap/2 {
+		pts += ptsWrap
+	}
+	return pts
+}
@@ -1,13 +1,13 @@
 package services
 
 import (
-	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"log"
 	"media-server/models"
-	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -18,6 +18,8 @@ type MediaFolderService struct {
 	defaultFolder string
 	mutex        sync.RWMutex
 	scanMutex    sync.Mutex
+	cacheService *CacheService
+	mediaIndex   *MediaIndexService
 }
 
 // NewMediaFolderService creates a new MediaFolderService
@@ -29,7 +31,7 @@ func NewMediaFolderService(defaultPath string) *MediaFolderService {
 	// Add default folder if provided
 	if defaultPath != "" {
 		defaultFolder := &models.MediaFolder{
-			ID:          service.generateID(),
+			ID:          folderID(defaultPath),
 			Name:        "Default Media Folder",
 			Path:        defaultPath,
 			Description: "Default media folder configured at startup",
@@ -48,6 +50,16 @@ func NewMediaFolderService(defaultPath string) *MediaFolderService {
 	return service
 }
 
+// SetCacheService sets the cache service used for video metadata during scans
+func (mfs *MediaFolderService) SetCacheService(cs *CacheService) {
+	mfs.cacheService = cs
+}
+
+// SetMediaIndex sets the media index updated by folder scans
+func (mfs *MediaFolderService) SetMediaIndex(mis *MediaIndexService) {
+	mfs.mediaIndex = mis
+}
+
 // AddFolder adds a new media folder
 func (mfs *MediaFolderService) AddFolder(req *models.MediaFolderRequest, addedBy string) (*models.MediaFolder, error) {
 	// Validate the request
@@ -73,7 +85,7 @@ func (mfs *MediaFolderService) AddFolder(req *models.MediaFolderRequest, addedBy
 
 	// Create new media folder
 	folder := &models.MediaFolder{
-		ID:          mfs.generateID(),
+		ID:          folderID(absPath),
 		Name:        req.Name,
 		Path:        req.Path,
 		Description: req.Description,
@@ -120,6 +132,11 @@ func (mfs *MediaFolderService) RemoveFolder(folderID string) error {
 
 	// Remove folder
 	delete(mfs.folders, folderID)
+	if mfs.mediaIndex != nil {
+		if err := mfs.mediaIndex.RemoveFolder(folder.Path); err != nil {
+			log.Printf("Error removing folder '%s' from media index: %v", folder.Name, err)
+		}
+	}
 
 	// If this was the default folder, set another as default
 	if folder.IsDefault && len(mfs.folders) > 0 {
@@ -240,7 +257,9 @@ func (mfs *MediaFolderService) ScanFolder(folderID string) (*models.MediaFolderS
 		return nil, fmt.Errorf("folder is not accessible: %s", folder.Path)
 	}
 
-	stats, err := folder.ScanFolder()
+	stats, err := folder.ScanFolder(func(fullPath string) *models.VideoInfo {
+		return cachedVideoInfo(mfs.cacheService, fullPath)
+	})
 	if err != nil {
 		log.Printf("Error scanning folder '%s': %v", folder.Name, err)
 		return stats, err
@@ -257,12 +276,36 @@ func (mfs *MediaFolderService) ScanFolder(folderID string) (*models.MediaFolderS
 	for mediaType := range stats.MediaTypes {
 		folder.MediaTypes = append(folder.MediaTypes, mediaType)
 	}
+	folder.VideoCodecs = stats.VideoCodecs
+	folder.Resolutions = stats.Resolutions
 	mfs.mutex.Unlock()
 
+	if mfs.mediaIndex != nil {
+		if err := mfs.mediaIndex.IndexFolder(folder.Path, stats.Files); err != nil {
+			log.Printf("Error indexing folder '%s': %v", folder.Name, err)
+		}
+	}
+
 	log.Printf("Scanned folder '%s': %d files, %s", folder.Name, stats.TotalFiles, formatBytes(stats.TotalSize))
 	return stats, nil
 }
 
+// UpdateFolderStats adjusts the statistics of a folder for files added, changed or removed since
+// its last scan, with the media types now present in it
+func (mfs *MediaFolderService) UpdateFolderStats(folderID string, fileDelta int, sizeDelta int64, mediaTypes []string) {
+	mfs.mutex.Lock()
+	defer mfs.mutex.Unlock()
+
+	folder, exists := mfs.folders[folderID]
+	if !exists {
+		return
+	}
+
+	folder.FileCount += fileDelta
+	folder.TotalSize += sizeDelta
+	folder.MediaTypes = mediaTypes
+}
+
 // scanFolderAsync scans a folder asynchronously
 func (mfs *MediaFolderService) scanFolderAsync(folderID string) {
 	_, err := mfs.ScanFolder(folderID)
@@ -288,33 +331,58 @@ func (mfs *MediaFolderService) ScanAllFolders() map[string]*models.MediaFolderSt
 	return results
 }
 
-// ResolvePath resolves a media path to the actual file system path
-func (mfs *MediaFolderService) ResolvePath(mediaPath string) (string, *models.MediaFolder, error) {
-	// Try to find the file in any active folder
-	folders := mfs.GetActiveFolders()
+// FolderByPath returns the media folder, active or not, located at the given directory, if any
+func (mfs *MediaFolderService) FolderByPath(dirPath string) *models.MediaFolder {
+	absPath, err := filepath.Abs(dirPath)
+	if err != nil {
+		return nil
+	}
 
-	for _, folder := range folders {
-		fullPath := filepath.Join(folder.Path, mediaPath)
-		if _, err := os.Stat(fullPath); err == nil {
-			return fullPath, folder, nil
+	for _, folder := range mfs.GetAllFolders() {
+		if folderPath, err := folder.GetAbsolutePath(); err == nil && folderPath == absPath {
+			return folder
 		}
 	}
+	return nil
+}
 
-	// If not found in any folder, try default folder
-	defaultFolder := mfs.GetDefaultFolder()
-	if defaultFolder != nil {
-		fullPath := filepath.Join(defaultFolder.Path, mediaPath)
-		return fullPath, defaultFolder, nil
+// FolderForPath returns the active media folder containing the given absolute file path, if any
+func (mfs *MediaFolderService) FolderForPath(fullPath string) *models.MediaFolder {
+	absPath, err := filepath.Abs(fullPath)
+	if err != nil {
+		return nil
 	}
 
-	return "", nil, fmt.Errorf("media file not found: %s", mediaPath)
+	var match *models.MediaFolder
+	matchLen := -1
+	for _, folder := range mfs.GetActiveFolders() {
+		folderPath, err := folder.GetAbsolutePath()
+		if err != nil {
+			continue
+		}
+
+		// Pick the most specific folder when folders are nested
+		rel, err := filepath.Rel(folderPath, absPath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(folderPath) > matchLen {
+			match = folder
+			matchLen = len(folderPath)
+		}
+	}
+
+	return match
 }
 
-// generateID generates a unique ID for folders
-func (mfs *MediaFolderService) generateID() string {
-	bytes := make([]byte, 8)
-	rand.Read(bytes)
-	return hex.EncodeToString(bytes)
+// folderID derives the ID of a media folder from its absolute path, so that the media paths of
+// its files (f/<folder ID>/...) stay the same across restarts and when the folder is re-added
+func folderID(path string) string {
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:8])
 }
 
 // formatBytes formats bytes into human readable format
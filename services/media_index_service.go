@@ -0,0 +1,324 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"media-server/models"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// mediaIndexFolders is the top-level bucket, holding one bucket of files per media folder
+var mediaIndexFolders = []byte("folders")
+
+// mediaIndexHashes is the top-level bucket of content fingerprints, keyed by absolute file path
+var mediaIndexHashes = []byte("hashes")
+
+// MediaIndexService keeps a persistent index of the media files in the media folders, built by
+// folder scans. Folders are keyed by their absolute path.
+type MediaIndexService struct {
+	db *bolt.DB
+
+	listeners []func(folderPath string)
+	mutex     sync.RWMutex
+}
+
+// NewMediaIndexService opens (or creates) the media index database at dbPath
+func NewMediaIndexService(dbPath string) (*MediaIndexService, error) {
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return nil, fmt.Errorf("error creating media index directory: %w", err)
+	}
+
+	// Another instance holding the lock would otherwise block startup forever
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening media index: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(mediaIndexFolders); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(mediaIndexHashes)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing media index: %w", err)
+	}
+
+	return &MediaIndexService{db: db}, nil
+}
+
+// OnChange registers fn to be called with the absolute path of a media folder after its indexed
+// files have changed
+func (mis *MediaIndexService) OnChange(fn func(folderPath string)) {
+	mis.mutex.Lock()
+	defer mis.mutex.Unlock()
+	mis.listeners = append(mis.listeners, fn)
+}
+
+// notify calls the change listeners for a folder
+func (mis *MediaIndexService) notify(key []byte) {
+	mis.mutex.RLock()
+	listeners := mis.listeners
+	mis.mutex.RUnlock()
+
+	for _, fn := range listeners {
+		fn(string(key))
+	}
+}
+
+// IndexFolder replaces the indexed files of a media folder with the files of a scan. Files
+// already indexed keep their date added.
+func (mis *MediaIndexService) IndexFolder(folderPath string, files []*models.IndexedFile) error {
+	key, err := folderKey(folderPath)
+	if err != nil {
+		return err
+	}
+
+	added, removed := 0, 0
+	err = mis.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(mediaIndexFolders).CreateBucketIfNotExists(key)
+		if err != nil {
+			return err
+		}
+
+		seen := make(map[string]bool, len(files))
+		now := time.Now()
+		for _, file := range files {
+			seen[file.Path] = true
+
+			entry := *file
+			entry.AddedAt = now
+			var existing models.IndexedFile
+			if value := bucket.Get([]byte(file.Path)); value != nil && json.Unmarshal(value, &existing) == nil {
+				entry.AddedAt = existing.AddedAt
+			} else {
+				added++
+			}
+
+			value, err := json.Marshal(&entry)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(file.Path), value); err != nil {
+				return err
+			}
+		}
+
+		// Collect first: deleting while iterating would skip keys
+		var stale [][]byte
+		bucket.ForEach(func(k, _ []byte) error {
+			if !seen[string(k)] {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		for _, k := range stale {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		removed = len(stale)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating media index: %w", err)
+	}
+
+	log.Printf("Indexed %s: %d files, %d added, %d removed", folderPath, len(files), added, removed)
+	mis.notify(key)
+	return nil
+}
+
+// UpdateFiles adds or updates files in the index of a media folder and removes the files with
+// the removed paths, as a folder changes between scans. Files already indexed keep their date
+// added. Folders that have never been indexed are left for their first scan.
+func (mis *MediaIndexService) UpdateFiles(folderPath string, files []*models.IndexedFile, removed []string) error {
+	key, err := folderKey(folderPath)
+	if err != nil {
+		return err
+	}
+
+	indexed := false
+	err = mis.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(mediaIndexFolders).Bucket(key)
+		if bucket == nil {
+			return nil
+		}
+		indexed = true
+
+		for _, path := range removed {
+			if err := bucket.Delete([]byte(path)); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		for _, file := range files {
+			entry := *file
+			entry.AddedAt = now
+			var existing models.IndexedFile
+			if value := bucket.Get([]byte(file.Path)); value != nil && json.Unmarshal(value, &existing) == nil {
+				entry.AddedAt = existing.AddedAt
+			}
+
+			value, err := json.Marshal(&entry)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(file.Path), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating media index: %w", err)
+	}
+
+	if indexed {
+		mis.notify(key)
+	}
+	return nil
+}
+
+// Files returns the indexed files of a media folder, ordered by path, and whether the folder
+// has been indexed at all
+func (mis *MediaIndexService) Files(folderPath string) ([]*models.IndexedFile, bool, error) {
+	key, err := folderKey(folderPath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var files []*models.IndexedFile
+	indexed := false
+	err = mis.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(mediaIndexFolders).Bucket(key)
+		if bucket == nil {
+			return nil
+		}
+		indexed = true
+
+		return bucket.ForEach(func(k, value []byte) error {
+			file := &models.IndexedFile{}
+			if err := json.Unmarshal(value, file); err != nil {
+				log.Printf("Skipping corrupt media index entry %s: %v", k, err)
+				return nil
+			}
+			files = append(files, file)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading media index: %w", err)
+	}
+
+	return files, indexed, nil
+}
+
+// RemoveFolder drops the indexed files of a media folder
+func (mis *MediaIndexService) RemoveFolder(folderPath string) error {
+	key, err := folderKey(folderPath)
+	if err != nil {
+		return err
+	}
+
+	err = mis.db.Update(func(tx *bolt.Tx) error {
+		folders := tx.Bucket(mediaIndexFolders)
+		if folders.Bucket(key) == nil {
+			return nil
+		}
+		return folders.DeleteBucket(key)
+	})
+	if err != nil {
+		return err
+	}
+
+	mis.notify(key)
+	return nil
+}
+
+// FileHash returns the stored content fingerprint of a file, if any. Callers must check that it
+// still matches the file.
+func (mis *MediaIndexService) FileHash(fullPath string) (*models.FileHash, bool) {
+	var hash *models.FileHash
+	mis.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(mediaIndexHashes).Get([]byte(fullPath))
+		if value == nil {
+			return nil
+		}
+		entry := &models.FileHash{}
+		if err := json.Unmarshal(value, entry); err == nil {
+			hash = entry
+		}
+		return nil
+	})
+	return hash, hash != nil
+}
+
+// PutFileHash stores the content fingerprint of a file. Concurrent calls are batched into one
+// transaction.
+func (mis *MediaIndexService) PutFileHash(fullPath string, hash *models.FileHash) error {
+	value, err := json.Marshal(hash)
+	if err != nil {
+		return err
+	}
+
+	err = mis.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(mediaIndexHashes).Put([]byte(fullPath), value)
+	})
+	if err != nil {
+		return fmt.Errorf("error storing file hash: %w", err)
+	}
+	return nil
+}
+
+// PruneFileHashes drops the stored fingerprints of files that are not in keep and returns how
+// many were dropped
+func (mis *MediaIndexService) PruneFileHashes(keep map[string]bool) (int, error) {
+	pruned := 0
+	err := mis.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(mediaIndexHashes)
+
+		// Collect first: deleting while iterating would skip keys
+		var stale [][]byte
+		bucket.ForEach(func(k, _ []byte) error {
+			if !keep[string(k)] {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		for _, k := range stale {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		pruned = len(stale)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error pruning file hashes: %w", err)
+	}
+	return pruned, nil
+}
+
+// Close closes the media index database
+func (mis *MediaIndexService) Close() error {
+	return mis.db.Close()
+}
+
+// folderKey returns the bucket name of a media folder
+func folderKey(folderPath string) ([]byte, error) {
+	absPath, err := filepath.Abs(folderPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid folder path: %w", err)
+	}
+	return []byte(absPath), nil
+}
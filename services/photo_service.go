@@ -0,0 +1,205 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"media-server/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// photoTimelineTTL is how long a built timeline is served before the media folders are walked again
+const photoTimelineTTL = 2 * time.Minute
+
+// PhotoService builds a date-based timeline of the images in all active media folders
+type PhotoService struct {
+	mediaFolderService *MediaFolderService
+	cacheService       *CacheService
+	timeline           *models.PhotoTimeline
+	mutex              sync.Mutex
+}
+
+// NewPhotoService creates a new PhotoService
+func NewPhotoService(mediaFolderService *MediaFolderService, cacheService *CacheService) *PhotoService {
+	return &PhotoService{
+		mediaFolderService: mediaFolderService,
+		cacheService:       cacheService,
+	}
+}
+
+// GetTimeline returns the photo timeline, rebuilding it when the cached one has expired
+func (ps *PhotoService) GetTimeline() (*models.PhotoTimeline, error) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	if ps.timeline != nil && time.Since(ps.timeline.GeneratedAt) < photoTimelineTTL {
+		return ps.timeline, nil
+	}
+
+	if ps.mediaFolderService == nil {
+		return nil, fmt.Errorf("media folders are not available")
+	}
+
+	photos := make([]*models.TimelinePhoto, 0)
+	for _, folder := range ps.mediaFolderService.GetActiveFolders() {
+		folderPhotos, err := ps.collectPhotos(folder)
+		if err != nil {
+			log.Printf("Error collecting photos from %s: %v", folder.Path, err)
+			continue
+		}
+		photos = append(photos, folderPhotos...)
+	}
+
+	ps.timeline = models.NewPhotoTimeline(photos)
+	return ps.timeline, nil
+}
+
+// collectPhotos walks a media folder for images, skipping hidden files and directories
+func (ps *PhotoService) collectPhotos(folder *models.MediaFolder) ([]*models.TimelinePhoto, error) {
+	photos := make([]*models.TimelinePhoto, 0)
+
+	err := filepath.WalkDir(folder.Path, func(walkPath string, d os.DirEntry, err error) error {
+		if err != nil {
+			if walkPath == folder.Path {
+				return err
+			}
+			return nil // Skip unreadable entries
+		}
+		if walkPath != folder.Path && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || models.GetMediaType(filepath.Ext(d.Name())) != "image" {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(folder.Path, walkPath)
+		if err != nil {
+			return nil
+		}
+
+		metadata := cachedPhotoMetadata(ps.cacheService, walkPath, info)
+		photos = append(photos, &models.TimelinePhoto{
+			Name:      d.Name(),
+			Path:      filepath.ToSlash(rel),
+			FolderID:  folder.ID,
+			MediaPath: models.FolderMediaPath(folder.ID, rel),
+			Size:      info.Size(),
+			TakenAt:   metadata.TakenAt,
+			Metadata:  metadata,
+		})
+		return nil
+	})
+
+	return photos, err
+}
+
+// cachedPhotoMetadata returns the cached metadata of a photo, reading it when the file has changed
+func cachedPhotoMetadata(cacheService *CacheService, fullPath string, info os.FileInfo) *models.PhotoMetadata {
+	if cacheService != nil {
+		if metadata, found := cacheService.GetPhotoMetadata(fullPath); found && metadata.IsValidFor(info.Size(), info.ModTime()) {
+			return metadata
+		}
+	}
+
+	metadata, err := ReadPhotoMetadata(fullPath)
+	if err != nil {
+		log.Printf("Error reading EXIF of %s: %v", fullPath, err)
+		metadata = &models.PhotoMetadata{Path: fullPath}
+	}
+	metadata.Size = info.Size()
+	metadata.ModTime = info.ModTime()
+	if metadata.DateSource == "" {
+		metadata.TakenAt = info.ModTime()
+		metadata.DateSource = models.PhotoDateModTime
+	}
+
+	if cacheService != nil {
+		cacheService.SetPhotoMetadata(fullPath, metadata)
+	}
+	return metadata
+}
+
+// ReadPhotoMetadata reads the EXIF metadata of a JPEG or TIFF file. Images without EXIF data
+// return empty metadata; only I/O errors and malformed EXIF blocks are returned as errors.
+// DateSource is left empty when the EXIF data carries no date.
+func ReadPhotoMetadata(fullPath string) (*models.PhotoMetadata, error) {
+	metadata := &models.PhotoMetadata{Path: fullPath}
+	if !models.IsEXIFSource(fullPath) {
+		return metadata, nil
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+
+	var tiff []byte
+	switch strings.ToLower(filepath.Ext(fullPath)) {
+	case ".tif", ".tiff":
+		tiff, err = readTIFFEXIF(file)
+	default:
+		tiff, err = readJPEGEXIF(file)
+	}
+	if err == errNoEXIF {
+		return metadata, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	exif, err := parseEXIF(tiff)
+	if err != nil {
+		return nil, err
+	}
+
+	if taken, ok := exif.DateTaken(); ok {
+		metadata.TakenAt = taken
+		metadata.DateSource = models.PhotoDateEXIF
+	}
+	metadata.Make = exif.stringValue(exif.ifd0, exifTagMake)
+	metadata.Model = exif.stringValue(exif.ifd0, exifTagModel)
+	metadata.Orientation = exif.Orientation()
+
+	metadata.Lens = exif.stringValue(exif.exif, exifTagLensModel)
+	if lensMake := exif.stringValue(exif.exif, exifTagLensMake); lensMake != "" && metadata.Lens != "" &&
+		!strings.HasPrefix(strings.ToLower(metadata.Lens), strings.ToLower(lensMake)) {
+		metadata.Lens = lensMake + " " + metadata.Lens
+	}
+	metadata.ExposureTime = exif.ExposureTime()
+	if fNumber, ok := exif.floatValue(exif.exif, exifTagFNumber); ok && fNumber > 0 {
+		metadata.FNumber = roundTo(fNumber, 1)
+	}
+	if iso, ok := exif.uintValue(exif.exif, exifTagISO); ok {
+		metadata.ISO = int(iso)
+	}
+	if focalLength, ok := exif.floatValue(exif.exif, exifTagFocalLength); ok && focalLength > 0 {
+		metadata.FocalLength = roundTo(focalLength, 1)
+	}
+	if latitude, longitude, altitude, ok := exif.GPS(); ok {
+		metadata.GPS = &models.GPSPosition{
+			Latitude:  roundTo(latitude, 6),
+			Longitude: roundTo(longitude, 6),
+			Altitude:  roundTo(altitude, 1),
+		}
+	}
+
+	return metadata, nil
+}
+
+// roundTo rounds a value to the given number of decimals
+func roundTo(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
@@ -0,0 +1,612 @@
+package services
+
+import (
+	"log"
+	"media-server/models"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Weights of the terms of a search document by the field they come from
+const (
+	searchWeightName     = 3.0
+	searchWeightMetadata = 2.0
+	searchWeightPath     = 1.0
+)
+
+// Match qualities of a query term against an indexed term. A fuzzy match loses
+// searchFuzzyPenalty for every edit after the first.
+const (
+	searchMatchExact   = 1.0
+	searchMatchPrefix  = 0.8
+	searchMatchFuzzy   = 0.6
+	searchFuzzyPenalty = 0.2
+)
+
+// foldedRunes spells out the letters that do not decompose into a base letter and a diacritic
+var foldedRunes = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ð': "d", 'þ': "th", 'ı': "i",
+}
+
+// searchDoc is a media file in the search index
+type searchDoc struct {
+	folderPath string // absolute path of the media folder
+	file       *models.IndexedFile
+	extension  string
+	summary    string
+	terms      map[string]float64 // term -> weight
+}
+
+// SearchService keeps an in-memory inverted index of the media index, with metadata, for
+// tokenized, diacritic-insensitive and typo-tolerant library search. It is kept in sync by
+// the change notifications of the media index.
+type SearchService struct {
+	mediaFolderService *MediaFolderService
+	mediaIndex         *MediaIndexService
+	cacheService       *CacheService
+
+	docs     map[int]*searchDoc
+	folders  map[string]map[string]int  // folder path -> file path -> doc ID
+	postings map[string]map[int]float64 // term -> doc ID -> weight
+	nextID   int
+	mutex    sync.RWMutex
+
+	// reindexMutex serializes reindexing, which reads metadata without holding mutex
+	reindexMutex sync.Mutex
+}
+
+// NewSearchService creates a search index over mediaIndex and subscribes it to its changes
+func NewSearchService(mediaFolderService *MediaFolderService, mediaIndex *MediaIndexService, cacheService *CacheService) *SearchService {
+	ss := &SearchService{
+		mediaFolderService: mediaFolderService,
+		mediaIndex:         mediaIndex,
+		cacheService:       cacheService,
+		docs:               make(map[int]*searchDoc),
+		folders:            make(map[string]map[string]int),
+		postings:           make(map[string]map[int]float64),
+	}
+
+	// Reading metadata for new files must not hold up scans and the folder watcher
+	mediaIndex.OnChange(func(folderPath string) {
+		go ss.ReindexFolder(folderPath)
+	})
+
+	return ss
+}
+
+// BuildIndex indexes every active media folder from the media index
+func (ss *SearchService) BuildIndex() {
+	for _, folder := range ss.mediaFolderService.GetActiveFolders() {
+		ss.ReindexFolder(folder.Path)
+	}
+}
+
+// ReindexFolder brings the documents of a media folder in line with the media index. Only files
+// that are new or have changed size or modification time are read again.
+func (ss *SearchService) ReindexFolder(folderPath string) {
+	ss.reindexMutex.Lock()
+	defer ss.reindexMutex.Unlock()
+
+	folderPath, err := filepath.Abs(folderPath)
+	if err != nil {
+		return
+	}
+
+	files, _, err := ss.mediaIndex.Files(folderPath)
+	if err != nil {
+		log.Printf("Error reindexing %s for search: %v", folderPath, err)
+		return
+	}
+
+	// Work out the changes, then read metadata without blocking searches
+	ss.mutex.RLock()
+	existing := ss.folders[folderPath]
+	seen := make(map[string]bool, len(files))
+	var changed []*models.IndexedFile
+	var unchanged []*models.IndexedFile
+	for _, file := range files {
+		seen[file.Path] = true
+		if id, found := existing[file.Path]; found {
+			indexed := ss.docs[id].file
+			if indexed.Size == file.Size && indexed.ModTime.Equal(file.ModTime) {
+				unchanged = append(unchanged, file)
+				continue
+			}
+		}
+		changed = append(changed, file)
+	}
+	var removed []string
+	for filePath := range existing {
+		if !seen[filePath] {
+			removed = append(removed, filePath)
+		}
+	}
+	ss.mutex.RUnlock()
+
+	docs := make([]*searchDoc, 0, len(changed))
+	for _, file := range changed {
+		docs = append(docs, ss.newDoc(folderPath, file))
+	}
+
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	for _, filePath := range removed {
+		ss.removeDoc(folderPath, filePath)
+	}
+	for _, doc := range docs {
+		ss.removeDoc(folderPath, doc.file.Path)
+		ss.addDoc(doc)
+	}
+	// The date added can be reset when a file is re-added to the media index
+	for _, file := range unchanged {
+		ss.docs[ss.folders[folderPath][file.Path]].file = file
+	}
+	if len(ss.folders[folderPath]) == 0 {
+		delete(ss.folders, folderPath)
+	}
+
+	if len(docs) > 0 || len(removed) > 0 {
+		log.Printf("Search index updated for %s: %d indexed, %d removed", folderPath, len(docs), len(removed))
+	}
+}
+
+// newDoc builds the search document of a file, reading its metadata
+func (ss *SearchService) newDoc(folderPath string, file *models.IndexedFile) *searchDoc {
+	doc := &searchDoc{
+		folderPath: folderPath,
+		file:       file,
+		extension:  strings.ToLower(path.Ext(file.Path)),
+		terms:      make(map[string]float64),
+	}
+
+	name := file.Name()
+	doc.addTerms(strings.TrimSuffix(name, path.Ext(name)), searchWeightName)
+	if dir := path.Dir(file.Path); dir != "." {
+		doc.addTerms(dir, searchWeightPath)
+	}
+	doc.addTerms(strings.TrimPrefix(doc.extension, "."), searchWeightPath)
+
+	fields, summary := ss.metadataFields(filepath.Join(folderPath, filepath.FromSlash(file.Path)), file)
+	for _, field := range fields {
+		doc.addTerms(field, searchWeightMetadata)
+	}
+	doc.summary = summary
+
+	return doc
+}
+
+// metadataFields returns the searchable metadata of a file and a short summary of it
+func (ss *SearchService) metadataFields(fullPath string, file *models.IndexedFile) ([]string, string) {
+	switch file.MediaType {
+	case "audio":
+		if !models.IsTaggedAudio(fullPath) {
+			break
+		}
+		tags := cachedAudioTags(ss.cacheService, fullPath)
+		if tags == nil {
+			break
+		}
+		fields := []string{tags.Title, tags.Artist, tags.Album, tags.AlbumArtist, tags.Genre}
+		if tags.Year > 0 {
+			fields = append(fields, strconv.Itoa(tags.Year))
+		}
+		return fields, joinNonEmpty(" · ", tags.Title, tags.Artist, tags.Album)
+
+	case "video":
+		if !models.IsProbeableVideo(fullPath) {
+			break
+		}
+		info := cachedVideoInfo(ss.cacheService, fullPath)
+		if info == nil {
+			break
+		}
+		fields := []string{info.VideoCodec, info.AudioCodec, info.ResolutionClass()}
+		for _, track := range info.AudioTracks {
+			fields = append(fields, track.Language, track.Name)
+		}
+		for _, track := range info.SubtitleTracks {
+			fields = append(fields, track.Language, track.Name)
+		}
+		return fields, joinNonEmpty(" · ", info.ResolutionClass(), info.FormattedDuration(), info.VideoCodec)
+
+	case "image":
+		if !models.IsEXIFSource(fullPath) {
+			break
+		}
+		stat, err := os.Stat(fullPath)
+		if err != nil {
+			break
+		}
+		metadata := cachedPhotoMetadata(ss.cacheService, fullPath, stat)
+		return []string{metadata.Make, metadata.Model, metadata.Lens}, metadata.Camera()
+	}
+
+	return nil, ""
+}
+
+// addDoc adds a document to the index; the caller holds the write lock
+func (ss *SearchService) addDoc(doc *searchDoc) {
+	id := ss.nextID
+	ss.nextID++
+
+	ss.docs[id] = doc
+	if ss.folders[doc.folderPath] == nil {
+		ss.folders[doc.folderPath] = make(map[string]int)
+	}
+	ss.folders[doc.folderPath][doc.file.Path] = id
+	for term, weight := range doc.terms {
+		if ss.postings[term] == nil {
+			ss.postings[term] = make(map[int]float64)
+		}
+		ss.postings[term][id] = weight
+	}
+}
+
+// removeDoc removes the document of a file from the index, if any; the caller holds the write lock
+func (ss *SearchService) removeDoc(folderPath, filePath string) {
+	id, found := ss.folders[folderPath][filePath]
+	if !found {
+		return
+	}
+
+	for term := range ss.docs[id].terms {
+		delete(ss.postings[term], id)
+		if len(ss.postings[term]) == 0 {
+			delete(ss.postings, term)
+		}
+	}
+	delete(ss.folders[folderPath], filePath)
+	delete(ss.docs, id)
+}
+
+// searchMatch is a document that matched the text of a search
+type searchMatch struct {
+	doc    *searchDoc
+	folder *models.MediaFolder
+	score  float64
+}
+
+// Search returns a page of the files in the active media folders matching a query, with facet
+// counts. Every word of the query text must match a word of the file name, its path or its
+// metadata, exactly, as a prefix or within a few typos.
+func (ss *SearchService) Search(query *models.SearchQuery) *models.SearchResult {
+	folders := make(map[string]*models.MediaFolder)
+	for _, folder := range ss.mediaFolderService.GetActiveFolders() {
+		if folderPath, err := folder.GetAbsolutePath(); err == nil {
+			folders[folderPath] = folder
+		}
+	}
+
+	ss.mutex.RLock()
+	defer ss.mutex.RUnlock()
+
+	typeCounts := make(map[string]int)
+	folderCounts := make(map[string]int)
+	extensionCounts := make(map[string]int)
+	var matches []searchMatch
+	for id, score := range ss.matchText(query.Text) {
+		doc := ss.docs[id]
+		folder, active := folders[doc.folderPath]
+		if !active || !matchesRange(doc.file, query) {
+			continue
+		}
+
+		// Each facet is counted with the other facets' filters applied
+		typeOK := len(query.MediaTypes) == 0 || slices.Contains(query.MediaTypes, doc.file.MediaType)
+		folderOK := len(query.FolderIDs) == 0 || slices.Contains(query.FolderIDs, folder.ID)
+		extensionOK := len(query.Extensions) == 0 || slices.Contains(query.Extensions, doc.extension)
+		if folderOK && extensionOK {
+			typeCounts[doc.file.MediaType]++
+		}
+		if typeOK && extensionOK {
+			folderCounts[folder.ID]++
+		}
+		if typeOK && folderOK {
+			extensionCounts[doc.extension]++
+		}
+
+		if typeOK && folderOK && extensionOK {
+			matches = append(matches, searchMatch{doc: doc, folder: folder, score: score})
+		}
+	}
+
+	sortSearchMatches(matches, query.Sort)
+
+	result := &models.SearchResult{
+		Query:   query,
+		Total:   len(matches),
+		Page:    query.Page,
+		PerPage: query.PerPage,
+		Pages:   (len(matches) + query.PerPage - 1) / query.PerPage,
+		Hits:    make([]*models.SearchHit, 0),
+	}
+
+	start := min((query.Page-1)*query.PerPage, len(matches))
+	end := min(start+query.PerPage, len(matches))
+	for _, match := range matches[start:end] {
+		file := match.doc.file
+		result.Hits = append(result.Hits, &models.SearchHit{
+			Name:      file.Name(),
+			Path:      file.Path,
+			MediaPath: models.FolderMediaPath(match.folder.ID, file.Path),
+			FolderID:  match.folder.ID,
+			Folder:    match.folder.Name,
+			MediaType: file.MediaType,
+			Extension: match.doc.extension,
+			Size:      file.Size,
+			ModTime:   file.ModTime,
+			AddedAt:   file.AddedAt,
+			Score:     roundTo(match.score, 3),
+			Summary:   match.doc.summary,
+		})
+	}
+
+	result.Facets.MediaTypes = facetCounts(typeCounts, func(value string) string {
+		return strings.ToUpper(value[:1]) + value[1:]
+	})
+	result.Facets.Folders = facetCounts(folderCounts, func(value string) string {
+		for _, folder := range folders {
+			if folder.ID == value {
+				return folder.Name
+			}
+		}
+		return value
+	})
+	result.Facets.Extensions = facetCounts(extensionCounts, func(value string) string {
+		return strings.ToUpper(strings.TrimPrefix(value, "."))
+	})
+
+	return result
+}
+
+// matchText returns the score of every document matching all the words of a query text, or of
+// every document when the text has no words; the caller holds the read lock
+func (ss *SearchService) matchText(text string) map[int]float64 {
+	terms := searchTokens(text)
+	if len(terms) == 0 {
+		scores := make(map[int]float64, len(ss.docs))
+		for id := range ss.docs {
+			scores[id] = 0
+		}
+		return scores
+	}
+
+	var scores map[int]float64
+	seen := make(map[string]bool)
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		matches := ss.matchTerm(term)
+		if scores == nil {
+			scores = matches
+			continue
+		}
+		for id := range scores {
+			if score, found := matches[id]; found {
+				scores[id] += score
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	return scores
+}
+
+// matchTerm returns the best score of a query term against the terms of every document, counting
+// exact, prefix and fuzzy matches; the caller holds the read lock
+func (ss *SearchService) matchTerm(term string) map[int]float64 {
+	termRunes := []rune(term)
+	edits := allowedEdits(len(termRunes))
+
+	matches := make(map[int]float64)
+	for candidate, postings := range ss.postings {
+		quality := 0.0
+		switch {
+		case candidate == term:
+			quality = searchMatchExact
+		case len(termRunes) >= 2 && strings.HasPrefix(candidate, term):
+			quality = searchMatchPrefix
+		case edits > 0:
+			length := utf8.RuneCountInString(candidate)
+			if length < len(termRunes)-edits || length > len(termRunes)+edits {
+				continue
+			}
+			distance := editDistance(termRunes, []rune(candidate), edits)
+			if distance > edits {
+				continue
+			}
+			quality = searchMatchFuzzy - searchFuzzyPenalty*float64(distance-1)
+		default:
+			continue
+		}
+
+		for id, weight := range postings {
+			if score := quality * weight; score > matches[id] {
+				matches[id] = score
+			}
+		}
+	}
+
+	return matches
+}
+
+// addTerms adds the words of a field to a document, keeping the highest weight of each word
+func (doc *searchDoc) addTerms(text string, weight float64) {
+	for _, term := range searchTokens(text) {
+		if weight > doc.terms[term] {
+			doc.terms[term] = weight
+		}
+	}
+}
+
+// searchTokens splits text into lowercase words without diacritics. Apostrophes are dropped
+// rather than splitting words.
+func searchTokens(text string) []string {
+	var tokens []string
+	var token strings.Builder
+	flush := func() {
+		if token.Len() > 0 {
+			tokens = append(tokens, token.String())
+			token.Reset()
+		}
+	}
+
+	for _, r := range norm.NFD.String(text) {
+		switch {
+		case unicode.Is(unicode.Mn, r), r == '\'', r == '’':
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			r = unicode.ToLower(r)
+			if folded, found := foldedRunes[r]; found {
+				token.WriteString(folded)
+			} else {
+				token.WriteRune(r)
+			}
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+// allowedEdits returns how many typos a query term of a given length may contain
+func allowedEdits(length int) int {
+	switch {
+	case length <= 3:
+		return 0
+	case length <= 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance returns the optimal string alignment distance between a and b (insertions,
+// deletions, substitutions and transpositions of adjacent letters), or limit+1 as soon as it
+// exceeds limit
+func editDistance(a, b []rune, limit int) int {
+	previous2 := make([]int, len(b)+1)
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		rowMin := i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				current[j] = min(current[j], previous2[j-2]+1)
+			}
+			rowMin = min(rowMin, current[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		previous2, previous, current = previous, current, previous2
+	}
+
+	return previous[len(b)]
+}
+
+// matchesRange checks the size and date filters of a query
+func matchesRange(file *models.IndexedFile, query *models.SearchQuery) bool {
+	if file.Size < query.MinSize || (query.MaxSize > 0 && file.Size > query.MaxSize) {
+		return false
+	}
+
+	date := file.ModTime
+	if query.DateField == models.SearchDateAdded {
+		date = file.AddedAt
+	}
+	if !query.From.IsZero() && date.Before(query.From) {
+		return false
+	}
+	if !query.To.IsZero() && !date.Before(query.To) {
+		return false
+	}
+	return true
+}
+
+// sortSearchMatches orders matches by a search sort order, then by name and path
+func sortSearchMatches(matches []searchMatch, order string) {
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		switch order {
+		case models.SearchSortRelevance:
+			if a.score != b.score {
+				return a.score > b.score
+			}
+		case models.SearchSortSize:
+			if a.doc.file.Size != b.doc.file.Size {
+				return a.doc.file.Size > b.doc.file.Size
+			}
+		case models.SearchSortModified:
+			if !a.doc.file.ModTime.Equal(b.doc.file.ModTime) {
+				return a.doc.file.ModTime.After(b.doc.file.ModTime)
+			}
+		case models.SearchSortAdded:
+			if !a.doc.file.AddedAt.Equal(b.doc.file.AddedAt) {
+				return a.doc.file.AddedAt.After(b.doc.file.AddedAt)
+			}
+		}
+
+		nameA, nameB := strings.ToLower(a.doc.file.Name()), strings.ToLower(b.doc.file.Name())
+		if nameA != nameB {
+			return nameA < nameB
+		}
+		if a.folder.Name != b.folder.Name {
+			return a.folder.Name < b.folder.Name
+		}
+		return a.doc.file.Path < b.doc.file.Path
+	})
+}
+
+// facetCounts turns facet counts into a list ordered by count, then value
+func facetCounts(counts map[string]int, label func(string) string) []models.FacetCount {
+	facets := make([]models.FacetCount, 0, len(counts))
+	for value, count := range counts {
+		facets = append(facets, models.FacetCount{Value: value, Label: label(value), Count: count})
+	}
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+		return facets[i].Value < facets[j].Value
+	})
+	return facets
+}
+
+// joinNonEmpty joins the non-empty values with a separator
+func joinNonEmpty(separator string, values ...string) string {
+	var parts []string
+	for _, value := range values {
+		if value != "" {
+			parts = append(parts, value)
+		}
+	}
+	return strings.Join(parts, separator)
+}
@@ -0,0 +1,438 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// subtitleCue is a timed block of subtitle text
+type subtitleCue struct {
+	start    time.Duration
+	end      time.Duration
+	settings string // WebVTT cue settings, kept for WebVTT input
+	text     string
+}
+
+// ErrNoSubtitleCues is returned when a subtitle file contains no usable cues
+var ErrNoSubtitleCues = fmt.Errorf("no subtitle cues found")
+
+var (
+	// markupPattern matches HTML-like tags in SRT and converted ASS text
+	markupPattern = regexp.MustCompile(`<[^>]*>`)
+	// assOverridePattern matches ASS override blocks ({\i1}, {\an8}, ...), also found in SRT files
+	assOverridePattern = regexp.MustCompile(`\{\\[^}]*\}`)
+	// allowedTagPattern matches the formatting tags kept in WebVTT output
+	allowedTagPattern = regexp.MustCompile(`^</?([ibu])>$`)
+)
+
+// ConvertToWebVTT converts subtitle data in format (srt, vtt, ass or ssa) to WebVTT, shifting
+// all cues by offset. The text encoding is detected (UTF-8 or UTF-16 with or without a byte
+// order mark, otherwise Windows-1252).
+func ConvertToWebVTT(data []byte, format string, offset time.Duration) ([]byte, error) {
+	text := decodeSubtitleText(data)
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	var cues []subtitleCue
+	var err error
+	switch strings.ToLower(format) {
+	case "srt":
+		cues, err = parseSRT(text)
+	case "vtt":
+		cues, err = parseWebVTT(text)
+	case "ass", "ssa":
+		cues, err = parseASS(text)
+	default:
+		return nil, fmt.Errorf("unsupported subtitle format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString("WEBVTT\n\n")
+	for _, cue := range cues {
+		start, end := cue.start+offset, cue.end+offset
+		if cue.text == "" || end <= 0 || end <= start {
+			continue
+		}
+		if start < 0 {
+			start = 0
+		}
+
+		out.WriteString(formatVTTTimestamp(start))
+		out.WriteString(" --> ")
+		out.WriteString(formatVTTTimestamp(end))
+		if cue.settings != "" {
+			out.WriteString(" ")
+			out.WriteString(cue.settings)
+		}
+		out.WriteString("\n")
+		out.WriteString(cue.text)
+		out.WriteString("\n\n")
+	}
+
+	return out.Bytes(), nil
+}
+
+// decodeSubtitleText detects the text encoding of subtitle data and returns it as a string
+func decodeSubtitleText(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return string(data[3:])
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return decodeUTF16(data[2:], false)
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return decodeUTF16(data[2:], true)
+	}
+
+	// UTF-16 without a byte order mark: ASCII characters leave every other byte zero
+	sample := data
+	if len(sample) > 512 {
+		sample = sample[:512]
+	}
+	var evenZeros, oddZeros int
+	for i, b := range sample {
+		if b == 0 {
+			if i%2 == 0 {
+				evenZeros++
+			} else {
+				oddZeros++
+			}
+		}
+	}
+	if len(sample) >= 16 {
+		if oddZeros > len(sample)/4 && evenZeros == 0 {
+			return decodeUTF16(data, false)
+		}
+		if evenZeros > len(sample)/4 && oddZeros == 0 {
+			return decodeUTF16(data, true)
+		}
+	}
+
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	return decodeWindows1252(data)
+}
+
+// decodeUTF16 decodes UTF-16 text in the given byte order
+func decodeUTF16(data []byte, bigEndian bool) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		} else {
+			units[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// windows1252 maps the 0x80-0x9F range of Windows-1252 to Unicode; the rest matches Latin-1
+var windows1252 = [32]rune{
+	'€', '\uFFFD', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '\uFFFD', 'Ž', '\uFFFD',
+	'\uFFFD', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '\uFFFD', 'ž', 'Ÿ',
+}
+
+// decodeWindows1252 decodes Windows-1252 text, the usual encoding of legacy Western subtitles
+func decodeWindows1252(data []byte) string {
+	var sb strings.Builder
+	sb.Grow(len(data))
+	for _, b := range data {
+		if b >= 0x80 && b < 0xA0 {
+			sb.WriteRune(windows1252[b-0x80])
+		} else {
+			sb.WriteRune(rune(b))
+		}
+	}
+	return sb.String()
+}
+
+// parseSRT parses SubRip cues
+func parseSRT(text string) ([]subtitleCue, error) {
+	var cues []subtitleCue
+	for _, block := range splitBlocks(text) {
+		lines := strings.Split(block, "\n")
+		timing := -1
+		for i, line := range lines {
+			if strings.Contains(line, "-->") {
+				timing = i
+				break
+			}
+		}
+		if timing < 0 {
+			continue
+		}
+
+		start, end, _, ok := parseTimingLine(lines[timing])
+		if !ok {
+			continue
+		}
+
+		// Coordinates after the timestamps (X1:... Y2:...) have no WebVTT equivalent
+		cues = append(cues, subtitleCue{
+			start: start,
+			end:   end,
+			text:  sanitizeCueText(assOverridePattern.ReplaceAllString(strings.Join(lines[timing+1:], "\n"), "")),
+		})
+	}
+
+	if len(cues) == 0 {
+		return nil, ErrNoSubtitleCues
+	}
+	return cues, nil
+}
+
+// parseWebVTT parses WebVTT cues, keeping their settings and markup
+func parseWebVTT(text string) ([]subtitleCue, error) {
+	if !strings.HasPrefix(text, "WEBVTT") {
+		return nil, fmt.Errorf("missing WEBVTT header")
+	}
+
+	var cues []subtitleCue
+	for _, block := range splitBlocks(text) {
+		lines := strings.Split(block, "\n")
+		if strings.HasPrefix(lines[0], "WEBVTT") || strings.HasPrefix(lines[0], "NOTE") {
+			continue
+		}
+
+		timing := 0
+		if !strings.Contains(lines[0], "-->") {
+			timing = 1 // cue identifier
+		}
+		if timing >= len(lines) {
+			continue
+		}
+
+		start, end, settings, ok := parseTimingLine(lines[timing])
+		if !ok {
+			continue
+		}
+		cues = append(cues, subtitleCue{
+			start:    start,
+			end:      end,
+			settings: settings,
+			text:     strings.Join(lines[timing+1:], "\n"),
+		})
+	}
+
+	if len(cues) == 0 {
+		return nil, ErrNoSubtitleCues
+	}
+	return cues, nil
+}
+
+// parseASS parses the dialogue events of Advanced SubStation Alpha (and SSA) files. Styling is
+// dropped except for italic, bold and underline overrides.
+func parseASS(text string) ([]subtitleCue, error) {
+	var cues []subtitleCue
+	inEvents := false
+	var fields []string
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			inEvents = strings.EqualFold(line, "[Events]")
+			continue
+		}
+		if !inEvents {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Format":
+			fields = nil
+			for _, field := range strings.Split(value, ",") {
+				fields = append(fields, strings.ToLower(strings.TrimSpace(field)))
+			}
+		case "Dialogue":
+			if len(fields) == 0 {
+				continue
+			}
+			values := strings.SplitN(strings.TrimSpace(value), ",", len(fields))
+			if len(values) != len(fields) {
+				continue
+			}
+
+			var start, end time.Duration
+			var dialogue string
+			var startOK, endOK bool
+			for i, field := range fields {
+				switch field {
+				case "start":
+					start, startOK = parseTimestamp(values[i])
+				case "end":
+					end, endOK = parseTimestamp(values[i])
+				case "text":
+					dialogue = values[i]
+				}
+			}
+			if !startOK || !endOK {
+				continue
+			}
+
+			cues = append(cues, subtitleCue{start: start, end: end, text: convertASSText(dialogue)})
+		}
+	}
+
+	if len(cues) == 0 {
+		return nil, ErrNoSubtitleCues
+	}
+
+	// Events are not required to be in order
+	sort.SliceStable(cues, func(i, j int) bool {
+		return cues[i].start < cues[j].start
+	})
+	return cues, nil
+}
+
+// convertASSText converts ASS dialogue text to WebVTT cue text
+func convertASSText(text string) string {
+	text = assOverridePattern.ReplaceAllStringFunc(text, func(block string) string {
+		var tags strings.Builder
+		for _, override := range strings.Split(strings.Trim(block, "{}"), `\`) {
+			switch override {
+			case "i1", "b1", "u1":
+				tags.WriteString("<" + override[:1] + ">")
+			case "i0", "b0", "u0":
+				tags.WriteString("</" + override[:1] + ">")
+			}
+		}
+		return tags.String()
+	})
+
+	text = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(text)
+	return sanitizeCueText(text)
+}
+
+// sanitizeCueText escapes cue text for WebVTT, keeping only <i>, <b> and <u> markup
+func sanitizeCueText(text string) string {
+	var sb strings.Builder
+	escape := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+	last := 0
+	for _, loc := range markupPattern.FindAllStringIndex(text, -1) {
+		sb.WriteString(escape.Replace(text[last:loc[0]]))
+		if tag := strings.ToLower(text[loc[0]:loc[1]]); allowedTagPattern.MatchString(tag) {
+			sb.WriteString(tag)
+		}
+		last = loc[1]
+	}
+	sb.WriteString(escape.Replace(text[last:]))
+
+	// Blank lines would end the cue early, and "-->" is not allowed in cue text
+	lines := strings.Split(strings.ReplaceAll(sb.String(), "-->", "->"), "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			kept = append(kept, strings.TrimRight(line, " \t"))
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// splitBlocks splits subtitle text into blocks separated by blank lines
+func splitBlocks(text string) []string {
+	var blocks []string
+	var current []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				blocks = append(blocks, strings.Join(current, "\n"))
+				current = nil
+			}
+			continue
+		}
+		current = append(current, line)
+	}
+	if len(current) > 0 {
+		blocks = append(blocks, strings.Join(current, "\n"))
+	}
+	return blocks
+}
+
+// parseTimingLine parses "start --> end [settings]"
+func parseTimingLine(line string) (start, end time.Duration, settings string, ok bool) {
+	left, right, found := strings.Cut(line, "-->")
+	if !found {
+		return 0, 0, "", false
+	}
+
+	rightFields := strings.Fields(right)
+	if len(rightFields) == 0 {
+		return 0, 0, "", false
+	}
+
+	start, startOK := parseTimestamp(left)
+	end, endOK := parseTimestamp(rightFields[0])
+	if !startOK || !endOK {
+		return 0, 0, "", false
+	}
+
+	// Keep WebVTT cue settings (name:value pairs such as "line:0"), drop SRT coordinates
+	var kept []string
+	for _, field := range rightFields[1:] {
+		name, _, _ := strings.Cut(field, ":")
+		switch name {
+		case "vertical", "line", "position", "size", "align", "region":
+			kept = append(kept, field)
+		}
+	}
+
+	return start, end, strings.Join(kept, " "), true
+}
+
+// parseTimestamp parses SRT (00:01:02,345), WebVTT (01:02.345, 00:01:02.345) and ASS (0:01:02.34)
+// timestamps
+func parseTimestamp(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	clock, fraction, _ := strings.Cut(strings.Replace(value, ",", ".", 1), ".")
+
+	parts := strings.Split(clock, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+
+	var total time.Duration
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		total = total*60 + time.Duration(n)*time.Second
+	}
+
+	if fraction != "" {
+		if len(fraction) > 3 {
+			fraction = fraction[:3]
+		}
+		n, err := strconv.Atoi(fraction)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		for i := len(fraction); i < 3; i++ {
+			n *= 10
+		}
+		total += time.Duration(n) * time.Millisecond
+	}
+
+	return total, true
+}
+
+// formatVTTTimestamp formats a duration as a WebVTT timestamp (HH:MM:SS.mmm)
+func formatVTTTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
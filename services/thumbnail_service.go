@@ -0,0 +1,321 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// maxThumbnailSourcePixels rejects images too large to decode safely (about 24 bytes per pixel
+// for YCbCr JPEG data plus working memory)
+const maxThumbnailSourcePixels = 120_000_000
+
+// thumbnailQuality is the JPEG quality of generated thumbnails
+const thumbnailQuality = 82
+
+// ErrThumbnailBusy is returned when the thumbnail worker pool cannot accept more work
+var ErrThumbnailBusy = fmt.Errorf("thumbnail generation is busy")
+
+// ThumbnailService generates JPEG thumbnails of images and keeps them in a persistent on-disk
+// cache keyed by source path, modification time, size and thumbnail size
+type ThumbnailService struct {
+	cacheDir   string
+	workerPool *WorkerPool
+	inflight   map[string]*thumbnailJob
+	mutex      sync.Mutex
+}
+
+// thumbnailJob lets concurrent requests for the same thumbnail share one generation
+type thumbnailJob struct {
+	done chan struct{}
+	err  error
+}
+
+// NewThumbnailService creates a new ThumbnailService. Thumbnails are generated on a worker pool
+// of the performance service, sized to half the CPU cores so generation cannot starve streaming.
+func NewThumbnailService(cacheDir string, performanceService *PerformanceService) (*ThumbnailService, error) {
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating thumbnail cache directory: %w", err)
+	}
+
+	workers := performanceService.GetCPUOptimalWorkerCount() / 2
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &ThumbnailService{
+		cacheDir:   cacheDir,
+		workerPool: performanceService.GetOrCreateWorkerPool("thumbnails", workers, 256),
+		inflight:   make(map[string]*thumbnailJob),
+	}, nil
+}
+
+// GetThumbnail returns the path of the cached thumbnail of fullPath with the given maximum edge
+// length, generating it first if needed
+func (ts *ThumbnailService) GetThumbnail(fullPath string, edge int) (string, error) {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("error accessing file: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d\x00%d", fullPath, info.ModTime().UnixNano(), info.Size(), edge)))
+	key := hex.EncodeToString(sum[:16])
+	cachePath := filepath.Join(ts.cacheDir, key[:2], key+".jpg")
+
+	if _, err := os.Stat(cachePath); err == nil {
+		return cachePath, nil
+	}
+
+	ts.mutex.Lock()
+	if job, running := ts.inflight[key]; running {
+		ts.mutex.Unlock()
+		<-job.done
+		return cachePath, job.err
+	}
+	job := &thumbnailJob{done: make(chan struct{})}
+	ts.inflight[key] = job
+	ts.mutex.Unlock()
+
+	job.err = ts.workerPool.SubmitAndWait("thumbnail:"+key, func() error {
+		return ts.generate(fullPath, cachePath, edge)
+	})
+	if job.err == ErrWorkerPoolFull {
+		job.err = ErrThumbnailBusy
+	}
+
+	ts.mutex.Lock()
+	delete(ts.inflight, key)
+	ts.mutex.Unlock()
+	close(job.done)
+
+	return cachePath, job.err
+}
+
+// generate decodes an image, scales it to fit edge, applies the EXIF orientation and writes the
+// thumbnail to cachePath
+func (ts *ThumbnailService) generate(fullPath, cachePath string, edge int) error {
+	startTime := time.Now()
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	config, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return fmt.Errorf("error reading image header: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > maxThumbnailSourcePixels {
+		return fmt.Errorf("unsupported image dimensions %dx%d", config.Width, config.Height)
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if tiff, err := readJPEGEXIF(file); err == nil {
+			if exif, err := parseEXIF(tiff); err == nil {
+				orientation = exif.Orientation()
+			}
+		}
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	var src image.Image
+	switch format {
+	case "jpeg":
+		src, err = jpeg.Decode(file)
+	case "png":
+		src, err = png.Decode(file)
+	case "gif":
+		src, err = gif.Decode(file) // first frame
+	default:
+		err = fmt.Errorf("unsupported image format: %s", format)
+	}
+	if err != nil {
+		return fmt.Errorf("error decoding image: %w", err)
+	}
+
+	bounds := src.Bounds()
+	width, height := fitWithin(bounds.Dx(), bounds.Dy(), edge)
+	thumb := orientImage(resizeImage(src, width, height), orientation)
+
+	// Write to a temporary file first so that readers never see a partial thumbnail
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(cachePath), ".thumb-*")
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(tmp, thumb, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error encoding thumbnail: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), cachePath); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	log.Printf("Generated %dpx thumbnail of %s in %v", edge, fullPath, time.Since(startTime).Round(time.Millisecond))
+	return nil
+}
+
+// fitWithin scales width x height down (never up) so that the longer edge is at most edge
+func fitWithin(width, height, edge int) (int, int) {
+	if width <= edge && height <= edge {
+		return width, height
+	}
+	if width >= height {
+		return edge, max(1, height*edge/width)
+	}
+	return max(1, width*edge/height), edge
+}
+
+// resizeImage scales src to width x height with a box filter, averaging every source pixel that
+// falls into a destination pixel. Transparent areas are composited onto white.
+func resizeImage(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	pixel := pixelReader(src)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		sy0 := bounds.Min.Y + y*srcH/height
+		sy1 := max(sy0+1, bounds.Min.Y+(y+1)*srcH/height)
+
+		for x := 0; x < width; x++ {
+			sx0 := bounds.Min.X + x*srcW/width
+			sx1 := max(sx0+1, bounds.Min.X+(x+1)*srcW/width)
+
+			var r, g, b, a, n uint32
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb, pa := pixel(sx, sy)
+					r += uint32(pr)
+					g += uint32(pg)
+					b += uint32(pb)
+					a += uint32(pa)
+					n++
+				}
+			}
+
+			// Premultiplied colour over a white background
+			background := 255 - a/n
+			offset := dst.PixOffset(x, y)
+			dst.Pix[offset] = uint8(r/n + background)
+			dst.Pix[offset+1] = uint8(g/n + background)
+			dst.Pix[offset+2] = uint8(b/n + background)
+			dst.Pix[offset+3] = 255
+		}
+	}
+
+	return dst
+}
+
+// pixelReader returns a function reading premultiplied 8-bit RGBA pixels, with fast paths for the
+// image types produced by the standard decoders
+func pixelReader(src image.Image) func(x, y int) (r, g, b, a uint8) {
+	switch img := src.(type) {
+	case *image.YCbCr:
+		return func(x, y int) (uint8, uint8, uint8, uint8) {
+			r, g, b := color.YCbCrToRGB(img.Y[img.YOffset(x, y)], img.Cb[img.COffset(x, y)], img.Cr[img.COffset(x, y)])
+			return r, g, b, 255
+		}
+	case *image.Gray:
+		return func(x, y int) (uint8, uint8, uint8, uint8) {
+			v := img.Pix[img.PixOffset(x, y)]
+			return v, v, v, 255
+		}
+	case *image.RGBA:
+		return func(x, y int) (uint8, uint8, uint8, uint8) {
+			p := img.Pix[img.PixOffset(x, y):]
+			return p[0], p[1], p[2], p[3]
+		}
+	case *image.NRGBA:
+		return func(x, y int) (uint8, uint8, uint8, uint8) {
+			p := img.Pix[img.PixOffset(x, y):]
+			a := uint32(p[3])
+			return uint8(uint32(p[0]) * a / 255), uint8(uint32(p[1]) * a / 255), uint8(uint32(p[2]) * a / 255), p[3]
+		}
+	case *image.Paletted:
+		palette := make([][4]uint8, len(img.Palette))
+		for i, c := range img.Palette {
+			r, g, b, a := c.RGBA()
+			palette[i] = [4]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+		}
+		return func(x, y int) (uint8, uint8, uint8, uint8) {
+			index := int(img.Pix[img.PixOffset(x, y)])
+			if index >= len(palette) {
+				return 0, 0, 0, 0
+			}
+			p := palette[index]
+			return p[0], p[1], p[2], p[3]
+		}
+	default:
+		return func(x, y int) (uint8, uint8, uint8, uint8) {
+			r, g, b, a := src.At(x, y).RGBA()
+			return uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)
+		}
+	}
+}
+
+// orientImage applies an EXIF orientation (2-8) so that the image is displayed upright
+func orientImage(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w // rotated by 90 degrees
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90 counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+
+	return dst
+}
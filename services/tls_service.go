@@ -0,0 +1,316 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// selfSignedValidity is how long a generated self-signed certificate is valid
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// selfSignedRenewBefore is how long before expiry a self-signed certificate is regenerated
+const selfSignedRenewBefore = 30 * 24 * time.Hour
+
+// certificateCheckInterval is how often the certificate files are checked for changes
+const certificateCheckInterval = 10 * time.Second
+
+// CertificateManager serves the TLS certificate of the server from a certificate and key file,
+// reloading them when either file changes so that renewed certificates are picked up without a
+// restart. In self-signed mode it also generates the files, and regenerates them before expiry.
+type CertificateManager struct {
+	certFile   string
+	keyFile    string
+	selfSigned bool
+	hosts      []string
+
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+	mutex   sync.RWMutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewCertificateManager creates a CertificateManager for an existing certificate and key file
+func NewCertificateManager(certFile, keyFile string) (*CertificateManager, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cm := &CertificateManager{
+		certFile: certFile,
+		keyFile:  keyFile,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	if err := cm.reload(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return cm, nil
+}
+
+// NewSelfSignedCertificateManager creates a CertificateManager for a self-signed certificate
+// stored in dir, generating it for hosts (host names and IP addresses) when it is missing,
+// about to expire or issued for other hosts
+func NewSelfSignedCertificateManager(dir string, hosts []string) (*CertificateManager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating certificate directory: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cm := &CertificateManager{
+		certFile:   filepath.Join(dir, "selfsigned-cert.pem"),
+		keyFile:    filepath.Join(dir, "selfsigned-key.pem"),
+		selfSigned: true,
+		hosts:      hosts,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+
+	if err := cm.reload(); err != nil || cm.needsRenewal() {
+		if err := cm.generateSelfSigned(); err != nil {
+			cancel()
+			return nil, err
+		}
+		if err := cm.reload(); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	return cm, nil
+}
+
+// TLSConfig returns a TLS configuration serving the managed certificate
+func (cm *CertificateManager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cm.GetCertificate,
+	}
+}
+
+// GetCertificate returns the current certificate; it is used as tls.Config.GetCertificate
+func (cm *CertificateManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	return cm.cert, nil
+}
+
+// CertFile returns the path of the certificate file
+func (cm *CertificateManager) CertFile() string {
+	return cm.certFile
+}
+
+// StartWatching checks the certificate files for changes until Stop is called
+func (cm *CertificateManager) StartWatching() {
+	ticker := time.NewTicker(certificateCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cm.ctx.Done():
+			return
+		case <-ticker.C:
+			cm.check()
+		}
+	}
+}
+
+// Stop stops watching the certificate files
+func (cm *CertificateManager) Stop() {
+	cm.cancel()
+}
+
+// check reloads the certificate when its files have changed, and renews an expiring
+// self-signed certificate. A failed reload keeps the current certificate.
+func (cm *CertificateManager) check() {
+	if cm.selfSigned && cm.needsRenewal() {
+		log.Printf("Self-signed certificate expires soon, generating a new one")
+		if err := cm.generateSelfSigned(); err != nil {
+			log.Printf("Error generating self-signed certificate: %v", err)
+			return
+		}
+	}
+
+	certInfo, err := os.Stat(cm.certFile)
+	if err != nil {
+		return
+	}
+	keyInfo, err := os.Stat(cm.keyFile)
+	if err != nil {
+		return
+	}
+
+	cm.mutex.RLock()
+	changed := !certInfo.ModTime().Equal(cm.certMod) || !keyInfo.ModTime().Equal(cm.keyMod)
+	cm.mutex.RUnlock()
+	if !changed {
+		return
+	}
+
+	if err := cm.reload(); err != nil {
+		// The files may be half-written; the next check retries
+		log.Printf("Error reloading TLS certificate: %v", err)
+		return
+	}
+	log.Printf("Reloaded TLS certificate from %s", cm.certFile)
+}
+
+// reload loads the certificate and key files
+func (cm *CertificateManager) reload() error {
+	certInfo, err := os.Stat(cm.certFile)
+	if err != nil {
+		return fmt.Errorf("error accessing certificate file: %w", err)
+	}
+	keyInfo, err := os.Stat(cm.keyFile)
+	if err != nil {
+		return fmt.Errorf("error accessing key file: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(cm.certFile, cm.keyFile)
+	if err != nil {
+		return fmt.Errorf("error loading TLS certificate: %w", err)
+	}
+
+	cm.mutex.Lock()
+	cm.cert = &cert
+	cm.certMod = certInfo.ModTime()
+	cm.keyMod = keyInfo.ModTime()
+	cm.mutex.Unlock()
+	return nil
+}
+
+// needsRenewal reports whether the current self-signed certificate is missing, expires within
+// selfSignedRenewBefore or does not cover the configured hosts
+func (cm *CertificateManager) needsRenewal() bool {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	if cm.cert == nil || cm.cert.Leaf == nil {
+		return true
+	}
+	leaf := cm.cert.Leaf
+	if time.Until(leaf.NotAfter) < selfSignedRenewBefore {
+		return true
+	}
+	for _, host := range cm.hosts {
+		if leaf.VerifyHostname(host) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// generateSelfSigned writes a new self-signed ECDSA P-256 certificate and key for the hosts
+func (cm *CertificateManager) generateSelfSigned() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("error generating key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("error generating serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Media Server"}, CommonName: "Media Server self-signed"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range cm.hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("error creating certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("error encoding key: %w", err)
+	}
+
+	// Write the key before the certificate so that a watcher never pairs a new certificate with
+	// the old key for long
+	if err := writePEMFile(cm.keyFile, "PRIVATE KEY", keyDER, 0600); err != nil {
+		return err
+	}
+	if err := writePEMFile(cm.certFile, "CERTIFICATE", der, 0644); err != nil {
+		return err
+	}
+
+	log.Printf("Generated self-signed TLS certificate for %v in %s", cm.hosts, cm.certFile)
+	return nil
+}
+
+// writePEMFile atomically writes a single PEM block to path
+func writePEMFile(path, blockType string, der []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".pem-*")
+	if err != nil {
+		return err
+	}
+	if err := pem.Encode(tmp, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// DefaultCertificateHosts returns the names a self-signed certificate should cover: localhost,
+// the machine's host name and the addresses of its network interfaces
+func DefaultCertificateHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" && hostname != "localhost" {
+		hosts = append(hosts, hostname)
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return hosts
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		hosts = append(hosts, ipNet.IP.String())
+	}
+	return hosts
+}
@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"media-server/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// transcodeRetryDelay is how long a failed rendition is reported as failed before it is retried
+const transcodeRetryDelay = 5 * time.Minute
+
+// partialSuffix marks rendition directories that are still being written
+const partialSuffix = ".partial"
+
+// ErrTranscodeQueueFull is returned when the transcoding worker pool cannot accept another job
+var ErrTranscodeQueueFull = fmt.Errorf("transcode queue is full")
+
+// TranscodeService produces browser-compatible renditions on demand and keeps them in a
+// size-limited on-disk cache. Jobs run on a dedicated worker pool, which bounds CPU use.
+type TranscodeService struct {
+	transcoder   Transcoder
+	workerPool   *WorkerPool
+	cacheDir     string
+	maxCacheSize int64 // bytes, 0 = unlimited
+	jobs         map[string]*models.TranscodeJob
+	inUse        map[string]int // requests serving each rendition, which eviction skips
+	mutex        sync.Mutex
+	ctx          context.Context
+	cancel       context.CancelFunc
+}
+
+// NewTranscodeService creates a new TranscodeService storing renditions under cacheDir
+func NewTranscodeService(transcoder Transcoder, workerPool *WorkerPool, cacheDir string, maxCacheSize int64) (*TranscodeService, error) {
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating transcode cache directory: %w", err)
+	}
+
+	// Renditions interrupted by a previous shutdown are incomplete
+	if entries, err := os.ReadDir(cacheDir); err == nil {
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), partialSuffix) {
+				os.RemoveAll(filepath.Join(cacheDir, entry.Name()))
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TranscodeService{
+		transcoder:   transcoder,
+		workerPool:   workerPool,
+		cacheDir:     cacheDir,
+		maxCacheSize: maxCacheSize,
+		jobs:         make(map[string]*models.TranscodeJob),
+		inUse:        make(map[string]int),
+		ctx:          ctx,
+		cancel:       cancel,
+	}, nil
+}
+
+// TranscoderName returns the name of the transcoder backend
+func (ts *TranscodeService) TranscoderName() string {
+	return ts.transcoder.Name()
+}
+
+// Request returns the state of the rendition of fullPath in format, queueing a job when the
+// rendition is neither cached nor in progress
+func (ts *TranscodeService) Request(fullPath string, format models.TranscodeFormat) (models.TranscodeJob, error) {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return models.TranscodeJob{}, fmt.Errorf("error accessing file: %w", err)
+	}
+
+	id := renditionID(fullPath, info, format)
+	outputDir := filepath.Join(ts.cacheDir, id)
+	now := time.Now()
+
+	ts.mutex.Lock()
+	if job, exists := ts.jobs[id]; exists {
+		switch {
+		case job.Status == models.TranscodeReady:
+			// Touch the rendition so that cache eviction sees it as recently used
+			if err := os.Chtimes(outputDir, now, now); err == nil {
+				snapshot := *job
+				ts.mutex.Unlock()
+				return snapshot, nil
+			}
+			// Evicted from the cache; produce it again
+		case job.Status == models.TranscodeFailed && now.Sub(job.FinishedAt) >= transcodeRetryDelay:
+			// Retry
+		default:
+			snapshot := *job
+			ts.mutex.Unlock()
+			return snapshot, nil
+		}
+	}
+
+	// Renditions left by a previous run are picked up from disk
+	if stat, err := os.Stat(outputDir); err == nil && stat.IsDir() {
+		os.Chtimes(outputDir, now, now)
+		job := &models.TranscodeJob{
+			ID:         id,
+			Path:       fullPath,
+			Format:     format,
+			Status:     models.TranscodeReady,
+			OutputDir:  outputDir,
+			Size:       dirSize(outputDir),
+			QueuedAt:   now,
+			FinishedAt: now,
+		}
+		ts.jobs[id] = job
+		ts.mutex.Unlock()
+		return *job, nil
+	}
+
+	job := &models.TranscodeJob{
+		ID:        id,
+		Path:      fullPath,
+		Format:    format,
+		Status:    models.TranscodeQueued,
+		OutputDir: outputDir,
+		QueuedAt:  now,
+	}
+	ts.jobs[id] = job
+	snapshot := *job
+	ts.mutex.Unlock()
+
+	if err := ts.workerPool.Submit("transcode:"+id, func() error { return ts.run(job) }); err != nil {
+		ts.mutex.Lock()
+		delete(ts.jobs, id)
+		ts.mutex.Unlock()
+		if err == ErrWorkerPoolFull {
+			return models.TranscodeJob{}, ErrTranscodeQueueFull
+		}
+		return models.TranscodeJob{}, err
+	}
+
+	log.Printf("Queued %s transcode of %s (%s)", format, fullPath, id)
+	return snapshot, nil
+}
+
+// OutputPath returns the path of a file inside a ready rendition
+func (ts *TranscodeService) OutputPath(job models.TranscodeJob, name string) string {
+	return filepath.Join(job.OutputDir, filepath.Base(name))
+}
+
+// Acquire marks a rendition as being served, so that cache eviction leaves it alone until the
+// matching Release
+func (ts *TranscodeService) Acquire(job models.TranscodeJob) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.inUse[job.ID]++
+}
+
+// Release ends a use of a rendition started with Acquire
+func (ts *TranscodeService) Release(job models.TranscodeJob) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	if ts.inUse[job.ID]--; ts.inUse[job.ID] <= 0 {
+		delete(ts.inUse, job.ID)
+	}
+}
+
+// Stop cancels running transcodes
+func (ts *TranscodeService) Stop() {
+	ts.cancel()
+}
+
+// run executes a queued job on a worker
+func (ts *TranscodeService) run(job *models.TranscodeJob) error {
+	ts.mutex.Lock()
+	job.Status = models.TranscodeRunning
+	job.StartedAt = time.Now()
+	ts.mutex.Unlock()
+
+	log.Printf("Transcoding %s to %s with %s", job.Path, job.Format, ts.transcoder.Name())
+
+	// Write into a partial directory and rename it once complete, so a rendition directory
+	// only ever exists in finished form
+	partialDir := job.OutputDir + partialSuffix
+	os.RemoveAll(partialDir)
+	err := os.MkdirAll(partialDir, 0755)
+	if err == nil {
+		err = ts.transcoder.Transcode(ts.ctx, job.Path, partialDir, job.Format)
+	}
+	if err == nil {
+		os.RemoveAll(job.OutputDir)
+		err = os.Rename(partialDir, job.OutputDir)
+	}
+
+	if err != nil {
+		os.RemoveAll(partialDir)
+		ts.mutex.Lock()
+		job.Status = models.TranscodeFailed
+		job.Error = err.Error()
+		job.FinishedAt = time.Now()
+		ts.mutex.Unlock()
+		return fmt.Errorf("error transcoding %s: %w", job.Path, err)
+	}
+
+	size := dirSize(job.OutputDir)
+	ts.mutex.Lock()
+	job.Status = models.TranscodeReady
+	job.Size = size
+	job.FinishedAt = time.Now()
+	duration := job.FinishedAt.Sub(job.StartedAt)
+	ts.mutex.Unlock()
+
+	log.Printf("Transcoded %s to %s in %v (%d bytes)", job.Path, job.Format, duration.Round(time.Millisecond), size)
+	ts.enforceCacheLimit(job.ID)
+	return nil
+}
+
+// enforceCacheLimit removes the least recently used renditions until the cache fits its size
+// limit; keep names a rendition that is never removed, and renditions being served are skipped
+func (ts *TranscodeService) enforceCacheLimit(keep string) {
+	if ts.maxCacheSize <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(ts.cacheDir)
+	if err != nil {
+		log.Printf("Error reading transcode cache directory: %v", err)
+		return
+	}
+
+	type rendition struct {
+		id      string
+		size    int64
+		modTime time.Time
+	}
+
+	var renditions []rendition
+	var total int64
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasSuffix(entry.Name(), partialSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		size := dirSize(filepath.Join(ts.cacheDir, entry.Name()))
+		renditions = append(renditions, rendition{id: entry.Name(), size: size, modTime: info.ModTime()})
+		total += size
+	}
+
+	sort.Slice(renditions, func(i, j int) bool {
+		return renditions[i].modTime.Before(renditions[j].modTime)
+	})
+
+	for _, r := range renditions {
+		if total <= ts.maxCacheSize {
+			break
+		}
+		if r.id == keep {
+			continue
+		}
+
+		// Forget the job first, so no new request is served from the rendition being removed
+		ts.mutex.Lock()
+		if ts.inUse[r.id] > 0 {
+			ts.mutex.Unlock()
+			continue
+		}
+		delete(ts.jobs, r.id)
+		ts.mutex.Unlock()
+
+		if err := os.RemoveAll(filepath.Join(ts.cacheDir, r.id)); err != nil {
+			log.Printf("Error evicting transcoded rendition %s: %v", r.id, err)
+			continue
+		}
+		total -= r.size
+		log.Printf("Evicted transcoded rendition %s (%d bytes) from cache", r.id, r.size)
+	}
+}
+
+// renditionID identifies the rendition of a specific version of a file in a format
+func renditionID(fullPath string, info os.FileInfo, format models.TranscodeFormat) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d\x00%s", fullPath, info.Size(), info.ModTime().UnixNano(), format)))
+	return hex.EncodeToString(sum[:12])
+}
+
+// dirSize returns the total size of the regular files in a directory tree
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"media-server/models"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingTranscoder counts the transcodes run by a FakeTranscoder
+type countingTranscoder struct {
+	FakeTranscoder
+	runs atomic.Int32
+}
+
+func (ct *countingTranscoder) Transcode(ctx context.Context, input, outputDir string, format models.TranscodeFormat) error {
+	ct.runs.Add(1)
+	return ct.FakeTranscoder.Transcode(ctx, input, outputDir, format)
+}
+
+// newTranscodeTestService returns a TranscodeService with a single worker and a cache limited
+// to maxCacheSize bytes
+func newTranscodeTestService(t *testing.T, transcoder Transcoder, maxCacheSize int64) *TranscodeService {
+	t.Helper()
+
+	workerPool := NewWorkerPool("transcoding-test", 1, 8)
+	ts, err := NewTranscodeService(transcoder, workerPool, t.TempDir(), maxCacheSize)
+	if err != nil {
+		t.Fatalf("NewTranscodeService: %v", err)
+	}
+	t.Cleanup(ts.Stop)
+	return ts
+}
+
+// writeMediaFile creates a source file of size bytes
+func writeMediaFile(t *testing.T, name string, size int) string {
+	t.Helper()
+
+	fullPath := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(fullPath, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fullPath
+}
+
+// waitForDone requests a rendition until its job has finished
+func waitForDone(t *testing.T, ts *TranscodeService, fullPath string, format models.TranscodeFormat) models.TranscodeJob {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := ts.Request(fullPath, format)
+		if err != nil {
+			t.Fatalf("Request: %v", err)
+		}
+		if job.IsDone() {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("transcode of %s still %s", fullPath, job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTranscodeRequestRunsOneJobPerRendition(t *testing.T) {
+	transcoder := &countingTranscoder{FakeTranscoder: FakeTranscoder{Delay: 50 * time.Millisecond}}
+	ts := newTranscodeTestService(t, transcoder, 0)
+	input := writeMediaFile(t, "film.mkv", 1000)
+
+	first, err := ts.Request(input, models.TranscodeFormatMP4)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if first.Status != models.TranscodeQueued {
+		t.Errorf("first Request status = %s, want %s", first.Status, models.TranscodeQueued)
+	}
+
+	second, err := ts.Request(input, models.TranscodeFormatMP4)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if second.ID != first.ID || second.IsDone() {
+		t.Errorf("second Request = %s (%s), want pending job %s", second.ID, second.Status, first.ID)
+	}
+
+	job := waitForDone(t, ts, input, models.TranscodeFormatMP4)
+	if job.Status != models.TranscodeReady {
+		t.Fatalf("status = %s (%s), want %s", job.Status, job.Error, models.TranscodeReady)
+	}
+	if runs := transcoder.runs.Load(); runs != 1 {
+		t.Errorf("transcoder ran %d times, want 1", runs)
+	}
+
+	// Another format is another rendition
+	waitForDone(t, ts, input, models.TranscodeFormatHLS)
+	if runs := transcoder.runs.Load(); runs != 2 {
+		t.Errorf("transcoder ran %d times after an HLS request, want 2", runs)
+	}
+}
+
+func TestTranscodeStatusTransitions(t *testing.T) {
+	ts := newTranscodeTestService(t, &FakeTranscoder{Delay: 200 * time.Millisecond}, 0)
+	input := writeMediaFile(t, "film.mkv", 1000)
+
+	job, err := ts.Request(input, models.TranscodeFormatMP4)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if job.Status != models.TranscodeQueued {
+		t.Fatalf("status = %s, want %s", job.Status, models.TranscodeQueued)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for job.Status == models.TranscodeQueued && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		job, _ = ts.Request(input, models.TranscodeFormatMP4)
+	}
+	if job.Status != models.TranscodeRunning {
+		t.Fatalf("status = %s, want %s", job.Status, models.TranscodeRunning)
+	}
+
+	job = waitForDone(t, ts, input, models.TranscodeFormatMP4)
+	if job.Status != models.TranscodeReady {
+		t.Fatalf("status = %s (%s), want %s", job.Status, job.Error, models.TranscodeReady)
+	}
+	if job.Size != 1000 {
+		t.Errorf("rendition size = %d, want 1000", job.Size)
+	}
+	if _, err := os.Stat(ts.OutputPath(job, models.TranscodeMP4Name)); err != nil {
+		t.Errorf("rendition file: %v", err)
+	}
+	if _, err := os.Stat(job.OutputDir + partialSuffix); !os.IsNotExist(err) {
+		t.Errorf("partial directory left behind: %v", err)
+	}
+}
+
+func TestTranscodeRetriesAfterFailure(t *testing.T) {
+	transcoder := &countingTranscoder{FakeTranscoder: FakeTranscoder{Err: errors.New("decoder error")}}
+	ts := newTranscodeTestService(t, transcoder, 0)
+	input := writeMediaFile(t, "film.mkv", 1000)
+
+	job := waitForDone(t, ts, input, models.TranscodeFormatMP4)
+	if job.Status != models.TranscodeFailed || job.Error != "decoder error" {
+		t.Fatalf("status = %s (%q), want %s", job.Status, job.Error, models.TranscodeFailed)
+	}
+	if _, err := os.Stat(job.OutputDir); !os.IsNotExist(err) {
+		t.Errorf("failed rendition directory exists: %v", err)
+	}
+
+	// Failures are reported until the retry delay has passed
+	job, _ = ts.Request(input, models.TranscodeFormatMP4)
+	if job.Status != models.TranscodeFailed || transcoder.runs.Load() != 1 {
+		t.Fatalf("status = %s after %d runs, want %s without a retry", job.Status, transcoder.runs.Load(), models.TranscodeFailed)
+	}
+
+	transcoder.Err = nil
+	ts.mutex.Lock()
+	ts.jobs[job.ID].FinishedAt = time.Now().Add(-transcodeRetryDelay)
+	ts.mutex.Unlock()
+
+	job, err := ts.Request(input, models.TranscodeFormatMP4)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if job.Status != models.TranscodeQueued {
+		t.Errorf("status after the retry delay = %s, want %s", job.Status, models.TranscodeQueued)
+	}
+	job = waitForDone(t, ts, input, models.TranscodeFormatMP4)
+	if job.Status != models.TranscodeReady || job.Error != "" {
+		t.Errorf("status after retry = %s (%q), want %s", job.Status, job.Error, models.TranscodeReady)
+	}
+}
+
+func TestTranscodeCacheEviction(t *testing.T) {
+	tests := []struct {
+		name        string
+		inUse       bool
+		wantEvicted bool
+	}{
+		{"least recently used", false, true},
+		{"in use", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The cache holds one 1000-byte rendition
+			ts := newTranscodeTestService(t, &FakeTranscoder{}, 1500)
+			older := writeMediaFile(t, "older.mkv", 1000)
+			newer := writeMediaFile(t, "newer.mkv", 1000)
+
+			olderJob := waitForDone(t, ts, older, models.TranscodeFormatMP4)
+			if tt.inUse {
+				ts.Acquire(olderJob)
+			}
+			waitForDone(t, ts, newer, models.TranscodeFormatMP4)
+
+			_, err := os.Stat(olderJob.OutputDir)
+			if evicted := os.IsNotExist(err); evicted != tt.wantEvicted {
+				t.Errorf("older rendition evicted = %v, want %v", evicted, tt.wantEvicted)
+			}
+			if tt.wantEvicted {
+				job, err := ts.Request(older, models.TranscodeFormatMP4)
+				if err != nil || job.Status != models.TranscodeQueued {
+					t.Errorf("Request of an evicted rendition = %s, %v, want %s", job.Status, err, models.TranscodeQueued)
+				}
+				waitForDone(t, ts, older, models.TranscodeFormatMP4)
+			}
+
+			if tt.inUse {
+				ts.Release(olderJob)
+				ts.mutex.Lock()
+				inUse := len(ts.inUse)
+				ts.mutex.Unlock()
+				if inUse != 0 {
+					t.Errorf("%d renditions still in use after Release", inUse)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"media-server/models"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Transcoder converts a media file into a browser-compatible rendition. Implementations write
+// their output into outputDir, which exists and is empty when Transcode is called: the MP4
+// format produces models.TranscodeMP4Name, the HLS format produces models.TranscodePlaylistName
+// plus "segment-<n>.ts" files. Transcode must stop and return when ctx is cancelled.
+type Transcoder interface {
+	Name() string
+	Transcode(ctx context.Context, input, outputDir string, format models.TranscodeFormat) error
+}
+
+// hlsSegmentSeconds is the target HLS segment duration of transcoded renditions
+const hlsSegmentSeconds = 6
+
+// FFmpegTranscoder transcodes by running an ffmpeg process per job
+type FFmpegTranscoder struct {
+	binary string
+}
+
+// NewFFmpegTranscoder creates an ffmpeg-backed transcoder, resolving binary (a name or path) on PATH
+func NewFFmpegTranscoder(binary string) (*FFmpegTranscoder, error) {
+	if binary == "" {
+		binary = "ffmpeg"
+	}
+
+	resolved, err := exec.LookPath(binary)
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg not found: %w", err)
+	}
+
+	return &FFmpegTranscoder{binary: resolved}, nil
+}
+
+// Name implements Transcoder
+func (ft *FFmpegTranscoder) Name() string {
+	return "ffmpeg"
+}
+
+// Transcode implements Transcoder, re-encoding to H.264 video and stereo AAC audio
+func (ft *FFmpegTranscoder) Transcode(ctx context.Context, input, outputDir string, format models.TranscodeFormat) error {
+	args := []string{
+		"-nostdin", "-hide_banner", "-loglevel", "error",
+		"-i", input,
+		"-map", "0:v:0?", "-map", "0:a:0?",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "160k", "-ac", "2",
+	}
+
+	switch format {
+	case models.TranscodeFormatMP4:
+		args = append(args,
+			"-movflags", "+frag_keyframe+empty_moov+default_base_moof",
+			"-f", "mp4", filepath.Join(outputDir, models.TranscodeMP4Name))
+	case models.TranscodeFormatHLS:
+		args = append(args,
+			"-f", "hls",
+			"-hls_time", fmt.Sprint(hlsSegmentSeconds),
+			"-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(outputDir, "segment-%d.ts"),
+			filepath.Join(outputDir, models.TranscodePlaylistName))
+	default:
+		return fmt.Errorf("unsupported transcode format: %s", format)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ft.binary, args...)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if msg := lastLine(stderr.String()); msg != "" {
+			return fmt.Errorf("ffmpeg failed: %v: %s", err, msg)
+		}
+		return fmt.Errorf("ffmpeg failed: %v", err)
+	}
+
+	return nil
+}
+
+// lastLine returns the last non-empty line of s
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// FakeTranscoder is a Transcoder for tests and for development without ffmpeg (TRANSCODER=fake).
+// It copies the input unchanged into the expected output layout (a single HLS segment for the
+// HLS format), optionally after a delay or failing with a fixed error.
+type FakeTranscoder struct {
+	Delay time.Duration
+	Err   error
+}
+
+// Name implements Transcoder
+func (ft *FakeTranscoder) Name() string {
+	return "fake"
+}
+
+// Transcode implements Transcoder
+func (ft *FakeTranscoder) Transcode(ctx context.Context, input, outputDir string, format models.TranscodeFormat) error {
+	if ft.Delay > 0 {
+		timer := time.NewTimer(ft.Delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if ft.Err != nil {
+		return ft.Err
+	}
+
+	switch format {
+	case models.TranscodeFormatMP4:
+		return copyFile(input, filepath.Join(outputDir, models.TranscodeMP4Name))
+	case models.TranscodeFormatHLS:
+		if err := copyFile(input, filepath.Join(outputDir, "segment-0.ts")); err != nil {
+			return err
+		}
+		playlist := fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n"+
+			"#EXT-X-PLAYLIST-TYPE:VOD\n#EXTINF:%d.000,\nsegment-0.ts\n#EXT-X-ENDLIST\n", hlsSegmentSeconds, hlsSegmentSeconds)
+		return os.WriteFile(filepath.Join(outputDir, models.TranscodePlaylistName), []byte(playlist), 0644)
+	}
+	return fmt.Errorf("unsupported transcode format: %s", format)
+}
+
+// copyFile copies the contents of src into a new file dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Signed URL errors
+var (
+	ErrURLUnsigned         = errors.New("stream URL is not signed")
+	ErrURLExpired          = errors.New("stream URL has expired")
+	ErrURLInvalidSignature = errors.New("stream URL signature is invalid")
+	ErrURLBudgetExhausted  = errors.New("stream URL byte budget exhausted")
+)
+
+// URLSigner issues and verifies HMAC-signed, expiring stream URLs
+type URLSigner struct {
+	secret     []byte
+	ttl        time.Duration
+	bindIP     bool
+	byteBudget int64
+	usage      map[string]*signedURLUsage
+	lastPrune  time.Time
+	mutex      sync.Mutex
+}
+
+// signedURLUsage tracks the bytes served for one signed URL
+type signedURLUsage struct {
+	used      int64
+	expiresAt time.Time
+}
+
+// SignedURLGrant describes a verified signed URL
+type SignedURLGrant struct {
+	Key       string
+	ExpiresAt time.Time
+	Budget    int64 // bytes, 0 = unlimited
+}
+
+// NewURLSigner creates a new URLSigner. An empty secret generates a random one,
+// which invalidates all issued URLs when the server restarts.
+func NewURLSigner(secret string, ttl time.Duration, bindIP bool, byteBudget int64) *URLSigner {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("Failed to generate stream URL secret: %v", err)
+		}
+		log.Println("No stream URL secret configured, generated a random one (signed URLs will not survive restarts)")
+	}
+
+	return &URLSigner{
+		secret:     key,
+		ttl:        ttl,
+		bindIP:     bindIP,
+		byteBudget: byteBudget,
+		usage:      make(map[string]*signedURLUsage),
+		lastPrune:  time.Now(),
+	}
+}
+
+// SignStreamURL returns a signed URL for mediaPath under prefix (e.g. "/stream/") for the given
+// client. The signature is only valid on that route.
+func (us *URLSigner) SignStreamURL(prefix, mediaPath, clientIP string) string {
+	return prefix + (&url.URL{Path: mediaPath}).EscapedPath() + "?" + us.SignQuery(prefix, mediaPath, clientIP).Encode()
+}
+
+// SignQuery returns the query parameters signing mediaPath on route (e.g. "/stream/") for the
+// given client, for routes that address several resources of one media path
+func (us *URLSigner) SignQuery(route, mediaPath, clientIP string) url.Values {
+	expires := time.Now().Add(us.ttl).Unix()
+
+	boundIP := ""
+	if us.bindIP {
+		boundIP = clientIP
+	}
+
+	query := url.Values{}
+	query.Set("exp", strconv.FormatInt(expires, 10))
+	if us.byteBudget > 0 {
+		query.Set("budget", strconv.FormatInt(us.byteBudget, 10))
+	}
+	if us.bindIP {
+		query.Set("bind", "1")
+	}
+	query.Set("sig", us.sign(route, mediaPath, expires, boundIP, us.byteBudget))
+	return query
+}
+
+// SignatureParams returns the signature parameters of a signed URL's query, so that a verified
+// signature can be carried over to other resources of the same media path and route
+func SignatureParams(query url.Values) url.Values {
+	params := url.Values{}
+	for _, key := range []string{"exp", "budget", "bind", "sig"} {
+		if value := query.Get(key); value != "" {
+			params.Set(key, value)
+		}
+	}
+	return params
+}
+
+// Verify checks the signature carried in query for mediaPath requested on route by clientIP
+func (us *URLSigner) Verify(route, mediaPath string, query url.Values, clientIP string) (*SignedURLGrant, error) {
+	sig := query.Get("sig")
+	if sig == "" || query.Get("exp") == "" {
+		return nil, ErrURLUnsigned
+	}
+
+	expires, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil {
+		return nil, ErrURLInvalidSignature
+	}
+
+	var budget int64
+	if budgetStr := query.Get("budget"); budgetStr != "" {
+		budget, err = strconv.ParseInt(budgetStr, 10, 64)
+		if err != nil || budget < 0 {
+			return nil, ErrURLInvalidSignature
+		}
+	}
+
+	boundIP := ""
+	if query.Get("bind") == "1" {
+		boundIP = clientIP
+	}
+
+	expected := us.sign(route, mediaPath, expires, boundIP, budget)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return nil, ErrURLInvalidSignature
+	}
+
+	expiresAt := time.Unix(expires, 0)
+	if time.Now().After(expiresAt) {
+		return nil, ErrURLExpired
+	}
+
+	return &SignedURLGrant{
+		Key:       sig,
+		ExpiresAt: expiresAt,
+		Budget:    budget,
+	}, nil
+}
+
+// RemainingBudget returns the bytes still available for a grant, or -1 when it is unlimited
+func (us *URLSigner) RemainingBudget(grant *SignedURLGrant) int64 {
+	if grant.Budget <= 0 {
+		return -1
+	}
+
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	remaining := grant.Budget
+	if usage, exists := us.usage[grant.Key]; exists {
+		remaining -= usage.used
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining
+}
+
+// ConsumeBudget reserves up to n bytes from a grant's budget and returns how many may be sent
+func (us *URLSigner) ConsumeBudget(grant *SignedURLGrant, n int64) int64 {
+	if grant.Budget <= 0 {
+		return n
+	}
+
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	us.pruneUsage()
+
+	usage, exists := us.usage[grant.Key]
+	if !exists {
+		usage = &signedURLUsage{expiresAt: grant.ExpiresAt}
+		us.usage[grant.Key] = usage
+	}
+
+	allowed := grant.Budget - usage.used
+	if allowed > n {
+		allowed = n
+	}
+	if allowed < 0 {
+		allowed = 0
+	}
+	usage.used += allowed
+
+	return allowed
+}
+
+// sign computes the URL-safe HMAC-SHA256 signature of the signed fields
+func (us *URLSigner) sign(route, mediaPath string, expires int64, clientIP string, budget int64) string {
+	mac := hmac.New(sha256.New, us.secret)
+	fmt.Fprintf(mac, "v2\n%s\n%s\n%d\n%s\n%d", route, mediaPath, expires, clientIP, budget)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// pruneUsage drops usage records of expired URLs at most once a minute; callers must hold the mutex
+func (us *URLSigner) pruneUsage() {
+	now := time.Now()
+	if now.Sub(us.lastPrune) < time.Minute {
+		return
+	}
+	us.lastPrune = now
+
+	for key, usage := range us.usage {
+		if now.After(usage.expiresAt) {
+			delete(us.usage, key)
+		}
+	}
+}
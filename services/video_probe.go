@@ -0,0 +1,742 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"media-server/models"
+	"os"
+	"strings"
+)
+
+// maxProbeElementSize caps the size of a container element (MP4 box, Matroska master element)
+// read into memory while probing
+const maxProbeElementSize = 16 << 20
+
+// Matroska element IDs
+const (
+	ebmlIDHeader          = 0x1A45DFA3
+	ebmlIDDocType         = 0x4282
+	mkvIDSegment          = 0x18538067
+	mkvIDSeekHead         = 0x114D9B74
+	mkvIDSeek             = 0x4DBB
+	mkvIDSeekID           = 0x53AB
+	mkvIDSeekPosition     = 0x53AC
+	mkvIDInfo             = 0x1549A966
+	mkvIDTimestampScale   = 0x2AD7B1
+	mkvIDDuration         = 0x4489
+	mkvIDTracks           = 0x1654AE6B
+	mkvIDTrackEntry       = 0xAE
+	mkvIDTrackType        = 0x83
+	mkvIDCodecID          = 0x86
+	mkvIDLanguage         = 0x22B59C
+	mkvIDLanguageBCP47    = 0x22B59D
+	mkvIDName             = 0x536E
+	mkvIDFlagDefault      = 0x88
+	mkvIDFlagForced       = 0x55AA
+	mkvIDDefaultDuration  = 0x23E383
+	mkvIDVideo            = 0xE0
+	mkvIDPixelWidth       = 0xB0
+	mkvIDPixelHeight      = 0xBA
+	mkvIDAudio            = 0xE1
+	mkvIDSamplingFreq     = 0xB5
+	mkvIDChannels         = 0x9F
+	mkvIDCluster          = 0x1F43B675
+	mkvTrackTypeVideo     = 1
+	mkvTrackTypeAudio     = 2
+	mkvTrackTypeSubtitles = 17
+)
+
+// ProbeVideo reads the duration, frame size, frame rate, codecs and tracks of an MP4/QuickTime
+// or Matroska/WebM file from its container headers
+func ProbeVideo(fullPath string) (*models.VideoInfo, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &models.VideoInfo{
+		Path:    fullPath,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	}
+
+	head := make([]byte, 8)
+	if _, err := file.ReadAt(head, 0); err != nil {
+		return nil, fmt.Errorf("error reading container header: %w", err)
+	}
+
+	switch {
+	case binary.BigEndian.Uint32(head) == ebmlIDHeader:
+		err = probeMatroska(file, stat.Size(), info)
+	case isMP4BoxType(string(head[4:8])):
+		err = probeMP4(file, stat.Size(), info)
+	default:
+		err = fmt.Errorf("unrecognized video container")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// cachedVideoInfo returns the container metadata of a video from cacheService (which may be
+// nil) or the file, or nil when it cannot be read
+func cachedVideoInfo(cacheService *CacheService, fullPath string) *models.VideoInfo {
+	stat, err := os.Stat(fullPath)
+	if err != nil {
+		return nil
+	}
+
+	if cacheService != nil {
+		if info, found := cacheService.GetVideoInfo(fullPath); found && info.IsValidFor(stat.Size(), stat.ModTime()) {
+			return info
+		}
+	}
+
+	info, err := ProbeVideo(fullPath)
+	if err != nil {
+		log.Printf("Error probing video %s: %v", fullPath, err)
+		return nil
+	}
+
+	if cacheService != nil {
+		cacheService.SetVideoInfo(fullPath, info)
+	}
+	return info
+}
+
+// isMP4BoxType checks if a file starts with a box that opens MP4 and QuickTime files
+func isMP4BoxType(boxType string) bool {
+	switch boxType {
+	case "ftyp", "moov", "mdat", "free", "skip", "wide", "pnot":
+		return true
+	}
+	return false
+}
+
+// mp4Track collects the properties of an MP4 track while its boxes are walked
+type mp4Track struct {
+	handler     string
+	codec       string
+	timescale   uint32
+	duration    uint64
+	language    string
+	width       int
+	height      int
+	rotated     bool
+	channels    int
+	sampleRate  int
+	sampleCount uint32
+}
+
+// probeMP4 reads the movie header and the tracks of an MP4 or QuickTime file
+func probeMP4(file io.ReaderAt, size int64, info *models.VideoInfo) error {
+	info.Container = "mp4"
+
+	moovStart, moovEnd := int64(-1), int64(-1)
+	err := mp4Children(file, 0, size, func(boxType string, start, end int64) error {
+		if boxType == "moov" {
+			moovStart, moovEnd = start, end
+			return errStopWalk
+		}
+		return nil
+	})
+	if err != nil && err != errStopWalk {
+		return err
+	}
+	if moovStart < 0 {
+		return fmt.Errorf("no moov box")
+	}
+
+	var movieTimescale uint32
+	var longestTrack float64
+	err = mp4Children(file, moovStart, moovEnd, func(boxType string, start, end int64) error {
+		switch boxType {
+		case "mvhd":
+			body, err := readBoxBody(file, start, end, 128)
+			if err != nil {
+				return err
+			}
+			timescale, duration, ok := mp4HeaderDuration(body, 12, 20)
+			if !ok {
+				return nil
+			}
+			movieTimescale = timescale
+			if duration > 0 {
+				info.Duration = float64(duration) / float64(timescale)
+			}
+		case "mvex":
+			// Fragmented files may only record their duration in the movie extends header
+			return mp4Children(file, start, end, func(childType string, start, end int64) error {
+				if childType != "mehd" || info.Duration > 0 || movieTimescale == 0 {
+					return nil
+				}
+				body, err := readBoxBody(file, start, end, 16)
+				if err != nil {
+					return err
+				}
+				var duration uint64
+				if len(body) >= 12 && body[0] == 1 {
+					duration = binary.BigEndian.Uint64(body[4:12])
+				} else if len(body) >= 8 {
+					duration = uint64(binary.BigEndian.Uint32(body[4:8]))
+				}
+				info.Duration = float64(duration) / float64(movieTimescale)
+				return nil
+			})
+		case "trak":
+			track := &mp4Track{}
+			if err := probeMP4Track(file, start, end, track); err != nil {
+				return err
+			}
+			if track.timescale > 0 {
+				longestTrack = max(longestTrack, float64(track.duration)/float64(track.timescale))
+			}
+			addMP4Track(info, track)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if info.Duration <= 0 {
+		info.Duration = longestTrack
+	}
+	return nil
+}
+
+// probeMP4Track walks the boxes of a trak box
+func probeMP4Track(file io.ReaderAt, start, end int64, track *mp4Track) error {
+	return mp4Children(file, start, end, func(boxType string, start, end int64) error {
+		switch boxType {
+		case "mdia", "minf", "stbl":
+			return probeMP4Track(file, start, end, track)
+		case "tkhd":
+			body, err := readBoxBody(file, start, end, 128)
+			if err != nil {
+				return err
+			}
+			// Presentation size as 16.16 fixed point after the transformation matrix
+			matrix, sizeOffset := 40, 76
+			if len(body) > 0 && body[0] == 1 {
+				matrix, sizeOffset = 52, 88
+			}
+			if len(body) >= sizeOffset+8 {
+				track.width = int(binary.BigEndian.Uint32(body[sizeOffset:]) >> 16)
+				track.height = int(binary.BigEndian.Uint32(body[sizeOffset+4:]) >> 16)
+				// A matrix with a zero first coefficient rotates the frame by 90 or 270 degrees
+				track.rotated = binary.BigEndian.Uint32(body[matrix:]) == 0 && binary.BigEndian.Uint32(body[matrix+4:]) != 0
+			}
+		case "mdhd":
+			body, err := readBoxBody(file, start, end, 64)
+			if err != nil {
+				return err
+			}
+			timescale, duration, ok := mp4HeaderDuration(body, 12, 20)
+			if !ok {
+				return nil
+			}
+			track.timescale, track.duration = timescale, duration
+			languageOffset := 20
+			if body[0] == 1 {
+				languageOffset = 32
+			}
+			if len(body) >= languageOffset+2 {
+				track.language = mp4Language(binary.BigEndian.Uint16(body[languageOffset:]))
+			}
+		case "hdlr":
+			body, err := readBoxBody(file, start, end, 64)
+			if err != nil {
+				return err
+			}
+			if len(body) >= 12 {
+				track.handler = string(body[8:12])
+			}
+		case "stsd":
+			body, err := readBoxBody(file, start, end, 512)
+			if err != nil {
+				return err
+			}
+			parseMP4SampleEntry(body, track)
+		case "stsz", "stz2":
+			body, err := readBoxBody(file, start, end, 12)
+			if err != nil {
+				return err
+			}
+			if len(body) >= 12 {
+				track.sampleCount = binary.BigEndian.Uint32(body[8:12])
+			}
+		}
+		return nil
+	})
+}
+
+// parseMP4SampleEntry reads the codec and basic stream properties from the first entry of an
+// stsd box
+func parseMP4SampleEntry(body []byte, track *mp4Track) {
+	// version/flags, entry count, then the first entry's size and type
+	if len(body) < 16 {
+		return
+	}
+	track.codec = string(body[12:16])
+	entry := body[8:]
+
+	switch track.handler {
+	case "vide":
+		// The coded size is only used when the track header has no presentation size
+		if len(entry) >= 36 && (track.width == 0 || track.height == 0) {
+			track.width = int(binary.BigEndian.Uint16(entry[32:34]))
+			track.height = int(binary.BigEndian.Uint16(entry[34:36]))
+		}
+	case "soun":
+		// Version 2 QuickTime entries store these fields elsewhere
+		if len(entry) >= 36 && binary.BigEndian.Uint16(entry[16:18]) < 2 {
+			track.channels = int(binary.BigEndian.Uint16(entry[24:26]))
+			track.sampleRate = int(binary.BigEndian.Uint32(entry[32:36]) >> 16)
+		}
+	}
+}
+
+// addMP4Track adds a walked track to the video metadata
+func addMP4Track(info *models.VideoInfo, track *mp4Track) {
+	switch track.handler {
+	case "vide":
+		if info.VideoCodec != "" {
+			return // cover art, preview or alternate angle
+		}
+		info.VideoCodec = models.CodecName(track.codec)
+		info.Width, info.Height = track.width, track.height
+		if track.rotated {
+			info.Width, info.Height = track.height, track.width
+		}
+		if track.duration > 0 && track.sampleCount > 1 {
+			info.FrameRate = roundFrameRate(float64(track.sampleCount) * float64(track.timescale) / float64(track.duration))
+		}
+	case "soun":
+		info.AudioTracks = append(info.AudioTracks, models.MediaTrack{
+			Codec:      models.CodecName(track.codec),
+			Language:   track.language,
+			Channels:   track.channels,
+			SampleRate: track.sampleRate,
+			Default:    len(info.AudioTracks) == 0,
+		})
+		if info.AudioCodec == "" {
+			info.AudioCodec = models.CodecName(track.codec)
+		}
+	case "sbtl", "subt", "text", "clcp":
+		info.SubtitleTracks = append(info.SubtitleTracks, models.MediaTrack{
+			Codec:    models.CodecName(track.codec),
+			Language: track.language,
+		})
+	}
+}
+
+// mp4HeaderDuration reads the timescale and duration of an mvhd or mdhd box; the timescale is
+// at v0Timescale in version 0 boxes and at v1Timescale in version 1 boxes, whose times are 64-bit
+func mp4HeaderDuration(body []byte, v0Timescale, v1Timescale int) (uint32, uint64, bool) {
+	if len(body) < 4 {
+		return 0, 0, false
+	}
+	if body[0] == 1 {
+		if len(body) < v1Timescale+12 {
+			return 0, 0, false
+		}
+		timescale := binary.BigEndian.Uint32(body[v1Timescale:])
+		duration := binary.BigEndian.Uint64(body[v1Timescale+4:])
+		if duration == math.MaxUint64 {
+			duration = 0
+		}
+		return timescale, duration, timescale > 0
+	}
+	if len(body) < v0Timescale+8 {
+		return 0, 0, false
+	}
+	timescale := binary.BigEndian.Uint32(body[v0Timescale:])
+	duration := uint64(binary.BigEndian.Uint32(body[v0Timescale+4:]))
+	if duration == math.MaxUint32 {
+		duration = 0
+	}
+	return timescale, duration, timescale > 0
+}
+
+// mp4Language decodes the packed ISO 639-2 language code of an mdhd box; "und" and QuickTime's
+// numeric Macintosh language codes yield ""
+func mp4Language(packed uint16) string {
+	if packed < 0x400 || packed == 0x55C4 {
+		return ""
+	}
+	code := []byte{
+		byte(packed>>10&0x1F) + 0x60,
+		byte(packed>>5&0x1F) + 0x60,
+		byte(packed&0x1F) + 0x60,
+	}
+	return string(code)
+}
+
+// readBoxBody reads up to limit bytes of a box body
+func readBoxBody(file io.ReaderAt, start, end int64, limit int64) ([]byte, error) {
+	body := make([]byte, min(end-start, limit))
+	if _, err := file.ReadAt(body, start); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return body, nil
+}
+
+// roundFrameRate rounds a measured frame rate to two decimals
+func roundFrameRate(rate float64) float64 {
+	return math.Round(rate*100) / 100
+}
+
+// probeMatroska reads the Info and Tracks elements of a Matroska or WebM file. They normally
+// precede the first cluster; otherwise they are located through the SeekHead.
+func probeMatroska(file io.ReaderAt, size int64, info *models.VideoInfo) error {
+	info.Container = "matroska"
+
+	id, headerSize, bodySize, err := readEBMLElementHeader(file, 0)
+	if err != nil || id != ebmlIDHeader || bodySize < 0 {
+		return fmt.Errorf("invalid EBML header")
+	}
+	header, err := readBoxBody(file, headerSize, headerSize+bodySize, 4096)
+	if err != nil {
+		return err
+	}
+	ebmlChildren(header, func(id uint32, body []byte) {
+		if id == ebmlIDDocType && ebmlString(body) == "webm" {
+			info.Container = "webm"
+		}
+	})
+
+	offset := headerSize + bodySize
+	id, headerSize, bodySize, err = readEBMLElementHeader(file, offset)
+	if err != nil || id != mkvIDSegment {
+		return fmt.Errorf("no Matroska segment")
+	}
+	segmentStart := offset + headerSize
+	segmentEnd := size
+	if bodySize >= 0 && segmentStart+bodySize < size {
+		segmentEnd = segmentStart + bodySize
+	}
+
+	var infoBody, tracksBody []byte
+	seeks := make(map[uint32]int64)
+
+scan:
+	for position := segmentStart; position < segmentEnd && (infoBody == nil || tracksBody == nil); {
+		id, headerSize, bodySize, err := readEBMLElementHeader(file, position)
+		if err != nil || bodySize < 0 {
+			break
+		}
+
+		switch id {
+		case mkvIDSeekHead, mkvIDInfo, mkvIDTracks:
+			if bodySize > maxProbeElementSize {
+				break
+			}
+			body := make([]byte, bodySize)
+			if _, err := file.ReadAt(body, position+headerSize); err != nil {
+				return err
+			}
+			switch id {
+			case mkvIDSeekHead:
+				parseMatroskaSeekHead(body, seeks)
+			case mkvIDInfo:
+				infoBody = body
+			case mkvIDTracks:
+				tracksBody = body
+			}
+		case mkvIDCluster:
+			break scan
+		}
+		position += headerSize + bodySize
+	}
+
+	// Follow the SeekHead for elements written after the clusters
+	for _, target := range []struct {
+		id   uint32
+		body *[]byte
+	}{{mkvIDInfo, &infoBody}, {mkvIDTracks, &tracksBody}} {
+		position, ok := seeks[target.id]
+		if *target.body != nil || !ok {
+			continue
+		}
+		id, headerSize, bodySize, err := readEBMLElementHeader(file, segmentStart+position)
+		if err != nil || id != target.id || bodySize < 0 || bodySize > maxProbeElementSize {
+			continue
+		}
+		body := make([]byte, bodySize)
+		if _, err := file.ReadAt(body, segmentStart+position+headerSize); err != nil {
+			return err
+		}
+		*target.body = body
+	}
+
+	if infoBody == nil && tracksBody == nil {
+		return fmt.Errorf("no Matroska Info or Tracks element")
+	}
+	parseMatroskaInfo(infoBody, info)
+	ebmlChildren(tracksBody, func(id uint32, body []byte) {
+		if id == mkvIDTrackEntry {
+			parseMatroskaTrack(body, info)
+		}
+	})
+	return nil
+}
+
+// parseMatroskaSeekHead records the segment-relative positions of top-level elements
+func parseMatroskaSeekHead(data []byte, seeks map[uint32]int64) {
+	ebmlChildren(data, func(id uint32, body []byte) {
+		if id != mkvIDSeek {
+			return
+		}
+		var target uint32
+		position := int64(-1)
+		ebmlChildren(body, func(id uint32, body []byte) {
+			switch id {
+			case mkvIDSeekID:
+				target = uint32(ebmlUint(body))
+			case mkvIDSeekPosition:
+				position = int64(ebmlUint(body))
+			}
+		})
+		if _, seen := seeks[target]; target != 0 && position >= 0 && !seen {
+			seeks[target] = position
+		}
+	})
+}
+
+// parseMatroskaInfo reads the segment duration
+func parseMatroskaInfo(data []byte, info *models.VideoInfo) {
+	timestampScale := uint64(1000000)
+	var duration float64
+	ebmlChildren(data, func(id uint32, body []byte) {
+		switch id {
+		case mkvIDTimestampScale:
+			if scale := ebmlUint(body); scale > 0 {
+				timestampScale = scale
+			}
+		case mkvIDDuration:
+			duration = ebmlFloat(body)
+		}
+	})
+	if duration > 0 && !math.IsInf(duration, 0) {
+		info.Duration = duration * float64(timestampScale) / 1e9
+	}
+}
+
+// parseMatroskaTrack adds a TrackEntry to the video metadata
+func parseMatroskaTrack(data []byte, info *models.VideoInfo) {
+	var trackType, defaultDuration, width, height, channels uint64
+	var codec, name, bcp47 string
+	var samplingFrequency float64
+	language, flagDefault, forced := "eng", true, false
+
+	ebmlChildren(data, func(id uint32, body []byte) {
+		switch id {
+		case mkvIDTrackType:
+			trackType = ebmlUint(body)
+		case mkvIDCodecID:
+			codec = ebmlString(body)
+		case mkvIDLanguage:
+			language = ebmlString(body)
+		case mkvIDLanguageBCP47:
+			bcp47 = ebmlString(body)
+		case mkvIDName:
+			name = ebmlString(body)
+		case mkvIDFlagDefault:
+			flagDefault = ebmlUint(body) != 0
+		case mkvIDFlagForced:
+			forced = ebmlUint(body) != 0
+		case mkvIDDefaultDuration:
+			defaultDuration = ebmlUint(body)
+		case mkvIDVideo:
+			ebmlChildren(body, func(id uint32, body []byte) {
+				switch id {
+				case mkvIDPixelWidth:
+					width = ebmlUint(body)
+				case mkvIDPixelHeight:
+					height = ebmlUint(body)
+				}
+			})
+		case mkvIDAudio:
+			channels = 1
+			ebmlChildren(body, func(id uint32, body []byte) {
+				switch id {
+				case mkvIDSamplingFreq:
+					samplingFrequency = ebmlFloat(body)
+				case mkvIDChannels:
+					channels = ebmlUint(body)
+				}
+			})
+		}
+	})
+
+	if bcp47 != "" {
+		language = bcp47
+	}
+	if language == "und" {
+		language = ""
+	}
+
+	switch trackType {
+	case mkvTrackTypeVideo:
+		if info.VideoCodec != "" {
+			return
+		}
+		info.VideoCodec = models.CodecName(codec)
+		info.Width, info.Height = int(width), int(height)
+		if defaultDuration > 0 {
+			info.FrameRate = roundFrameRate(1e9 / float64(defaultDuration))
+		}
+	case mkvTrackTypeAudio:
+		info.AudioTracks = append(info.AudioTracks, models.MediaTrack{
+			Codec:      models.CodecName(codec),
+			Language:   language,
+			Name:       name,
+			Channels:   int(channels),
+			SampleRate: int(samplingFrequency),
+			Default:    flagDefault,
+		})
+		if info.AudioCodec == "" {
+			info.AudioCodec = models.CodecName(codec)
+		}
+	case mkvTrackTypeSubtitles:
+		info.SubtitleTracks = append(info.SubtitleTracks, models.MediaTrack{
+			Codec:    models.CodecName(codec),
+			Language: language,
+			Name:     name,
+			Default:  flagDefault,
+			Forced:   forced,
+		})
+	}
+}
+
+// readEBMLElementHeader reads the ID and size of the EBML element at offset. The size is -1 for
+// elements of unknown size.
+func readEBMLElementHeader(file io.ReaderAt, offset int64) (id uint32, headerSize, bodySize int64, err error) {
+	buf := make([]byte, 12)
+	n, err := file.ReadAt(buf, offset)
+	if err != nil && (err != io.EOF || n == 0) {
+		return 0, 0, 0, err
+	}
+
+	id, idSize, ok := ebmlID(buf[:n])
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid EBML element ID")
+	}
+	size, sizeSize, ok := ebmlSize(buf[idSize:n])
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid EBML element size")
+	}
+	return id, int64(idSize + sizeSize), size, nil
+}
+
+// ebmlChildren calls fn for every element in data; an element of unknown size extends to the
+// end of data
+func ebmlChildren(data []byte, fn func(id uint32, body []byte)) {
+	for len(data) > 0 {
+		id, idSize, ok := ebmlID(data)
+		if !ok {
+			return
+		}
+		size, sizeSize, ok := ebmlSize(data[idSize:])
+		if !ok {
+			return
+		}
+		start := idSize + sizeSize
+		end := int64(len(data))
+		if size >= 0 && size <= end-int64(start) {
+			end = int64(start) + size
+		} else if size >= 0 {
+			return // truncated element
+		}
+		fn(id, data[start:end])
+		data = data[end:]
+	}
+}
+
+// ebmlID decodes an element ID, which keeps its length marker bits
+func ebmlID(data []byte) (uint32, int, bool) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0, false
+	}
+	length := 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 4 || len(data) < length {
+		return 0, 0, false
+	}
+	var id uint32
+	for _, b := range data[:length] {
+		id = id<<8 | uint32(b)
+	}
+	return id, length, true
+}
+
+// ebmlSize decodes an element size; a size with all value bits set means "unknown" (-1)
+func ebmlSize(data []byte) (int64, int, bool) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0, false
+	}
+	length := 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if len(data) < length {
+		return 0, 0, false
+	}
+
+	value := uint64(data[0] & (0xFF >> length))
+	allOnes := value == uint64(0xFF>>length)
+	for _, b := range data[1:length] {
+		value = value<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+	if allOnes {
+		return -1, length, true
+	}
+	if value > math.MaxInt64 {
+		return 0, 0, false
+	}
+	return int64(value), length, true
+}
+
+// ebmlUint decodes an unsigned integer element
+func ebmlUint(body []byte) uint64 {
+	var value uint64
+	for _, b := range body[:min(len(body), 8)] {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+// ebmlFloat decodes a 4- or 8-byte float element
+func ebmlFloat(body []byte) float64 {
+	switch len(body) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(body)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(body))
+	}
+	return 0
+}
+
+// ebmlString decodes a string element, which may be padded with null bytes
+func ebmlString(body []byte) string {
+	if i := bytes.IndexByte(body, 0); i >= 0 {
+		body = body[:i]
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,427 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"media-server/models"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch modes
+const (
+	WatchModeAuto = "auto" // inotify, falling back to polling where it cannot be set up
+	WatchModePoll = "poll"
+	WatchModeOff  = "off"
+)
+
+// folderSyncInterval is how often the watched folders are matched against the active folders
+const folderSyncInterval = 10 * time.Second
+
+// maxDebounceDelay bounds, in debounce periods, how long a steady stream of events can postpone
+// processing, so that a long copy shows up in batches as it progresses
+const maxDebounceDelay = 10
+
+// WatchService watches the active media folders for changes, keeping the media index, the
+// folder statistics and the directory listing cache up to date without a full rescan. Events
+// are coalesced until the folder has been quiet for the debounce period.
+type WatchService struct {
+	mediaFolderService *MediaFolderService
+	cacheService       *CacheService
+	mediaIndex         *MediaIndexService
+	mode               string
+	debounce           time.Duration
+	pollInterval       time.Duration
+
+	watchers map[string]*folderWatcher // by folder ID
+	mutex    sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewWatchService creates a new WatchService. mediaIndex may be nil.
+func NewWatchService(mediaFolderService *MediaFolderService, cacheService *CacheService, mediaIndex *MediaIndexService,
+	mode string, debounce, pollInterval time.Duration) *WatchService {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &WatchService{
+		mediaFolderService: mediaFolderService,
+		cacheService:       cacheService,
+		mediaIndex:         mediaIndex,
+		mode:               mode,
+		debounce:           debounce,
+		pollInterval:       pollInterval,
+		watchers:           make(map[string]*folderWatcher),
+		ctx:                ctx,
+		cancel:             cancel,
+	}
+}
+
+// StartWatching watches the active media folders, following folders as they are added, removed
+// or toggled, until Stop is called
+func (ws *WatchService) StartWatching() {
+	ticker := time.NewTicker(folderSyncInterval)
+	defer ticker.Stop()
+
+	ws.syncFolders()
+	for {
+		select {
+		case <-ws.ctx.Done():
+			ws.mutex.Lock()
+			for id, watcher := range ws.watchers {
+				watcher.stop()
+				delete(ws.watchers, id)
+			}
+			ws.mutex.Unlock()
+			return
+		case <-ticker.C:
+			ws.syncFolders()
+		}
+	}
+}
+
+// Stop stops watching
+func (ws *WatchService) Stop() {
+	ws.cancel()
+}
+
+// syncFolders starts watchers for newly active folders and stops those of inactive ones
+func (ws *WatchService) syncFolders() {
+	active := make(map[string]*models.MediaFolder)
+	for _, folder := range ws.mediaFolderService.GetActiveFolders() {
+		active[folder.ID] = folder
+	}
+
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	for id, watcher := range ws.watchers {
+		if active[id] == nil {
+			watcher.stop()
+			delete(ws.watchers, id)
+		}
+	}
+
+	for id, folder := range active {
+		if ws.watchers[id] != nil {
+			continue
+		}
+		watcher, err := ws.watchFolder(folder)
+		if err != nil {
+			log.Printf("Error watching folder '%s': %v", folder.Name, err)
+			continue
+		}
+		ws.watchers[id] = watcher
+	}
+}
+
+// fileState is the last seen size and modification time of a file
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// folderWatcher tracks the files of one media folder. Its state is only touched by its own
+// goroutine.
+type folderWatcher struct {
+	service  *WatchService
+	folderID string
+	name     string
+	root     string
+	watcher  *fsnotify.Watcher // nil when polling
+
+	files      map[string]fileState // by slash-separated path relative to root
+	typeCounts map[string]int
+	pending    map[string]bool // full paths with unprocessed events
+
+	done chan struct{}
+}
+
+// folderChanges are the effects of processing a batch of events
+type folderChanges struct {
+	updated   []*models.IndexedFile
+	removed   []string
+	fileDelta int
+	sizeDelta int64
+	paths     map[string]bool // paths whose cached file info or listing is stale
+}
+
+// watchFolder takes a snapshot of a folder and starts watching it
+func (ws *WatchService) watchFolder(folder *models.MediaFolder) (*folderWatcher, error) {
+	root, err := folder.GetAbsolutePath()
+	if err != nil {
+		return nil, err
+	}
+
+	fw := &folderWatcher{
+		service:    ws,
+		folderID:   folder.ID,
+		name:       folder.Name,
+		root:       root,
+		files:      make(map[string]fileState),
+		typeCounts: make(map[string]int),
+		pending:    make(map[string]bool),
+		done:       make(chan struct{}),
+	}
+
+	if ws.mode != WatchModePoll {
+		if fw.watcher, err = fsnotify.NewWatcher(); err != nil {
+			log.Printf("Filesystem events unavailable for '%s', polling instead: %v", folder.Name, err)
+		}
+	}
+
+	// The snapshot walk also registers every directory with the watcher
+	fw.reconcile([]string{root})
+	if fw.watcher != nil {
+		log.Printf("Watching folder '%s' for changes", folder.Name)
+	} else {
+		log.Printf("Polling folder '%s' for changes every %v", folder.Name, ws.pollInterval)
+	}
+
+	go fw.run()
+	return fw, nil
+}
+
+// stop stops the watcher's goroutine
+func (fw *folderWatcher) stop() {
+	close(fw.done)
+}
+
+// run processes events (or polls) until the watcher is stopped
+func (fw *folderWatcher) run() {
+	var events <-chan fsnotify.Event
+	var watchErrors <-chan error
+	if fw.watcher != nil {
+		events = fw.watcher.Events
+		watchErrors = fw.watcher.Errors
+	}
+	defer func() {
+		if fw.watcher != nil {
+			fw.watcher.Close()
+		}
+	}()
+
+	pollTicker := time.NewTicker(fw.service.pollInterval)
+	defer pollTicker.Stop()
+	var poll <-chan time.Time
+	if fw.watcher == nil {
+		poll = pollTicker.C
+	}
+
+	debounce := time.NewTimer(fw.service.debounce)
+	debounce.Stop()
+	defer debounce.Stop()
+	var firstEvent time.Time
+
+	for {
+		select {
+		case <-fw.done:
+			return
+
+		case event, ok := <-events:
+			if !ok {
+				// The watcher was closed after falling back to polling
+				events, watchErrors, poll = nil, nil, pollTicker.C
+				continue
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			fw.pending[event.Name] = true
+
+			// Restart the quiet period, unless events have been postponing it for too long
+			if firstEvent.IsZero() {
+				firstEvent = time.Now()
+			}
+			wait := fw.service.debounce
+			if time.Since(firstEvent) >= maxDebounceDelay*fw.service.debounce {
+				wait = 0
+			}
+			debounce.Reset(wait)
+
+		case err, ok := <-watchErrors:
+			if !ok {
+				events, watchErrors, poll = nil, nil, pollTicker.C
+				continue
+			}
+			log.Printf("Error watching folder '%s': %v", fw.name, err)
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				// Events were lost; compare the whole folder
+				fw.pending[fw.root] = true
+				debounce.Reset(fw.service.debounce)
+			}
+
+		case <-debounce.C:
+			paths := make([]string, 0, len(fw.pending))
+			for p := range fw.pending {
+				paths = append(paths, p)
+			}
+			fw.pending = make(map[string]bool)
+			firstEvent = time.Time{}
+			fw.apply(fw.reconcile(paths))
+
+		case <-poll:
+			fw.apply(fw.reconcile([]string{fw.root}))
+		}
+	}
+}
+
+// reconcile compares the files at or under each of the full paths with the snapshot, updating
+// the snapshot and returning the differences
+func (fw *folderWatcher) reconcile(paths []string) *folderChanges {
+	changes := &folderChanges{paths: make(map[string]bool)}
+
+	for _, fullPath := range paths {
+		rel, err := filepath.Rel(fw.root, fullPath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		changes.paths[rel] = true
+		changes.paths[path.Dir(rel)] = true
+
+		seen := make(map[string]bool)
+		filepath.WalkDir(fullPath, func(walkPath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				// New directories need a watch of their own
+				if fw.watcher != nil {
+					if err := fw.watcher.Add(walkPath); err != nil {
+						fw.fallBackToPolling(err)
+					}
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+
+			fileRel, err := filepath.Rel(fw.root, walkPath)
+			if err != nil {
+				return nil
+			}
+			fileRel = filepath.ToSlash(fileRel)
+			seen[fileRel] = true
+			fw.update(fileRel, info, changes)
+			return nil
+		})
+
+		// Whatever was under the path and was not found again is gone
+		prefix := rel + "/"
+		for fileRel := range fw.files {
+			if !seen[fileRel] && (rel == "." || fileRel == rel || strings.HasPrefix(fileRel, prefix)) {
+				fw.remove(fileRel, changes)
+			}
+		}
+	}
+
+	return changes
+}
+
+// fallBackToPolling switches a folder whose directories cannot all be watched (e.g. when the
+// inotify watch limit is reached) to polling
+func (fw *folderWatcher) fallBackToPolling(err error) {
+	log.Printf("Cannot watch folder '%s' for changes, polling every %v instead: %v", fw.name, fw.service.pollInterval, err)
+	fw.watcher.Close()
+	fw.watcher = nil
+}
+
+// update records the current state of a file
+func (fw *folderWatcher) update(rel string, info fs.FileInfo, changes *folderChanges) {
+	state := fileState{size: info.Size(), modTime: info.ModTime()}
+	old, existed := fw.files[rel]
+	if existed && old.size == state.size && old.modTime.Equal(state.modTime) {
+		return
+	}
+	fw.files[rel] = state
+
+	ext := path.Ext(rel)
+	if existed {
+		changes.sizeDelta += state.size - old.size
+		changes.paths[rel] = true
+	} else {
+		changes.fileDelta++
+		changes.sizeDelta += state.size
+		if models.IsMediaFile(ext) {
+			fw.typeCounts[models.GetMediaType(ext)]++
+		}
+	}
+	changes.paths[path.Dir(rel)] = true
+
+	if models.IsMediaFile(ext) && !isHiddenPath(rel) {
+		changes.updated = append(changes.updated, &models.IndexedFile{
+			Path:      rel,
+			Size:      state.size,
+			ModTime:   state.modTime,
+			MediaType: models.GetMediaType(ext),
+		})
+	}
+}
+
+// remove forgets a file that no longer exists
+func (fw *folderWatcher) remove(rel string, changes *folderChanges) {
+	old := fw.files[rel]
+	delete(fw.files, rel)
+
+	changes.fileDelta--
+	changes.sizeDelta -= old.size
+	changes.paths[rel] = true
+	changes.paths[path.Dir(rel)] = true
+
+	ext := path.Ext(rel)
+	if models.IsMediaFile(ext) {
+		mediaType := models.GetMediaType(ext)
+		if fw.typeCounts[mediaType]--; fw.typeCounts[mediaType] <= 0 {
+			delete(fw.typeCounts, mediaType)
+		}
+		if !isHiddenPath(rel) {
+			changes.removed = append(changes.removed, rel)
+		}
+	}
+}
+
+// apply brings the folder statistics, the media index and the cache in line with a batch of
+// changes
+func (fw *folderWatcher) apply(changes *folderChanges) {
+	if changes.fileDelta == 0 && changes.sizeDelta == 0 && len(changes.updated) == 0 && len(changes.removed) == 0 {
+		return
+	}
+
+	mediaTypes := make([]string, 0, len(fw.typeCounts))
+	for mediaType := range fw.typeCounts {
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+	sort.Strings(mediaTypes)
+	fw.service.mediaFolderService.UpdateFolderStats(fw.folderID, changes.fileDelta, changes.sizeDelta, mediaTypes)
+
+	if fw.service.mediaIndex != nil && (len(changes.updated) > 0 || len(changes.removed) > 0) {
+		if err := fw.service.mediaIndex.UpdateFiles(fw.root, changes.updated, changes.removed); err != nil {
+			log.Printf("Error updating media index for '%s': %v", fw.name, err)
+		}
+	}
+
+	// Cache keys use the media path, f/<folder ID> being the folder itself
+	if fw.service.cacheService != nil {
+		for changedPath := range changes.paths {
+			fw.service.cacheService.InvalidateFileCache(filepath.FromSlash(models.FolderMediaPath(fw.folderID, changedPath)))
+		}
+	}
+
+	log.Printf("Detected changes in folder '%s': %d media files added or changed, %d removed",
+		fw.name, len(changes.updated), len(changes.removed))
+}
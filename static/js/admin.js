@@ -337,6 +337,12 @@ class AdminDashboard {
             this.updateElement('#average-speed', '0 B/s');
             this.updateElement('#peak-speed', '0 B/s');
             this.updateElement('#concurrent-peak', '0');
+            this.updateElement('#current-throughput', '0 B/s');
+            this.updateElement('#throttled-streams', '0');
+            this.updateElement('#active-downloads', '0');
+            this.updateElement('#total-downloads', '0');
+            this.updateElement('#bytes-downloaded', '0 B');
+            this.updateSessionsTable([]);
             return;
         }
 
@@ -347,6 +353,12 @@ class AdminDashboard {
         this.updateElement('#average-speed', this.formatSpeed(metrics.average_speed || 0));
         this.updateElement('#peak-speed', this.formatSpeed(metrics.peak_speed || 0));
         this.updateElement('#concurrent-peak', this.formatNumber(metrics.concurrent_peak || 0));
+        this.updateElement('#current-throughput', this.formatSpeed(metrics.current_throughput || 0));
+        this.updateElement('#throttled-streams', this.formatNumber(metrics.throttled_streams || 0));
+        this.updateElement('#active-downloads', this.formatNumber(metrics.active_downloads || 0));
+        this.updateElement('#total-downloads', this.formatNumber(metrics.total_downloads || 0));
+        this.updateElement('#bytes-downloaded', this.formatBytes(metrics.bytes_downloaded || 0));
+        this.updateSessionsTable(metrics.sessions || []);
 
         // Add visual indicators for active streams
         const activeStreamsElement = document.querySelector('#active-streams');
@@ -362,6 +374,38 @@ class AdminDashboard {
         }
     }
 
+    updateSessionsTable(sessions) {
+        const tbody = document.getElementById('sessions-tbody');
+        if (!tbody) return;
+
+        if (sessions.length === 0) {
+            tbody.innerHTML = '<tr><td colspan="8">No active sessions</td></tr>';
+            return;
+        }
+
+        tbody.innerHTML = sessions.map(session => {
+            const started = new Date(session.started_at);
+            const lastActive = new Date(session.last_active);
+            const duration = (lastActive - started) * 1000000;
+            const position = session.file_size > 0
+                ? `${(session.last_position / session.file_size * 100).toFixed(1)}%`
+                : this.formatBytes(session.last_position);
+
+            return `
+            <tr>
+                <td>${session.client_ip}</td>
+                <td>${this.escapeHtml(session.media_path)}</td>
+                <td>${session.download ? 'Download' : 'Playback'}</td>
+                <td>${this.formatTime(session.started_at)}</td>
+                <td>${this.formatDuration(duration)}</td>
+                <td>${session.total_requests}${session.active_requests > 0 ? ` (${session.active_requests} active)` : ''}</td>
+                <td>${this.formatBytes(session.bytes_served)}</td>
+                <td>${position}</td>
+            </tr>
+        `;
+        }).join('');
+    }
+
     updateCacheMetrics(metrics) {
         if (!metrics) {
             // Set default values when metrics are not available
@@ -746,6 +790,8 @@ class AdminDashboard {
         // Load data for specific tabs
         if (tabName === 'media-folders') {
             this.loadMediaFolders();
+        } else if (tabName === 'duplicates') {
+            this.loadDuplicates();
         }
     }
 
@@ -949,6 +995,7 @@ class AdminDashboard {
                 </div>
 
                 <div class="folder-actions">
+                    ${folder.is_active ? `<a class="btn btn-secondary" href="/f/${folder.id}">📂 Browse</a>` : ''}
                     <button class="btn btn-primary" onclick="scanFolder('${folder.id}')">🔍 Scan</button>
                     <button class="btn btn-secondary" onclick="toggleFolder('${folder.id}')">${folder.is_active ? '⏸️ Disable' : '▶️ Enable'}</button>
                     ${!folder.is_default ? `<button class="btn btn-secondary" onclick="setDefaultFolder('${folder.id}')">⭐ Set Default</button>` : ''}
@@ -1059,7 +1106,14 @@ class AdminDashboard {
             if (!response.ok) throw new Error('Failed to scan folder');
 
             const stats = await response.json();
-            this.showNotification(`Scan complete: ${stats.total_files} files found`, 'success');
+            const breakdown = [stats.resolutions, stats.video_codecs]
+                .map(counts => Object.entries(counts || {})
+                    .sort((a, b) => b[1] - a[1])
+                    .map(([name, count]) => `${name}: ${count}`)
+                    .join(', '))
+                .filter(text => text)
+                .join(' · ');
+            this.showNotification(`Scan complete: ${stats.total_files} files found${breakdown ? ` (${breakdown})` : ''}`, 'success');
 
             // Refresh folders to show updated stats
             this.loadMediaFolders();
@@ -1121,6 +1175,79 @@ class AdminDashboard {
         }
     }
 
+    async loadDuplicates() {
+        clearTimeout(this.duplicatesTimer);
+        try {
+            const response = await fetch('/admin/api/duplicates');
+            if (!response.ok) throw new Error(await response.text() || 'Failed to load duplicates');
+
+            const report = await response.json();
+            this.displayDuplicates(report);
+
+            // Follow a running hashing pass while the tab is open
+            if (report.hashing && document.getElementById('duplicates-tab').classList.contains('active')) {
+                this.duplicatesTimer = setTimeout(() => this.loadDuplicates(), 2000);
+            }
+        } catch (error) {
+            console.error('Error loading duplicates:', error);
+            document.getElementById('duplicates-summary').textContent = error.message;
+            document.getElementById('duplicates-container').innerHTML = '';
+        }
+    }
+
+    displayDuplicates(report) {
+        const summary = document.getElementById('duplicates-summary');
+        const container = document.getElementById('duplicates-container');
+        if (!summary || !container) return;
+
+        const status = report.hashing ? ' · Checking for duplicates…' : '';
+        if (!report.updated_at || report.updated_at.startsWith('0001')) {
+            summary.textContent = 'No duplicate check has finished yet' + status;
+        } else {
+            summary.textContent = `${report.groups.length} groups, ${report.duplicate_files} extra copies wasting ` +
+                `${this.formatBytes(report.wasted_space)} · ${report.hashed_files} files fingerprinted · ` +
+                `checked ${this.formatTime(report.updated_at)}${status}`;
+        }
+
+        if (report.groups.length === 0) {
+            container.innerHTML = '<div class="loading-message">No duplicate files found.</div>';
+            return;
+        }
+
+        container.innerHTML = report.groups.map(group => `
+            <div class="folder-card">
+                <div class="folder-header">
+                    <h4 class="folder-title">${group.files.length} copies of ${this.formatBytes(group.size)}</h4>
+                    <div class="folder-badges">
+                        <span class="folder-badge inactive">${this.formatBytes(group.wasted_space)} wasted</span>
+                    </div>
+                </div>
+                <div class="folder-path" title="SHA-256">${group.sha256}</div>
+                <ul class="duplicate-files">
+                    ${group.files.map(file => `
+                        <li>
+                            <a href="/player/${file.media_path.split('/').map(encodeURIComponent).join('/')}" target="_blank">${this.escapeHtml(file.path)}</a>
+                            <span>${this.escapeHtml(file.folder)}</span>
+                        </li>
+                    `).join('')}
+                </ul>
+            </div>
+        `).join('');
+    }
+
+    async refreshDuplicates() {
+        try {
+            const response = await fetch('/admin/api/duplicates', { method: 'POST' });
+            if (!response.ok) throw new Error(await response.text() || 'Failed to start duplicate check');
+
+            this.showNotification('Checking for duplicates...', 'info');
+            this.loadDuplicates();
+        } catch (error) {
+            console.error('Error refreshing duplicates:', error);
+            this.showNotification('Failed to check for duplicates: ' + error.message, 'error');
+        }
+    }
+
     escapeHtml(text) {
         const div = document.createElement('div');
         div.textContent = text;
@@ -1248,6 +1375,12 @@ function removeUser(ipAddress, name) {
     }
 }
 
+function refreshDuplicates() {
+    if (window.adminDashboard) {
+        window.adminDashboard.refreshDuplicates();
+    }
+}
+
 // Media folder management functions
 function showAddFolderModal() {
     const modal = document.getElementById('addFolderModal');
@@ -48,15 +48,17 @@ class MediaLibrary {
 
     setupSearch() {
         if (this.searchInput) {
+            this.search = new LibrarySearch(this);
+
             this.searchInput.addEventListener('input', (e) => {
-                this.filterMedia(e.target.value);
+                this.search.setText(e.target.value);
             });
 
             // Clear search on escape
             this.searchInput.addEventListener('keydown', (e) => {
                 if (e.key === 'Escape') {
                     this.searchInput.value = '';
-                    this.filterMedia('');
+                    this.search.setText('');
                 }
             });
         }
@@ -201,6 +203,269 @@ class MediaLibrary {
     }
 }
 
+// Library search against /api/search, with facets, filters and paging. Falls back to
+// filtering the cards on the page when the server has search disabled.
+class LibrarySearch {
+    constructor(library) {
+        this.library = library;
+        this.main = document.querySelector('.library-main');
+        this.panel = document.getElementById('search-results');
+        this.summary = document.getElementById('search-summary');
+        this.facets = document.getElementById('search-facets');
+        this.grid = document.getElementById('search-grid');
+        this.error = document.getElementById('search-error');
+        this.moreButton = document.getElementById('search-more');
+        this.sortSelect = document.getElementById('search-sort');
+        this.filterInputs = {
+            min_size: document.getElementById('search-min-size'),
+            max_size: document.getElementById('search-max-size'),
+            date: document.getElementById('search-date-field'),
+            from: document.getElementById('search-from'),
+            to: document.getElementById('search-to')
+        };
+
+        this.available = !!this.panel;
+        this.text = '';
+        this.filters = { type: new Set(), folder: new Set(), ext: new Set() };
+        this.page = 1;
+        this.request = null;
+        this.debounceTimer = null;
+
+        if (this.available) {
+            this.bindEvents();
+        }
+    }
+
+    bindEvents() {
+        this.sortSelect.addEventListener('change', () => this.search(1));
+        Object.values(this.filterInputs).forEach(input => {
+            input.addEventListener('change', () => this.search(1));
+        });
+        this.moreButton.addEventListener('click', () => this.search(this.page + 1));
+    }
+
+    setText(text) {
+        this.text = text.trim();
+        if (!this.available) {
+            this.library.filterMedia(this.text);
+            return;
+        }
+
+        clearTimeout(this.debounceTimer);
+        if (this.text === '') {
+            this.close();
+            return;
+        }
+        this.debounceTimer = setTimeout(() => this.search(1), 250);
+    }
+
+    params(page) {
+        const params = new URLSearchParams({ q: this.text });
+        Object.entries(this.filters).forEach(([name, values]) => {
+            values.forEach(value => params.append(name, value));
+        });
+        Object.entries(this.filterInputs).forEach(([name, input]) => {
+            if (input.value) {
+                params.set(name, input.value);
+            }
+        });
+        params.set('sort', this.sortSelect.value);
+        params.set('page', page);
+        return params;
+    }
+
+    async search(page) {
+        if (this.text === '') {
+            return;
+        }
+        if (this.request) {
+            this.request.abort();
+        }
+        const request = new AbortController();
+        this.request = request;
+
+        try {
+            const response = await fetch(`/api/search?${this.params(page)}`, { signal: request.signal });
+            if (response.status === 503) {
+                // Search is disabled on the server
+                this.available = false;
+                this.close();
+                this.library.filterMedia(this.text);
+                return;
+            }
+            if (!response.ok) {
+                this.showError(await response.text());
+                return;
+            }
+            this.render(await response.json(), page > 1);
+        } catch (error) {
+            if (error.name !== 'AbortError') {
+                this.showError(`Search failed: ${error.message}`);
+            }
+        } finally {
+            if (this.request === request) {
+                this.request = null;
+            }
+        }
+    }
+
+    render(result, append) {
+        this.open();
+        this.error.hidden = true;
+        this.page = result.page;
+
+        this.summary.textContent = `${result.total} result${result.total === 1 ? '' : 's'} for “${this.text}”`;
+        if (!append) {
+            this.grid.replaceChildren();
+        }
+        result.hits.forEach(hit => this.grid.appendChild(this.renderHit(hit)));
+        this.moreButton.hidden = result.page >= result.pages;
+
+        this.renderFacets(result.facets);
+    }
+
+    renderFacets(facets) {
+        const groups = [
+            ['type', 'Type', facets.media_types],
+            ['folder', 'Folder', facets.folders],
+            ['ext', 'Format', facets.extensions]
+        ];
+
+        this.facets.replaceChildren();
+        groups.forEach(([name, title, counts]) => {
+            // Selected values without matches stay listed so they can be cleared
+            const values = new Map(counts.map(facet => [facet.value, facet]));
+            this.filters[name].forEach(value => {
+                if (!values.has(value)) {
+                    values.set(value, { value, label: value, count: 0 });
+                }
+            });
+            if (values.size === 0) {
+                return;
+            }
+
+            const group = document.createElement('div');
+            group.className = 'search-facet-group';
+            const label = document.createElement('span');
+            label.className = 'search-facet-title';
+            label.textContent = title;
+            group.appendChild(label);
+
+            values.forEach(facet => {
+                const chip = document.createElement('button');
+                chip.className = 'facet-chip';
+                chip.classList.toggle('active', this.filters[name].has(facet.value));
+                chip.textContent = `${facet.label} (${facet.count})`;
+                chip.addEventListener('click', () => {
+                    if (this.filters[name].has(facet.value)) {
+                        this.filters[name].delete(facet.value);
+                    } else {
+                        this.filters[name].add(facet.value);
+                    }
+                    this.search(1);
+                });
+                group.appendChild(chip);
+            });
+
+            this.facets.appendChild(group);
+        });
+    }
+
+    renderHit(hit) {
+        const icons = { video: '🎬', audio: '🎵', image: '🖼️' };
+
+        const card = document.createElement('div');
+        card.className = 'media-card';
+        card.dataset.type = hit.media_type;
+        card.dataset.title = hit.name;
+
+        const link = document.createElement('a');
+        link.className = 'media-link';
+        link.href = hit.player_url;
+
+        const thumbnail = document.createElement('div');
+        thumbnail.className = 'media-thumbnail';
+        const icon = document.createElement('div');
+        icon.className = 'media-icon';
+        icon.textContent = icons[hit.media_type] || '📄';
+        thumbnail.appendChild(icon);
+        if (hit.image_url) {
+            const img = document.createElement('img');
+            img.src = hit.image_url;
+            img.alt = '';
+            img.loading = 'lazy';
+            img.className = hit.media_type === 'audio' ? 'thumbnail-image cover-art' : 'thumbnail-image';
+            img.addEventListener('error', () => img.remove());
+            thumbnail.appendChild(img);
+        }
+        const badge = document.createElement('div');
+        badge.className = 'media-duration';
+        badge.textContent = hit.extension;
+        thumbnail.appendChild(badge);
+
+        const info = document.createElement('div');
+        info.className = 'media-info';
+        const title = document.createElement('h3');
+        title.className = 'media-title';
+        title.textContent = hit.name;
+        title.title = hit.name;
+        const meta = document.createElement('div');
+        meta.className = 'media-meta';
+        if (hit.summary) {
+            meta.appendChild(this.metaSpan('media-artist', hit.summary));
+        }
+        meta.appendChild(this.metaSpan('media-size', this.formatFileSize(hit.size)));
+        meta.appendChild(this.metaSpan('media-path', `${hit.folder} / ${hit.path}`));
+        info.append(title, meta);
+
+        link.append(thumbnail, info);
+        card.appendChild(link);
+        return card;
+    }
+
+    metaSpan(className, text) {
+        const span = document.createElement('span');
+        span.className = className;
+        span.textContent = text;
+        return span;
+    }
+
+    formatFileSize(bytes) {
+        const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+        let size = bytes;
+        let unitIndex = 0;
+
+        while (size >= 1024 && unitIndex < units.length - 1) {
+            size /= 1024;
+            unitIndex++;
+        }
+
+        return `${size.toFixed(1)} ${units[unitIndex]}`;
+    }
+
+    showError(message) {
+        this.open();
+        this.error.textContent = message;
+        this.error.hidden = false;
+    }
+
+    open() {
+        this.panel.hidden = false;
+        this.main.classList.add('searching');
+    }
+
+    close() {
+        if (this.request) {
+            this.request.abort();
+        }
+        Object.values(this.filters).forEach(values => values.clear());
+        if (this.panel) {
+            this.panel.hidden = true;
+            this.main.classList.remove('searching');
+        }
+    }
+}
+
 // Infinite scroll for large libraries
 class InfiniteScroll {
     constructor() {
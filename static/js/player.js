@@ -16,6 +16,7 @@ class MediaPlayer {
         this.setupKeyboardControls();
         this.setupFullscreen();
         this.setupAutoplay();
+        this.setupTranscode();
     }
 
     setupEventListeners() {
@@ -126,6 +127,48 @@ class MediaPlayer {
         }
     }
 
+    setupTranscode() {
+        // Files in containers browsers cannot play are transcoded on demand: poll the rendition
+        // status until it is ready, then load it
+        if (!this.player || !this.player.dataset.transcodeStatus) return;
+
+        const statusUrl = this.withPassword(this.player.dataset.transcodeStatus);
+        this.showLoading('Preparing video for playback...');
+
+        const poll = () => {
+            fetch(statusUrl)
+                .then(response => response.json())
+                .then(job => {
+                    if (job.status === 'ready') {
+                        this.hideLoading();
+                        this.player.src = this.withPassword(job.url);
+                        this.player.load();
+                    } else if (job.status === 'failed') {
+                        this.hideLoading();
+                        this.showError(`Unable to convert this media file for playback. ${job.error || ''}`);
+                    } else {
+                        this.showLoading(job.status === 'running' ? 'Converting video for playback...' : 'Waiting for a free transcoder...');
+                        setTimeout(poll, 3000);
+                    }
+                })
+                .catch(error => {
+                    console.error('Transcode status error:', error);
+                    setTimeout(poll, 5000);
+                });
+        };
+        poll();
+    }
+
+    withPassword(url) {
+        // Carry a media password given in the page URL over to media requests
+        const password = new URLSearchParams(window.location.search).get('password');
+        if (!password) return url;
+
+        const target = new URL(url, window.location.origin);
+        target.searchParams.set('password', password);
+        return target.pathname + target.search;
+    }
+
     showPlayButton() {
         // Create a play button overlay for when autoplay is blocked
         const playButton = document.createElement('div');
@@ -259,13 +302,20 @@ class MediaPlayer {
         this.container.appendChild(errorDiv);
     }
 
-    showLoading() {
+    showLoading(message = 'Loading...') {
+        const existing = this.container.querySelector('.player-loading');
+        if (existing) {
+            existing.querySelector('.loading-message').textContent = message;
+            return;
+        }
+
         const loadingDiv = document.createElement('div');
         loadingDiv.className = 'player-loading';
         loadingDiv.innerHTML = `
             <div class="loading-spinner">⏳</div>
-            <div class="loading-message">Loading...</div>
+            <div class="loading-message"></div>
         `;
+        loadingDiv.querySelector('.loading-message').textContent = message;
         this.container.appendChild(loadingDiv);
     }
 